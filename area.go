@@ -0,0 +1,88 @@
+package screenshot
+
+import (
+	"errors"
+	"image"
+)
+
+// AreaWatcher captures one area of the screen again and again, e.g. a status
+// indicator, and tells when it may have changed.
+//
+// On X11 it captures on a connection kept open until Close, and Damaged reports
+// when the X server draws to the area, as told by the DAMAGE extension of the
+// root window. Elsewhere, without that extension, for capturers other than the
+// default one or while a capture hook is set, its captures go through Capture and
+// Damaged returns nil.
+type AreaWatcher struct {
+	rect    image.Rectangle
+	capture CaptureFunc
+	backend areaBackend // nil when captures go through capture
+}
+
+// areaBackend captures an area on a kept connection and reports damage to it.
+type areaBackend interface {
+	// capture captures the area, filling the parts outside the screen with
+	// opaque black.
+	capture() (*image.RGBA, error)
+	// damaged is signalled when the area is drawn to and closed when the
+	// backend stops reporting, nil if it never reports.
+	damaged() <-chan struct{}
+	close() error
+}
+
+// WatchArea returns an AreaWatcher for rect, in the coordinates of Capture.
+func WatchArea(rect image.Rectangle) (*AreaWatcher, error) {
+	return WatchAreaWith(DefaultCapturer(), rect)
+}
+
+// WatchAreaWith is like WatchArea but uses the given capturer.
+func WatchAreaWith(c ScreenCapturer, rect image.Rectangle) (*AreaWatcher, error) {
+	if rect.Empty() {
+		return nil, errors.New("screenshot: empty rectangle")
+	}
+	a := &AreaWatcher{rect: rect, capture: c.Capture}
+	if _, ok := c.(defaultCapturer); ok {
+		b, err := watchArea(rect)
+		switch {
+		case err == nil:
+			a.backend = b
+		case !errors.Is(err, ErrUnsupported):
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Rect returns the watched area.
+func (a *AreaWatcher) Rect() image.Rectangle {
+	return a.rect
+}
+
+// Capture captures the area.
+func (a *AreaWatcher) Capture() (*image.RGBA, error) {
+	if a.backend == nil || captureHook.Load() != nil {
+		// The hook sees every capture, as with Capture.
+		return a.capture(a.rect.Min.X, a.rect.Min.Y, a.rect.Dx(), a.rect.Dy())
+	}
+	return a.backend.capture()
+}
+
+// Damaged returns a channel that receives a value when the area was drawn to since
+// the last value was received, so that a caller can wait for changes instead of
+// polling. Damage to the area while a value is pending is not reported again. The
+// channel is closed when the reports stop, e.g. when the X server goes away. It is
+// nil where damage is not reported.
+func (a *AreaWatcher) Damaged() <-chan struct{} {
+	if a.backend == nil {
+		return nil
+	}
+	return a.backend.damaged()
+}
+
+// Close releases the connection of the watcher.
+func (a *AreaWatcher) Close() error {
+	if a.backend == nil {
+		return nil
+	}
+	return a.backend.close()
+}
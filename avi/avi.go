@@ -0,0 +1,562 @@
+// Package avi writes Motion-JPEG AVI files, a format every video player and editor
+// reads, without cgo or external tools.
+//
+// Files use the OpenDML extensions, so they can grow past 1GB as a chain of RIFF
+// chunks, and carry a legacy idx1 index for old readers. The headers and the OpenDML
+// index are brought up to date at regular checkpoints while recording: if the process
+// dies, the file is playable up to the last checkpoint.
+//
+// AVI has a constant frame rate. Frames are placed in the slot matching their capture
+// time; missed slots repeat the previous frame with an empty chunk, as VirtualDub
+// writes dropped frames, and frames arriving before their slot are skipped.
+package avi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"time"
+
+	"github.com/Fast-IQ/screenshot/encode"
+)
+
+// Options configures a Writer.
+type Options struct {
+	// FPS is the frame rate, default 10.
+	FPS float64
+	// Quality is the JPEG quality, default encode.DefaultJPEGQuality.
+	Quality int
+	// Checkpoint is how often the index and headers are updated, default one second
+	// of frames.
+	Checkpoint time.Duration
+	// Sync flushes the file to stable storage at every checkpoint when the writer has
+	// a Sync method, as *os.File does, so that power loss keeps the checkpoint too.
+	Sync bool
+}
+
+// ErrIndexFull is returned by Writer when the OpenDML index has no room left. The
+// index holds an entry per checkpoint, enough for more than an hour at the default
+// interval.
+var ErrIndexFull = errors.New("avi: index full")
+
+var errClosed = errors.New("avi: writer closed")
+
+const (
+	// superIndexSize is the number of standard index chunks the super index can
+	// list, reserved in the header.
+	superIndexSize = 4096
+	// checkpointReserve entries are kept for RIFF switches and closing.
+	checkpointReserve = 16
+
+	avihSize = 56
+	strhSize = 56
+	strfSize = 40
+	indxSize = 24 + 16*superIndexSize
+	dmlhSize = 248
+
+	avifHasIndex      = 0x10
+	avifIsInterleaved = 0x100
+	aviifKeyframe     = 0x10
+)
+
+// riffLimit is the size after which a new RIFF chunk is started. Readers of plain
+// AVI stop at the first one.
+var riffLimit int64 = 1 << 30
+
+// maxRIFF is the size a RIFF chunk cannot exceed.
+const maxRIFF = 1<<32 - 1<<20
+
+type chunkRef struct {
+	off  int64 // offset of the chunk header
+	size uint32
+}
+
+type superEntry struct {
+	off      int64
+	size     uint32
+	duration uint32
+}
+
+// Writer writes one MJPEG AVI file.
+type Writer struct {
+	w             io.WriteSeeker
+	opts          Options
+	width, height int
+	scale, rate   uint32
+
+	pos       int64 // end of the file
+	riffStart int64 // offset of the current RIFF chunk
+	moviStart int64 // offset of its movi list
+	riffs     int
+
+	frames   int        // frames written, repeats included
+	riff0    int        // frames in the first RIFF chunk once it is complete
+	pending  []chunkRef // frames not in a standard index yet
+	idx1     []chunkRef // frames for the legacy index, while in the first RIFF chunk
+	super    []superEntry
+	patched  int // super index entries already in the header
+	perIndex int // frames per checkpoint
+	maxChunk uint32
+	hasFrame bool
+	start    time.Time
+	jpeg     bytes.Buffer
+	closed   bool
+	err      error
+}
+
+// Offsets of the header fields updated at checkpoints.
+const (
+	offRIFFSize    = 4
+	offAvih        = 12 + 12
+	offAvihFrames  = offAvih + 8 + 16
+	offAvihBuffer  = offAvih + 8 + 28
+	offStrl        = offAvih + 8 + avihSize
+	offStrh        = offStrl + 12
+	offStrhLength  = offStrh + 8 + 32
+	offStrhBuffer  = offStrh + 8 + 36
+	offIndx        = offStrh + 8 + strhSize + 8 + strfSize
+	offIndxEntries = offIndx + 8 + 4
+	offIndxTable   = offIndx + 8 + 24
+	offOdml        = offIndx + 8 + indxSize
+	offDmlhFrames  = offOdml + 12 + 8
+	offMovi        = offOdml + 12 + 8 + dmlhSize
+	hdrlSize       = offMovi - 12 - 8
+	strlSize       = offOdml - offStrl - 8
+)
+
+// NewWriter writes the header of a width×height video to w, which must be empty.
+func NewWriter(w io.WriteSeeker, width, height int, opts Options) (*Writer, error) {
+	if width <= 0 || height <= 0 || width > 65535 || height > 65535 {
+		return nil, fmt.Errorf("avi: invalid frame size %dx%d", width, height)
+	}
+	if opts.FPS == 0 {
+		opts.FPS = 10
+	}
+	if opts.FPS < 0.001 || opts.FPS > 1000 || math.IsNaN(opts.FPS) {
+		return nil, fmt.Errorf("avi: invalid frame rate %v", opts.FPS)
+	}
+	if opts.Checkpoint <= 0 {
+		opts.Checkpoint = time.Second
+	}
+	a := &Writer{w: w, opts: opts, width: width, height: height, riffs: 1}
+	a.rate, a.scale = rational(opts.FPS)
+	a.perIndex = max(int(math.Round(opts.Checkpoint.Seconds()*opts.FPS)), 1)
+
+	var b []byte
+	b = append(b, "RIFF"...)
+	b = le32(b, 0) // patched below
+	b = append(b, "AVI "...)
+	b = list(b, "hdrl", hdrlSize)
+	b = chunk(b, "avih", avihSize)
+	b = le32(b, uint32(math.Round(1e6*float64(a.scale)/float64(a.rate))))
+	b = le32(b, 0) // max bytes per second
+	b = le32(b, 0) // padding granularity
+	b = le32(b, avifHasIndex|avifIsInterleaved)
+	b = le32(b, 0) // total frames
+	b = le32(b, 0) // initial frames
+	b = le32(b, 1) // streams
+	b = le32(b, 0) // suggested buffer size
+	b = le32(b, uint32(width))
+	b = le32(b, uint32(height))
+	b = append(b, make([]byte, 16)...)
+
+	b = list(b, "strl", strlSize)
+	b = chunk(b, "strh", strhSize)
+	b = append(b, "vidsMJPG"...)
+	b = le32(b, 0) // flags
+	b = le32(b, 0) // priority and language
+	b = le32(b, 0) // initial frames
+	b = le32(b, a.scale)
+	b = le32(b, a.rate)
+	b = le32(b, 0)          // start
+	b = le32(b, 0)          // length
+	b = le32(b, 0)          // suggested buffer size
+	b = le32(b, 0xffffffff) // default quality
+	b = le32(b, 0)          // sample size
+	b = le16(b, 0)
+	b = le16(b, 0)
+	b = le16(b, uint16(width))
+	b = le16(b, uint16(height))
+
+	b = chunk(b, "strf", strfSize) // BITMAPINFOHEADER
+	b = le32(b, strfSize)
+	b = le32(b, uint32(width))
+	b = le32(b, uint32(height))
+	b = le16(b, 1)  // planes
+	b = le16(b, 24) // bits per pixel
+	b = append(b, "MJPG"...)
+	b = le32(b, uint32(width*height*3))
+	b = append(b, make([]byte, 16)...)
+
+	b = chunk(b, "indx", indxSize) // super index, filled at checkpoints
+	b = le16(b, 4)                 // longs per entry
+	b = append(b, 0, 0)            // index of indexes
+	b = le32(b, 0)                 // entries in use
+	b = append(b, "00dc"...)
+	b = append(b, make([]byte, 12+16*superIndexSize)...)
+
+	b = list(b, "odml", 4+8+dmlhSize)
+	b = chunk(b, "dmlh", dmlhSize)
+	b = append(b, make([]byte, dmlhSize)...)
+
+	b = list(b, "movi", 4)
+	binary.LittleEndian.PutUint32(b[offRIFFSize:], uint32(len(b)-8))
+
+	a.moviStart = offMovi
+	if err := a.write(b); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FrameRate returns the frame rate of the file as a fraction.
+func (a *Writer) FrameRate() (num, den int) {
+	return int(a.rate), int(a.scale)
+}
+
+// Frames returns the number of frames written, repeats included.
+func (a *Writer) Frames() int {
+	return a.frames
+}
+
+// Size returns the size the file would have if it was closed now.
+func (a *Writer) Size() int64 {
+	return a.sizeWith(0, -1)
+}
+
+// sizeWith returns the size after Close if fills repeated frames and then a frame of
+// n bytes, or none for n < 0, were written first.
+func (a *Writer) sizeWith(fills, n int) int64 {
+	size, chunks := a.pos, fills
+	size += 8 * int64(fills)
+	if n >= 0 {
+		size += 8 + int64(n+n&1)
+		chunks++
+	}
+	// Standard index chunks: one per checkpoint and one for the rest on closing.
+	pending := len(a.pending) + chunks
+	size += 8 * int64(pending)
+	if len(a.super) < superIndexSize-checkpointReserve {
+		size += 32 * int64(pending/a.perIndex)
+		pending %= a.perIndex
+	}
+	if pending > 0 {
+		size += 32
+	}
+	if a.riffs == 1 {
+		size += 8 + 16*int64(len(a.idx1)+chunks)
+	}
+	return size
+}
+
+// WriteFrame encodes img, which must have the size of the video, as the next frame.
+func (a *Writer) WriteFrame(img *image.RGBA) error {
+	if a.closed {
+		return errClosed
+	}
+	if img.Rect.Dx() != a.width || img.Rect.Dy() != a.height {
+		return fmt.Errorf("avi: frame size %v differs from video size %dx%d", img.Rect.Size(), a.width, a.height)
+	}
+	a.jpeg.Reset()
+	if err := encode.JPEG(&a.jpeg, img, &encode.JPEGOptions{Quality: a.opts.Quality}); err != nil {
+		return err
+	}
+	return a.WriteJPEG(a.jpeg.Bytes())
+}
+
+// WriteJPEG writes an encoded JPEG image as the next frame.
+func (a *Writer) WriteJPEG(data []byte) error {
+	if a.closed {
+		return errClosed
+	}
+	if err := a.writeChunk(data); err != nil {
+		return err
+	}
+	a.hasFrame = true
+	return nil
+}
+
+// WriteFrameAt writes img as the frame shown at t, the first call fixing the start of
+// the video. Slots since the previous frame repeat it; if t falls in a slot that is
+// already filled, img is skipped.
+func (a *Writer) WriteFrameAt(img *image.RGBA, t time.Time) error {
+	if a.start.IsZero() {
+		a.start = t
+	}
+	slot := a.slot(t)
+	if slot < a.frames {
+		return nil
+	}
+	if err := a.fill(slot); err != nil {
+		return err
+	}
+	return a.WriteFrame(img)
+}
+
+func (a *Writer) slot(t time.Time) int {
+	return int(math.Round(t.Sub(a.start).Seconds() * float64(a.rate) / float64(a.scale)))
+}
+
+// fill repeats the last frame up to slot.
+func (a *Writer) fill(slot int) error {
+	if !a.hasFrame {
+		return nil
+	}
+	for a.frames < slot {
+		if err := a.writeChunk(nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close updates the index and headers. It does not close the underlying writer.
+func (a *Writer) Close() error {
+	if a.closed {
+		return errClosed
+	}
+	a.closed = true
+	if err := a.index(true); err != nil {
+		return err
+	}
+	if a.riffs == 1 {
+		if err := a.writeIdx1(); err != nil {
+			return err
+		}
+	}
+	return a.checkpoint()
+}
+
+func (a *Writer) writeChunk(data []byte) error {
+	if a.err != nil {
+		return a.err
+	}
+	n := 8 + int64(len(data)+len(data)&1)
+	if a.pos+n-a.riffStart > riffLimit && a.pos > a.moviStart+12 {
+		if err := a.nextRIFF(); err != nil {
+			return err
+		}
+	}
+	if a.pos+n+32+8*int64(len(a.pending)+1)-a.riffStart > maxRIFF {
+		return a.fail(ErrIndexFull)
+	}
+	ref := chunkRef{a.pos, uint32(len(data))}
+	b := make([]byte, 0, n)
+	b = append(b, "00dc"...)
+	b = le32(b, uint32(len(data)))
+	b = append(b, data...)
+	if len(data)&1 != 0 {
+		b = append(b, 0)
+	}
+	if err := a.write(b); err != nil {
+		return err
+	}
+	a.pending = append(a.pending, ref)
+	if a.riffs == 1 {
+		a.idx1 = append(a.idx1, ref)
+	}
+	a.maxChunk = max(a.maxChunk, ref.size)
+	a.frames++
+	if len(a.pending) >= a.perIndex && len(a.super) < superIndexSize-checkpointReserve {
+		if err := a.index(false); err != nil {
+			return err
+		}
+		return a.checkpoint()
+	}
+	return nil
+}
+
+// index writes a standard index chunk for the pending frames. It fails with
+// ErrIndexFull when the super index has no room, except when closing, for which an
+// entry is always kept free.
+func (a *Writer) index(closing bool) error {
+	if len(a.pending) == 0 {
+		return nil
+	}
+	if len(a.super) >= superIndexSize-1 && !(closing && len(a.super) < superIndexSize) {
+		return a.fail(ErrIndexFull)
+	}
+	b := append([]byte(nil), "ix00"...)
+	b = le32(b, uint32(24+8*len(a.pending)))
+	b = le16(b, 2)      // longs per entry
+	b = append(b, 0, 1) // index of chunks
+	b = le32(b, uint32(len(a.pending)))
+	b = append(b, "00dc"...)
+	b = le64(b, uint64(a.riffStart))
+	b = le32(b, 0)
+	for _, c := range a.pending {
+		b = le32(b, uint32(c.off+8-a.riffStart))
+		b = le32(b, c.size) // bit 31 clear: every frame is a key frame
+	}
+	a.super = append(a.super, superEntry{a.pos, uint32(len(b)), uint32(len(a.pending))})
+	a.pending = a.pending[:0]
+	return a.write(b)
+}
+
+// writeIdx1 ends the movi list of the first RIFF chunk with the legacy index.
+func (a *Writer) writeIdx1() error {
+	moviEnd := a.pos
+	b := append([]byte(nil), "idx1"...)
+	b = le32(b, uint32(16*len(a.idx1)))
+	for _, c := range a.idx1 {
+		b = append(b, "00dc"...)
+		flags := uint32(aviifKeyframe)
+		if c.size == 0 {
+			flags = 0
+		}
+		b = le32(b, flags)
+		b = le32(b, uint32(c.off-(a.moviStart+8)))
+		b = le32(b, c.size)
+	}
+	a.idx1 = nil
+	a.riff0 = a.frames
+	if err := a.patch(a.moviStart+4, le32(nil, uint32(moviEnd-a.moviStart-8))); err != nil {
+		return err
+	}
+	return a.write(b)
+}
+
+// nextRIFF closes the current RIFF chunk and starts an AVIX one.
+func (a *Writer) nextRIFF() error {
+	if len(a.super) >= superIndexSize-2 {
+		return a.fail(ErrIndexFull)
+	}
+	if err := a.index(false); err != nil {
+		return err
+	}
+	if a.riffs == 1 {
+		if err := a.writeIdx1(); err != nil {
+			return err
+		}
+	} else if err := a.patch(a.moviStart+4, le32(nil, uint32(a.pos-a.moviStart-8))); err != nil {
+		return err
+	}
+	if err := a.patch(a.riffStart+4, le32(nil, uint32(a.pos-a.riffStart-8))); err != nil {
+		return err
+	}
+	a.riffs++
+	a.riffStart, a.moviStart = a.pos, a.pos+12
+	b := append([]byte(nil), "RIFF"...)
+	b = le32(b, 4+12)
+	b = append(b, "AVIX"...)
+	b = list(b, "movi", 4)
+	if err := a.write(b); err != nil {
+		return err
+	}
+	return a.checkpoint()
+}
+
+// checkpoint brings the headers up to date with what has been written.
+func (a *Writer) checkpoint() error {
+	if a.err != nil {
+		return a.err
+	}
+	riff0 := a.frames
+	if a.riffs > 1 {
+		riff0 = a.riff0
+	}
+	patches := []struct {
+		off int64
+		b   []byte
+	}{
+		{offIndxTable + 16*int64(a.patched), a.superTable()},
+		{offIndxEntries, le32(nil, uint32(len(a.super)))},
+		{offAvihFrames, le32(nil, uint32(riff0))},
+		{offAvihBuffer, le32(nil, a.maxChunk+8)},
+		{offStrhLength, le32(nil, uint32(a.frames))},
+		{offStrhBuffer, le32(nil, a.maxChunk+8)},
+		{offDmlhFrames, le32(nil, uint32(a.frames))},
+		{a.riffStart + 4, le32(nil, uint32(a.pos-a.riffStart-8))},
+	}
+	if a.riffs > 1 || a.idx1 != nil {
+		// The movi list runs to the end, unless the legacy index ended it.
+		patches = append(patches, struct {
+			off int64
+			b   []byte
+		}{a.moviStart + 4, le32(nil, uint32(a.pos-a.moviStart-8))})
+	}
+	for _, p := range patches {
+		if err := a.patch(p.off, p.b); err != nil {
+			return err
+		}
+	}
+	a.patched = len(a.super)
+	if s, ok := a.w.(interface{ Sync() error }); ok && a.opts.Sync {
+		if err := s.Sync(); err != nil {
+			return a.fail(err)
+		}
+	}
+	return nil
+}
+
+func (a *Writer) superTable() []byte {
+	var b []byte
+	for _, e := range a.super[a.patched:] {
+		b = le64(b, uint64(e.off))
+		b = le32(b, e.size)
+		b = le32(b, e.duration)
+	}
+	return b
+}
+
+func (a *Writer) write(b []byte) error {
+	if a.err != nil {
+		return a.err
+	}
+	if _, err := a.w.Write(b); err != nil {
+		return a.fail(err)
+	}
+	a.pos += int64(len(b))
+	return nil
+}
+
+// patch overwrites earlier bytes and seeks back to the end.
+func (a *Writer) patch(off int64, b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if _, err := a.w.Seek(off, io.SeekStart); err != nil {
+		return a.fail(err)
+	}
+	if _, err := a.w.Write(b); err != nil {
+		return a.fail(err)
+	}
+	if _, err := a.w.Seek(a.pos, io.SeekStart); err != nil {
+		return a.fail(err)
+	}
+	return nil
+}
+
+func (a *Writer) fail(err error) error {
+	if a.err == nil {
+		a.err = err
+	}
+	return err
+}
+
+// rational returns fps as rate/scale in thousandths, reduced.
+func rational(fps float64) (rate, scale uint32) {
+	rate, scale = uint32(math.Round(fps*1000)), 1000
+	for x, y := rate, scale; ; {
+		if y == 0 {
+			return rate / x, scale / x
+		}
+		x, y = y, x%y
+	}
+}
+
+func le16(b []byte, v uint16) []byte { return binary.LittleEndian.AppendUint16(b, v) }
+func le32(b []byte, v uint32) []byte { return binary.LittleEndian.AppendUint32(b, v) }
+func le64(b []byte, v uint64) []byte { return binary.LittleEndian.AppendUint64(b, v) }
+
+func chunk(b []byte, id string, size int) []byte {
+	return le32(append(b, id...), uint32(size))
+}
+
+func list(b []byte, typ string, size int) []byte {
+	return append(le32(append(b, "LIST"...), uint32(size)), typ...)
+}
@@ -0,0 +1,381 @@
+package avi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+)
+
+// memFile is an in-memory io.WriteSeeker.
+type memFile struct {
+	data []byte
+	pos  int
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if end := f.pos + len(p); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	copy(f.data[f.pos:], p)
+	f.pos += len(p)
+	return len(p), nil
+}
+
+func (f *memFile) Seek(off int64, whence int) (int64, error) {
+	if whence != io.SeekStart {
+		return 0, errors.New("unsupported whence")
+	}
+	f.pos = int(off)
+	return off, nil
+}
+
+// aviFile is what a reader sees in a file.
+type aviFile struct {
+	riffs       []string // form types
+	totalFrames uint32   // avih, frames of the first RIFF chunk
+	length      uint32   // strh
+	odmlFrames  uint32   // dmlh
+	scale, rate uint32
+	width       uint32
+	frames      [][]byte // from the OpenDML index
+	idx1        [][]byte // from the legacy index
+	end         int      // end of the last RIFF chunk
+}
+
+func u32(b []byte) uint32 { return binary.LittleEndian.Uint32(b) }
+
+// parseAVI reads data the way a strict reader does: only what the RIFF sizes cover.
+func parseAVI(t *testing.T, data []byte) *aviFile {
+	t.Helper()
+	a := &aviFile{}
+	var moviData int // offset of the first movi fourcc
+	var superIndex []byte
+	for off := 0; off+12 <= len(data) && string(data[off:off+4]) == "RIFF"; {
+		size := int(u32(data[off+4:]))
+		if off+8+size > len(data) {
+			t.Fatalf("RIFF chunk at %d of %d bytes exceeds the file of %d", off, size, len(data))
+		}
+		a.riffs = append(a.riffs, string(data[off+8:off+12]))
+		var walk func(b []byte, base int)
+		walk = func(b []byte, base int) {
+			for p := 0; p+8 <= len(b); {
+				id, n := string(b[p:p+4]), int(u32(b[p+4:]))
+				if p+8+n > len(b) {
+					t.Fatalf("chunk %s at %d overruns its parent", id, base+p)
+				}
+				body := b[p+8 : p+8+n]
+				switch id {
+				case "LIST":
+					if string(body[:4]) == "movi" && moviData == 0 {
+						moviData = base + p + 8
+					}
+					walk(body[4:], base+p+12)
+				case "avih":
+					a.totalFrames = u32(body[16:])
+					a.width = u32(body[32:])
+				case "strh":
+					a.scale, a.rate, a.length = u32(body[20:]), u32(body[24:]), u32(body[32:])
+				case "indx":
+					superIndex = body
+				case "dmlh":
+					a.odmlFrames = u32(body)
+				case "idx1":
+					for e := 0; e < n; e += 16 {
+						ck := moviData + int(u32(body[e+8:]))
+						if string(data[ck:ck+4]) != "00dc" {
+							t.Fatalf("idx1 entry %d does not point at a frame", e/16)
+						}
+						a.idx1 = append(a.idx1, data[ck+8:ck+8+int(u32(body[e+12:]))])
+					}
+				}
+				p += 8 + n + n&1
+			}
+		}
+		walk(data[off+12:off+8+size], off+12)
+		off += 8 + size
+		a.end = off
+	}
+
+	if superIndex == nil || superIndex[3] != 0 {
+		t.Fatal("missing super index")
+	}
+	for i := 0; i < int(u32(superIndex[4:])); i++ {
+		e := superIndex[24+16*i:]
+		ix := int(binary.LittleEndian.Uint64(e))
+		if string(data[ix:ix+4]) != "ix00" || int(u32(e[8:])) != 8+int(u32(data[ix+4:])) {
+			t.Fatalf("super index entry %d does not point at an index chunk", i)
+		}
+		n := int(u32(data[ix+12:]))
+		if n != int(u32(e[12:])) {
+			t.Fatalf("index chunk %d holds %d frames, super index says %d", i, n, u32(e[12:]))
+		}
+		base := int(binary.LittleEndian.Uint64(data[ix+20:]))
+		for j := 0; j < n; j++ {
+			pos := base + int(u32(data[ix+32+8*j:]))
+			size := int(u32(data[ix+36+8*j:]) &^ (1 << 31))
+			if string(data[pos-8:pos-4]) != "00dc" || int(u32(data[pos-4:])) != size {
+				t.Fatalf("index chunk %d entry %d does not point at a frame", i, j)
+			}
+			a.frames = append(a.frames, data[pos:pos+size])
+		}
+	}
+	return a
+}
+
+func testImage(w, h, seed int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{byte(x*7 + seed*31), byte(y*5 + seed*17), byte((x ^ y) + seed), 255})
+		}
+	}
+	return img
+}
+
+// checkFrames fails unless frames decode to JPEGs of the given size and repeats
+// are empty.
+func checkFrames(t *testing.T, frames [][]byte, width int, repeats map[int]bool) {
+	t.Helper()
+	for i, f := range frames {
+		if repeats[i] {
+			if len(f) != 0 {
+				t.Errorf("frame %d: %d bytes, want a repeat", i, len(f))
+			}
+			continue
+		}
+		img, err := jpeg.Decode(bytes.NewReader(f))
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if img.Bounds().Dx() != width {
+			t.Errorf("frame %d is %v wide", i, img.Bounds().Dx())
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	f := &memFile{}
+	w, err := NewWriter(f, 64, 48, Options{FPS: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1000, 0)
+	// Slots are 100ms: the frame at 300ms repeats the one before for slot 2 and the
+	// frame at 320ms finds slot 3 taken.
+	for i, ms := range []int{0, 100, 300, 320, 400} {
+		if err := w.WriteFrameAt(testImage(64, 48, i), start.Add(time.Duration(ms)*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size := w.Size()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(f.data)) != size {
+		t.Errorf("Size predicted %d bytes, file has %d", size, len(f.data))
+	}
+
+	a := parseAVI(t, f.data)
+	if a.end != len(f.data) {
+		t.Errorf("RIFF chunks end at %d of %d bytes", a.end, len(f.data))
+	}
+	if len(a.riffs) != 1 || a.riffs[0] != "AVI " {
+		t.Errorf("RIFF chunks %q", a.riffs)
+	}
+	if a.rate != 10 || a.scale != 1 || a.width != 64 {
+		t.Errorf("rate %d/%d width %d", a.rate, a.scale, a.width)
+	}
+	if a.totalFrames != 5 || a.length != 5 || a.odmlFrames != 5 || len(a.frames) != 5 || len(a.idx1) != 5 {
+		t.Fatalf("frames: avih %d, strh %d, dmlh %d, index %d, idx1 %d, want 5",
+			a.totalFrames, a.length, a.odmlFrames, len(a.frames), len(a.idx1))
+	}
+	checkFrames(t, a.frames, 64, map[int]bool{2: true})
+	for i := range a.frames {
+		if !bytes.Equal(a.frames[i], a.idx1[i]) {
+			t.Errorf("frame %d differs between the indexes", i)
+		}
+	}
+
+	if err := w.WriteFrame(testImage(64, 48, 0)); err == nil {
+		t.Error("frame written after Close")
+	}
+	w, _ = NewWriter(&memFile{}, 64, 48, Options{})
+	if err := w.WriteFrame(testImage(32, 48, 0)); err == nil {
+		t.Error("frame of the wrong size accepted")
+	}
+}
+
+func TestOpenDML(t *testing.T) {
+	defer func(limit int64) { riffLimit = limit }(riffLimit)
+	riffLimit = 200 << 10
+
+	f := &memFile{}
+	w, err := NewWriter(f, 160, 120, Options{FPS: 25, Quality: 95})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 60
+	for i := range n {
+		if err := w.WriteFrame(testImage(160, 120, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	a := parseAVI(t, f.data)
+	if a.end != len(f.data) {
+		t.Errorf("RIFF chunks end at %d of %d bytes", a.end, len(f.data))
+	}
+	if len(a.riffs) < 3 || a.riffs[0] != "AVI " || a.riffs[1] != "AVIX" {
+		t.Fatalf("RIFF chunks %q", a.riffs)
+	}
+	if len(a.frames) != n || a.length != n || a.odmlFrames != n {
+		t.Fatalf("index %d, strh %d, dmlh %d frames, want %d", len(a.frames), a.length, a.odmlFrames, n)
+	}
+	if a.totalFrames != uint32(len(a.idx1)) || len(a.idx1) == 0 || len(a.idx1) >= n {
+		t.Errorf("first RIFF chunk: avih %d frames, idx1 %d", a.totalFrames, len(a.idx1))
+	}
+	checkFrames(t, a.frames, 160, nil)
+}
+
+func TestCrashRecovery(t *testing.T) {
+	defer func(limit int64) { riffLimit = limit }(riffLimit)
+	for _, limit := range []int64{1 << 30, 20 << 10} {
+		riffLimit = limit
+		f := &memFile{}
+		w, err := NewWriter(f, 64, 48, Options{FPS: 10, Checkpoint: 500 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 23 || len(w.pending) == 0; i++ {
+			if err := w.WriteFrame(testImage(64, 48, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// No Close: the file stops between checkpoints, as if the process was
+		// killed. Checkpoints come every 5 frames and at every new RIFF chunk.
+		want := w.Frames() - len(w.pending)
+		if limit == 1<<30 && want != 20 || want < 20 {
+			t.Fatalf("limit %d: %d frames checkpointed", limit, want)
+		}
+		a := parseAVI(t, f.data)
+		if len(a.frames) != want || int(a.length) != want || int(a.odmlFrames) != want {
+			t.Fatalf("limit %d: index %d, strh %d, dmlh %d frames, want %d",
+				limit, len(a.frames), a.length, a.odmlFrames, want)
+		}
+		checkFrames(t, a.frames, 64, nil)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRecorder(RecorderOptions{
+		Options:         Options{FPS: 4},
+		Dir:             dir,
+		Prefix:          "session-",
+		SegmentDuration: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	for i := 0; i < 10; i++ {
+		img := testImage(64, 48, i)
+		if i == 9 {
+			img = testImage(80, 48, i)
+		}
+		// The capture missed frame 2, its slot repeats frame 1.
+		if i == 2 {
+			continue
+		}
+		f := screenshot.Frame{Image: img, Rect: img.Rect, Time: start.Add(time.Duration(250*i) * time.Millisecond)}
+		if err := r.Add(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Frames at 0-2000ms make segments from 0, 1000 and 2000ms, whose names share the
+	// second of the first one; the resized frame at 2250ms starts a fourth.
+	files := r.Files()
+	want := []string{"session-20240501-120000.avi", "session-20240501-120001.avi", "session-20240501-120002.avi", "session-20240501-120002-1.avi"}
+	if len(files) != len(want) {
+		t.Fatalf("files %q, want %q", files, want)
+	}
+	counts := []int{4, 4, 1, 1}
+	for i, path := range files {
+		if filepath.Base(path) != want[i] {
+			t.Errorf("file %d is %s, want %s", i, filepath.Base(path), want[i])
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := parseAVI(t, data)
+		if len(a.frames) != counts[i] {
+			t.Errorf("%s: %d frames, want %d", want[i], len(a.frames), counts[i])
+		}
+		repeats := map[int]bool{}
+		if i == 0 {
+			repeats[2] = true
+		}
+		checkFrames(t, a.frames, int(a.width), repeats)
+	}
+}
+
+func TestRecorderSize(t *testing.T) {
+	dir := t.TempDir()
+	const limit = 120 << 10
+	r, err := NewRecorder(RecorderOptions{Options: Options{FPS: 10, Quality: 90}, Dir: dir, SegmentSize: limit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1000, 0)
+	for i := range 30 {
+		img := testImage(160, 120, i)
+		if err := r.Add(screenshot.Frame{Image: img, Rect: img.Rect, Time: start.Add(time.Duration(i) * 100 * time.Millisecond)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Files()) < 2 {
+		t.Fatalf("%d files, want several", len(r.Files()))
+	}
+	total := 0
+	for _, path := range r.Files() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > limit {
+			t.Errorf("%s has %d bytes, more than %d", path, len(data), limit)
+		}
+		total += len(parseAVI(t, data).frames)
+	}
+	if total != 30 {
+		t.Errorf("%d frames in all files, want 30", total)
+	}
+}
+
+func TestRational(t *testing.T) {
+	for fps, want := range map[float64][2]uint32{10: {10, 1}, 29.97: {2997, 100}, 0.5: {1, 2}, 12.5: {25, 2}} {
+		if rate, scale := rational(fps); [2]uint32{rate, scale} != want {
+			t.Errorf("rational(%v) = %d/%d, want %d/%d", fps, rate, scale, want[0], want[1])
+		}
+	}
+}
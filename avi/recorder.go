@@ -0,0 +1,171 @@
+package avi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/encode"
+)
+
+// RecorderOptions configures a Recorder.
+type RecorderOptions struct {
+	Options
+	// Dir is the directory of the files, created if missing. Files are named after
+	// the time of their first frame, e.g. 20240501-120000.avi.
+	Dir string
+	// Prefix is prepended to file names.
+	Prefix string
+	// SegmentDuration starts a new file once a file spans this long, zero never.
+	SegmentDuration time.Duration
+	// SegmentSize starts a new file before a file would grow past this many bytes,
+	// zero never. A single frame larger than that still gets a file of its own.
+	SegmentSize int64
+}
+
+// Recorder writes a frame stream into a series of AVI files.
+type Recorder struct {
+	opts  RecorderOptions
+	file  *os.File
+	w     *Writer
+	start time.Time // first frame of the current file
+	files []string
+	jpeg  bytes.Buffer
+	err   error
+}
+
+// NewRecorder returns a Recorder. No file is created before the first frame.
+func NewRecorder(opts RecorderOptions) (*Recorder, error) {
+	if opts.SegmentDuration < 0 || opts.SegmentSize < 0 {
+		return nil, errors.New("avi: negative segment limit")
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Recorder{opts: opts}, nil
+}
+
+// Files returns the paths of the files written so far, the current one last.
+func (r *Recorder) Files() []string {
+	return r.files
+}
+
+// Add writes f, starting a new file when a segment limit is reached or the frame size
+// changes.
+func (r *Recorder) Add(f screenshot.Frame) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.jpeg.Reset()
+	if err := encode.JPEG(&r.jpeg, f.Image, &encode.JPEGOptions{Quality: r.opts.Quality}); err != nil {
+		return err
+	}
+	if r.w != nil && r.rotate(f) {
+		// Show the last frame until this one before moving on.
+		err := r.w.fill(r.w.slot(f.Time))
+		if cerr := r.closeFile(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return r.fail(err)
+		}
+	}
+	if r.w == nil {
+		if err := r.openFile(f.Image.Rect.Size(), f.Time); err != nil {
+			return r.fail(err)
+		}
+	}
+	slot := r.w.slot(f.Time)
+	if slot < r.w.frames {
+		return nil
+	}
+	if err := r.w.fill(slot); err != nil {
+		return r.fail(err)
+	}
+	if err := r.w.WriteJPEG(r.jpeg.Bytes()); err != nil {
+		return r.fail(err)
+	}
+	return nil
+}
+
+func (r *Recorder) rotate(f screenshot.Frame) bool {
+	switch {
+	case f.Image.Rect.Dx() != r.w.width || f.Image.Rect.Dy() != r.w.height:
+		return true
+	case r.opts.SegmentDuration > 0 && f.Time.Sub(r.start) >= r.opts.SegmentDuration:
+		return true
+	case r.opts.SegmentSize > 0 && r.w.sizeWith(max(r.w.slot(f.Time)-r.w.frames, 0), r.jpeg.Len()) > r.opts.SegmentSize:
+		return true
+	case len(r.w.super) >= superIndexSize-checkpointReserve:
+		return true
+	}
+	return false
+}
+
+func (r *Recorder) openFile(size image.Point, t time.Time) error {
+	name := r.opts.Prefix + t.Format("20060102-150405")
+	path := filepath.Join(r.opts.Dir, name+".avi")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	for n := 1; errors.Is(err, os.ErrExist); n++ {
+		path = filepath.Join(r.opts.Dir, fmt.Sprintf("%s-%d.avi", name, n))
+		file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	w, err := NewWriter(file, size.X, size.Y, r.opts.Options)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	w.start = t
+	r.file, r.w, r.start = file, w, t
+	r.files = append(r.files, path)
+	return nil
+}
+
+func (r *Recorder) closeFile() error {
+	err := r.w.Close()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.file, r.w = nil, nil
+	return err
+}
+
+// Close finishes the current file.
+func (r *Recorder) Close() error {
+	if r.w == nil {
+		return r.err
+	}
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+	return r.err
+}
+
+func (r *Recorder) fail(err error) error {
+	r.err = err
+	return err
+}
+
+// Record captures stream into AVI files until ctx is done, then finishes the last
+// file.
+func Record(ctx context.Context, stream screenshot.StreamOptions, opts RecorderOptions) error {
+	r, err := NewRecorder(opts)
+	if err != nil {
+		return err
+	}
+	err = screenshot.Stream(ctx, stream, r.Add)
+	if cerr := r.Close(); ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/avi"
+	"github.com/Fast-IQ/screenshot/encode"
+)
+
+func runArchive(args []string, stdout, stderr io.Writer) error {
+	fs, shared := newFlagSet("archive", stderr)
+	region := addRegionFlags(fs)
+	out := fs.String("out", "", "output directory, created if missing")
+	prefix := fs.String("prefix", "", "prefix of the file names")
+	fps := fs.Float64("fps", 5, "frames per second")
+	quality := fs.Int("quality", encode.DefaultJPEGQuality, "JPEG quality 1-100")
+	segment := fs.Duration("segment", 0, "start a new file after this long, 0 never")
+	segmentSize := fs.Int64("segment-size", 0, "start a new file before this many bytes, 0 never")
+	duration := fs.Duration("duration", 0, "stop after this long, 0 runs until interrupted")
+	sync := fs.Bool("sync", false, "flush files to disk at every index checkpoint")
+	if err := parseFlags(fs, shared, args); err != nil {
+		return err
+	}
+	if *out == "" || *out == "-" {
+		return usagef("archive needs an output directory, use --out DIR")
+	}
+	if *fps <= 0 || *fps > 1000 {
+		return usagef("fps must be in (0, 1000], got %v", *fps)
+	}
+	if *quality < 1 || *quality > 100 {
+		return usagef("quality must be 1-100, got %d", *quality)
+	}
+	if *segment < 0 || *segmentSize < 0 || *duration < 0 {
+		return usagef("segment, segment-size and duration must not be negative")
+	}
+	rect, err := region.resolve()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	r, err := avi.NewRecorder(avi.RecorderOptions{
+		Options:         avi.Options{FPS: *fps, Quality: *quality, Sync: *sync},
+		Dir:             *out,
+		Prefix:          *prefix,
+		SegmentDuration: *segment,
+		SegmentSize:     *segmentSize,
+	})
+	if err != nil {
+		return err
+	}
+	files := 0
+	err = screenshot.Stream(ctx, screenshot.StreamOptions{Rect: rect, FPS: *fps}, func(f screenshot.Frame) error {
+		if err := r.Add(f); err != nil {
+			return err
+		}
+		for ; files < len(r.Files()); files++ {
+			fmt.Fprintln(stdout, r.Files()[files])
+		}
+		return nil
+	})
+	if cerr := r.Close(); err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"runtime"
+)
+
+// backendOS lists the platforms each selectable backend is built for.
+var backendOS = map[string][]string{
+	"x11":     {"linux", "freebsd", "openbsd", "netbsd"},
+	"wayland": {"linux", "openbsd", "netbsd"},
+}
+
+// selectBackend forces a capture backend. The library picks the Linux backend from
+// XDG_SESSION_TYPE, so selecting one overrides that variable for this process.
+func selectBackend(name string) error {
+	if name == "" || name == "auto" {
+		return nil
+	}
+	goos, ok := backendOS[name]
+	if !ok {
+		return usagef("unknown backend %q", name)
+	}
+	for _, g := range goos {
+		if g == runtime.GOOS {
+			return os.Setenv("XDG_SESSION_TYPE", name)
+		}
+	}
+	return usagef("backend %q is not available on %s", name, runtime.GOOS)
+}
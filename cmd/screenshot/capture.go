@@ -0,0 +1,62 @@
+package main
+
+import (
+	"image/color"
+	"io"
+	"strconv"
+
+	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/icc"
+)
+
+func runCapture(args []string, stdout, stderr io.Writer) error {
+	fs, shared := newFlagSet("capture", stderr)
+	region := addRegionFlags(fs)
+	format := addFormatFlags(fs)
+	out := fs.String("out", "-", "output file, - for stdout")
+	var opts screenshot.CaptureOptions
+	fs.Func("exclude-window", "leave out the window with this `ID`, as listed by 'screenshot windows'; repeatable", func(s string) error {
+		id, err := strconv.ParseUint(s, 0, 64)
+		if err != nil {
+			return usagef("invalid window id %q", s)
+		}
+		opts.ExcludeWindows = append(opts.ExcludeWindows, screenshot.WindowID(id))
+		return nil
+	})
+	fs.Func("color-space", "convert to this colour space from that of the displays: srgb or p3", func(s string) error {
+		switch s {
+		case "srgb":
+			opts.ColorSpace = icc.SRGB
+		case "p3":
+			opts.ColorSpace = icc.DisplayP3
+		default:
+			return usagef("unknown colour space %q", s)
+		}
+		return nil
+	})
+	if err := parseFlags(fs, shared, args); err != nil {
+		return err
+	}
+	if err := format.resolve(*out); err != nil {
+		return err
+	}
+
+	// The whole desktop is stitched from the displays so the holes between them are black.
+	if region.all && region.rect == "" {
+		desktop, err := screenshot.CaptureVirtualDesktopWith(screenshot.NewCapturer(opts), screenshot.VirtualDesktopOptions{Fill: color.Black})
+		if err != nil {
+			return err
+		}
+		return format.writeImage(*out, stdout, desktop.Image)
+	}
+
+	rect, err := region.resolve()
+	if err != nil {
+		return err
+	}
+	img, err := screenshot.CaptureWithOptions(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy(), opts)
+	if err != nil {
+		return err
+	}
+	return format.writeImage(*out, stdout, img)
+}
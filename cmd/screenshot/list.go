@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/Fast-IQ/screenshot"
+)
+
+type displayJSON struct {
+	Index   int  `json:"index"`
+	X       int  `json:"x"`
+	Y       int  `json:"y"`
+	Width   int  `json:"width"`
+	Height  int  `json:"height"`
+	Primary bool `json:"primary"`
+}
+
+type windowJSON struct {
+	ID     uint64 `json:"id"`
+	Title  string `json:"title"`
+	Class  string `json:"class"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+func runDisplays(args []string, stdout, stderr io.Writer) error {
+	fs, shared := newFlagSet("displays", stderr)
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := parseFlags(fs, shared, args); err != nil {
+		return err
+	}
+
+	bounds, err := screenshot.GetAllDisplayBounds()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		displays := make([]displayJSON, len(bounds))
+		for i, b := range bounds {
+			displays[i] = displayJSON{
+				Index:   i,
+				X:       b.Min.X,
+				Y:       b.Min.Y,
+				Width:   b.Dx(),
+				Height:  b.Dy(),
+				Primary: i == 0,
+			}
+		}
+		return writeJSON(stdout, displays)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tGEOMETRY\tPRIMARY")
+	for i, b := range bounds {
+		fmt.Fprintf(tw, "%d\t%s\t%v\n", i, formatRect(b), i == 0)
+	}
+	return tw.Flush()
+}
+
+func runWindows(args []string, stdout, stderr io.Writer) error {
+	fs, shared := newFlagSet("windows", stderr)
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := parseFlags(fs, shared, args); err != nil {
+		return err
+	}
+
+	windows, err := screenshot.ListWindows()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		list := make([]windowJSON, len(windows))
+		for i, w := range windows {
+			list[i] = windowJSON{
+				ID:     uint64(w.ID),
+				Title:  w.Title,
+				Class:  w.Class,
+				X:      w.Bounds.Min.X,
+				Y:      w.Bounds.Min.Y,
+				Width:  w.Bounds.Dx(),
+				Height: w.Bounds.Dy(),
+			}
+		}
+		return writeJSON(stdout, list)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tGEOMETRY\tCLASS\tTITLE")
+	for _, w := range windows {
+		fmt.Fprintf(tw, "0x%x\t%s\t%s\t%s\n", uint64(w.ID), formatRect(w.Bounds), w.Class, w.Title)
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,160 @@
+// Command screenshot captures the desktop from the command line.
+//
+// Usage:
+//
+//	screenshot capture [--display N | --all] [--rect x,y,w,h] [--format png|jpeg|qoi|webp] [--quality Q] [--subsampling 420|422|444] [--exclude-window ID] [--out FILE|-]
+//	screenshot displays [--json]
+//	screenshot windows [--json]
+//	screenshot watch --out DIR [--fps F] [--count N] [--display N] [--rect x,y,w,h] [--format png|jpeg|qoi|webp]
+//	screenshot timelapse --out DIR [--every D | --cron SPEC] [--display N] [--max-distance BITS] [--max-count N] [--max-age D] [--max-size BYTES] [--format png|jpeg|qoi|webp]
+//	screenshot record --out FILE.gif|FILE.png [--duration D] [--fps F] [--scale S] [--max-size BYTES] [--display N] [--rect x,y,w,h]
+//	screenshot archive --out DIR [--fps F] [--quality Q] [--segment D] [--segment-size BYTES] [--duration D] [--display N] [--rect x,y,w,h]
+//	screenshot video [--out FILE|-] [--format y4m|i420|nv12] [--fps F] [--duration D] [--matrix 601|709] [--range limited|full] [--display N] [--rect x,y,w,h]
+//	screenshot serve [--addr HOST:PORT] [--token T] [--cache D] [--max-concurrent N] [--max-pixels N]
+//	screenshot vnc [--addr HOST:PORT] [--display N] [--fps F] [--password P]
+//
+// Every subcommand accepts --backend auto|x11|wayland to force a Linux capture backend,
+// and --redact rect=x,y,w,h|display=N|class=REGEX|title=REGEX, repeatable, with
+// --redact-effect black|pixelate|blur to mask parts of every capture.
+//
+// Exit codes: 0 on success, 1 when capturing or writing fails, 2 on invalid usage,
+// 3 when the platform or backend does not support the operation.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Fast-IQ/screenshot"
+)
+
+const (
+	exitOK          = 0
+	exitFailure     = 1
+	exitUsage       = 2
+	exitUnsupported = 3
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) error
+}
+
+var commands = []command{
+	{"capture", "capture a display or region to a file or stdout", runCapture},
+	{"displays", "list active displays", runDisplays},
+	{"windows", "list visible top-level windows", runWindows},
+	{"watch", "capture periodically into a directory", runWatch},
+	{"timelapse", "capture on a schedule into a dated directory tree", runTimelapse},
+	{"record", "record an animated GIF or APNG clip", runRecord},
+	{"archive", "record Motion-JPEG AVI files into a directory", runArchive},
+	{"video", "stream raw YUV video for external encoders", runVideo},
+	{"serve", "serve captures over HTTP", runServe},
+	{"vnc", "serve the desktop to VNC viewers", runVNC},
+}
+
+// usageError marks errors caused by invalid arguments.
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		printUsage(stderr)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		err := cmd.run(args[1:], stdout, stderr)
+		return exitCode(stderr, err)
+	}
+
+	fmt.Fprintf(stderr, "screenshot: unknown command %q\n", args[0])
+	printUsage(stderr)
+	return exitUsage
+}
+
+func exitCode(stderr io.Writer, err error) int {
+	var uerr usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &uerr):
+		fmt.Fprintf(stderr, "screenshot: %v\n", err)
+		return exitUsage
+	case errors.Is(err, screenshot.ErrUnsupported):
+		fmt.Fprintf(stderr, "screenshot: %v\n", err)
+		return exitUnsupported
+	default:
+		fmt.Fprintf(stderr, "screenshot: %v\n", err)
+		return exitFailure
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: screenshot <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'screenshot <command> -h' for the flags of a command.")
+}
+
+// sharedFlags are the flags every command accepts.
+type sharedFlags struct {
+	backend string
+	redact  redactFlags
+}
+
+// newFlagSet returns a flag set that reports parse errors instead of exiting,
+// with the --backend and --redact flags every command shares.
+func newFlagSet(name string, stderr io.Writer) (*flag.FlagSet, *sharedFlags) {
+	fs := flag.NewFlagSet("screenshot "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	shared := &sharedFlags{}
+	fs.StringVar(&shared.backend, "backend", "auto", "capture backend: auto, x11 or wayland (Linux only)")
+	shared.redact.register(fs)
+	return fs, shared
+}
+
+// parseFlags parses args, applies the backend selection and installs the redaction
+// rules.
+func parseFlags(fs *flag.FlagSet, shared *sharedFlags, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{err}
+	}
+	if fs.NArg() > 0 {
+		return usagef("unexpected arguments: %v", fs.Args())
+	}
+	if err := selectBackend(shared.backend); err != nil {
+		return err
+	}
+	return shared.redact.install()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/Fast-IQ/screenshot/encode"
+)
+
+func TestParseRect(t *testing.T) {
+	r, err := parseRect("10, 20,30,40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != image.Rect(10, 20, 40, 60) {
+		t.Errorf("got %v", r)
+	}
+	for _, s := range []string{"", "1,2,3", "1,2,0,4", "a,b,c,d"} {
+		if _, err := parseRect(s); err == nil {
+			t.Errorf("parseRect(%q): expected error", s)
+		}
+	}
+}
+
+func TestFormatResolve(t *testing.T) {
+	tests := []struct {
+		format, out, want string
+		wantErr           bool
+	}{
+		{"", "-", "png", false},
+		{"", "shot.JPG", "jpeg", false},
+		{"jpg", "-", "jpeg", false},
+		{"png", "shot.jpg", "png", false},
+		{"", "shot.webp", "webp", false},
+		{"QOI", "-", "qoi", false},
+		{"bmp", "-", "", true},
+	}
+	for _, tt := range tests {
+		f := &formatFlags{name: tt.format, quality: 90, subsampling: "420"}
+		err := f.resolve(tt.out)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolve(%q, %q): err %v", tt.format, tt.out, err)
+			continue
+		}
+		if err == nil && string(f.format) != tt.want {
+			t.Errorf("resolve(%q, %q) = %q, want %q", tt.format, tt.out, f.format, tt.want)
+		}
+	}
+
+	f := &formatFlags{name: "jpeg", quality: 90, subsampling: "444"}
+	if err := f.resolve("-"); err != nil || f.opts.JPEG.Subsampling != encode.Subsampling444 || f.opts.JPEG.Quality != 90 {
+		t.Errorf("resolve with subsampling 444: %+v, %v", f.opts.JPEG, err)
+	}
+	f = &formatFlags{name: "jpeg", quality: 90, subsampling: "411"}
+	if err := f.resolve("-"); err == nil {
+		t.Error("resolve with subsampling 411: expected error")
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{nil, exitUsage},
+		{[]string{"help"}, exitOK},
+		{[]string{"nope"}, exitUsage},
+		{[]string{"capture", "--bogus"}, exitUsage},
+		{[]string{"capture", "--format", "bmp"}, exitUsage},
+		{[]string{"capture", "--backend", "quartz"}, exitUsage},
+		{[]string{"watch", "--fps", "5"}, exitUsage},
+		{[]string{"record", "--fps", "5"}, exitUsage},
+		{[]string{"record", "--out", "clip.mp4"}, exitUsage},
+		{[]string{"record", "--out", "clip.gif", "--scale", "2"}, exitUsage},
+		{[]string{"archive", "--fps", "5"}, exitUsage},
+		{[]string{"archive", "--out", "dir", "--quality", "0"}, exitUsage},
+		{[]string{"timelapse", "--every", "1m"}, exitUsage},
+		{[]string{"timelapse", "--out", "dir", "--cron", "* * *"}, exitUsage},
+		{[]string{"timelapse", "--out", "dir", "--every", "1m", "--cron", "@daily"}, exitUsage},
+		{[]string{"capture", "--exclude-window", "indicator"}, exitUsage},
+		{[]string{"capture", "--color-space", "adobe"}, exitUsage},
+		{[]string{"capture", "--redact", "window=x"}, exitUsage},
+		{[]string{"capture", "--redact", "title=("}, exitUsage},
+		{[]string{"capture", "--redact", "display=0", "--redact-effect", "smudge"}, exitUsage},
+		{[]string{"video", "--format", "h264"}, exitUsage},
+		{[]string{"video", "--matrix", "2020"}, exitUsage},
+		{[]string{"displays", "-h"}, exitOK},
+		{[]string{"vnc", "--fps", "0"}, exitUsage},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		if got := run(tt.args, &stdout, &stderr); got != tt.want {
+			t.Errorf("run(%q) = %d, want %d; stderr: %s", tt.args, got, tt.want, strings.TrimSpace(stderr.String()))
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/encode"
+)
+
+// formatFlags are the image encoding flags shared by capture and watch.
+type formatFlags struct {
+	name        string
+	quality     int
+	subsampling string
+
+	format encode.Format
+	opts   encode.Options
+}
+
+func addFormatFlags(fs *flag.FlagSet) *formatFlags {
+	f := &formatFlags{}
+	fs.StringVar(&f.name, "format", "", "image format: png, jpeg, qoi or webp (default from --out extension, else png)")
+	fs.IntVar(&f.quality, "quality", encode.DefaultJPEGQuality, "JPEG quality, 1-100")
+	fs.StringVar(&f.subsampling, "subsampling", "420", "JPEG chroma subsampling: 420, 422 or 444")
+	return f
+}
+
+// resolve validates the flags, inferring the format from the output path when it is not given.
+func (f *formatFlags) resolve(out string) error {
+	f.format = encode.FormatPNG
+	if f.name != "" {
+		var err error
+		if f.format, err = encode.ParseFormat(f.name); err != nil {
+			return usagef("unsupported format %q", f.name)
+		}
+	} else if format, ok := encode.FormatFromPath(out); ok {
+		f.format = format
+	}
+	if f.format != encode.FormatJPEG {
+		return nil
+	}
+	if f.quality < 1 || f.quality > 100 {
+		return usagef("quality must be between 1 and 100, got %d", f.quality)
+	}
+	switch f.subsampling {
+	case "420":
+		f.opts.JPEG.Subsampling = encode.Subsampling420
+	case "422":
+		f.opts.JPEG.Subsampling = encode.Subsampling422
+	case "444":
+		f.opts.JPEG.Subsampling = encode.Subsampling444
+	default:
+		return usagef("subsampling must be 420, 422 or 444, got %q", f.subsampling)
+	}
+	f.opts.JPEG.Quality = f.quality
+	return nil
+}
+
+func (f *formatFlags) ext() string {
+	return f.format.Extension()
+}
+
+func (f *formatFlags) encode(w io.Writer, img *image.RGBA) error {
+	return encode.Encode(w, img, f.format, &f.opts)
+}
+
+// writeImage encodes img to path, or to stdout when path is "-".
+// A partially written file is removed on failure.
+func (f *formatFlags) writeImage(path string, stdout io.Writer, img *image.RGBA) error {
+	if path == "-" {
+		return f.encode(stdout, img)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = f.encode(file, img)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return err
+}
+
+// regionFlags select what to capture, shared by capture and watch.
+type regionFlags struct {
+	display int
+	rect    string
+	all     bool
+}
+
+func addRegionFlags(fs *flag.FlagSet) *regionFlags {
+	r := &regionFlags{}
+	fs.IntVar(&r.display, "display", 0, "display index, 0 is the primary display")
+	fs.StringVar(&r.rect, "rect", "", "region x,y,w,h relative to the selected display")
+	fs.BoolVar(&r.all, "all", false, "capture the whole virtual desktop")
+	return r
+}
+
+// resolve returns the desktop rectangle selected by the flags.
+func (r *regionFlags) resolve() (image.Rectangle, error) {
+	var base image.Rectangle
+	if r.all {
+		bounds, err := screenshot.GetAllDisplayBounds()
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+		for _, b := range bounds {
+			base = base.Union(b)
+		}
+	} else {
+		if r.display < 0 {
+			return image.Rectangle{}, usagef("invalid display index %d", r.display)
+		}
+		n := screenshot.NumActiveDisplays()
+		if n > 0 && r.display >= n {
+			return image.Rectangle{}, usagef("display %d does not exist, %d active", r.display, n)
+		}
+		var err error
+		base, err = screenshot.GetDisplayBounds(r.display)
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+	}
+	if r.rect == "" {
+		return base, nil
+	}
+	rect, err := parseRect(r.rect)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	return rect.Add(base.Min), nil
+}
+
+// parseRect parses "x,y,w,h".
+func parseRect(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, usagef("invalid rect %q, want x,y,w,h", s)
+	}
+	var v [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return image.Rectangle{}, usagef("invalid rect %q: %v", s, err)
+		}
+		v[i] = n
+	}
+	if v[2] <= 0 || v[3] <= 0 {
+		return image.Rectangle{}, usagef("invalid rect %q: width and height must be > 0", s)
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+func formatRect(r image.Rectangle) string {
+	return fmt.Sprintf("%dx%d+%d+%d", r.Dx(), r.Dy(), r.Min.X, r.Min.Y)
+}
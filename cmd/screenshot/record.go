@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/record"
+)
+
+func runRecord(args []string, stdout, stderr io.Writer) error {
+	fs, shared := newFlagSet("record", stderr)
+	region := addRegionFlags(fs)
+	out := fs.String("out", "", "output file, .gif or .png, or - for stdout")
+	format := fs.String("format", "", "gif or apng, default from the --out extension")
+	fps := fs.Float64("fps", 10, "frames per second")
+	duration := fs.Duration("duration", 10*time.Second, "stop after this long, 0 runs until interrupted")
+	scale := fs.Float64("scale", 1, "downscale factor in (0, 1]")
+	maxSize := fs.Int("max-size", 0, "stop before the file exceeds this many bytes, 0 for no limit")
+	noDither := fs.Bool("no-dither", false, "map GIF colours to the nearest palette entry")
+	if err := parseFlags(fs, shared, args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return usagef("record needs an output file, use --out FILE")
+	}
+	if *fps <= 0 {
+		return usagef("fps must be > 0, got %v", *fps)
+	}
+	if *scale <= 0 || *scale > 1 {
+		return usagef("scale must be in (0, 1], got %v", *scale)
+	}
+	if *duration < 0 || *maxSize < 0 {
+		return usagef("duration and max-size must not be negative")
+	}
+	opts := record.Options{
+		Format:      record.Format(*format),
+		Scale:       *scale,
+		MaxDuration: *duration,
+		MaxBytes:    *maxSize,
+		NoDither:    *noDither,
+	}
+	switch {
+	case *format == "" && *out == "-":
+		return usagef("record to stdout needs --format gif|apng")
+	case *format == "":
+		f, ok := record.FormatFromPath(*out)
+		if !ok {
+			return usagef("cannot tell the format of %q, use --format gif|apng", *out)
+		}
+		opts.Format = f
+	case opts.Format != record.FormatGIF && opts.Format != record.FormatAPNG:
+		return usagef("unknown format %q, want gif or apng", *format)
+	}
+	rect, err := region.resolve()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stream := screenshot.StreamOptions{Rect: rect, FPS: *fps}
+	if *out == "-" {
+		return record.Record(ctx, stdout, stream, opts)
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = record.Record(ctx, file, stream, opts)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(*out)
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Fast-IQ/screenshot/redact"
+)
+
+// redactFlags collect the redaction rules of the --redact flags.
+type redactFlags struct {
+	targets []redact.Target
+	effect  string
+}
+
+func (r *redactFlags) register(fs *flag.FlagSet) {
+	fs.Func("redact", "mask `KIND=VALUE` in every capture: rect=x,y,w,h (desktop coordinates), display=N, class=REGEX or title=REGEX; repeatable", r.add)
+	fs.StringVar(&r.effect, "redact-effect", "black", "redaction effect: black, pixelate or blur")
+}
+
+func (r *redactFlags) add(s string) error {
+	kind, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return usagef("invalid redaction %q, want KIND=VALUE", s)
+	}
+	switch kind {
+	case "rect":
+		rect, err := parseRect(value)
+		if err != nil {
+			return err
+		}
+		r.targets = append(r.targets, redact.Rect(rect))
+	case "display":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return usagef("invalid display index %q", value)
+		}
+		r.targets = append(r.targets, redact.Display(n))
+	case "class", "title":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return usagef("invalid %s pattern: %v", kind, err)
+		}
+		if kind == "class" {
+			r.targets = append(r.targets, redact.Windows(re, nil))
+		} else {
+			r.targets = append(r.targets, redact.Windows(nil, re))
+		}
+	default:
+		return usagef("unknown redaction kind %q, want rect, display, class or title", kind)
+	}
+	return nil
+}
+
+// install makes every capture of this process apply the rules.
+func (r *redactFlags) install() error {
+	effect, err := redact.ParseEffect(r.effect)
+	if err != nil {
+		return usagef("unknown redaction effect %q, want black, pixelate or blur", r.effect)
+	}
+	if len(r.targets) == 0 {
+		return nil
+	}
+	rules := make([]redact.Rule, len(r.targets))
+	for i, t := range r.targets {
+		rules[i] = redact.Rule{Target: t, Effect: effect}
+	}
+	redact.New(redact.Options{}, rules...).Install()
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Fast-IQ/screenshot/server"
+)
+
+func runServe(args []string, stdout, stderr io.Writer) error {
+	fs, shared := newFlagSet("serve", stderr)
+	addr := fs.String("addr", "127.0.0.1:8080", "listen address")
+	token := fs.String("token", "", "require this bearer token, defaults to $SCREENSHOT_TOKEN")
+	cache := fs.Duration("cache", time.Second, "reuse a captured frame for this long")
+	maxConcurrent := fs.Int("max-concurrent", 1, "maximum number of simultaneous captures")
+	maxPixels := fs.Int("max-pixels", server.DefaultMaxPixels, "maximum area of a requested region")
+	if err := parseFlags(fs, shared, args); err != nil {
+		return err
+	}
+	if *token == "" {
+		*token = os.Getenv("SCREENSHOT_TOKEN")
+	}
+
+	srv := &http.Server{
+		Addr: *addr,
+		Handler: server.New(server.Options{
+			CacheTTL:      *cache,
+			MaxConcurrent: *maxConcurrent,
+			MaxPixels:     *maxPixels,
+			Token:         *token,
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(stderr, "screenshot: serving on http://%s\n", *addr)
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
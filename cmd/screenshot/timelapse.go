@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Fast-IQ/screenshot/timelapse"
+)
+
+func runTimelapse(args []string, stdout, stderr io.Writer) error {
+	fs, shared := newFlagSet("timelapse", stderr)
+	format := addFormatFlags(fs)
+	out := fs.String("out", "", "root of the output tree, created if missing")
+	every := fs.Duration("every", 0, "capture interval (default 1m)")
+	cron := fs.String("cron", "", "capture on a cron schedule, e.g. \"*/5 9-17 * * 1-5\"")
+	display := fs.Int("display", -1, "display index, -1 captures every display")
+	keep := fs.Bool("keep-identical", false, "save captures identical to the previous one")
+	distance := fs.Int("max-distance", 0, "also skip captures within this many bits (0-64) of the previous perceptual hash")
+	maxCount := fs.Int("max-count", 0, "keep at most this many files, 0 no limit")
+	maxAge := fs.Duration("max-age", 0, "remove files older than this, 0 no limit")
+	maxSize := fs.Int64("max-size", 0, "keep at most this many bytes, 0 no limit")
+	duration := fs.Duration("duration", 0, "stop after this long, 0 runs until interrupted")
+	if err := parseFlags(fs, shared, args); err != nil {
+		return err
+	}
+	if *out == "" || *out == "-" {
+		return usagef("timelapse needs an output directory, use --out DIR")
+	}
+	if *distance < 0 || *distance > 64 {
+		return usagef("max-distance must be 0-64, got %d", *distance)
+	}
+	if *every < 0 || *maxCount < 0 || *maxAge < 0 || *maxSize < 0 || *duration < 0 {
+		return usagef("every, max-count, max-age, max-size and duration must not be negative")
+	}
+	var schedule timelapse.Schedule
+	switch {
+	case *cron != "" && *every > 0:
+		return usagef("use either --every or --cron")
+	case *cron != "":
+		var err error
+		if schedule, err = timelapse.ParseCron(*cron); err != nil {
+			return usageError{err}
+		}
+	case *every > 0:
+		schedule = timelapse.Every(*every)
+	}
+	if err := format.resolve(""); err != nil {
+		return err
+	}
+	var displays []int
+	if *display < -1 {
+		return usagef("invalid display index %d", *display)
+	} else if *display >= 0 {
+		displays = []int{*display}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	err := timelapse.Run(ctx, timelapse.Options{
+		Dir:           *out,
+		Schedule:      schedule,
+		Displays:      displays,
+		Format:        format.format,
+		Encode:        &format.opts,
+		KeepIdentical: *keep,
+		MaxDistance:   *distance,
+		Retention:     timelapse.Retention{MaxCount: *maxCount, MaxAge: *maxAge, MaxBytes: *maxSize},
+		OnSave:        func(f timelapse.File) { fmt.Fprintln(stdout, f.Path) },
+	})
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
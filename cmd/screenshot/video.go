@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/yuv"
+)
+
+func runVideo(args []string, stdout, stderr io.Writer) error {
+	fs, shared := newFlagSet("video", stderr)
+	region := addRegionFlags(fs)
+	out := fs.String("out", "-", "output file or - for stdout")
+	format := fs.String("format", "y4m", "y4m, or i420 or nv12 for headerless frames")
+	fps := fs.Float64("fps", 30, "frames per second")
+	duration := fs.Duration("duration", 0, "stop after this long, 0 runs until interrupted")
+	matrix := fs.String("matrix", "709", "colour matrix: 601 or 709")
+	colorRange := fs.String("range", "limited", "colour range: limited or full")
+	if err := parseFlags(fs, shared, args); err != nil {
+		return err
+	}
+	if *fps <= 0 {
+		return usagef("fps must be > 0, got %v", *fps)
+	}
+	var cs yuv.Colorspace
+	switch *matrix {
+	case "601":
+		cs.Matrix = yuv.BT601
+	case "709":
+		cs.Matrix = yuv.BT709
+	default:
+		return usagef("unknown matrix %q, want 601 or 709", *matrix)
+	}
+	switch *colorRange {
+	case "limited":
+		cs.Range = yuv.Limited
+	case "full":
+		cs.Range = yuv.Full
+	default:
+		return usagef("unknown range %q, want limited or full", *colorRange)
+	}
+	switch *format {
+	case "y4m", "i420", "nv12":
+	default:
+		return usagef("unknown format %q, want y4m, i420 or nv12", *format)
+	}
+	rect, err := region.resolve()
+	if err != nil {
+		return err
+	}
+
+	if *out == "-" {
+		return writeVideo(stdout, rect, *format, *fps, *duration, cs)
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = writeVideo(file, rect, *format, *fps, *duration, cs)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writeVideo(w io.Writer, rect image.Rectangle, format string, fps float64, duration time.Duration, cs yuv.Colorspace) error {
+	bw := bufio.NewWriterSize(w, 1<<20)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, duration)
+		defer cancel()
+	}
+
+	// Y4M frames follow the capture clock, raw frames are written as captured.
+	var y4m *yuv.Y4MWriter
+	var frame []byte
+	convert := yuv.I420
+	if format == "nv12" {
+		convert = yuv.NV12
+	}
+	err := screenshot.Stream(ctx, screenshot.StreamOptions{Rect: rect, FPS: fps}, func(f screenshot.Frame) error {
+		if format != "y4m" {
+			frame = convert(frame, f.Image, cs)
+			_, err := bw.Write(frame)
+			return err
+		}
+		if y4m == nil {
+			var err error
+			y4m, err = yuv.NewY4MWriter(bw, f.Image.Rect.Dx(), f.Image.Rect.Dy(), yuv.Y4MOptions{FPS: fps, Colorspace: cs})
+			if err != nil {
+				return err
+			}
+		}
+		return y4m.WriteFrameAt(f.Image, f.Time)
+	})
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return bw.Flush()
+}
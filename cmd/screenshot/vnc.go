@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Fast-IQ/screenshot/vnc"
+)
+
+func runVNC(args []string, stdout, stderr io.Writer) error {
+	fs, shared := newFlagSet("vnc", stderr)
+	addr := fs.String("addr", "127.0.0.1:5900", "listen address")
+	display := fs.Int("display", 0, "display to serve")
+	fps := fs.Float64("fps", 10, "capture rate")
+	password := fs.String("password", "", "require VNC authentication with this password, defaults to $SCREENSHOT_VNC_PASSWORD")
+	if err := parseFlags(fs, shared, args); err != nil {
+		return err
+	}
+	if *fps <= 0 {
+		return usagef("--fps must be > 0")
+	}
+	if *password == "" {
+		*password = os.Getenv("SCREENSHOT_VNC_PASSWORD")
+	}
+
+	// Input injection is not available from the command line, viewers only watch.
+	srv := vnc.New(vnc.Options{
+		Display:  *display,
+		FPS:      *fps,
+		Password: *password,
+		ViewOnly: true,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	fmt.Fprintf(stderr, "screenshot: serving VNC on %s\n", *addr)
+	err := srv.ListenAndServe(*addr)
+	if errors.Is(err, vnc.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/Fast-IQ/screenshot"
+)
+
+var errDone = errors.New("done")
+
+func runWatch(args []string, stdout, stderr io.Writer) error {
+	fs, shared := newFlagSet("watch", stderr)
+	region := addRegionFlags(fs)
+	format := addFormatFlags(fs)
+	out := fs.String("out", "", "output directory, created if missing")
+	fps := fs.Float64("fps", 1, "frames per second")
+	count := fs.Int("count", 0, "stop after this many frames, 0 runs until interrupted")
+	duration := fs.Duration("duration", 0, "stop after this long, 0 runs until interrupted")
+	if err := parseFlags(fs, shared, args); err != nil {
+		return err
+	}
+	if *out == "" || *out == "-" {
+		return usagef("watch needs an output directory, use --out DIR")
+	}
+	if *fps <= 0 {
+		return usagef("fps must be > 0, got %v", *fps)
+	}
+	if err := format.resolve(""); err != nil {
+		return err
+	}
+	rect, err := region.resolve()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	err = screenshot.Stream(ctx, screenshot.StreamOptions{Rect: rect, FPS: *fps}, func(f screenshot.Frame) error {
+		name := fmt.Sprintf("%s-%06d%s", f.Time.Format("20060102-150405.000"), f.Seq, format.ext())
+		path := filepath.Join(*out, name)
+		if err := format.writeImage(path, stdout, f.Image); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, path)
+		if *count > 0 && f.Seq+1 >= uint64(*count) {
+			return errDone
+		}
+		return nil
+	})
+	if errors.Is(err, errDone) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,239 @@
+package screenshot
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"slices"
+	"sync"
+
+	"github.com/Fast-IQ/screenshot/icc"
+)
+
+// colorCache keeps the parsed display profiles, by their bytes, and the transforms
+// built from them, so that repeated captures parse and build nothing. It also
+// keeps the colour space of each display of the latest layout, so that captures
+// read no profiles until the displays change.
+var colorCache struct {
+	sync.Mutex
+	profiles   map[string]*icc.Profile
+	transforms map[[2]*icc.Profile]*icc.Transform
+	layout     []image.Rectangle // display bounds the displays were read with
+	displays   map[int]*icc.Profile
+}
+
+// DisplayColorSpace returns the colour space of the displayIndex'th display, from
+// the ICC profile the system assigns it: the _ICC_PROFILE property of the root
+// window on X11, _ICC_PROFILE_1 and so on for the other displays, the display
+// colour space on macOS and the monitor's ICM profile on Windows. A display without
+// a profile is taken to be sRGB and returns icc.SRGB. On Wayland it returns
+// ErrUnsupported.
+//
+// Captures hold the values of the framebuffer as they are, in this colour space.
+// They read the profiles once per display layout; DisplayColorSpace always reads
+// the current one, which later captures then use.
+func DisplayColorSpace(displayIndex int) (*icc.Profile, error) {
+	bounds, err := GetAllDisplayBounds()
+	if err != nil {
+		return nil, err
+	}
+	return readDisplayColorSpace(displayIndex, bounds)
+}
+
+// displayColorSpace returns the colour space of the displayIndex'th display of the
+// layout bounds, read once per layout.
+func displayColorSpace(displayIndex int, bounds []image.Rectangle) (*icc.Profile, error) {
+	colorCache.Lock()
+	p, ok := colorCache.displays[displayIndex]
+	ok = ok && slices.Equal(colorCache.layout, bounds)
+	colorCache.Unlock()
+	if ok {
+		return p, nil
+	}
+	return readDisplayColorSpace(displayIndex, bounds)
+}
+
+// readDisplayColorSpace reads the colour space of the displayIndex'th display of
+// the layout bounds, see DisplayColorSpace, and caches it for displayColorSpace.
+func readDisplayColorSpace(displayIndex int, bounds []image.Rectangle) (*icc.Profile, error) {
+	if displayIndex < 0 || displayIndex >= len(bounds) {
+		return nil, fmt.Errorf("invalid display index: %d", displayIndex)
+	}
+	data, err := displayProfile(displayIndex)
+
+	colorCache.Lock()
+	defer colorCache.Unlock()
+	if !slices.Equal(colorCache.layout, bounds) {
+		colorCache.layout = slices.Clone(bounds)
+		colorCache.displays = make(map[int]*icc.Profile)
+	}
+	p := icc.SRGB
+	if err == nil && len(data) > 0 {
+		p, err = parseProfile(data)
+		if err != nil {
+			err = fmt.Errorf("display %d: %w", displayIndex, err)
+		}
+	}
+	if err != nil {
+		delete(colorCache.displays, displayIndex)
+		return nil, err
+	}
+	colorCache.displays[displayIndex] = p
+	return p, nil
+}
+
+// parseProfile returns the cached profile parsed from data. colorCache must be
+// locked.
+func parseProfile(data []byte) (*icc.Profile, error) {
+	if p, ok := colorCache.profiles[string(data)]; ok {
+		return p, nil
+	}
+	p, err := icc.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if colorCache.profiles == nil {
+		colorCache.profiles = make(map[string]*icc.Profile)
+	}
+	colorCache.profiles[string(data)] = p
+	return p, nil
+}
+
+// colorTransform returns the cached transform from src to dst.
+func colorTransform(src, dst *icc.Profile) (*icc.Transform, error) {
+	colorCache.Lock()
+	defer colorCache.Unlock()
+	key := [2]*icc.Profile{src, dst}
+	if t, ok := colorCache.transforms[key]; ok {
+		return t, nil
+	}
+	t, err := icc.NewTransform(src, dst)
+	if err != nil {
+		return nil, err
+	}
+	if colorCache.transforms == nil {
+		colorCache.transforms = make(map[[2]*icc.Profile]*icc.Transform)
+	}
+	colorCache.transforms[key] = t
+	return t, nil
+}
+
+// convertCapture converts img, a capture of rect, to dst from the colour spaces of
+// the displays it covers.
+func convertCapture(img *image.RGBA, rect image.Rectangle, dst *icc.Profile) error {
+	bounds, err := GetAllDisplayBounds()
+	if err != nil {
+		return err
+	}
+	source := func(i int) (*icc.Profile, error) { return displayColorSpace(i, bounds) }
+	return convertDisplays(img, rect, bounds, source, dst)
+}
+
+// convertDisplays converts the part of img, a capture of rect, on each display to
+// dst from the colour space source returns for it, sRGB where it is unsupported.
+// Where displays overlap, the pixels are converted once, from the first of them.
+func convertDisplays(img *image.RGBA, rect image.Rectangle, bounds []image.Rectangle, source func(int) (*icc.Profile, error), dst *icc.Profile) error {
+	for i, b := range bounds {
+		parts := []image.Rectangle{b.Intersect(rect)}
+		for _, prev := range bounds[:i] {
+			var rest []image.Rectangle
+			for _, p := range parts {
+				rest = append(rest, subtract(p, prev)...)
+			}
+			parts = rest
+		}
+		if len(parts) == 0 || parts[0].Empty() {
+			continue
+		}
+		src, err := source(i)
+		if errors.Is(err, ErrUnsupported) {
+			src = icc.SRGB
+		} else if err != nil {
+			return err
+		}
+		t, err := colorTransform(src, dst)
+		if err != nil {
+			return fmt.Errorf("display %d: %w", i, err)
+		}
+		for _, p := range parts {
+			t.Apply(img.SubImage(p.Sub(rect.Min).Add(img.Rect.Min)).(*image.RGBA))
+		}
+	}
+	return nil
+}
+
+// subtract returns the parts of r outside cut, as up to four rectangles.
+func subtract(r, cut image.Rectangle) []image.Rectangle {
+	cut = cut.Intersect(r)
+	if cut.Empty() {
+		if r.Empty() {
+			return nil
+		}
+		return []image.Rectangle{r}
+	}
+	var parts []image.Rectangle
+	for _, p := range []image.Rectangle{
+		{r.Min, image.Pt(r.Max.X, cut.Min.Y)},
+		{image.Pt(r.Min.X, cut.Max.Y), r.Max},
+		{image.Pt(r.Min.X, cut.Min.Y), image.Pt(cut.Min.X, cut.Max.Y)},
+		{image.Pt(cut.Max.X, cut.Min.Y), image.Pt(r.Max.X, cut.Max.Y)},
+	} {
+		if !p.Empty() {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// colorSpacer is implemented by the capturers of this package, which know the
+// colour space of what they capture.
+type colorSpacer interface {
+	// colorSpace returns the colour space of the displayIndex'th display of the
+	// layout bounds.
+	colorSpace(displayIndex int, bounds []image.Rectangle) *icc.Profile
+}
+
+func (defaultCapturer) colorSpace(displayIndex int, bounds []image.Rectangle) *icc.Profile {
+	p, _ := displayColorSpace(displayIndex, bounds)
+	return p
+}
+
+func (c optionsCapturer) colorSpace(displayIndex int, bounds []image.Rectangle) *icc.Profile {
+	if c.opts.ColorSpace != nil {
+		return c.opts.ColorSpace
+	}
+	return c.defaultCapturer.colorSpace(displayIndex, bounds)
+}
+
+// setColorSpaces fills in the colour spaces of displays captured with c from the
+// layout bounds, if c knows them.
+func setColorSpaces(c ScreenCapturer, displays []DisplayImage, bounds []image.Rectangle) {
+	if s, ok := c.(colorSpacer); ok {
+		for i := range displays {
+			displays[i].ColorSpace = s.colorSpace(displays[i].Index, bounds)
+		}
+	}
+}
+
+// rectColorSpace returns the colour space of c's captures of rect, that of the
+// display covering most of it, or nil if c does not know it.
+func rectColorSpace(c ScreenCapturer, rect image.Rectangle) *icc.Profile {
+	s, ok := c.(colorSpacer)
+	if !ok {
+		return nil
+	}
+	bounds, err := c.GetAllDisplayBounds()
+	if err != nil {
+		return nil
+	}
+	best, area := -1, 0
+	for i, b := range bounds {
+		if r := b.Intersect(rect); r.Dx()*r.Dy() > area {
+			best, area = i, r.Dx()*r.Dy()
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	return s.colorSpace(best, bounds)
+}
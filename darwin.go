@@ -12,7 +12,15 @@ package screenshot
 #endif
 #include <CoreGraphics/CoreGraphics.h>
 
-static CGImageRef capture(CGDirectDisplayID id, CGRect diIntersectDisplayLocal, CGColorSpaceRef colorSpace) {
+static int canExcludeWindows() {
+#if __ENVIRONMENT_MAC_OS_X_VERSION_MIN_REQUIRED__ > MAC_OS_VERSION_14_4
+    return 1;
+#else
+    return 0;
+#endif
+}
+
+static CGImageRef capture(CGDirectDisplayID id, CGRect diIntersectDisplayLocal, CGColorSpaceRef colorSpace, const uint32_t* exclude, int excludeCount) {
 #if __ENVIRONMENT_MAC_OS_X_VERSION_MIN_REQUIRED__ > MAC_OS_VERSION_14_4
     dispatch_semaphore_t semaphore = dispatch_semaphore_create(0);
     __block CGImageRef result = nil;
@@ -33,7 +41,16 @@ static CGImageRef capture(CGDirectDisplayID id, CGRect diIntersectDisplayLocal,
                 dispatch_semaphore_signal(semaphore);
                 return;
             }
-            SCContentFilter* filter = [[SCContentFilter alloc] initWithDisplay:target excludingWindows:@[]];
+            NSMutableArray<SCWindow*>* excluded = [NSMutableArray array];
+            for (SCWindow *window in content.windows) {
+                for (int i = 0; i < excludeCount; i++) {
+                    if (window.windowID == exclude[i]) {
+                        [excluded addObject:window];
+                        break;
+                    }
+                }
+            }
+            SCContentFilter* filter = [[SCContentFilter alloc] initWithDisplay:target excludingWindows:excluded];
             SCStreamConfiguration* config = [[SCStreamConfiguration alloc] init];
             config.sourceRect = diIntersectDisplayLocal;
             config.width = diIntersectDisplayLocal.size.width;
@@ -64,16 +81,52 @@ static CGImageRef capture(CGDirectDisplayID id, CGRect diIntersectDisplayLocal,
     return copy;
 #endif
 }
+
+static CFDataRef displayICCData(CGDirectDisplayID id) {
+    CGColorSpaceRef space = CGDisplayCopyColorSpace(id);
+    if (!space) {
+        return NULL;
+    }
+    CFDataRef data = CGColorSpaceCopyICCData(space);
+    CGColorSpaceRelease(space);
+    return data;
+}
 */
 import "C"
 
 import (
 	"errors"
+	"fmt"
 	"image"
+	"image/color"
 	"unsafe"
 )
 
-func Capture(x, y, width, height int) (*image.RGBA, error) {
+// captureBackend captures with the platform backend, see Capture.
+func captureBackend(x, y, width, height int) (*image.RGBA, error) {
+	return captureQuartz(x, y, width, height, nil)
+}
+
+// captureExcluding captures without the given windows, which needs ScreenCaptureKit.
+func captureExcluding(x, y, width, height int, exclude []WindowID) (*image.RGBA, error) {
+	if C.canExcludeWindows() == 0 {
+		return nil, ErrUnsupported
+	}
+	ids := make([]C.uint32_t, len(exclude))
+	for i, id := range exclude {
+		ids[i] = C.uint32_t(id)
+	}
+	return captureQuartz(x, y, width, height, ids)
+}
+
+// samplePoints reads single pixels by capturing them, see ColorsAt.
+func samplePoints(points []image.Point) ([]color.RGBA, error) {
+	return sampleCapture(captureBackend, points)
+}
+
+// captureQuartz captures the region from every display it covers, leaving out the
+// windows with the given CGWindowIDs.
+func captureQuartz(x, y, width, height int, exclude []C.uint32_t) (*image.RGBA, error) {
 	if width <= 0 || height <= 0 {
 		return nil, errors.New("width or height should be > 0")
 	}
@@ -129,7 +182,11 @@ func Capture(x, y, width, height int) (*image.RGBA, error) {
 			cgBounds.origin.y+cgBounds.size.height-(cgIntersect.origin.y+cgIntersect.size.height),
 			cgIntersect.size.width, cgIntersect.size.height)
 
-		image := C.capture(id, diIntersectDisplayLocal, colorSpace)
+		var excludePtr *C.uint32_t
+		if len(exclude) > 0 {
+			excludePtr = &exclude[0]
+		}
+		image := C.capture(id, diIntersectDisplayLocal, colorSpace, excludePtr, C.int(len(exclude)))
 		if unsafe.Pointer(image) == nil {
 			return nil, errors.New("cannot capture display")
 		}
@@ -163,7 +220,10 @@ func NumActiveDisplays() int {
 	}
 }
 
-func GetDisplayBounds(displayIndex int) image.Rectangle {
+func GetDisplayBounds(displayIndex int) (image.Rectangle, error) {
+	if displayIndex < 0 || displayIndex >= NumActiveDisplays() {
+		return image.Rectangle{}, fmt.Errorf("invalid display index: %d", displayIndex)
+	}
 	id := getDisplayId(displayIndex)
 	main := C.CGMainDisplayID()
 
@@ -181,7 +241,24 @@ func GetDisplayBounds(displayIndex int) image.Rectangle {
 	rect.Max.X = rect.Min.X + int(bounds.size.width)
 	rect.Max.Y = rect.Min.Y + int(bounds.size.height)
 
-	return rect
+	return rect, nil
+}
+
+// GetAllDisplayBounds returns the bounds of all active displays, in display index order.
+func GetAllDisplayBounds() ([]image.Rectangle, error) {
+	n := NumActiveDisplays()
+	if n <= 0 {
+		return nil, errors.New("no active displays")
+	}
+	rects := make([]image.Rectangle, n)
+	for i := range rects {
+		rect, err := GetDisplayBounds(i)
+		if err != nil {
+			return nil, err
+		}
+		rects[i] = rect
+	}
+	return rects, nil
 }
 
 func getDisplayId(displayIndex int) C.CGDirectDisplayID {
@@ -236,6 +313,22 @@ func createBitmapContext(width int, height int, data *C.uint32_t, bytesPerRow in
 		C.kCGImageAlphaNoneSkipFirst)
 }
 
+// displayProfile returns the ICC profile of a display's colour space, see
+// DisplayColorSpace. Captures are labelled sRGB but not converted to it, so their
+// pixels are in this colour space.
+func displayProfile(displayIndex int) ([]byte, error) {
+	id := getDisplayId(displayIndex)
+	if id == 0 {
+		return nil, fmt.Errorf("invalid display index: %d", displayIndex)
+	}
+	data := C.displayICCData(id)
+	if data == 0 {
+		return nil, nil
+	}
+	defer C.CFRelease(C.CFTypeRef(data))
+	return C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(data)), C.int(C.CFDataGetLength(data))), nil
+}
+
 func createColorspace() C.CGColorSpaceRef {
 	return C.CGColorSpaceCreateWithName(C.kCGColorSpaceSRGB)
 }
@@ -249,3 +342,18 @@ func activeDisplayList() []C.CGDirectDisplayID {
 		return make([]C.CGDirectDisplayID, 0)
 	}
 }
+
+// ListWindows returns the visible top-level windows.
+func ListWindows() ([]Window, error) {
+	return nil, ErrUnsupported
+}
+
+// captureDeep is not available, captures are drawn into an 8 bit context.
+func captureDeep(x, y, width, height int) (*image.RGBA64, error) {
+	return nil, ErrUnsupported
+}
+
+// watchArea is not available, AreaWatcher captures through Capture.
+func watchArea(rect image.Rectangle) (areaBackend, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,38 @@
+package screenshot
+
+import (
+	"errors"
+	"image"
+)
+
+// CaptureRGBA64 is like Capture, but keeps the full precision of displays with more
+// than 8 bits per channel, such as 30 bit X11 visuals. Elsewhere, and when a capture
+// hook is set, it returns the 8 bit capture widened to 16 bits per channel.
+func CaptureRGBA64(x, y, width, height int) (*image.RGBA64, error) {
+	if captureHook.Load() == nil {
+		img, err := captureDeep(x, y, width, height)
+		if !errors.Is(err, ErrUnsupported) {
+			return img, err
+		}
+	}
+	img, err := Capture(x, y, width, height)
+	if err != nil {
+		return nil, err
+	}
+	return widen(img), nil
+}
+
+// widen copies img to an RGBA64 image starting at (0, 0).
+func widen(img *image.RGBA) *image.RGBA64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	dst := image.NewRGBA64(image.Rect(0, 0, w, h))
+	for y := range h {
+		src := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		row := dst.Pix[y*dst.Stride:]
+		// v·257 has v in both bytes.
+		for i := range 4 * w {
+			row[2*i], row[2*i+1] = src[i], src[i]
+		}
+	}
+	return dst
+}
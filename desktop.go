@@ -0,0 +1,90 @@
+package screenshot
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// VirtualDesktopOptions controls how CaptureVirtualDesktop assembles the displays.
+type VirtualDesktopOptions struct {
+	// Fill is the color of areas not covered by any display.
+	// nil leaves them fully transparent.
+	Fill color.Color
+	// Compact places the displays side by side from left to right, top aligned,
+	// ignoring their real positions and the holes between them.
+	Compact bool
+}
+
+// VirtualDesktop is a capture of all displays stitched into one image.
+type VirtualDesktop struct {
+	Image *image.RGBA
+	// Layout maps display index to the rectangle the display occupies in Image.
+	Layout map[int]image.Rectangle
+}
+
+// CaptureVirtualDesktop captures all displays in a single pass and stitches them
+// into one image according to opts.
+func CaptureVirtualDesktop(opts VirtualDesktopOptions) (*VirtualDesktop, error) {
+	displays, err := CaptureAllDisplays()
+	if err != nil {
+		return nil, err
+	}
+	return stitchDisplays(displays, opts), nil
+}
+
+// CaptureVirtualDesktopWith is like CaptureVirtualDesktop but uses the given capturer.
+func CaptureVirtualDesktopWith(c ScreenCapturer, opts VirtualDesktopOptions) (*VirtualDesktop, error) {
+	displays, err := CaptureAllDisplaysWith(c)
+	if err != nil {
+		return nil, err
+	}
+	return stitchDisplays(displays, opts), nil
+}
+
+func stitchDisplays(displays []DisplayImage, opts VirtualDesktopOptions) *VirtualDesktop {
+	layout := make(map[int]image.Rectangle, len(displays))
+
+	if opts.Compact {
+		order := make([]DisplayImage, len(displays))
+		copy(order, displays)
+		sort.SliceStable(order, func(i, j int) bool {
+			a, b := order[i].Bounds.Min, order[j].Bounds.Min
+			if a.X != b.X {
+				return a.X < b.X
+			}
+			return a.Y < b.Y
+		})
+		x := 0
+		for _, d := range order {
+			layout[d.Index] = image.Rect(x, 0, x+d.Bounds.Dx(), d.Bounds.Dy())
+			x += d.Bounds.Dx()
+		}
+	} else {
+		var all image.Rectangle
+		for _, d := range displays {
+			all = all.Union(d.Bounds)
+		}
+		for _, d := range displays {
+			layout[d.Index] = d.Bounds.Sub(all.Min)
+		}
+	}
+
+	var rect image.Rectangle
+	for _, r := range layout {
+		rect = rect.Union(r)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, rect.Max.X, rect.Max.Y))
+	if opts.Fill != nil {
+		draw.Draw(img, img.Rect, image.NewUniform(opts.Fill), image.Point{}, draw.Src)
+	}
+	for _, d := range displays {
+		draw.Draw(img, layout[d.Index], d.Image, d.Image.Rect.Min, draw.Src)
+	}
+
+	return &VirtualDesktop{
+		Image:  img,
+		Layout: layout,
+	}
+}
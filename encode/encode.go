@@ -0,0 +1,133 @@
+// Package encode writes the *image.RGBA frames returned by the capturers as PNG,
+// JPEG, QOI or lossless WebP.
+//
+// The encoders are tuned for screen content: PNG can trade size for speed and
+// compress strips of the image in parallel, JPEG lets the caller keep full chroma
+// resolution so that coloured text stays sharp, QOI is a very fast lossless format
+// and WebP is written in its lossless (VP8L) variant, which is usually the smallest.
+package encode
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Format is an output image format.
+type Format string
+
+const (
+	FormatPNG  Format = "png"
+	FormatJPEG Format = "jpeg"
+	FormatQOI  Format = "qoi"
+	FormatWebP Format = "webp"
+)
+
+// Formats lists the supported formats.
+var Formats = []Format{FormatPNG, FormatJPEG, FormatQOI, FormatWebP}
+
+// ParseFormat returns the format called name, case-insensitively. "jpg" is accepted for JPEG.
+func ParseFormat(name string) (Format, error) {
+	name = strings.ToLower(name)
+	if name == "jpg" {
+		return FormatJPEG, nil
+	}
+	for _, f := range Formats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("encode: unsupported format %q", name)
+}
+
+// FormatFromPath returns the format matching the extension of path.
+func FormatFromPath(path string) (Format, bool) {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if ext == "" {
+		return "", false
+	}
+	f, err := ParseFormat(ext)
+	return f, err == nil
+}
+
+// Extension returns the usual file extension of f, including the dot.
+func (f Format) Extension() string {
+	if f == FormatJPEG {
+		return ".jpg"
+	}
+	return "." + string(f)
+}
+
+// ContentType returns the MIME type of f.
+func (f Format) ContentType() string {
+	return "image/" + string(f)
+}
+
+// Options holds the settings of the formats that have any. Fields of other formats are ignored.
+type Options struct {
+	PNG  PNGOptions
+	JPEG JPEGOptions
+}
+
+// Encode writes img to w in format f. A nil opts uses the defaults.
+func Encode(w io.Writer, img *image.RGBA, f Format, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	switch f {
+	case FormatPNG:
+		return PNG(w, img, &opts.PNG)
+	case FormatJPEG:
+		return JPEG(w, img, &opts.JPEG)
+	case FormatQOI:
+		return QOI(w, img)
+	case FormatWebP:
+		return WebP(w, img)
+	}
+	return fmt.Errorf("encode: unsupported format %q", f)
+}
+
+// opaque reports whether every pixel of img has full alpha.
+func opaque(img *image.RGBA) bool {
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		i := img.PixOffset(img.Rect.Min.X, y)
+		row := img.Pix[i+3 : i+img.Rect.Dx()*4 : i+img.Rect.Dx()*4]
+		for j := 0; j < len(row); j += 4 {
+			if row[j] != 0xff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// straight writes the non-premultiplied colour of the premultiplied RGBA pixel p to dst.
+func straight(dst, p []byte) {
+	a := uint32(p[3])
+	switch a {
+	case 0xff:
+		copy(dst[:4], p[:4])
+	case 0:
+		dst[0], dst[1], dst[2], dst[3] = 0, 0, 0, 0
+	default:
+		// The same rounding as color.NRGBAModel, so that the result matches image/png.
+		dst[0] = byte(min(255, uint32(p[0])*0xffff/a>>8))
+		dst[1] = byte(min(255, uint32(p[1])*0xffff/a>>8))
+		dst[2] = byte(min(255, uint32(p[2])*0xffff/a>>8))
+		dst[3] = p[3]
+	}
+}
+
+// checkSize rejects images a format cannot store.
+func checkSize(img *image.RGBA, format string, max int) error {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w <= 0 || h <= 0 {
+		return fmt.Errorf("encode: %s: empty image", format)
+	}
+	if w > max || h > max {
+		return fmt.Errorf("encode: %s: image of %dx%d exceeds the maximum size %d", format, w, h, max)
+	}
+	return nil
+}
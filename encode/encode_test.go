@@ -0,0 +1,301 @@
+package encode
+
+import (
+	"bytes"
+	"fmt"
+	"hash/adler32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// screen draws something resembling a desktop: a gradient wallpaper, a window with a
+// title bar, lines of text-like glyphs in several colours and a noisy photo area.
+func screen(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	rnd := rand.New(rand.NewSource(1))
+	set := func(x, y int, c color.RGBA) {
+		if x >= 0 && y >= 0 && x < w && y < h {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			set(x, y, color.RGBA{uint8(20 + 60*y/h), uint8(40 + 80*x/w), 140, 255})
+		}
+	}
+	win := image.Rect(w/10, h/10, w*8/10, h*9/10)
+	for y := win.Min.Y; y < win.Max.Y; y++ {
+		for x := win.Min.X; x < win.Max.X; x++ {
+			c := color.RGBA{250, 250, 250, 255}
+			if y < win.Min.Y+30 {
+				c = color.RGBA{60, 63, 65, 255}
+			}
+			set(x, y, c)
+		}
+	}
+	colors := []color.RGBA{{0, 0, 0, 255}, {200, 30, 30, 255}, {30, 90, 200, 255}, {20, 140, 60, 255}}
+	for line, y := 0, win.Min.Y+44; y+12 < win.Max.Y; line, y = line+1, y+18 {
+		x := win.Min.X + 12
+		for x < win.Min.X+win.Dx()/2 {
+			c := colors[(line+x/97)%len(colors)]
+			// A glyph: a few random strokes in a 7x11 cell.
+			for s := 0; s < 4; s++ {
+				gx, gy := rnd.Intn(7), rnd.Intn(11)
+				for k := 0; k < 4; k++ {
+					set(x+gx, y+gy+k, c)
+				}
+			}
+			x += 8
+			if rnd.Intn(7) == 0 {
+				x += 8
+			}
+		}
+	}
+	photo := image.Rect(win.Min.X+win.Dx()*6/10, win.Min.Y+50, win.Max.X-20, win.Min.Y+50+win.Dy()/2)
+	for y := photo.Min.Y; y < photo.Max.Y; y++ {
+		for x := photo.Min.X; x < photo.Max.X; x++ {
+			v := 128 + 100*math.Sin(float64(x)/17)*math.Cos(float64(y)/23)
+			set(x, y, color.RGBA{uint8(v), uint8(v*0.8) + uint8(rnd.Intn(12)), uint8(255 - v), 255})
+		}
+	}
+	return img
+}
+
+// translucent returns a copy of img with an alpha ramp over its left half.
+func translucent(img *image.RGBA) *image.RGBA {
+	out := image.NewRGBA(img.Rect)
+	copy(out.Pix, img.Pix)
+	w := img.Rect.Dx()
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < w/2; x++ {
+			c := img.RGBAAt(x, y)
+			a := uint32(x * 255 / (w / 2))
+			out.SetRGBA(x, y, color.RGBA{uint8(uint32(c.R) * a / 255), uint8(uint32(c.G) * a / 255), uint8(uint32(c.B) * a / 255), uint8(a)})
+		}
+	}
+	return out
+}
+
+// sameImage reports the first pixel where got differs from want in non-premultiplied colour.
+// Colours of fully transparent pixels are not compared.
+func sameImage(t *testing.T, got image.Image, want *image.RGBA) {
+	t.Helper()
+	if got.Bounds().Size() != want.Rect.Size() {
+		t.Fatalf("size %v, want %v", got.Bounds().Size(), want.Rect.Size())
+	}
+	off := got.Bounds().Min.Sub(want.Rect.Min)
+	for y := want.Rect.Min.Y; y < want.Rect.Max.Y; y++ {
+		for x := want.Rect.Min.X; x < want.Rect.Max.X; x++ {
+			w := color.NRGBAModel.Convert(want.At(x, y)).(color.NRGBA)
+			g := color.NRGBAModel.Convert(got.At(x+off.X, y+off.Y)).(color.NRGBA)
+			if w.A == 0 && g.A == 0 {
+				continue
+			}
+			if g != w {
+				t.Fatalf("pixel (%d,%d) is %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+// psnr returns the peak signal-to-noise ratio of the RGB channels of got against want.
+func psnr(got image.Image, want *image.RGBA) float64 {
+	var sum float64
+	b := want.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r1, g1, b1, _ := got.At(x-b.Min.X, y-b.Min.Y).RGBA()
+			c := want.RGBAAt(x, y)
+			for _, d := range []float64{float64(r1>>8) - float64(c.R), float64(g1>>8) - float64(c.G), float64(b1>>8) - float64(c.B)} {
+				sum += d * d
+			}
+		}
+	}
+	mse := sum / float64(3*b.Dx()*b.Dy())
+	return 10 * math.Log10(255*255/mse)
+}
+
+func TestPNG(t *testing.T) {
+	img := screen(500, 400)
+	for _, src := range []struct {
+		name string
+		img  *image.RGBA
+	}{
+		{"opaque", img},
+		{"translucent", translucent(img)},
+		{"subimage", img.SubImage(image.Rect(33, 17, 290, 301)).(*image.RGBA)},
+		{"tiny", img.SubImage(image.Rect(5, 5, 6, 6)).(*image.RGBA)},
+	} {
+		for _, speed := range []PNGSpeed{PNGDefault, PNGFast, PNGFastest, PNGSmallest} {
+			for _, workers := range []int{1, 4} {
+				t.Run(fmt.Sprintf("%s/speed%d/workers%d", src.name, speed, workers), func(t *testing.T) {
+					var buf bytes.Buffer
+					if err := PNG(&buf, src.img, &PNGOptions{Speed: speed, Workers: workers}); err != nil {
+						t.Fatal(err)
+					}
+					got, err := png.Decode(&buf)
+					if err != nil {
+						t.Fatal(err)
+					}
+					sameImage(t, got, src.img)
+				})
+			}
+		}
+	}
+}
+
+func TestJPEG(t *testing.T) {
+	img := screen(333, 201) // not a multiple of the MCU size
+	sizes := map[Subsampling]int{}
+	for _, sub := range []Subsampling{Subsampling420, Subsampling422, Subsampling444} {
+		for _, quality := range []int{0, 10, 90, 100} {
+			var buf bytes.Buffer
+			if err := JPEG(&buf, img, &JPEGOptions{Quality: quality, Subsampling: sub}); err != nil {
+				t.Fatal(err)
+			}
+			n := buf.Len()
+			got, err := jpeg.Decode(&buf)
+			if err != nil {
+				t.Fatalf("subsampling %d quality %d: %v", sub, quality, err)
+			}
+			if got.Bounds().Size() != img.Rect.Size() {
+				t.Fatalf("size %v", got.Bounds())
+			}
+			min := map[int]float64{0: 29, 10: 22, 90: 32, 100: 33}[quality]
+			if sub == Subsampling444 && quality == 100 {
+				min = 45
+			}
+			if p := psnr(got, img); p < min {
+				t.Errorf("subsampling %d quality %d: PSNR %.1f dB, want at least %.0f", sub, quality, p, min)
+			}
+			if quality == 90 {
+				sizes[sub] = n
+			}
+		}
+	}
+	if !(sizes[Subsampling420] < sizes[Subsampling422] && sizes[Subsampling422] < sizes[Subsampling444]) {
+		t.Errorf("sizes %v, want 4:2:0 < 4:2:2 < 4:4:4", sizes)
+	}
+}
+
+func TestQOI(t *testing.T) {
+	img := screen(300, 200)
+	for _, src := range []*image.RGBA{img, translucent(img), img.SubImage(image.Rect(7, 9, 250, 190)).(*image.RGBA)} {
+		var buf bytes.Buffer
+		if err := QOI(&buf, src); err != nil {
+			t.Fatal(err)
+		}
+		got, format, err := image.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != "qoi" {
+			t.Errorf("format %q", format)
+		}
+		sameImage(t, got, src)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	img := screen(64, 48)
+	for _, f := range Formats {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, f, nil); err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		if f == FormatWebP {
+			continue // not registered with the image package
+		}
+		if _, format, err := image.Decode(&buf); err != nil || format != string(f) {
+			t.Errorf("%s: decoded as %q, %v", f, format, err)
+		}
+	}
+	if err := Encode(&bytes.Buffer{}, image.NewRGBA(image.Rectangle{}), FormatPNG, nil); err == nil {
+		t.Error("empty image encoded without error")
+	}
+	if err := Encode(&bytes.Buffer{}, img, "bmp", nil); err == nil {
+		t.Error("unknown format encoded without error")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, want := range map[string]Format{"png": FormatPNG, "JPG": FormatJPEG, "jpeg": FormatJPEG, "qoi": FormatQOI, "webp": FormatWebP} {
+		if f, err := ParseFormat(name); err != nil || f != want {
+			t.Errorf("ParseFormat(%q) = %q, %v", name, f, err)
+		}
+	}
+	if _, err := ParseFormat("gif"); err == nil {
+		t.Error("ParseFormat(gif) succeeded")
+	}
+	if f, ok := FormatFromPath("shots/a.JPG"); !ok || f != FormatJPEG || f.Extension() != ".jpg" || f.ContentType() != "image/jpeg" {
+		t.Errorf("FormatFromPath(a.JPG) = %q, %v", f, ok)
+	}
+	if _, ok := FormatFromPath("shot"); ok {
+		t.Error("FormatFromPath without extension succeeded")
+	}
+}
+
+func TestAdler32Combine(t *testing.T) {
+	data := bytes.Repeat([]byte("screenshot"), 10000)
+	for _, split := range []int{0, 1, 65521, 70000, len(data)} {
+		a, b := data[:split], data[split:]
+		if got, want := adler32Combine(adler32.Checksum(a), adler32.Checksum(b), len(b)), adler32.Checksum(data); got != want {
+			t.Errorf("split %d: %08x, want %08x", split, got, want)
+		}
+	}
+}
+
+// benchScreen is a 4K screen, made on first use.
+var benchScreen = sync.OnceValue(func() *image.RGBA { return screen(3840, 2160) })
+
+func benchmark(b *testing.B, f Format, opts *Options) {
+	img := benchScreen()
+	var buf bytes.Buffer
+	b.SetBytes(int64(len(img.Pix)))
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := Encode(&buf, img, f, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(buf.Len()), "bytes/op")
+}
+
+func BenchmarkPNGDefault(b *testing.B) { benchmark(b, FormatPNG, nil) }
+func BenchmarkPNGFast(b *testing.B) {
+	benchmark(b, FormatPNG, &Options{PNG: PNGOptions{Speed: PNGFast}})
+}
+func BenchmarkPNGFastest(b *testing.B) {
+	benchmark(b, FormatPNG, &Options{PNG: PNGOptions{Speed: PNGFastest}})
+}
+func BenchmarkPNGFastSerial(b *testing.B) {
+	benchmark(b, FormatPNG, &Options{PNG: PNGOptions{Speed: PNGFast, Workers: 1}})
+}
+
+// BenchmarkStdlibPNG is the baseline: image/png at its best compression, as the example used.
+func BenchmarkStdlibPNG(b *testing.B) {
+	img := benchScreen()
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	b.SetBytes(int64(len(img.Pix)))
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := enc.Encode(&buf, img); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(buf.Len()), "bytes/op")
+}
+
+func BenchmarkJPEG420(b *testing.B) { benchmark(b, FormatJPEG, nil) }
+func BenchmarkJPEG444(b *testing.B) {
+	benchmark(b, FormatJPEG, &Options{JPEG: JPEGOptions{Subsampling: Subsampling444}})
+}
+func BenchmarkQOI(b *testing.B)  { benchmark(b, FormatQOI, nil) }
+func BenchmarkWebP(b *testing.B) { benchmark(b, FormatWebP, nil) }
@@ -0,0 +1,410 @@
+package encode
+
+import (
+	"bufio"
+	"image"
+	"io"
+	"math"
+)
+
+// Subsampling is the chroma resolution of a JPEG relative to its luma resolution.
+type Subsampling int
+
+const (
+	// Subsampling420 halves chroma horizontally and vertically, the usual choice for photos.
+	Subsampling420 Subsampling = iota
+	// Subsampling422 halves chroma horizontally.
+	Subsampling422
+	// Subsampling444 keeps full chroma resolution, which keeps coloured text and thin
+	// coloured lines sharp at the cost of a larger file.
+	Subsampling444
+)
+
+// JPEGOptions configures JPEG encoding.
+type JPEGOptions struct {
+	// Quality ranges from 1 to 100. Zero means 75.
+	Quality     int
+	Subsampling Subsampling
+}
+
+// DefaultJPEGQuality is the quality used when JPEGOptions.Quality is zero.
+const DefaultJPEGQuality = 75
+
+// unzig maps the zig-zag order of the coefficients to their natural order.
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// Quantization tables of Annex K of the JPEG specification in natural order.
+var baseQuant = [2][64]int{
+	{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	},
+	{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// huffmanSpec is a Huffman table as stored in a DHT segment: the number of codes of
+// each length from 1 to 16 and the symbols in order of increasing code length.
+type huffmanSpec struct {
+	counts [16]byte
+	values []byte
+}
+
+// The Huffman tables of Annex K: luminance DC, luminance AC, chrominance DC, chrominance AC.
+var huffmanSpecs = [4]huffmanSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// huffmanCodes holds the code and its length in bits for every symbol of a table.
+type huffmanCodes [256]struct {
+	code uint32
+	bits uint8
+}
+
+var huffmanTables = func() (t [4]huffmanCodes) {
+	for i, spec := range huffmanSpecs {
+		code, k := uint32(0), 0
+		for n, count := range spec.counts {
+			for j := 0; j < int(count); j++ {
+				t[i][spec.values[k]].code = code
+				t[i][spec.values[k]].bits = uint8(n + 1)
+				code++
+				k++
+			}
+			code <<= 1
+		}
+	}
+	return t
+}()
+
+// aanScale are the output scale factors of the AAN forward DCT.
+var aanScale = [8]float32{1.0, 1.387039845, 1.306562965, 1.175875602, 1.0, 0.785694958, 0.541196100, 0.275899379}
+
+// JPEG writes img to w as a baseline JPEG. Alpha is ignored.
+func JPEG(w io.Writer, img *image.RGBA, opts *JPEGOptions) error {
+	if opts == nil {
+		opts = &JPEGOptions{}
+	}
+	if err := checkSize(img, "jpeg", 65535); err != nil {
+		return err
+	}
+	quality := opts.Quality
+	if quality == 0 {
+		quality = DefaultJPEGQuality
+	}
+	quality = min(100, max(1, quality))
+	hs, vs := 2, 2
+	switch opts.Subsampling {
+	case Subsampling422:
+		vs = 1
+	case Subsampling444:
+		hs, vs = 1, 1
+	}
+
+	e := &jpegEncoder{w: bufio.NewWriter(w), hs: hs, vs: vs}
+	e.setQuality(quality)
+	e.writeHeader(img.Rect.Dx(), img.Rect.Dy())
+	e.writeScan(img)
+	_, _ = e.w.Write([]byte{0xff, 0xd9})
+	return e.w.Flush()
+}
+
+type jpegEncoder struct {
+	w      *bufio.Writer
+	hs, vs int // luma blocks per MCU horizontally and vertically
+
+	quant [2][64]byte // natural order
+	// scale multiplies the DCT output into quantized coefficients, natural order.
+	scale [2][64]float32
+
+	bits  uint32
+	nbits uint
+}
+
+// setQuality scales the Annex K tables the way libjpeg does.
+func (e *jpegEncoder) setQuality(quality int) {
+	s := 200 - 2*quality
+	if quality < 50 {
+		s = 5000 / quality
+	}
+	for t := range e.quant {
+		for i, q := range baseQuant[t] {
+			q = min(255, max(1, (q*s+50)/100))
+			e.quant[t][i] = byte(q)
+			e.scale[t][i] = 1 / (float32(q) * aanScale[i/8] * aanScale[i%8] * 8)
+		}
+	}
+}
+
+func (e *jpegEncoder) writeHeader(width, height int) {
+	w := e.w
+	_, _ = w.Write([]byte{0xff, 0xd8})
+	// JFIF APP0: version 1.1, no density units, 1:1 aspect, no thumbnail.
+	_, _ = w.Write([]byte{0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0})
+
+	_, _ = w.Write([]byte{0xff, 0xdb, 0, 2 + 2*65})
+	for t := range e.quant {
+		_ = w.WriteByte(byte(t))
+		for _, i := range unzig {
+			_ = w.WriteByte(e.quant[t][i])
+		}
+	}
+
+	_, _ = w.Write([]byte{0xff, 0xc0, 0, 17, 8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), 3,
+		1, byte(e.hs<<4 | e.vs), 0,
+		2, 0x11, 1,
+		3, 0x11, 1})
+
+	n := 2
+	for _, spec := range huffmanSpecs {
+		n += 17 + len(spec.values)
+	}
+	_, _ = w.Write([]byte{0xff, 0xc4, byte(n >> 8), byte(n)})
+	for i, class := range []byte{0x00, 0x10, 0x01, 0x11} {
+		_ = w.WriteByte(class)
+		_, _ = w.Write(huffmanSpecs[i].counts[:])
+		_, _ = w.Write(huffmanSpecs[i].values)
+	}
+
+	_, _ = w.Write([]byte{0xff, 0xda, 0, 12, 3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0})
+}
+
+// writeScan encodes the image MCU by MCU. Blocks past the right and bottom edges
+// repeat the edge pixels.
+func (e *jpegEncoder) writeScan(img *image.RGBA) {
+	b := img.Rect
+	mw, mh := 8*e.hs, 8*e.vs
+	var (
+		lum    [4][64]float32
+		cb, cr [64]float32
+		dc     [3]int
+	)
+	for my := b.Min.Y; my < b.Max.Y; my += mh {
+		for mx := b.Min.X; mx < b.Max.X; mx += mw {
+			clear(cb[:])
+			clear(cr[:])
+			for dy := 0; dy < mh; dy++ {
+				y := min(my+dy, b.Max.Y-1)
+				row := img.Pix[img.PixOffset(b.Min.X, y):]
+				for dx := 0; dx < mw; dx++ {
+					x := min(mx+dx, b.Max.X-1) - b.Min.X
+					p := row[4*x : 4*x+3 : 4*x+3]
+					r, g, bl := float32(p[0]), float32(p[1]), float32(p[2])
+					lum[(dy/8)*e.hs+dx/8][(dy%8)*8+dx%8] = 0.299*r + 0.587*g + 0.114*bl - 128
+					c := (dy/e.vs)*8 + dx/e.hs
+					cb[c] += -0.168736*r - 0.331264*g + 0.5*bl
+					cr[c] += 0.5*r - 0.418688*g - 0.081312*bl
+				}
+			}
+			for i := 0; i < e.hs*e.vs; i++ {
+				dc[0] = e.writeBlock(&lum[i], 0, dc[0])
+			}
+			if n := float32(e.hs * e.vs); n > 1 {
+				for i := range cb {
+					cb[i] /= n
+					cr[i] /= n
+				}
+			}
+			dc[1] = e.writeBlock(&cb, 1, dc[1])
+			dc[2] = e.writeBlock(&cr, 1, dc[2])
+		}
+	}
+	// Pad the last byte with one bits.
+	e.emit(0x7f, 7)
+}
+
+// writeBlock transforms, quantizes and entropy codes a block with table t (0 for luma,
+// 1 for chroma) and returns its DC coefficient.
+func (e *jpegEncoder) writeBlock(blk *[64]float32, t int, prevDC int) int {
+	fdct(blk)
+	var q [64]int
+	for i, n := range unzig {
+		q[i] = int(math.Round(float64(blk[n] * e.scale[t][n])))
+	}
+	dcTable, acTable := &huffmanTables[2*t], &huffmanTables[2*t+1]
+
+	e.emitValue(dcTable, 0, q[0]-prevDC)
+	run := 0
+	for i := 1; i < 64; i++ {
+		if q[i] == 0 {
+			run++
+			continue
+		}
+		for run > 15 {
+			e.emitHuffman(acTable, 0xf0)
+			run -= 16
+		}
+		e.emitValue(acTable, run, q[i])
+		run = 0
+	}
+	if run > 0 {
+		e.emitHuffman(acTable, 0x00)
+	}
+	return q[0]
+}
+
+// emitValue writes the symbol combining run and the magnitude category of v, followed
+// by the bits of v.
+func (e *jpegEncoder) emitValue(t *huffmanCodes, run, v int) {
+	a, b := v, v
+	if v < 0 {
+		a, b = -v, v-1
+	}
+	n := uint(0)
+	for a > 0 {
+		n++
+		a >>= 1
+	}
+	e.emitHuffman(t, byte(run<<4|int(n)))
+	if n > 0 {
+		e.emit(uint32(b)&(1<<n-1), n)
+	}
+}
+
+func (e *jpegEncoder) emitHuffman(t *huffmanCodes, sym byte) {
+	e.emit(t[sym].code, uint(t[sym].bits))
+}
+
+// emit writes the n low bits of bits, stuffing a zero byte after every 0xff.
+func (e *jpegEncoder) emit(bits uint32, n uint) {
+	e.bits = e.bits<<n | bits
+	e.nbits += n
+	for e.nbits >= 8 {
+		b := byte(e.bits >> (e.nbits - 8))
+		_ = e.w.WriteByte(b)
+		if b == 0xff {
+			_ = e.w.WriteByte(0)
+		}
+		e.nbits -= 8
+	}
+}
+
+// fdct is the floating-point AAN forward DCT of libjpeg. The outputs are scaled by
+// 8*aanScale[row]*aanScale[col], which setQuality folds into the quantization.
+func fdct(b *[64]float32) {
+	for i := 0; i < 64; i += 8 {
+		fdct1(b, i, 1)
+	}
+	for i := 0; i < 8; i++ {
+		fdct1(b, i, 8)
+	}
+}
+
+// fdct1 transforms the 8 values of b starting at off, stride apart.
+func fdct1(b *[64]float32, off, stride int) {
+	d := func(k int) *float32 { return &b[off+k*stride] }
+	tmp0 := *d(0) + *d(7)
+	tmp7 := *d(0) - *d(7)
+	tmp1 := *d(1) + *d(6)
+	tmp6 := *d(1) - *d(6)
+	tmp2 := *d(2) + *d(5)
+	tmp5 := *d(2) - *d(5)
+	tmp3 := *d(3) + *d(4)
+	tmp4 := *d(3) - *d(4)
+
+	tmp10 := tmp0 + tmp3
+	tmp13 := tmp0 - tmp3
+	tmp11 := tmp1 + tmp2
+	tmp12 := tmp1 - tmp2
+	*d(0) = tmp10 + tmp11
+	*d(4) = tmp10 - tmp11
+	z1 := (tmp12 + tmp13) * 0.707106781
+	*d(2) = tmp13 + z1
+	*d(6) = tmp13 - z1
+
+	tmp10 = tmp4 + tmp5
+	tmp11 = tmp5 + tmp6
+	tmp12 = tmp6 + tmp7
+	z5 := (tmp10 - tmp12) * 0.382683433
+	z2 := 0.541196100*tmp10 + z5
+	z4 := 1.306562965*tmp12 + z5
+	z3 := tmp11 * 0.707106781
+	z11 := tmp7 + z3
+	z13 := tmp7 - z3
+	*d(5) = z13 + z2
+	*d(3) = z13 - z2
+	*d(1) = z11 + z4
+	*d(7) = z11 - z4
+}
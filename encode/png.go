@@ -0,0 +1,299 @@
+package encode
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/adler32"
+	"hash/crc32"
+	"image"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// PNGSpeed trades PNG encoding time against file size.
+type PNGSpeed int
+
+const (
+	// PNGDefault picks the best filter per row and uses the default zlib level.
+	PNGDefault PNGSpeed = iota
+	// PNGFast picks the best filter per row and uses the fastest zlib level.
+	PNGFast
+	// PNGFastest uses the Up filter for every row and the fastest zlib level. It is
+	// several times faster than PNGFast and costs little size on screen content.
+	PNGFastest
+	// PNGSmallest picks the best filter per row and uses the best zlib level.
+	PNGSmallest
+)
+
+// PNGOptions configures PNG encoding.
+type PNGOptions struct {
+	Speed PNGSpeed
+	// Workers is the number of horizontal strips compressed in parallel. Zero means
+	// runtime.GOMAXPROCS(0) and 1 compresses the image as a single stream. Strips
+	// do not share a compression dictionary, which costs a little size.
+	Workers int
+}
+
+// PNG filter types.
+const (
+	filterNone = iota
+	filterSub
+	filterUp
+	filterAverage
+	filterPaeth
+)
+
+// minStripRows is the smallest strip worth compressing on its own.
+const minStripRows = 64
+
+// PNG writes img to w as an 8-bit PNG, RGB when img is opaque and RGBA otherwise.
+func PNG(w io.Writer, img *image.RGBA, opts *PNGOptions) error {
+	if opts == nil {
+		opts = &PNGOptions{}
+	}
+	if err := checkSize(img, "png", 1<<31-1); err != nil {
+		return err
+	}
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	bpp := 4
+	colorType := byte(6)
+	if opaque(img) {
+		bpp, colorType = 3, 2
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	strips := min(workers, max(1, height/minStripRows))
+	rows := (height + strips - 1) / strips
+
+	type strip struct {
+		data   []byte
+		adler  uint32
+		length int
+		err    error
+	}
+	out := make([]strip, strips)
+	var wg sync.WaitGroup
+	for i := range out {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			y0 := i * rows
+			y1 := min(height, y0+rows)
+			s := &out[i]
+			s.data, s.adler, s.length, s.err = compressStrip(img, y0, y1, bpp, opts.Speed, i == strips-1)
+		}()
+	}
+	wg.Wait()
+
+	bw := bufio.NewWriter(w)
+	_, _ = bw.WriteString("\x89PNG\r\n\x1a\n")
+	var ihdr [13]byte
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8], ihdr[9] = 8, colorType
+	writeChunk(bw, "IHDR", ihdr[:])
+
+	// The strips form one zlib stream: header, the deflate data of all strips, of which
+	// only the last one is final, and the Adler-32 of all uncompressed data.
+	adler := uint32(1)
+	for i, s := range out {
+		if s.err != nil {
+			return s.err
+		}
+		data := s.data
+		if i == 0 {
+			data = append([]byte{0x78, 0x01}, data...)
+		}
+		adler = adler32Combine(adler, s.adler, s.length)
+		if i == len(out)-1 {
+			data = binary.BigEndian.AppendUint32(data, adler)
+		}
+		writeChunk(bw, "IDAT", data)
+	}
+	writeChunk(bw, "IEND", nil)
+	return bw.Flush()
+}
+
+// compressStrip filters rows y0 to y1 of img and deflates them. The deflate stream is
+// closed when last is set and ends on a byte boundary otherwise.
+func compressStrip(img *image.RGBA, y0, y1, bpp int, speed PNGSpeed, last bool) ([]byte, uint32, int, error) {
+	level := flate.DefaultCompression
+	switch speed {
+	case PNGFast, PNGFastest:
+		level = flate.BestSpeed
+	case PNGSmallest:
+		level = flate.BestCompression
+	}
+	var buf bytes.Buffer
+	zw, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	adler := adler32.New()
+	n := img.Rect.Dx() * bpp
+	prev := make([]byte, n)
+	cur := make([]byte, n)
+	if y0 > 0 {
+		packRow(prev, img, y0-1, bpp)
+	}
+	filtered := make([][]byte, filterPaeth+1)
+	for i := range filtered {
+		filtered[i] = make([]byte, n+1)
+	}
+	for y := y0; y < y1; y++ {
+		packRow(cur, img, y, bpp)
+		var row []byte
+		if speed == PNGFastest {
+			row = filterRow(filtered[filterUp], filterUp, cur, prev, bpp)
+		} else {
+			row = bestFilter(filtered, cur, prev, bpp)
+		}
+		_, _ = adler.Write(row)
+		if _, err := zw.Write(row); err != nil {
+			return nil, 0, 0, err
+		}
+		prev, cur = cur, prev
+	}
+	if last {
+		err = zw.Close()
+	} else {
+		err = zw.Flush()
+	}
+	return buf.Bytes(), adler.Sum32(), (y1 - y0) * (n + 1), err
+}
+
+// packRow copies row y, counted from the top of img, into dst, dropping alpha when bpp is 3 and undoing the
+// alpha premultiplication otherwise.
+func packRow(dst []byte, img *image.RGBA, y, bpp int) {
+	i := img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y)
+	src := img.Pix[i : i+img.Rect.Dx()*4]
+	if bpp == 4 {
+		for j := 0; j < len(src); j += 4 {
+			straight(dst[j:j+4], src[j:j+4])
+		}
+		return
+	}
+	for j, k := 0, 0; j < len(src); j, k = j+4, k+3 {
+		dst[k], dst[k+1], dst[k+2] = src[j], src[j+1], src[j+2]
+	}
+}
+
+// bestFilter applies every filter and returns the row with the smallest sum of
+// absolute values, the heuristic recommended by the PNG specification.
+func bestFilter(filtered [][]byte, cur, prev []byte, bpp int) []byte {
+	var best []byte
+	bestSum := -1
+	for f := filterNone; f <= filterPaeth; f++ {
+		row := filterRow(filtered[f], f, cur, prev, bpp)
+		sum := 0
+		for _, b := range row[1:] {
+			sum += int(absInt8(b))
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = row, sum
+		}
+	}
+	return best
+}
+
+func absInt8(b byte) byte {
+	if b >= 0x80 {
+		return -b
+	}
+	return b
+}
+
+// filterRow writes the filter type and the filtered row cur into dst.
+func filterRow(dst []byte, filter int, cur, prev []byte, bpp int) []byte {
+	dst[0] = byte(filter)
+	out := dst[1:]
+	switch filter {
+	case filterNone:
+		copy(out, cur)
+	case filterSub:
+		copy(out[:bpp], cur[:bpp])
+		for i := bpp; i < len(cur); i++ {
+			out[i] = cur[i] - cur[i-bpp]
+		}
+	case filterUp:
+		for i := range cur {
+			out[i] = cur[i] - prev[i]
+		}
+	case filterAverage:
+		for i := 0; i < bpp; i++ {
+			out[i] = cur[i] - prev[i]/2
+		}
+		for i := bpp; i < len(cur); i++ {
+			out[i] = cur[i] - byte((int(cur[i-bpp])+int(prev[i]))/2)
+		}
+	case filterPaeth:
+		for i := 0; i < bpp; i++ {
+			out[i] = cur[i] - prev[i]
+		}
+		for i := bpp; i < len(cur); i++ {
+			out[i] = cur[i] - paeth(cur[i-bpp], prev[i], prev[i-bpp])
+		}
+	}
+	return dst
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func writeChunk(w *bufio.Writer, typ string, data []byte) {
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(data)))
+	copy(hdr[4:], typ)
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write(hdr[4:])
+	_, _ = crc.Write(data)
+	_, _ = w.Write(hdr[:])
+	_, _ = w.Write(data)
+	_ = binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// adler32Combine returns the Adler-32 of the concatenation of two byte sequences
+// from their checksums a1 and a2 and the length len2 of the second one.
+func adler32Combine(a1, a2 uint32, len2 int) uint32 {
+	const base = 65521
+	rem := uint32(len2 % base)
+	sum1 := a1 & 0xffff
+	sum2 := rem * sum1 % base
+	sum1 += a2&0xffff + base - 1
+	sum2 += a1>>16 + a2>>16 + base - rem
+	if sum1 >= base {
+		sum1 -= base
+	}
+	if sum1 >= base {
+		sum1 -= base
+	}
+	if sum2 >= base<<1 {
+		sum2 -= base << 1
+	}
+	if sum2 >= base {
+		sum2 -= base
+	}
+	return sum1 | sum2<<16
+}
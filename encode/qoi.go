@@ -0,0 +1,180 @@
+package encode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// QOI operations, see https://qoiformat.org/qoi-specification.pdf.
+const (
+	qoiOpIndex = 0x00
+	qoiOpDiff  = 0x40
+	qoiOpLuma  = 0x80
+	qoiOpRun   = 0xc0
+	qoiOpRGB   = 0xfe
+	qoiOpRGBA  = 0xff
+	qoiMask    = 0xc0
+)
+
+const qoiMagic = "qoif"
+
+var qoiEnd = [8]byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+// maxQOIPixels bounds the images DecodeQOI accepts, as the reference implementation does.
+const maxQOIPixels = 400_000_000
+
+func init() {
+	image.RegisterFormat("qoi", qoiMagic, DecodeQOI, DecodeQOIConfig)
+}
+
+func qoiHash(p [4]byte) byte {
+	return (p[0]*3 + p[1]*5 + p[2]*7 + p[3]*11) % 64
+}
+
+// QOI writes img to w in the Quite OK Image format, with 3 channels when img is opaque.
+func QOI(w io.Writer, img *image.RGBA) error {
+	if err := checkSize(img, "qoi", 1<<31-1); err != nil {
+		return err
+	}
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	bw := bufio.NewWriterSize(w, 64<<10)
+	hdr := []byte(qoiMagic)
+	hdr = binary.BigEndian.AppendUint32(hdr, uint32(width))
+	hdr = binary.BigEndian.AppendUint32(hdr, uint32(height))
+	channels := byte(4)
+	if opaque(img) {
+		channels = 3
+	}
+	hdr = append(hdr, channels, 0)
+	_, _ = bw.Write(hdr)
+
+	var index [64][4]byte
+	prev := [4]byte{0, 0, 0, 0xff}
+	run := 0
+	last := width*height - 1
+	var px [4]byte
+	for y := 0; y < height; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		for x := 0; x < width; x++ {
+			straight(px[:], row[4*x:4*x+4])
+			if px == prev {
+				run++
+				if run == 62 || y*width+x == last {
+					_ = bw.WriteByte(qoiOpRun | byte(run-1))
+					run = 0
+				}
+				continue
+			}
+			if run > 0 {
+				_ = bw.WriteByte(qoiOpRun | byte(run-1))
+				run = 0
+			}
+			h := qoiHash(px)
+			if index[h] == px {
+				_ = bw.WriteByte(qoiOpIndex | h)
+				prev = px
+				continue
+			}
+			index[h] = px
+			if px[3] != prev[3] {
+				_, _ = bw.Write([]byte{qoiOpRGBA, px[0], px[1], px[2], px[3]})
+				prev = px
+				continue
+			}
+			vr := int8(px[0] - prev[0])
+			vg := int8(px[1] - prev[1])
+			vb := int8(px[2] - prev[2])
+			vgr, vgb := vr-vg, vb-vg
+			switch {
+			case vr >= -2 && vr <= 1 && vg >= -2 && vg <= 1 && vb >= -2 && vb <= 1:
+				_ = bw.WriteByte(qoiOpDiff | byte(vr+2)<<4 | byte(vg+2)<<2 | byte(vb+2))
+			case vg >= -32 && vg <= 31 && vgr >= -8 && vgr <= 7 && vgb >= -8 && vgb <= 7:
+				_, _ = bw.Write([]byte{qoiOpLuma | byte(vg+32), byte(vgr+8)<<4 | byte(vgb+8)})
+			default:
+				_, _ = bw.Write([]byte{qoiOpRGB, px[0], px[1], px[2]})
+			}
+			prev = px
+		}
+	}
+	_, _ = bw.Write(qoiEnd[:])
+	return bw.Flush()
+}
+
+var errQOI = errors.New("encode: invalid QOI image")
+
+// DecodeQOIConfig returns the size and colour model of a QOI image.
+func DecodeQOIConfig(r io.Reader) (image.Config, error) {
+	var hdr [14]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return image.Config{}, err
+	}
+	if string(hdr[:4]) != qoiMagic || hdr[12] < 3 || hdr[12] > 4 {
+		return image.Config{}, errQOI
+	}
+	w, h := binary.BigEndian.Uint32(hdr[4:]), binary.BigEndian.Uint32(hdr[8:])
+	if w == 0 || h == 0 || uint64(w)*uint64(h) > maxQOIPixels {
+		return image.Config{}, errQOI
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: int(w), Height: int(h)}, nil
+}
+
+// DecodeQOI reads a QOI image. It is registered with the image package as "qoi".
+func DecodeQOI(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	cfg, err := DecodeQOIConfig(br)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+	var index [64][4]byte
+	px := [4]byte{0, 0, 0, 0xff}
+	run := 0
+	for i := 0; i < len(img.Pix); i += 4 {
+		if run > 0 {
+			run--
+		} else {
+			b, err := br.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case b == qoiOpRGB:
+				if _, err := io.ReadFull(br, px[:3]); err != nil {
+					return nil, err
+				}
+			case b == qoiOpRGBA:
+				if _, err := io.ReadFull(br, px[:]); err != nil {
+					return nil, err
+				}
+			case b&qoiMask == qoiOpIndex:
+				px = index[b]
+			case b&qoiMask == qoiOpDiff:
+				px[0] += (b>>4)&3 - 2
+				px[1] += (b>>2)&3 - 2
+				px[2] += b&3 - 2
+			case b&qoiMask == qoiOpLuma:
+				b2, err := br.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				vg := b&0x3f - 32
+				px[0] += vg + b2>>4 - 8
+				px[1] += vg
+				px[2] += vg + b2&0x0f - 8
+			default:
+				run = int(b & 0x3f)
+			}
+			index[qoiHash(px)] = px
+		}
+		copy(img.Pix[i:i+4], px[:])
+	}
+	var end [8]byte
+	if _, err := io.ReadFull(br, end[:]); err != nil || end != qoiEnd {
+		return nil, errQOI
+	}
+	return img, nil
+}
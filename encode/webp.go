@@ -0,0 +1,926 @@
+package encode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"math/bits"
+	"slices"
+)
+
+// Lossless WebP (VP8L), see https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification.
+// The encoder applies the subtract-green and predictor transforms and compresses the
+// residuals with LZ77, a colour cache and one set of prefix codes; it uses no meta
+// prefix codes.
+
+const (
+	vp8lSignature = 0x2f
+	vp8lMaxSize   = 1 << 14
+
+	vp8lTransformPredictor     = 0
+	vp8lTransformSubtractGreen = 2
+
+	// vp8lCacheBits is the log2 of the colour cache size.
+	vp8lCacheBits = 10
+	// vp8lPredictorBits is the log2 of the predictor block size.
+	vp8lPredictorBits = 4
+
+	vp8lNumLiterals      = 256
+	vp8lNumLengthCodes   = 24
+	vp8lNumDistanceCodes = 40
+	vp8lMaxCodeBits      = 15
+	vp8lMaxCodeLenBits   = 7
+
+	// vp8lMinMatch and vp8lMaxMatch bound the LZ77 backward references.
+	vp8lMinMatch = 3
+	vp8lMaxMatch = 4096
+	// vp8lWindow is the largest distance the 40 distance codes can express, less the
+	// 120 codes reserved for the neighbourhood of the current pixel.
+	vp8lWindow = 1<<20 - 120
+	// vp8lMaxChain bounds the candidates tried per position.
+	vp8lMaxChain = 16
+	vp8lHashBits = 16
+)
+
+// vp8lCodeLengthOrder is the order in which the lengths of the code length code are stored.
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// WebP writes img to w as a lossless WebP image.
+func WebP(w io.Writer, img *image.RGBA) error {
+	if err := checkSize(img, "webp", vp8lMaxSize); err != nil {
+		return err
+	}
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	argb := make([]uint32, width*height)
+	var px [4]byte
+	for y := 0; y < height; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		for x := 0; x < width; x++ {
+			straight(px[:], row[4*x:4*x+4])
+			g := uint32(px[1])
+			// Subtract green from red and blue.
+			argb[y*width+x] = uint32(px[3])<<24 | uint32(px[0]-px[1])<<16 | g<<8 | uint32(px[2]-px[1])
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if opaque(img) {
+		bw.write(0, 1)
+	} else {
+		bw.write(1, 1)
+	}
+	bw.write(0, 3) // version
+
+	bw.write(1, 1)
+	bw.write(vp8lTransformSubtractGreen, 2)
+
+	modes, mw := choosePredictors(argb, width, height)
+	bw.write(1, 1)
+	bw.write(vp8lTransformPredictor, 2)
+	bw.write(vp8lPredictorBits-2, 3)
+	writeEntropyImage(bw, modes, mw, false, 0)
+	residuals := predict(argb, width, height, modes, mw)
+
+	bw.write(0, 1) // no more transforms
+	writeEntropyImage(bw, residuals, width, true, vp8lCacheBits)
+	data := bw.flush()
+
+	out := bufio.NewWriter(w)
+	pad := len(data) & 1
+	_, _ = out.WriteString("RIFF")
+	_ = binary.Write(out, binary.LittleEndian, uint32(4+8+len(data)+pad))
+	_, _ = out.WriteString("WEBPVP8L")
+	_ = binary.Write(out, binary.LittleEndian, uint32(len(data)))
+	_, _ = out.Write(data)
+	if pad != 0 {
+		_ = out.WriteByte(0)
+	}
+	return out.Flush()
+}
+
+// choosePredictors picks for each block the predictor with the smallest residuals and
+// returns the predictor image, with the mode in the green channel, and its width.
+func choosePredictors(argb []uint32, width, height int) ([]uint32, int) {
+	const size = 1 << vp8lPredictorBits
+	mw, mh := (width+size-1)/size, (height+size-1)/size
+	modes := make([]uint32, mw*mh)
+	for by := 0; by < mh; by++ {
+		for bx := 0; bx < mw; bx++ {
+			best, bestCost := 0, -1
+			for mode := 0; mode < 14; mode++ {
+				cost := 0
+				for y := by * size; y < min(height, (by+1)*size) && (bestCost < 0 || cost < bestCost); y++ {
+					for x := bx * size; x < min(width, (bx+1)*size); x++ {
+						i := y*width + x
+						r := subPixels(argb[i], predictPixel(argb, i, x, y, width, mode))
+						cost += residualCost(r)
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[by*mw+bx] = 0xff000000 | uint32(best)<<8
+		}
+	}
+	return modes, mw
+}
+
+// predict returns the residuals of argb under the block predictors of modes.
+func predict(argb []uint32, width, height int, modes []uint32, mw int) []uint32 {
+	res := make([]uint32, len(argb))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			mode := int(modes[(y>>vp8lPredictorBits)*mw+x>>vp8lPredictorBits] >> 8 & 0xff)
+			res[i] = subPixels(argb[i], predictPixel(argb, i, x, y, width, mode))
+		}
+	}
+	return res
+}
+
+// residualCost estimates the cost of a residual as the sum of its signed channel magnitudes.
+func residualCost(r uint32) int {
+	return int(absInt8(byte(r>>24))) + int(absInt8(byte(r>>16))) + int(absInt8(byte(r>>8))) + int(absInt8(byte(r)))
+}
+
+// predictPixel returns the prediction of pixel i at (x, y). The top-left pixel is
+// predicted as opaque black, the rest of the top row from the left and the left column
+// from the top; mode applies to all other pixels.
+func predictPixel(argb []uint32, i, x, y, width, mode int) uint32 {
+	switch {
+	case y == 0 && x == 0:
+		return 0xff000000
+	case y == 0:
+		return argb[i-1]
+	case x == 0:
+		return argb[i-width]
+	}
+	l, t, tl := argb[i-1], argb[i-width], argb[i-width-1]
+	// For the rightmost column this is the leftmost pixel of the current row, as the
+	// specification requires.
+	tr := argb[i-width+1]
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return average2(average2(l, tr), t)
+	case 6:
+		return average2(l, tl)
+	case 7:
+		return average2(l, t)
+	case 8:
+		return average2(tl, t)
+	case 9:
+		return average2(t, tr)
+	case 10:
+		return average2(average2(l, tl), average2(t, tr))
+	case 11:
+		return selectPixel(l, t, tl)
+	case 12:
+		return clampAddSubtractFull(l, t, tl)
+	}
+	return clampAddSubtractHalf(average2(l, t), tl)
+}
+
+func average2(a, b uint32) uint32 {
+	return ((a^b)&0xfefefefe)>>1 + a&b
+}
+
+// selectPixel returns whichever of l and t is closer to the gradient estimate l+t-tl.
+func selectPixel(l, t, tl uint32) uint32 {
+	pl, pt := 0, 0
+	for s := 0; s < 32; s += 8 {
+		pl += abs(int(t>>s&0xff) - int(tl>>s&0xff))
+		pt += abs(int(l>>s&0xff) - int(tl>>s&0xff))
+	}
+	if pl < pt {
+		return l
+	}
+	return t
+}
+
+func clampAddSubtractFull(a, b, c uint32) uint32 {
+	var p uint32
+	for s := 0; s < 32; s += 8 {
+		v := int(a>>s&0xff) + int(b>>s&0xff) - int(c>>s&0xff)
+		p |= uint32(min(255, max(0, v))) << s
+	}
+	return p
+}
+
+func clampAddSubtractHalf(a, b uint32) uint32 {
+	var p uint32
+	for s := 0; s < 32; s += 8 {
+		ca := int(a >> s & 0xff)
+		v := ca + (ca-int(b>>s&0xff))/2
+		p |= uint32(min(255, max(0, v))) << s
+	}
+	return p
+}
+
+// subPixels subtracts b from a channel by channel, modulo 256.
+func subPixels(a, b uint32) uint32 {
+	ag := 0x00ff00ff + a&0xff00ff00 - b&0xff00ff00
+	rb := 0xff00ff00 + a&0x00ff00ff - b&0x00ff00ff
+	return ag&0xff00ff00 | rb&0x00ff00ff
+}
+
+// vp8lToken is a literal pixel, a colour cache hit or a backward reference.
+type vp8lToken struct {
+	kind   int
+	argb   uint32 // literal
+	index  int    // colour cache index
+	length int    // backward reference length
+	dist   int    // distance code
+}
+
+const (
+	tokenLiteral = iota
+	tokenCache
+	tokenCopy
+)
+
+// writeEntropyImage writes argb, an image of the given width, as LZ77-coded pixels with
+// one set of prefix codes and, when cacheBits is non-zero, a colour cache of that many
+// bits. Only the main image has the meta prefix code bit.
+func writeEntropyImage(bw *bitWriter, argb []uint32, width int, main bool, cacheBits int) {
+	if cacheBits > 0 {
+		bw.write(1, 1)
+		bw.write(uint32(cacheBits), 4)
+	} else {
+		bw.write(0, 1)
+	}
+	if main {
+		bw.write(0, 1) // no meta prefix codes
+	}
+	tokens := lz77(argb, width)
+	if cacheBits > 0 {
+		useColorCache(tokens, argb, cacheBits)
+	}
+
+	freq := [5][]int{
+		make([]int, vp8lNumLiterals+vp8lNumLengthCodes+1<<cacheBits),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, vp8lNumDistanceCodes),
+	}
+	if cacheBits == 0 {
+		freq[0] = freq[0][:vp8lNumLiterals+vp8lNumLengthCodes]
+	}
+	for _, t := range tokens {
+		switch t.kind {
+		case tokenLiteral:
+			freq[0][t.argb>>8&0xff]++
+			freq[1][t.argb>>16&0xff]++
+			freq[2][t.argb&0xff]++
+			freq[3][t.argb>>24]++
+		case tokenCache:
+			freq[0][vp8lNumLiterals+vp8lNumLengthCodes+t.index]++
+		case tokenCopy:
+			lc, _, _ := prefixEncode(t.length)
+			dc, _, _ := prefixEncode(t.dist)
+			freq[0][vp8lNumLiterals+lc]++
+			freq[4][dc]++
+		}
+	}
+	var codes [5]prefixCode
+	for i := range codes {
+		codes[i] = newPrefixCode(freq[i], vp8lMaxCodeBits)
+		codes[i].writeHeader(bw)
+	}
+	for _, t := range tokens {
+		switch t.kind {
+		case tokenLiteral:
+			codes[0].write(bw, int(t.argb>>8&0xff))
+			codes[1].write(bw, int(t.argb>>16&0xff))
+			codes[2].write(bw, int(t.argb&0xff))
+			codes[3].write(bw, int(t.argb>>24))
+		case tokenCache:
+			codes[0].write(bw, vp8lNumLiterals+vp8lNumLengthCodes+t.index)
+		case tokenCopy:
+			lc, n, extra := prefixEncode(t.length)
+			codes[0].write(bw, vp8lNumLiterals+lc)
+			bw.write(uint32(extra), uint(n))
+			dc, n, extra := prefixEncode(t.dist)
+			codes[4].write(bw, dc)
+			bw.write(uint32(extra), uint(n))
+		}
+	}
+}
+
+// useColorCache turns literals found in the colour cache into cache hits. The cache
+// holds the last pixel seen for each hash value, including copied pixels.
+func useColorCache(tokens []vp8lToken, p []uint32, cacheBits int) {
+	cache := make([]uint32, 1<<cacheBits)
+	valid := make([]bool, len(cache))
+	shift := 32 - cacheBits
+	i := 0
+	for k := range tokens {
+		t := &tokens[k]
+		n := 1
+		if t.kind == tokenCopy {
+			n = t.length
+		} else if h := int(0x1e35a7bd * t.argb >> shift); valid[h] && cache[h] == t.argb {
+			t.kind, t.index = tokenCache, h
+		}
+		for ; n > 0; n, i = n-1, i+1 {
+			h := 0x1e35a7bd * p[i] >> shift
+			cache[h], valid[h] = p[i], true
+		}
+	}
+}
+
+// lz77 splits p into literals and backward references found with hash chains. The
+// pixel to the left and the one above are tried first since they have the shortest
+// distance codes.
+func lz77(p []uint32, width int) []vp8lToken {
+	head := make([]int32, 1<<vp8lHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	chain := make([]int32, len(p))
+	hash := func(i int) uint32 {
+		return (p[i]*0x1e35a7bd + p[i+1]*0x9e3779b1) >> (32 - vp8lHashBits)
+	}
+	insert := func(i int) {
+		if i+1 < len(p) {
+			h := hash(i)
+			chain[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+	match := func(i, d int) int {
+		n, limit := 0, min(vp8lMaxMatch, len(p)-i)
+		for n < limit && p[i+n] == p[i+n-d] {
+			n++
+		}
+		return n
+	}
+
+	var tokens []vp8lToken
+	for i := 0; i < len(p); {
+		bestLen, bestDist := 0, 0
+		for _, d := range [2]int{1, width} {
+			if d <= i {
+				if n := match(i, d); n > bestLen {
+					bestLen, bestDist = n, d
+				}
+			}
+		}
+		if bestLen < vp8lMaxMatch && i+1 < len(p) {
+			for j, tries := head[hash(i)], 0; j >= 0 && tries < vp8lMaxChain && i-int(j) <= vp8lWindow; j, tries = chain[j], tries+1 {
+				if n := match(i, i-int(j)); n > bestLen {
+					bestLen, bestDist = n, i-int(j)
+					if n == vp8lMaxMatch {
+						break
+					}
+				}
+			}
+		}
+		if bestLen < vp8lMinMatch {
+			tokens = append(tokens, vp8lToken{kind: tokenLiteral, argb: p[i]})
+			insert(i)
+			i++
+			continue
+		}
+		tokens = append(tokens, vp8lToken{kind: tokenCopy, length: bestLen, dist: distanceCode(bestDist, width)})
+		for k := i; k < i+bestLen; k++ {
+			insert(k)
+		}
+		i += bestLen
+	}
+	return tokens
+}
+
+// distanceCode maps a distance to its code: 1 is the pixel above, 2 the pixel to the
+// left and codes above 120 are plain distances.
+func distanceCode(d, width int) int {
+	switch d {
+	case width:
+		return 1
+	case 1:
+		return 2
+	}
+	return d + 120
+}
+
+// prefixEncode splits a length or distance code v into a prefix symbol and extra bits.
+func prefixEncode(v int) (sym, nbits, extra int) {
+	d := v - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	hb := bits.Len(uint(d)) - 1
+	second := d >> (hb - 1) & 1
+	nbits = hb - 1
+	return 2*hb + second, nbits, d & (1<<nbits - 1)
+}
+
+// prefixCode is a canonical prefix code for the symbols of one alphabet.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16 // bit-reversed, as the bit writer is LSB first
+	// single is set when at most one symbol is used. Such codes take no bits.
+	single bool
+}
+
+func newPrefixCode(freq []int, maxBits int) prefixCode {
+	lengths := huffmanLengths(freq, maxBits)
+	used := 0
+	for _, l := range lengths {
+		if l > 0 {
+			used++
+		}
+	}
+	return prefixCode{lengths: lengths, codes: canonicalCodes(lengths), single: used <= 1}
+}
+
+func (c *prefixCode) write(bw *bitWriter, sym int) {
+	if !c.single {
+		bw.write(uint32(c.codes[sym]), uint(c.lengths[sym]))
+	}
+}
+
+// writeHeader writes c as a simple code when it has at most two symbols below 256,
+// otherwise as a normal code with run-length coded code lengths.
+func (c *prefixCode) writeHeader(bw *bitWriter) {
+	var used []int
+	for s, l := range c.lengths {
+		if l > 0 {
+			used = append(used, s)
+		}
+	}
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		bw.write(1, 1)
+		bw.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+		}
+		return
+	}
+
+	bw.write(0, 1)
+	type token struct{ sym, extra, nbits int }
+	var tokens []token
+	prev := uint8(8)
+	for i := 0; i < len(c.lengths); {
+		v := c.lengths[i]
+		run := 1
+		for i+run < len(c.lengths) && c.lengths[i+run] == v {
+			run++
+		}
+		i += run
+		if v == 0 {
+			for run >= 3 {
+				if run >= 11 {
+					r := min(run, 138)
+					tokens = append(tokens, token{18, r - 11, 7})
+					run -= r
+				} else {
+					r := min(run, 10)
+					tokens = append(tokens, token{17, r - 3, 3})
+					run -= r
+				}
+			}
+		} else {
+			if v != prev {
+				tokens = append(tokens, token{int(v), 0, 0})
+				prev = v
+				run--
+			}
+			for run >= 3 {
+				r := min(run, 6)
+				tokens = append(tokens, token{16, r - 3, 2})
+				run -= r
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, token{int(v), 0, 0})
+		}
+	}
+
+	freq := make([]int, len(vp8lCodeLengthOrder))
+	for _, t := range tokens {
+		freq[t.sym]++
+	}
+	cl := newPrefixCode(freq, vp8lMaxCodeLenBits)
+	n := len(vp8lCodeLengthOrder)
+	for n > 4 && cl.lengths[vp8lCodeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	bw.write(uint32(n-4), 4)
+	for _, s := range vp8lCodeLengthOrder[:n] {
+		bw.write(uint32(cl.lengths[s]), 3)
+	}
+	bw.write(0, 1) // code lengths for the whole alphabet follow
+	for _, t := range tokens {
+		cl.write(bw, t.sym)
+		bw.write(uint32(t.extra), uint(t.nbits))
+	}
+}
+
+// huffmanLengths returns the Huffman code lengths for freq, limited to maxBits by
+// raising the smallest frequencies until the tree is shallow enough. A lone symbol gets
+// length 1.
+func huffmanLengths(freq []int, maxBits int) []uint8 {
+	lengths := make([]uint8, len(freq))
+	var syms []int
+	for s, f := range freq {
+		if f > 0 {
+			syms = append(syms, s)
+		}
+	}
+	switch len(syms) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[syms[0]] = 1
+		return lengths
+	}
+	type node struct{ freq, parent int }
+	n := len(syms)
+	nodes := make([]node, 2*n-1)
+	depth := make([]int, 2*n-1)
+	for floor := 1; ; floor *= 2 {
+		weight := func(s int) int { return max(freq[s], floor) }
+		slices.SortStableFunc(syms, func(a, b int) int { return weight(a) - weight(b) })
+		for i, s := range syms {
+			nodes[i] = node{freq: weight(s)}
+		}
+		// Two-queue construction: leaves in nodes[:n] and internal nodes appended in
+		// non-decreasing order after them.
+		leaf, inner, next := 0, n, n
+		pick := func() int {
+			if leaf < n && (inner >= next || nodes[leaf].freq <= nodes[inner].freq) {
+				leaf++
+				return leaf - 1
+			}
+			inner++
+			return inner - 1
+		}
+		for ; next < 2*n-1; next++ {
+			a, b := pick(), pick()
+			nodes[next] = node{freq: nodes[a].freq + nodes[b].freq}
+			nodes[a].parent, nodes[b].parent = next, next
+		}
+		depth[2*n-2] = 0
+		longest := 0
+		for i := 2*n - 3; i >= 0; i-- {
+			depth[i] = depth[nodes[i].parent] + 1
+			longest = max(longest, depth[i])
+		}
+		if longest <= maxBits {
+			for i, s := range syms {
+				lengths[s] = uint8(depth[i])
+			}
+			return lengths
+		}
+	}
+}
+
+// canonicalCodes assigns canonical codes to lengths and reverses their bits.
+func canonicalCodes(lengths []uint8) []uint16 {
+	var count [vp8lMaxCodeBits + 1]int
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [vp8lMaxCodeBits + 2]int
+	code := 0
+	for l := 1; l <= vp8lMaxCodeBits; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint16, len(lengths))
+	for s, l := range lengths {
+		if l > 0 {
+			codes[s] = bits.Reverse16(uint16(next[l])) >> (16 - l)
+			next[l]++
+		}
+	}
+	return codes
+}
+
+// bitWriter packs values least significant bit first.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (b *bitWriter) write(v uint32, n uint) {
+	b.acc |= uint64(v) << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.nbits -= 8
+	}
+}
+
+// flush pads the last byte with zeros and returns the written bytes.
+func (b *bitWriter) flush() []byte {
+	if b.nbits > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc, b.nbits = 0, 0
+	}
+	return b.buf
+}
+
+// DecodeWebP reads a lossless WebP image as written by WebP: other WebP files, which
+// use lossy compression, the colour indexing or cross-colour transforms, meta
+// prefix codes or distance codes for the neighbourhood other than the pixel above
+// and the one to the left, return an error. It is not registered with the image
+// package, as it does not decode WebP in general.
+func DecodeWebP(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 21 || string(data[:4]) != "RIFF" || string(data[8:16]) != "WEBPVP8L" {
+		return nil, errors.New("encode: not a lossless WebP image")
+	}
+	if int(binary.LittleEndian.Uint32(data[4:])) != len(data)-8 {
+		return nil, errors.New("encode: wrong WebP RIFF size")
+	}
+	n := int(binary.LittleEndian.Uint32(data[16:]))
+	if n > len(data)-20 {
+		return nil, errors.New("encode: truncated WebP image")
+	}
+	br := &bitReader{data: data[20 : 20+n]}
+	if br.read(8) != vp8lSignature {
+		return nil, errors.New("encode: bad VP8L signature")
+	}
+	w, h := int(br.read(14))+1, int(br.read(14))+1
+	br.read(1) // alpha hint
+	if br.read(3) != 0 {
+		return nil, errors.New("encode: unsupported WebP version")
+	}
+
+	type transform struct {
+		kind  uint32
+		bits  int
+		modes []uint32
+	}
+	var transforms []transform
+	for br.read(1) == 1 {
+		t := transform{kind: br.read(2)}
+		switch t.kind {
+		case vp8lTransformSubtractGreen:
+		case vp8lTransformPredictor:
+			t.bits = int(br.read(3)) + 2
+			size := 1 << t.bits
+			t.modes = br.readImage((w+size-1)/size, (h+size-1)/size, false)
+		default:
+			return nil, errors.New("encode: unsupported WebP transform")
+		}
+		transforms = append(transforms, t)
+	}
+	argb := br.readImage(w, h, true)
+	if br.err != nil {
+		return nil, br.err
+	}
+
+	for i := len(transforms) - 1; i >= 0; i-- {
+		t := transforms[i]
+		switch t.kind {
+		case vp8lTransformSubtractGreen:
+			for j, p := range argb {
+				g := p >> 8 & 0xff
+				argb[j] = p&0xff00ff00 | (p&0x00ff00ff+(g<<16|g))&0x00ff00ff
+			}
+		case vp8lTransformPredictor:
+			mw := (w + 1<<t.bits - 1) >> t.bits
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					j := y*w + x
+					mode := int(t.modes[(y>>t.bits)*mw+x>>t.bits] >> 8 & 0xff)
+					pred := predictPixel(argb, j, x, y, w, mode)
+					ag := argb[j]&0xff00ff00 + pred&0xff00ff00
+					rb := argb[j]&0x00ff00ff + pred&0x00ff00ff
+					argb[j] = ag&0xff00ff00 | rb&0x00ff00ff
+				}
+			}
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for j, p := range argb {
+		img.Pix[4*j], img.Pix[4*j+1], img.Pix[4*j+2], img.Pix[4*j+3] = byte(p>>16), byte(p>>8), byte(p), byte(p>>24)
+	}
+	return img, nil
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *bitReader) read(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		if r.pos>>3 >= len(r.data) {
+			r.err = errors.New("encode: truncated WebP image")
+			return 0
+		}
+		v |= uint32(r.data[r.pos>>3]>>(r.pos&7)&1) << i
+		r.pos++
+	}
+	return v
+}
+
+// readImage reads an entropy-coded image of w×h pixels.
+func (r *bitReader) readImage(w, h int, main bool) []uint32 {
+	cacheBits := 0
+	if r.read(1) == 1 {
+		cacheBits = int(r.read(4))
+		if cacheBits < 1 || cacheBits > 11 {
+			r.err = errors.New("encode: invalid WebP colour cache size")
+			return nil
+		}
+	}
+	if main && r.read(1) != 0 {
+		r.err = errors.New("encode: WebP meta prefix codes are not supported")
+		return nil
+	}
+	var codes [5]decodeCode
+	for i, size := range []int{vp8lNumLiterals + vp8lNumLengthCodes + 1<<cacheBits, 256, 256, 256, vp8lNumDistanceCodes} {
+		if i == 0 && cacheBits == 0 {
+			size = vp8lNumLiterals + vp8lNumLengthCodes
+		}
+		codes[i] = r.readCode(size)
+	}
+	cache := make([]uint32, 1<<cacheBits)
+	argb := make([]uint32, w*h)
+	for i, cached := 0, 0; i < len(argb) && r.err == nil; {
+		g := codes[0].decode(r)
+		switch {
+		case g < vp8lNumLiterals:
+			red, blue, alpha := codes[1].decode(r), codes[2].decode(r), codes[3].decode(r)
+			argb[i] = uint32(alpha)<<24 | uint32(red)<<16 | uint32(g)<<8 | uint32(blue)
+			i++
+		case g >= vp8lNumLiterals+vp8lNumLengthCodes:
+			argb[i] = cache[g-vp8lNumLiterals-vp8lNumLengthCodes]
+			i++
+		default:
+			length := r.prefixValue(g - vp8lNumLiterals)
+			dist := r.prefixValue(codes[4].decode(r))
+			switch {
+			case dist == 1:
+				dist = w
+			case dist == 2:
+				dist = 1
+			case dist > 120:
+				dist -= 120
+			default:
+				r.err = errors.New("encode: WebP neighbourhood distance codes are not supported")
+				return nil
+			}
+			if dist > i || i+length > len(argb) {
+				r.err = errors.New("encode: WebP backward reference out of range")
+				return nil
+			}
+			for k := 0; k < length; k++ {
+				argb[i] = argb[i-dist]
+				i++
+			}
+		}
+		if cacheBits > 0 {
+			for ; cached < i; cached++ {
+				cache[0x1e35a7bd*argb[cached]>>(32-cacheBits)] = argb[cached]
+			}
+		}
+	}
+	return argb
+}
+
+func (r *bitReader) prefixValue(sym int) int {
+	if sym < 4 {
+		return sym + 1
+	}
+	extra := (sym - 2) >> 1
+	offset := (2 + sym&1) << extra
+	return offset + int(r.read(extra)) + 1
+}
+
+// decodeCode maps code lengths and codes to symbols.
+type decodeCode struct {
+	symbols map[[2]int]int
+	single  int // the symbol of a code with one symbol, -1 otherwise
+}
+
+func newDecodeCode(lengths []int) decodeCode {
+	c := decodeCode{symbols: make(map[[2]int]int), single: -1}
+	used := 0
+	for s, l := range lengths {
+		if l > 0 {
+			used++
+			c.single = s
+		}
+	}
+	if used != 1 {
+		c.single = -1
+	}
+	code := 0
+	for l := 1; l <= vp8lMaxCodeBits; l++ {
+		for s, sl := range lengths {
+			if sl == l {
+				c.symbols[[2]int{l, code}] = s
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return c
+}
+
+func (c decodeCode) decode(r *bitReader) int {
+	if c.single >= 0 {
+		return c.single
+	}
+	code := 0
+	for l := 1; l <= vp8lMaxCodeBits; l++ {
+		code = code<<1 | int(r.read(1))
+		if s, ok := c.symbols[[2]int{l, code}]; ok {
+			return s
+		}
+	}
+	r.err = errors.New("encode: invalid WebP prefix code")
+	return 0
+}
+
+func (r *bitReader) readCode(size int) decodeCode {
+	lengths := make([]int, size)
+	if r.read(1) == 1 {
+		n := r.read(1) + 1
+		symbols := []uint32{r.read(1 + 7*int(r.read(1)))}
+		if n == 2 {
+			symbols = append(symbols, r.read(8))
+		}
+		for _, s := range symbols {
+			if int(s) >= size {
+				r.err = errors.New("encode: invalid WebP prefix code")
+				return decodeCode{}
+			}
+			lengths[s] = 1
+		}
+		return newDecodeCode(lengths)
+	}
+	clLengths := make([]int, len(vp8lCodeLengthOrder))
+	for _, s := range vp8lCodeLengthOrder[:r.read(4)+4] {
+		clLengths[s] = int(r.read(3))
+	}
+	cl := newDecodeCode(clLengths)
+	if r.read(1) != 0 {
+		r.err = errors.New("encode: WebP max_symbol is not supported")
+		return decodeCode{}
+	}
+	prev := 8
+	for i := 0; i < size && r.err == nil; {
+		sym := cl.decode(r)
+		repeat, value := 1, sym
+		switch sym {
+		case 16:
+			repeat, value = 3+int(r.read(2)), prev
+		case 17:
+			repeat, value = 3+int(r.read(3)), 0
+		case 18:
+			repeat, value = 11+int(r.read(7)), 0
+		default:
+			if sym != 0 {
+				prev = sym
+			}
+		}
+		for ; repeat > 0 && i < size; repeat-- {
+			lengths[i] = value
+			i++
+		}
+	}
+	return newDecodeCode(lengths)
+}
@@ -0,0 +1,76 @@
+package encode
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestWebP(t *testing.T) {
+	img := screen(300, 200)
+	noise := image.NewRGBA(image.Rect(0, 0, 37, 29))
+	for i := range noise.Pix {
+		noise.Pix[i] = byte(i * 7919 >> 3)
+		if i%4 == 3 {
+			noise.Pix[i] = 0xff
+		}
+	}
+	flat := image.NewRGBA(image.Rect(0, 0, 100, 3))
+	for i := range flat.Pix {
+		flat.Pix[i] = 0xff
+	}
+	single := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	single.SetRGBA(0, 0, color.RGBA{1, 2, 3, 255})
+	for name, src := range map[string]*image.RGBA{
+		"screen":      img,
+		"translucent": translucent(img),
+		"subimage":    img.SubImage(image.Rect(31, 7, 250, 190)).(*image.RGBA),
+		"noise":       noise,
+		"flat":        flat,
+		"single":      single,
+	} {
+		var buf bytes.Buffer
+		if err := WebP(&buf, src); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if buf.Len()&1 != 0 {
+			t.Errorf("%s: odd file size %d", name, buf.Len())
+		}
+		got, err := DecodeWebP(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		sameImage(t, got, src)
+	}
+
+	var pngBuf, webpBuf bytes.Buffer
+	_ = PNG(&pngBuf, img, nil)
+	_ = WebP(&webpBuf, img)
+	if webpBuf.Len() >= pngBuf.Len() {
+		t.Errorf("WebP of %d bytes is not smaller than PNG of %d bytes", webpBuf.Len(), pngBuf.Len())
+	}
+
+	if err := WebP(&bytes.Buffer{}, image.NewRGBA(image.Rect(0, 0, vp8lMaxSize+1, 1))); err == nil {
+		t.Error("oversized image encoded without error")
+	}
+}
+
+func TestDecodeWebPMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WebP(&buf, screen(60, 40)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// Truncated and corrupted files return errors or wrong pixels, but never panic.
+	for n := 0; n < len(data); n += 7 {
+		if _, err := DecodeWebP(bytes.NewReader(data[:n])); err == nil {
+			t.Errorf("%d of %d bytes decoded without error", n, len(data))
+		}
+	}
+	for i := 21; i < len(data); i++ {
+		bad := bytes.Clone(data)
+		bad[i] ^= 0x5a
+		_, _ = DecodeWebP(bytes.NewReader(bad))
+	}
+}
@@ -3,12 +3,13 @@ package main
 import (
 	"fmt"
 	"image"
-	"image/png"
+	"image/color"
 	"log/slog"
 	"os"
 	"runtime"
 
 	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/encode"
 )
 
 // save *image.RGBA to filePath with PNG format.
@@ -39,10 +40,6 @@ func save(img *image.RGBA, filePath string) {
 		}
 	}()
 
-	enc := png.Encoder{
-		CompressionLevel: png.BestCompression,
-	}
-
 	/*	// Сохраняем копию изображения на случай, если оригинал станет недействительным
 		imgCopy := &image.RGBA{
 			Pix:    make([]byte, len(img.Pix)),
@@ -51,7 +48,7 @@ func save(img *image.RGBA, filePath string) {
 		}
 		copy(imgCopy.Pix, img.Pix)*/
 
-	err = enc.Encode(file, img)
+	err = encode.PNG(file, img, &encode.PNGOptions{Speed: encode.PNGFast})
 	if err != nil {
 		panic(err)
 	}
@@ -77,22 +74,18 @@ func main() {
 		panic(err)
 	}
 
-	for i := 0; i < n; i++ {
-		bounds, err := screenshot.GetDisplayBounds(i)
-		if err != nil {
-			panic(fmt.Sprintf("Bounds uncorrected for display %d: %v", i, err))
-		}
-		all = bounds.Union(all)
+	displays, err := screenshot.CaptureAllDisplays()
+	if err != nil {
+		panic(fmt.Sprintf("Capture failed: %v", err))
+	}
 
-		img, err := screenshot.CaptureRect(bounds)
-		if err != nil {
-			panic(fmt.Sprintf("Capture failed for display %d: %v", i, err))
-		}
+	for _, d := range displays {
+		all = d.Bounds.Union(all)
 
-		fileName := fmt.Sprintf("example/%d_%dx%d.png", i, bounds.Dx(), bounds.Dy())
-		save(img, fileName)
+		fileName := fmt.Sprintf("example/%d_%dx%d.png", d.Index, d.Bounds.Dx(), d.Bounds.Dy())
+		save(d.Image, fileName)
 
-		fmt.Printf("#%d : %v \"%s\"\n", i, bounds, fileName)
+		fmt.Printf("#%d : %v \"%s\"\n", d.Index, d.Bounds, fileName)
 	}
 	// Capture all desktop region into an image.
 	if all.Empty() {
@@ -100,10 +93,10 @@ func main() {
 	}
 
 	fmt.Printf("Capturing full area: %v\n", all)
-	img, err := screenshot.Capture(all.Min.X, all.Min.Y, all.Dx(), all.Dy())
+	desktop, err := screenshot.CaptureVirtualDesktop(screenshot.VirtualDesktopOptions{Fill: color.Black})
 	if err != nil {
 		panic(fmt.Sprintf("Full capture failed: %v", err))
 	}
-	save(img, "example/all.png")
+	save(desktop.Image, "example/all.png")
 
 }
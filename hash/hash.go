@@ -0,0 +1,170 @@
+// Package hash fingerprints captured images, to skip duplicate frames and to tell
+// a meaningful change of the screen from a blinking cursor or a ticking clock.
+//
+// Exact hashes the pixels with XXH64: equal hashes mean equal images, barring a
+// 1 in 2^64 collision. The perceptual hashes AHash, DHash and PHash reduce an image
+// to 64 bits describing its coarse structure; similar images get hashes a small
+// Distance apart, so small changes such as a cursor, a clock or a few characters of
+// text typically move a hash by no more than a few bits, while a new window or a
+// scrolled page moves it by a dozen or more. PHash is the most robust of the three,
+// DHash the cheapest to compare reliably.
+//
+// Every function reads the pixels in place and honours Stride, so a region is
+// hashed without copying by passing img.SubImage(r).(*image.RGBA).
+package hash
+
+import (
+	"encoding/binary"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// Exact returns the XXH64 hash of the size and the pixels of img, row by row.
+// Images with the same size and pixels hash the same wherever they lie in memory
+// and whatever their bounds.
+func Exact(img *image.RGBA) uint64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	d := newXXH64()
+	var size [8]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(max(w, 0)))
+	binary.LittleEndian.PutUint32(size[4:], uint32(max(h, 0)))
+	d.write(size[:])
+	for y := 0; y < h && w > 0; y++ {
+		i := img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y)
+		d.write(img.Pix[i : i+4*w])
+	}
+	return d.sum()
+}
+
+// AHash returns the average hash of img: a bit per cell of an 8×8 grid, set when
+// the cell is brighter than the mean of all cells.
+func AHash(img *image.RGBA) uint64 {
+	g := shrink(img, 8, 8)
+	var mean float64
+	for _, v := range g {
+		mean += v
+	}
+	mean /= float64(len(g))
+	var h uint64
+	for i, v := range g {
+		if v > mean {
+			h |= 1 << i
+		}
+	}
+	return h
+}
+
+// DHash returns the difference hash of img: on a 9×8 grid, a bit per pair of
+// horizontally adjacent cells, set when the right cell is brighter.
+func DHash(img *image.RGBA) uint64 {
+	g := shrink(img, 9, 8)
+	var h uint64
+	for y := range 8 {
+		for x := range 8 {
+			if g[y*9+x] < g[y*9+x+1] {
+				h |= 1 << (y*8 + x)
+			}
+		}
+	}
+	return h
+}
+
+// dctSize is the grid PHash transforms, of which the lowest 8×8 frequencies are kept.
+const dctSize = 32
+
+// dctCos holds the DCT-II basis for the lowest 8 frequencies.
+var dctCos [8][dctSize]float64
+
+func init() {
+	for u := range dctCos {
+		for x := range dctCos[u] {
+			dctCos[u][x] = math.Cos(math.Pi * float64(u) * (2*float64(x) + 1) / (2 * dctSize))
+		}
+	}
+}
+
+// PHash returns the perceptual hash of img: the image is reduced to a 32×32 grid
+// and transformed with a DCT, and a bit is set per coefficient of the lowest 8×8
+// frequencies that is above their median.
+func PHash(img *image.RGBA) uint64 {
+	g := shrink(img, dctSize, dctSize)
+	// Rows first, then columns, keeping 8 frequencies of each.
+	var rows [dctSize][8]float64
+	for y := range dctSize {
+		for u := range 8 {
+			var s float64
+			for x, v := range g[y*dctSize : (y+1)*dctSize] {
+				s += v * dctCos[u][x]
+			}
+			rows[y][u] = s
+		}
+	}
+	var coef [64]float64
+	for v := range 8 {
+		for u := range 8 {
+			var s float64
+			for y := range dctSize {
+				s += rows[y][u] * dctCos[v][y]
+			}
+			coef[v*8+u] = s
+		}
+	}
+
+	sorted := coef
+	sort.Float64s(sorted[:])
+	median := (sorted[31] + sorted[32]) / 2
+	var h uint64
+	for i, c := range coef {
+		if c > median {
+			h |= 1 << i
+		}
+	}
+	return h
+}
+
+// Distance returns the number of bits in which the perceptual hashes a and b differ,
+// from 0 for images alike to 64.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// shrink returns the mean luma of the cells of a w×h grid laid over img, row by
+// row. An image smaller than the grid repeats its pixels.
+func shrink(img *image.RGBA, w, h int) []float64 {
+	out := make([]float64, w*h)
+	iw, ih := img.Rect.Dx(), img.Rect.Dy()
+	if iw <= 0 || ih <= 0 {
+		return out
+	}
+	sums := make([]uint64, w*h)
+	counts := make([]uint32, w*h)
+	cols := make([]int, iw)
+	for x := range cols {
+		cols[x] = x * w / iw
+	}
+	for y := 0; y < ih; y++ {
+		cell := y * h / ih * w
+		i := img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y)
+		row := img.Pix[i : i+4*iw]
+		for x, cx := range cols {
+			sums[cell+cx] += uint64(luma(row[4*x : 4*x+3]))
+			counts[cell+cx]++
+		}
+	}
+	for i := range out {
+		if counts[i] == 0 {
+			x, y := i%w*iw/w, i/w*ih/h
+			j := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			sums[i], counts[i] = uint64(luma(img.Pix[j:j+3])), 1
+		}
+		out[i] = float64(sums[i]) / float64(counts[i]) / (1 << 16)
+	}
+	return out
+}
+
+// luma returns the BT.601 luma of an RGB pixel, scaled by 1<<16.
+func luma(p []byte) uint32 {
+	return 19595*uint32(p[0]) + 38470*uint32(p[1]) + 7471*uint32(p[2])
+}
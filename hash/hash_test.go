@@ -0,0 +1,162 @@
+package hash
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+
+	"github.com/Fast-IQ/screenshot/internal/imgutil"
+)
+
+func TestXXH64(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	} {
+		d := newXXH64()
+		d.write([]byte(tc.in))
+		if got := d.sum(); got != tc.want {
+			t.Errorf("XXH64(%q) = %#x, want %#x", tc.in, got, tc.want)
+		}
+	}
+
+	// Any split of the input gives the same hash.
+	data := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(data)
+	d := newXXH64()
+	d.write(data)
+	want := d.sum()
+	for _, chunk := range []int{1, 7, 31, 32, 33, 100} {
+		d := newXXH64()
+		for b := data; len(b) > 0; {
+			n := min(chunk, len(b))
+			d.write(b[:n])
+			b = b[n:]
+		}
+		if got := d.sum(); got != want {
+			t.Errorf("chunks of %d: %#x, want %#x", chunk, got, want)
+		}
+	}
+}
+
+// desktop returns a synthetic screen: a background, a few windows and lines of
+// "text".
+func desktop() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 400))
+	draw.Draw(img, img.Rect, image.NewUniform(color.RGBA{40, 70, 110, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(40, 30, 400, 300), image.NewUniform(color.RGBA{240, 240, 240, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(40, 30, 400, 50), image.NewUniform(color.RGBA{60, 60, 60, 255}), image.Point{}, draw.Src)
+	r := rand.New(rand.NewSource(2))
+	for y := 60; y < 290; y += 14 {
+		for x := 50; x < 390; x += 7 {
+			if r.Intn(5) > 0 {
+				draw.Draw(img, image.Rect(x, y, x+5, y+9), image.NewUniform(color.RGBA{20, 20, 20, 255}), image.Point{}, draw.Src)
+			}
+		}
+	}
+	draw.Draw(img, image.Rect(0, 370, 640, 400), image.NewUniform(color.RGBA{30, 30, 30, 255}), image.Point{}, draw.Src)
+	return img
+}
+
+func TestExact(t *testing.T) {
+	img := desktop()
+	h := Exact(img)
+	if h != Exact(desktop()) {
+		t.Fatal("equal images hash differently")
+	}
+
+	// A sub-image hashes like a copy of it.
+	r := image.Rect(33, 41, 250, 199)
+	sub := img.SubImage(r).(*image.RGBA)
+	cp := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(cp, cp.Rect, img, r.Min, draw.Src)
+	if Exact(sub) != Exact(cp) {
+		t.Error("sub-image and copy hash differently")
+	}
+
+	img.Pix[img.PixOffset(639, 399)] ^= 1
+	if Exact(img) == h {
+		t.Error("a changed pixel keeps the hash")
+	}
+	// The same bytes in another shape.
+	a := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	b := image.NewRGBA(image.Rect(0, 0, 1, 2))
+	if Exact(a) == Exact(b) {
+		t.Error("2×1 and 1×2 images hash the same")
+	}
+	Exact(image.NewRGBA(image.Rectangle{}))
+}
+
+func TestPerceptual(t *testing.T) {
+	base := desktop()
+
+	// A text cursor blinks.
+	cursor := desktop()
+	draw.Draw(cursor, image.Rect(200, 200, 202, 214), image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	// The same screen at half the resolution.
+	small := imgutil.Downscale(base, 0.5)
+
+	// A window opens.
+	window := desktop()
+	draw.Draw(window, image.Rect(300, 150, 620, 360), image.NewUniform(color.RGBA{250, 250, 200, 255}), image.Point{}, draw.Src)
+	draw.Draw(window, image.Rect(300, 150, 620, 170), image.NewUniform(color.RGBA{20, 40, 160, 255}), image.Point{}, draw.Src)
+
+	for _, f := range []struct {
+		name string
+		hash func(*image.RGBA) uint64
+	}{
+		{"AHash", AHash},
+		{"DHash", DHash},
+		{"PHash", PHash},
+	} {
+		h := f.hash(base)
+		if d := Distance(h, f.hash(cursor)); d > 2 {
+			t.Errorf("%s: cursor moves the hash by %d bits", f.name, d)
+		}
+		if d := Distance(h, f.hash(small)); d > 4 {
+			t.Errorf("%s: scaling moves the hash by %d bits", f.name, d)
+		}
+		if d := Distance(h, f.hash(window)); d < 8 {
+			t.Errorf("%s: a new window moves the hash by only %d bits", f.name, d)
+		}
+		if h != f.hash(base.SubImage(base.Rect).(*image.RGBA)) {
+			t.Errorf("%s: not deterministic", f.name)
+		}
+		// Tiny and empty images are hashed too.
+		f.hash(image.NewRGBA(image.Rect(5, 5, 8, 7)))
+		f.hash(image.NewRGBA(image.Rectangle{}))
+	}
+}
+
+func TestDistance(t *testing.T) {
+	if d := Distance(0, ^uint64(0)); d != 64 {
+		t.Errorf("Distance = %d, want 64", d)
+	}
+	if d := Distance(0b1011, 0b0110); d != 3 {
+		t.Errorf("Distance = %d, want 3", d)
+	}
+}
+
+func BenchmarkExact(b *testing.B) {
+	img := image.NewRGBA(image.Rect(0, 0, 1920, 1080))
+	b.SetBytes(int64(len(img.Pix)))
+	for b.Loop() {
+		Exact(img)
+	}
+}
+
+func BenchmarkPHash(b *testing.B) {
+	img := image.NewRGBA(image.Rect(0, 0, 1920, 1080))
+	b.SetBytes(int64(len(img.Pix)))
+	for b.Loop() {
+		PHash(img)
+	}
+}
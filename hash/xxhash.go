@@ -0,0 +1,101 @@
+package hash
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// XXH64, see https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md.
+const (
+	prime1 uint64 = 11400714785074694791
+	prime2 uint64 = 14029467366897019727
+	prime3 uint64 = 1609587929392839161
+	prime4 uint64 = 9650029242287828579
+	prime5 uint64 = 2870177450012600261
+)
+
+// xxh64 is a streaming XXH64 digest with seed 0.
+type xxh64 struct {
+	v     [4]uint64
+	buf   [32]byte
+	n     int // bytes in buf
+	total uint64
+}
+
+func newXXH64() *xxh64 {
+	p1, p2 := prime1, prime2 // wrapping arithmetic
+	return &xxh64{v: [4]uint64{p1 + p2, p2, 0, -p1}}
+}
+
+func round(acc, input uint64) uint64 {
+	return bits.RotateLeft64(acc+input*prime2, 31) * prime1
+}
+
+func mergeRound(acc, val uint64) uint64 {
+	return (acc^round(0, val))*prime1 + prime4
+}
+
+func (d *xxh64) write(b []byte) {
+	d.total += uint64(len(b))
+	if d.n > 0 {
+		c := copy(d.buf[d.n:], b)
+		d.n += c
+		b = b[c:]
+		if d.n < 32 {
+			return
+		}
+		d.stripes(d.buf[:])
+		d.n = 0
+	}
+	full := len(b) &^ 31
+	d.stripes(b[:full])
+	d.n = copy(d.buf[:], b[full:])
+}
+
+// stripes consumes b, a multiple of 32 bytes long.
+func (d *xxh64) stripes(b []byte) {
+	v0, v1, v2, v3 := d.v[0], d.v[1], d.v[2], d.v[3]
+	for ; len(b) >= 32; b = b[32:] {
+		v0 = round(v0, binary.LittleEndian.Uint64(b[0:]))
+		v1 = round(v1, binary.LittleEndian.Uint64(b[8:]))
+		v2 = round(v2, binary.LittleEndian.Uint64(b[16:]))
+		v3 = round(v3, binary.LittleEndian.Uint64(b[24:]))
+	}
+	d.v = [4]uint64{v0, v1, v2, v3}
+}
+
+func (d *xxh64) sum() uint64 {
+	var h uint64
+	if d.total >= 32 {
+		v := d.v
+		h = bits.RotateLeft64(v[0], 1) + bits.RotateLeft64(v[1], 7) + bits.RotateLeft64(v[2], 12) + bits.RotateLeft64(v[3], 18)
+		for _, x := range v {
+			h = mergeRound(h, x)
+		}
+	} else {
+		h = prime5
+	}
+	h += d.total
+
+	b := d.buf[:d.n]
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= round(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*prime1 + prime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * prime1
+		h = bits.RotateLeft64(h, 23)*prime2 + prime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * prime5
+		h = bits.RotateLeft64(h, 11) * prime1
+	}
+
+	h ^= h >> 33
+	h *= prime2
+	h ^= h >> 29
+	h *= prime3
+	h ^= h >> 32
+	return h
+}
@@ -0,0 +1,323 @@
+// Package icc describes the colour spaces of captured pixels and converts between
+// them in pure Go.
+//
+// Displays report their colour space as an ICC profile, and backends return the
+// values of the framebuffer as they are, so the same colour captured on a
+// wide-gamut display and on an sRGB one gives different pixels. Converting both to
+// SRGB or DisplayP3 makes them comparable.
+//
+// Parse reads RGB display profiles, version 2 or 4, of the matrix and tone
+// reproduction curve kind that monitors and operating systems use. Profiles built
+// on lookup tables are read, but cannot be converted from or to.
+package icc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf16"
+)
+
+// Profile is an RGB colour space.
+type Profile struct {
+	// Description is the name of the profile, e.g. "sRGB IEC61966-2.1".
+	Description string
+	// Data is the ICC profile it was parsed from, nil for SRGB and DisplayP3.
+	Data []byte
+
+	// matrix converts linear RGB to the D50 XYZ connection space, its columns
+	// being the colorants. ok is false for profiles without one.
+	matrix [3][3]float64
+	curves [3]curve // tone reproduction curves of R, G and B, encoded to linear
+	ok     bool
+}
+
+// Convertible reports whether p can be converted from and to, that is whether it
+// is a matrix and tone reproduction curve profile.
+func (p *Profile) Convertible() bool {
+	return p.ok
+}
+
+func (p *Profile) String() string {
+	return p.Description
+}
+
+var (
+	// SRGB is the sRGB colour space of IEC 61966-2-1, the usual assumption for
+	// pixels without a profile.
+	SRGB = fromPrimaries("sRGB", [3][2]float64{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0.06}})
+	// DisplayP3 is the Display P3 colour space of Apple devices: the DCI-P3
+	// primaries with the D65 white point and the sRGB curve.
+	DisplayP3 = fromPrimaries("Display P3", [3][2]float64{{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}})
+)
+
+// srgbCurve is the tone reproduction curve of sRGB.
+var srgbCurve = parametric{g: 2.4, a: 1 / 1.055, b: 0.055 / 1.055, c: 1 / 12.92, d: 0.04045}
+
+// d65 and d50 are the XYZ coordinates of the illuminants, d50 as in the ICC
+// connection space.
+var (
+	d65 = [3]float64{0.3127 / 0.3290, 1, (1 - 0.3127 - 0.3290) / 0.3290}
+	d50 = [3]float64{0.9642, 1, 0.8249}
+)
+
+// fromPrimaries returns the profile of a colour space with the given xy
+// chromaticities of red, green and blue, the D65 white point and the sRGB curve,
+// adapted to D50 with the Bradford transform like ICC profiles are.
+func fromPrimaries(name string, xy [3][2]float64) *Profile {
+	// Colorants scaled so that they sum to the white point.
+	var m [3][3]float64
+	for i, c := range xy {
+		m[0][i] = c[0] / c[1]
+		m[1][i] = 1
+		m[2][i] = (1 - c[0] - c[1]) / c[1]
+	}
+	s := mulVec(invert(m), d65)
+	for r := range m {
+		for i := range m[r] {
+			m[r][i] *= s[i]
+		}
+	}
+	p := &Profile{Description: name, matrix: mul(bradford(d65, d50), m), ok: true}
+	for i := range p.curves {
+		p.curves[i] = srgbCurve
+	}
+	return p
+}
+
+// bradford returns the chromatic adaptation from the white src to dst.
+func bradford(src, dst [3]float64) [3][3]float64 {
+	b := [3][3]float64{
+		{0.8951, 0.2664, -0.1614},
+		{-0.7502, 1.7135, 0.0367},
+		{0.0389, -0.0685, 1.0296},
+	}
+	s, d := mulVec(b, src), mulVec(b, dst)
+	var scale [3][3]float64
+	for i := range scale {
+		scale[i][i] = d[i] / s[i]
+	}
+	return mul(invert(b), mul(scale, b))
+}
+
+var errFormat = errors.New("icc: malformed profile")
+
+// Parse parses an ICC profile of an RGB colour space.
+func Parse(data []byte) (*Profile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, errFormat
+	}
+	if cs := string(data[16:20]); cs != "RGB " {
+		return nil, fmt.Errorf("icc: %q colour space, not RGB", cs)
+	}
+	tags := make(map[string][]byte)
+	n := int(binary.BigEndian.Uint32(data[128:]))
+	if n > (len(data)-132)/12 {
+		return nil, errFormat
+	}
+	for i := range n {
+		e := data[132+12*i:]
+		off, size := binary.BigEndian.Uint32(e[4:]), binary.BigEndian.Uint32(e[8:])
+		if uint64(off)+uint64(size) > uint64(len(data)) || size < 8 {
+			return nil, errFormat
+		}
+		tags[string(e[:4])] = data[off : off+size]
+	}
+
+	p := &Profile{Data: data, Description: description(tags["desc"])}
+	if string(data[20:24]) != "XYZ " {
+		return p, nil
+	}
+	for i, name := range []string{"r", "g", "b"} {
+		xyz, ok := tags[name+"XYZ"]
+		if !ok || len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return p, nil
+		}
+		for r := range 3 {
+			p.matrix[r][i] = s15Fixed16(xyz[8+4*r:])
+		}
+		c, err := parseCurve(tags[name+"TRC"])
+		if err != nil {
+			return p, nil
+		}
+		p.curves[i] = c
+	}
+	if det(p.matrix) == 0 {
+		return nil, errFormat
+	}
+	p.ok = true
+	return p, nil
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// description decodes a 'desc' (version 2) or 'mluc' (version 4) tag, preferring
+// English.
+func description(tag []byte) string {
+	switch {
+	case len(tag) >= 12 && string(tag[:4]) == "desc":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if n > len(tag)-12 {
+			return ""
+		}
+		s := tag[12 : 12+n]
+		for len(s) > 0 && s[len(s)-1] == 0 {
+			s = s[:len(s)-1]
+		}
+		return string(s)
+	case len(tag) >= 16 && string(tag[:4]) == "mluc":
+		n, size := int(binary.BigEndian.Uint32(tag[8:])), int(binary.BigEndian.Uint32(tag[12:]))
+		if size < 12 || n > (len(tag)-16)/size {
+			return ""
+		}
+		var best string
+		for i := range n {
+			r := tag[16+i*size:]
+			length, off := int(binary.BigEndian.Uint32(r[4:])), int(binary.BigEndian.Uint32(r[8:]))
+			if off+length > len(tag) || length%2 != 0 {
+				continue
+			}
+			u := make([]uint16, length/2)
+			for j := range u {
+				u[j] = binary.BigEndian.Uint16(tag[off+2*j:])
+			}
+			s := string(utf16.Decode(u))
+			if string(r[:2]) == "en" {
+				return s
+			}
+			if best == "" {
+				best = s
+			}
+		}
+		return best
+	}
+	return ""
+}
+
+// curve maps an encoded channel value to linear light, both from 0 to 1.
+type curve interface {
+	eval(x float64) float64
+}
+
+// gamma is a pure power curve.
+type gamma float64
+
+func (g gamma) eval(x float64) float64 {
+	return math.Pow(x, float64(g))
+}
+
+// table interpolates linearly between equally spaced samples.
+type table []float64
+
+func (t table) eval(x float64) float64 {
+	f := math.Max(0, math.Min(x, 1)) * float64(len(t)-1)
+	i := min(int(f), len(t)-2)
+	return t[i] + (t[i+1]-t[i])*(f-float64(i))
+}
+
+// parametric is a 'para' curve in its most general form:
+// y = (a·x + b)^g + e for x >= d, y = c·x + f below.
+type parametric struct {
+	g, a, b, c, d, e, f float64
+}
+
+func (p parametric) eval(x float64) float64 {
+	if x >= p.d {
+		if v := p.a*x + p.b; v > 0 {
+			return math.Pow(v, p.g) + p.e
+		}
+		return p.e
+	}
+	return p.c*x + p.f
+}
+
+func parseCurve(tag []byte) (curve, error) {
+	if len(tag) < 12 {
+		return nil, errFormat
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		switch {
+		case n > (len(tag)-12)/2:
+			return nil, errFormat
+		case n == 0:
+			return gamma(1), nil
+		case n == 1:
+			return gamma(float64(binary.BigEndian.Uint16(tag[12:])) / 256), nil
+		}
+		t := make(table, n)
+		for i := range t {
+			t[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return t, nil
+	case "para":
+		counts := []int{1, 3, 4, 5, 7}
+		fn := int(binary.BigEndian.Uint16(tag[8:]))
+		if fn >= len(counts) || len(tag) < 12+4*counts[fn] {
+			return nil, errFormat
+		}
+		var v [7]float64
+		for i := range counts[fn] {
+			v[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := v[0], v[1], v[2], v[3], v[4], v[5], v[6]
+		switch fn {
+		case 0:
+			return gamma(g), nil
+		case 1:
+			// y = (a·x + b)^g for x >= -b/a, 0 below.
+			return parametric{g: g, a: a, b: b, d: -b / a}, nil
+		case 2:
+			// y = (a·x + b)^g + c for x >= -b/a, c below.
+			return parametric{g: g, a: a, b: b, d: -b / a, e: c, f: c}, nil
+		case 3:
+			return parametric{g: g, a: a, b: b, c: c, d: d}, nil
+		}
+		return parametric{g: g, a: a, b: b, c: c, d: d, e: e, f: f}, nil
+	}
+	return nil, fmt.Errorf("icc: unsupported curve type %q", tag[:4])
+}
+
+func mul(a, b [3][3]float64) [3][3]float64 {
+	var m [3][3]float64
+	for i := range 3 {
+		for j := range 3 {
+			for k := range 3 {
+				m[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return m
+}
+
+func mulVec(m [3][3]float64, v [3]float64) [3]float64 {
+	var r [3]float64
+	for i := range 3 {
+		r[i] = m[i][0]*v[0] + m[i][1]*v[1] + m[i][2]*v[2]
+	}
+	return r
+}
+
+func det(m [3][3]float64) float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+func invert(m [3][3]float64) [3][3]float64 {
+	d := det(m)
+	var r [3][3]float64
+	for i := range 3 {
+		for j := range 3 {
+			// Cofactor of m[j][i].
+			a, b := (j+1)%3, (j+2)%3
+			c, e := (i+1)%3, (i+2)%3
+			r[i][j] = (m[a][c]*m[b][e] - m[a][e]*m[b][c]) / d
+		}
+	}
+	return r
+}
@@ -0,0 +1,224 @@
+package icc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+	"unicode/utf16"
+)
+
+// build returns an ICC profile with the given tags.
+func build(space, pcs string, tags map[string][]byte) []byte {
+	var names []string
+	for _, n := range []string{"desc", "rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC", "A2B0"} {
+		if _, ok := tags[n]; ok {
+			names = append(names, n)
+		}
+	}
+	header := make([]byte, 132+12*len(names))
+	copy(header[12:], "mntr")
+	copy(header[16:], space)
+	copy(header[20:], pcs)
+	copy(header[36:], "acsp")
+	binary.BigEndian.PutUint32(header[128:], uint32(len(names)))
+	var body bytes.Buffer
+	for i, n := range names {
+		e := header[132+12*i:]
+		copy(e, n)
+		binary.BigEndian.PutUint32(e[4:], uint32(len(header)+body.Len()))
+		binary.BigEndian.PutUint32(e[8:], uint32(len(tags[n])))
+		body.Write(tags[n])
+		for body.Len()%4 != 0 {
+			body.WriteByte(0)
+		}
+	}
+	data := append(header, body.Bytes()...)
+	binary.BigEndian.PutUint32(data, uint32(len(data)))
+	return data
+}
+
+func fixed(v float64) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(int32(math.Round(v*65536))))
+}
+
+func xyzTag(x, y, z float64) []byte {
+	b := append([]byte("XYZ \x00\x00\x00\x00"), fixed(x)...)
+	return append(append(b, fixed(y)...), fixed(z)...)
+}
+
+func paraTag(fn uint16, params ...float64) []byte {
+	b := binary.BigEndian.AppendUint16([]byte("para\x00\x00\x00\x00"), fn)
+	b = append(b, 0, 0)
+	for _, p := range params {
+		b = append(b, fixed(p)...)
+	}
+	return b
+}
+
+func curvTag(values ...uint16) []byte {
+	b := binary.BigEndian.AppendUint32([]byte("curv\x00\x00\x00\x00"), uint32(len(values)))
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+func mlucTag(lang, s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := []byte("mluc\x00\x00\x00\x00")
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint32(b, 12)
+	b = append(b, lang...)
+	b = binary.BigEndian.AppendUint32(b, uint32(2*len(u)))
+	b = binary.BigEndian.AppendUint32(b, 28)
+	for _, c := range u {
+		b = binary.BigEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+// srgbTags returns the tags of an sRGB profile as the ICC publishes it.
+func srgbTags() map[string][]byte {
+	trc := paraTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+	return map[string][]byte{
+		"desc": mlucTag("enUS", "sRGB IEC61966-2.1"),
+		"rXYZ": xyzTag(0.4360747, 0.2225045, 0.0139322),
+		"gXYZ": xyzTag(0.3850649, 0.7168786, 0.0971045),
+		"bXYZ": xyzTag(0.1430804, 0.0606169, 0.7141733),
+		"rTRC": trc, "gTRC": trc, "bTRC": trc,
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	want := [3][3]float64{
+		{0.4360747, 0.3850649, 0.1430804},
+		{0.2225045, 0.7168786, 0.0606169},
+		{0.0139322, 0.0971045, 0.7141733},
+	}
+	for i := range 3 {
+		for j := range 3 {
+			if d := math.Abs(SRGB.matrix[i][j] - want[i][j]); d > 1e-3 {
+				t.Errorf("SRGB matrix[%d][%d] = %f, want %f", i, j, SRGB.matrix[i][j], want[i][j])
+			}
+		}
+	}
+	// Both white points map to the D50 of the connection space.
+	for _, p := range []*Profile{SRGB, DisplayP3} {
+		w := mulVec(p.matrix, [3]float64{1, 1, 1})
+		for i := range w {
+			if math.Abs(w[i]-d50[i]) > 1e-3 {
+				t.Errorf("%s white %v, want %v", p, w, d50)
+			}
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	p, err := Parse(build("RGB ", "XYZ ", srgbTags()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Description != "sRGB IEC61966-2.1" || !p.Convertible() || !same(p, SRGB) {
+		t.Errorf("parsed sRGB: %q, convertible %v, same %v", p.Description, p.Convertible(), same(p, SRGB))
+	}
+	if tr, _ := NewTransform(p, SRGB); !tr.identity {
+		t.Error("sRGB to sRGB is not the identity")
+	}
+
+	// Gamma and table curves, and a version 2 description.
+	tags := srgbTags()
+	tags["desc"] = append(binary.BigEndian.AppendUint32([]byte("desc\x00\x00\x00\x00"), 8), "Monitor\x00"...)
+	tags["rTRC"] = curvTag(0x0233) // gamma 2.2
+	tags["gTRC"] = curvTag(0, 0x4000, 0xffff)
+	tags["bTRC"] = curvTag()
+	p, err = Parse(build("RGB ", "XYZ ", tags))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Description != "Monitor" || !p.Convertible() {
+		t.Fatalf("parsed %q, convertible %v", p.Description, p.Convertible())
+	}
+	for i, want := range []float64{math.Pow(0.5, 2.2), 0.25, 0.5} {
+		if got := p.curves[i].eval(0.5); math.Abs(got-want) > 2e-3 {
+			t.Errorf("curve %d at 0.5 = %f, want %f", i, got, want)
+		}
+	}
+
+	// A lookup table profile is described, but not convertible.
+	p, err = Parse(build("RGB ", "Lab ", map[string][]byte{"desc": mlucTag("deDE", "Tabelle"), "A2B0": make([]byte, 32)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Description != "Tabelle" || p.Convertible() {
+		t.Errorf("LUT profile: %q, convertible %v", p.Description, p.Convertible())
+	}
+	if _, err := NewTransform(p, SRGB); err == nil {
+		t.Error("LUT profile converted")
+	}
+
+	for _, data := range [][]byte{nil, make([]byte, 200), build("CMYK", "XYZ ", nil)} {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("Parse accepted %d bytes", len(data))
+		}
+	}
+	bad := build("RGB ", "XYZ ", srgbTags())
+	binary.BigEndian.PutUint32(bad[132+4:], 1<<30)
+	if _, err := Parse(bad); err == nil {
+		t.Error("tag outside the profile accepted")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	colors := []color.RGBA{{255, 0, 0, 255}, {255, 255, 255, 255}, {128, 128, 128, 255}, {0, 0, 0, 255}}
+	for i, c := range colors {
+		img.SetRGBA(i, 0, c)
+	}
+	if err := Convert(img, SRGB, DisplayP3); err != nil {
+		t.Fatal(err)
+	}
+	// sRGB red is (0.9175, 0.2003, 0.1387) in Display P3.
+	want := []color.RGBA{{234, 51, 35, 255}, colors[1], colors[2], colors[3]}
+	for i, w := range want {
+		got := img.RGBAAt(i, 0)
+		for c, d := range []int{int(got.R) - int(w.R), int(got.G) - int(w.G), int(got.B) - int(w.B)} {
+			if d < -1 || d > 1 {
+				t.Errorf("pixel %d channel %d: %v, want %v", i, c, got, w)
+			}
+		}
+	}
+
+	// Colours in both gamuts survive a round trip, but for the rounding to 8 bits
+	// in between, which the matrix back to sRGB amplifies for saturated colours.
+	r := image.NewRGBA(image.Rect(0, 0, 256, 64))
+	for i := 0; i < len(r.Pix); i += 4 {
+		r.Pix[i], r.Pix[i+1], r.Pix[i+2], r.Pix[i+3] = uint8(i/4), uint8(i/4*7), uint8(i/4*13), 255
+	}
+	orig := bytes.Clone(r.Pix)
+	if err := Convert(r, SRGB, DisplayP3); err != nil {
+		t.Fatal(err)
+	}
+	if err := Convert(r, DisplayP3, SRGB); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range r.Pix {
+		if d := int(v) - int(orig[i]); d < -3 || d > 3 {
+			t.Fatalf("byte %d: %d after the round trip, was %d", i, v, orig[i])
+		}
+	}
+}
+
+func BenchmarkApply(b *testing.B) {
+	img := image.NewRGBA(image.Rect(0, 0, 1920, 1080))
+	tr, err := NewTransform(DisplayP3, SRGB)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(img.Pix)))
+	for b.Loop() {
+		tr.Apply(img)
+	}
+}
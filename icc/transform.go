@@ -0,0 +1,134 @@
+package icc
+
+import (
+	"errors"
+	"image"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// outBits is the precision of the linear values looked up in the output table.
+const outBits = 14
+
+// Transform converts pixels from one profile to another. It is safe for
+// concurrent use.
+type Transform struct {
+	in       [3][256]float32 // encoded source to linear, per channel
+	matrix   [3][3]float32   // linear source to linear destination
+	out      [3][1<<outBits + 1]uint8
+	identity bool
+}
+
+// NewTransform returns the conversion from src to dst. Colours outside the gamut
+// of dst are clipped.
+func NewTransform(src, dst *Profile) (*Transform, error) {
+	if !src.ok || !dst.ok {
+		return nil, errors.New("icc: only matrix profiles can be converted")
+	}
+	t := &Transform{identity: same(src, dst)}
+	if t.identity {
+		return t, nil
+	}
+	m := mul(invert(dst.matrix), src.matrix)
+	for i := range 3 {
+		for j := range 3 {
+			t.matrix[i][j] = float32(m[i][j])
+		}
+	}
+	for c := range 3 {
+		for v := range 256 {
+			t.in[c][v] = float32(src.curves[c].eval(float64(v) / 255))
+		}
+		// The curve increases, so the encoded value of a linear one is the number
+		// of midpoints between encoded values that it reaches.
+		var mid [255]float64
+		for k := range mid {
+			mid[k] = dst.curves[c].eval((float64(k) + 0.5) / 255)
+		}
+		k := 0
+		for v := range t.out[c] {
+			for k < len(mid) && mid[k] <= float64(v)/(1<<outBits) {
+				k++
+			}
+			t.out[c][v] = uint8(k)
+		}
+	}
+	return t, nil
+}
+
+// same reports whether a and b describe the same colour space, so that converting
+// between them changes nothing.
+func same(a, b *Profile) bool {
+	for i := range 3 {
+		for j := range 3 {
+			if math.Abs(a.matrix[i][j]-b.matrix[i][j]) > 1e-3 {
+				return false
+			}
+		}
+		for v := 0; v <= 255; v += 15 {
+			x := float64(v) / 255
+			if math.Abs(a.curves[i].eval(x)-b.curves[i].eval(x)) > 1e-3 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Apply converts the pixels of img in place. Alpha is left alone, the pixels
+// are assumed opaque.
+func (t *Transform) Apply(img *image.RGBA) {
+	if t.identity {
+		return
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w <= 0 || h <= 0 {
+		return
+	}
+	workers := min(runtime.GOMAXPROCS(0), max(h/64, 1))
+	var wg sync.WaitGroup
+	for n := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := n; y < h; y += workers {
+				i := img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y)
+				t.row(img.Pix[i : i+4*w])
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (t *Transform) row(pix []byte) {
+	m := &t.matrix
+	const scale = 1 << outBits
+	for i := 0; i < len(pix); i += 4 {
+		r, g, b := t.in[0][pix[i]], t.in[1][pix[i+1]], t.in[2][pix[i+2]]
+		pix[i] = t.out[0][index(scale*(m[0][0]*r+m[0][1]*g+m[0][2]*b))]
+		pix[i+1] = t.out[1][index(scale*(m[1][0]*r+m[1][1]*g+m[1][2]*b))]
+		pix[i+2] = t.out[2][index(scale*(m[2][0]*r+m[2][1]*g+m[2][2]*b))]
+	}
+}
+
+// index clips a scaled linear value to the output table.
+func index(v float32) int {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 1<<outBits:
+		return 1 << outBits
+	}
+	return int(v + 0.5)
+}
+
+// Convert converts the pixels of img in place from src to dst.
+func Convert(img *image.RGBA, src, dst *Profile) error {
+	t, err := NewTransform(src, dst)
+	if err != nil {
+		return err
+	}
+	t.Apply(img)
+	return nil
+}
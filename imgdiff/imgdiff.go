@@ -0,0 +1,298 @@
+// Package imgdiff compares images pixel by pixel, for screenshot tests against
+// golden images.
+//
+// Pixels match when every channel is within a tolerance. Pixels that differ only
+// because an edge is anti-aliased differently, as happens between font renderers
+// and GPUs, can be told apart from real changes with the detection of pixelmatch
+// (https://github.com/mapbox/pixelmatch). Areas that legitimately change, such as
+// clocks, can be ignored by rectangle or by mask. The result counts the changed
+// pixels, boxes the changed regions and draws a diff image highlighting them.
+package imgdiff
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// ErrSize is returned when the images do not have the same size.
+var ErrSize = errors.New("imgdiff: image sizes differ")
+
+// Options configures Compare. The zero value requires exact equality.
+type Options struct {
+	// Tolerance is the largest difference of any channel, 0-255, for pixels that
+	// match.
+	Tolerance uint8
+	// AntiAliasing counts pixels that look like differently anti-aliased edges as
+	// anti-aliased instead of changed.
+	AntiAliasing bool
+	// Ignore lists rectangles that are not compared, relative to the top-left
+	// corner of the images.
+	Ignore []image.Rectangle
+	// Mask, if set, excludes the pixels where it is not fully transparent, relative
+	// to the top-left corner of the images.
+	Mask image.Image
+}
+
+// Result describes the differences between two images.
+type Result struct {
+	// Changed is the number of pixels that differ.
+	Changed int
+	// AntiAliased is the number of pixels that differ in an anti-aliased edge only.
+	AntiAliased int
+	// Ignored is the number of pixels excluded by Options.Ignore and Options.Mask.
+	Ignored int
+	// Total is the number of pixels of each image.
+	Total int
+	// Bounds is the smallest rectangle holding every changed pixel, relative to the
+	// top-left corner of the images, empty when none changed.
+	Bounds image.Rectangle
+	// Regions box groups of changed pixels, changes up to about 8 pixels apart
+	// being grouped together, from top to bottom.
+	Regions []image.Rectangle
+	// Diff shows the expected image faded to grey, changed pixels in red,
+	// anti-aliased ones in yellow and ignored areas tinted blue.
+	Diff *image.RGBA
+}
+
+// Equal reports whether no pixel changed.
+func (r *Result) Equal() bool {
+	return r.Changed == 0
+}
+
+// String summarizes r, e.g. "1520 of 307200 pixels changed (0.49%) in 2 regions".
+func (r *Result) String() string {
+	if r.Total == 0 {
+		return "0 pixels compared"
+	}
+	return fmt.Sprintf("%d of %d pixels changed (%.2f%%) in %d regions", r.Changed, r.Total, 100*float64(r.Changed)/float64(r.Total), len(r.Regions))
+}
+
+var (
+	changedColor     = color.RGBA{255, 0, 0, 255}
+	antiAliasedColor = color.RGBA{255, 255, 0, 255}
+)
+
+// Compare compares got against want. The images must have the same size, their
+// bounds may start anywhere.
+func Compare(got, want image.Image, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	a, b := toRGBA(got), toRGBA(want)
+	w, h := a.Rect.Dx(), a.Rect.Dy()
+	if b.Rect.Dx() != w || b.Rect.Dy() != h {
+		return nil, fmt.Errorf("%w: %dx%d and %dx%d", ErrSize, w, h, b.Rect.Dx(), b.Rect.Dy())
+	}
+
+	ignored := ignoreMap(w, h, opts)
+	res := &Result{Total: w * h, Diff: image.NewRGBA(image.Rect(0, 0, w, h))}
+	changed := make([]bool, w*h)
+	tol := int(opts.Tolerance)
+	for y := 0; y < h; y++ {
+		pa := a.Pix[a.PixOffset(a.Rect.Min.X, a.Rect.Min.Y+y):]
+		pb := b.Pix[b.PixOffset(b.Rect.Min.X, b.Rect.Min.Y+y):]
+		out := res.Diff.Pix[y*res.Diff.Stride:]
+		for x := 0; x < w; x++ {
+			i := 4 * x
+			grey := faded(pb[i : i+4])
+			switch {
+			case ignored != nil && ignored[y*w+x]:
+				res.Ignored++
+				out[i], out[i+1], out[i+2], out[i+3] = grey/2, grey/2, 128+grey/2, 255
+			case within(pa[i:i+4], pb[i:i+4], tol):
+				out[i], out[i+1], out[i+2], out[i+3] = grey, grey, grey, 255
+			case opts.AntiAliasing && (antiAliased(a, b, x, y) || antiAliased(b, a, x, y)):
+				res.AntiAliased++
+				out[i], out[i+1], out[i+2], out[i+3] = antiAliasedColor.R, antiAliasedColor.G, antiAliasedColor.B, 255
+			default:
+				res.Changed++
+				changed[y*w+x] = true
+				res.Bounds = res.Bounds.Union(image.Rect(x, y, x+1, y+1))
+				out[i], out[i+1], out[i+2], out[i+3] = changedColor.R, changedColor.G, changedColor.B, 255
+			}
+		}
+	}
+	if res.Changed > 0 {
+		res.Regions = regions(changed, w, h)
+	}
+	return res, nil
+}
+
+// toRGBA returns img as an *image.RGBA, converting it if needed.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, img, b.Min, draw.Src)
+	return rgba
+}
+
+// ignoreMap returns which pixels opts excludes, nil for none.
+func ignoreMap(w, h int, opts *Options) []bool {
+	if len(opts.Ignore) == 0 && opts.Mask == nil {
+		return nil
+	}
+	m := make([]bool, w*h)
+	full := image.Rect(0, 0, w, h)
+	for _, r := range opts.Ignore {
+		r = r.Canon().Intersect(full)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				m[y*w+x] = true
+			}
+		}
+	}
+	if opts.Mask != nil {
+		mb := opts.Mask.Bounds()
+		for y := 0; y < h && y < mb.Dy(); y++ {
+			for x := 0; x < w && x < mb.Dx(); x++ {
+				if _, _, _, a := opts.Mask.At(mb.Min.X+x, mb.Min.Y+y).RGBA(); a != 0 {
+					m[y*w+x] = true
+				}
+			}
+		}
+	}
+	return m
+}
+
+func within(p, q []byte, tol int) bool {
+	for c := range 4 {
+		d := int(p[c]) - int(q[c])
+		if d > tol || -d > tol {
+			return false
+		}
+	}
+	return true
+}
+
+// faded returns the luma of p blended towards white, for the background of the diff.
+func faded(p []byte) byte {
+	return byte(255 - (255-luma(p)/256)/5)
+}
+
+// luma returns the BT.601 luma of p, scaled by 256, with transparent pixels on
+// white.
+func luma(p []byte) int {
+	y := 77*int(p[0]) + 150*int(p[1]) + 29*int(p[2])
+	return y + (255-int(p[3]))*256
+}
+
+// antiAliased reports whether the pixel (x, y) of img, relative to its top-left
+// corner, looks like part of an anti-aliased edge: it has both a darker and a
+// brighter neighbour, at most two identical ones, and one of its darkest or
+// brightest neighbours lies in a flat area in both images.
+func antiAliased(img, other *image.RGBA, x, y int) bool {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	x0, y0, x1, y1 := max(x-1, 0), max(y-1, 0), min(x+1, w-1), min(y+1, h-1)
+	zeroes := 0
+	if x == x0 || x == x1 || y == y0 || y == y1 {
+		zeroes = 1
+	}
+	center := luma(pixel(img, x, y))
+	minDelta, maxDelta := 0, 0
+	var minX, minY, maxX, maxY int
+	for ny := y0; ny <= y1; ny++ {
+		for nx := x0; nx <= x1; nx++ {
+			if nx == x && ny == y {
+				continue
+			}
+			d := luma(pixel(img, nx, ny)) - center
+			switch {
+			case d == 0:
+				if zeroes++; zeroes > 2 {
+					return false
+				}
+			case d < minDelta:
+				minDelta, minX, minY = d, nx, ny
+			case d > maxDelta:
+				maxDelta, maxX, maxY = d, nx, ny
+			}
+		}
+	}
+	if minDelta == 0 || maxDelta == 0 {
+		return false
+	}
+	return (flat(img, minX, minY) && flat(other, minX, minY)) ||
+		(flat(img, maxX, maxY) && flat(other, maxX, maxY))
+}
+
+// flat reports whether more than two neighbours of (x, y) have its exact colour.
+func flat(img *image.RGBA, x, y int) bool {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	x0, y0, x1, y1 := max(x-1, 0), max(y-1, 0), min(x+1, w-1), min(y+1, h-1)
+	zeroes := 0
+	if x == x0 || x == x1 || y == y0 || y == y1 {
+		zeroes = 1
+	}
+	c := pixel(img, x, y)
+	for ny := y0; ny <= y1; ny++ {
+		for nx := x0; nx <= x1; nx++ {
+			if nx == x && ny == y {
+				continue
+			}
+			p := pixel(img, nx, ny)
+			if p[0] == c[0] && p[1] == c[1] && p[2] == c[2] && p[3] == c[3] {
+				if zeroes++; zeroes > 2 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func pixel(img *image.RGBA, x, y int) []byte {
+	i := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+	return img.Pix[i : i+4]
+}
+
+// regionCell is the grid on which changes are grouped into regions: changes in
+// touching cells belong to the same region.
+const regionCell = 8
+
+// regions returns the bounding boxes of the groups of changed pixels.
+func regions(changed []bool, w, h int) []image.Rectangle {
+	cw, ch := (w+regionCell-1)/regionCell, (h+regionCell-1)/regionCell
+	cells := make([]image.Rectangle, cw*ch) // changed pixels per cell
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if changed[y*w+x] {
+				i := y/regionCell*cw + x/regionCell
+				cells[i] = cells[i].Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	var result []image.Rectangle
+	seen := make([]bool, len(cells))
+	var stack []int
+	for start := range cells {
+		if seen[start] || cells[start].Empty() {
+			continue
+		}
+		var box image.Rectangle
+		seen[start] = true
+		stack = append(stack[:0], start)
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			box = box.Union(cells[i])
+			cx, cy := i%cw, i/cw
+			for ny := max(cy-1, 0); ny <= min(cy+1, ch-1); ny++ {
+				for nx := max(cx-1, 0); nx <= min(cx+1, cw-1); nx++ {
+					if j := ny*cw + nx; !seen[j] && !cells[j].Empty() {
+						seen[j] = true
+						stack = append(stack, j)
+					}
+				}
+			}
+		}
+		result = append(result, box)
+	}
+	return result
+}
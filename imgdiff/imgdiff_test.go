@@ -0,0 +1,165 @@
+package imgdiff
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func fill(img draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+func page() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 60))
+	fill(img, img.Rect, color.White)
+	fill(img, image.Rect(10, 10, 40, 20), color.Black)
+	return img
+}
+
+func TestCompare(t *testing.T) {
+	want := page()
+	res, err := Compare(page(), want, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Equal() || res.Total != 6000 || len(res.Regions) != 0 || !res.Bounds.Empty() {
+		t.Errorf("equal images: %+v", res)
+	}
+
+	// Two separate changes, and a nearby third one joining the second.
+	got := page()
+	fill(got, image.Rect(60, 5, 65, 8), color.Black)
+	fill(got, image.Rect(20, 40, 25, 45), color.Black)
+	fill(got, image.Rect(27, 46, 30, 50), color.Black)
+	res, err = Compare(got, want, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Changed != 15+25+12 {
+		t.Errorf("Changed = %d, want 52", res.Changed)
+	}
+	if want := image.Rect(20, 5, 65, 50); res.Bounds != want {
+		t.Errorf("Bounds = %v, want %v", res.Bounds, want)
+	}
+	wantRegions := []image.Rectangle{image.Rect(60, 5, 65, 8), image.Rect(20, 40, 30, 50)}
+	if len(res.Regions) != len(wantRegions) {
+		t.Fatalf("Regions = %v, want %v", res.Regions, wantRegions)
+	}
+	for i, r := range wantRegions {
+		if res.Regions[i] != r {
+			t.Errorf("Regions = %v, want %v", res.Regions, wantRegions)
+		}
+	}
+	if c := res.Diff.RGBAAt(61, 6); c != changedColor {
+		t.Errorf("changed pixel drawn %v", c)
+	}
+	if c := res.Diff.RGBAAt(0, 0); c.R != c.G || c.G != c.B || c.R < 200 {
+		t.Errorf("unchanged pixel drawn %v", c)
+	}
+
+	// Bounds need not start at the origin.
+	sub := got.SubImage(image.Rect(50, 0, 100, 30))
+	moved := image.NewRGBA(image.Rect(-7, 3, 43, 33))
+	fill(moved, moved.Rect, color.White)
+	res, err = Compare(sub, moved, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Changed != 15 || res.Bounds != image.Rect(10, 5, 15, 8) {
+		t.Errorf("sub-image: %d changed in %v", res.Changed, res.Bounds)
+	}
+
+	if _, err := Compare(image.NewRGBA(image.Rect(0, 0, 2, 2)), want, nil); !errors.Is(err, ErrSize) {
+		t.Errorf("size mismatch: %v", err)
+	}
+}
+
+func TestTolerance(t *testing.T) {
+	want := page()
+	got := page()
+	fill(got, image.Rect(0, 30, 100, 60), color.RGBA{250, 252, 255, 255})
+	for _, tc := range []struct {
+		tol  uint8
+		want int
+	}{{0, 3000}, {4, 3000}, {5, 0}} {
+		res, err := Compare(got, want, &Options{Tolerance: tc.tol})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Changed != tc.want {
+			t.Errorf("Tolerance %d: Changed = %d, want %d", tc.tol, res.Changed, tc.want)
+		}
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	want := page()
+	got := page()
+	fill(got, image.Rect(80, 0, 100, 10), color.Black) // a clock
+	fill(got, image.Rect(0, 50, 10, 60), color.Black)  // an avatar
+	fill(got, image.Rect(50, 30, 55, 35), color.Black) // a real change
+
+	mask := image.NewAlpha(image.Rect(0, 0, 100, 60))
+	fill(mask, image.Rect(0, 50, 10, 60), color.Opaque)
+	res, err := Compare(got, want, &Options{Ignore: []image.Rectangle{image.Rect(80, 0, 120, 10)}, Mask: mask})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Changed != 25 || res.Ignored != 200+100 {
+		t.Errorf("Changed = %d, Ignored = %d, want 25, 300", res.Changed, res.Ignored)
+	}
+	if c := res.Diff.RGBAAt(90, 5); c.B <= c.R {
+		t.Errorf("ignored pixel drawn %v", c)
+	}
+}
+
+func TestAntiAliasing(t *testing.T) {
+	// A diagonal edge between black and white, with its edge pixels grey in one
+	// image and the next shade in the other.
+	edge := func(shade uint8) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		for y := range 40 {
+			for x := range 40 {
+				switch {
+				case x < y:
+					img.Set(x, y, color.Black)
+				case x == y:
+					img.Set(x, y, color.Gray{shade})
+				default:
+					img.Set(x, y, color.White)
+				}
+			}
+		}
+		return img
+	}
+	got, want := edge(100), edge(160)
+	res, err := Compare(got, want, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Changed != 40 {
+		t.Errorf("without detection: Changed = %d, want 40", res.Changed)
+	}
+	res, err = Compare(got, want, &Options{AntiAliasing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The corners lack a darker or a brighter neighbour.
+	if res.Changed > 2 || res.AntiAliased < 38 {
+		t.Errorf("with detection: Changed = %d, AntiAliased = %d", res.Changed, res.AntiAliased)
+	}
+
+	// A real change inside a flat area is not anti-aliasing.
+	got = edge(100)
+	got.Set(30, 5, color.Gray{128})
+	res, err = Compare(got, edge(100), &Options{AntiAliasing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Changed != 1 {
+		t.Errorf("dot: Changed = %d, want 1", res.Changed)
+	}
+}
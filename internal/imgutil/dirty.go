@@ -0,0 +1,36 @@
+package imgutil
+
+import (
+	"bytes"
+	"image"
+)
+
+// DirtyTiles splits cur into size×size tiles and returns the tiles whose pixels differ
+// from prev, clipped to cur.Rect, in row-major order. Every tile is dirty when prev is nil
+// or its bounds differ from cur.
+func DirtyTiles(prev, cur *image.RGBA, size int) []image.Rectangle {
+	all := prev == nil || prev.Rect != cur.Rect
+	var dirty []image.Rectangle
+	for y := cur.Rect.Min.Y; y < cur.Rect.Max.Y; y += size {
+		for x := cur.Rect.Min.X; x < cur.Rect.Max.X; x += size {
+			tile := image.Rect(x, y, x+size, y+size).Intersect(cur.Rect)
+			if all || !equalRect(prev, cur, tile) {
+				dirty = append(dirty, tile)
+			}
+		}
+	}
+	return dirty
+}
+
+// equalRect reports whether a and b hold the same pixels in r.
+func equalRect(a, b *image.RGBA, r image.Rectangle) bool {
+	n := r.Dx() * 4
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := a.PixOffset(r.Min.X, y)
+		j := b.PixOffset(r.Min.X, y)
+		if !bytes.Equal(a.Pix[i:i+n], b.Pix[j:j+n]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,28 @@
+package imgutil
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestDirtyTiles(t *testing.T) {
+	prev := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	if got := DirtyTiles(nil, prev, 8); len(got) != 6 {
+		t.Fatalf("no previous frame: %d tiles, want 6", len(got))
+	}
+
+	cur := image.NewRGBA(prev.Rect)
+	copy(cur.Pix, prev.Pix)
+	if got := DirtyTiles(prev, cur, 8); len(got) != 0 {
+		t.Fatalf("identical frames: %v", got)
+	}
+
+	cur.SetRGBA(9, 1, color.RGBA{R: 1})
+	cur.SetRGBA(19, 9, color.RGBA{G: 1})
+	want := []image.Rectangle{image.Rect(8, 0, 16, 8), image.Rect(16, 8, 20, 10)}
+	if got := DirtyTiles(prev, cur, 8); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+// Package imgutil holds image helpers shared by the screenshot subpackages.
+package imgutil
+
+import (
+	"image"
+)
+
+// Downscale shrinks src by scale (0 < scale <= 1) with a box filter: every destination
+// pixel is the average of the source pixels it covers. scale >= 1 returns src unchanged.
+func Downscale(src *image.RGBA, scale float64) *image.RGBA {
+	if scale >= 1 || scale <= 0 {
+		return src
+	}
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dw := int(float64(sw)*scale + 0.5)
+	dh := int(float64(sh)*scale + 0.5)
+	return Resize(src, dw, dh)
+}
+
+// Resize shrinks src to width×height with a box filter. The size is clamped to [1, src size].
+func Resize(src *image.RGBA, width, height int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	width = clamp(width, 1, sw)
+	height = clamp(height, 1, sh)
+	if width == sw && height == sh {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for dy := 0; dy < height; dy++ {
+		y0 := dy * sh / height
+		y1 := (dy + 1) * sh / height
+		for dx := 0; dx < width; dx++ {
+			x0 := dx * sw / width
+			x1 := (dx + 1) * sw / width
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				i := src.PixOffset(src.Rect.Min.X+x0, src.Rect.Min.Y+y)
+				for x := x0; x < x1; x++ {
+					r += uint64(src.Pix[i])
+					g += uint64(src.Pix[i+1])
+					b += uint64(src.Pix[i+2])
+					a += uint64(src.Pix[i+3])
+					i += 4
+				}
+				n += uint64(x1 - x0)
+			}
+			j := dst.PixOffset(dx, dy)
+			dst.Pix[j] = uint8((r + n/2) / n)
+			dst.Pix[j+1] = uint8((g + n/2) / n)
+			dst.Pix[j+2] = uint8((b + n/2) / n)
+			dst.Pix[j+3] = uint8((a + n/2) / n)
+		}
+	}
+	return dst
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
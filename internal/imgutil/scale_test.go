@@ -0,0 +1,50 @@
+package imgutil
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			src.SetRGBA(x, y, color.RGBA{uint8(x * 40), uint8(y * 100), 0, 255})
+		}
+	}
+
+	dst := Downscale(src, 0.5)
+	if dst.Rect != image.Rect(0, 0, 2, 1) {
+		t.Fatalf("rect %v", dst.Rect)
+	}
+	// Each destination pixel averages a 2×2 block.
+	if got, want := dst.RGBAAt(0, 0), (color.RGBA{20, 50, 0, 255}); got != want {
+		t.Errorf("pixel 0 = %v, want %v", got, want)
+	}
+	if got, want := dst.RGBAAt(1, 0), (color.RGBA{100, 50, 0, 255}); got != want {
+		t.Errorf("pixel 1 = %v, want %v", got, want)
+	}
+
+	if Downscale(src, 1) != src {
+		t.Error("scale 1 should return the source image")
+	}
+	sub := src.SubImage(image.Rect(2, 0, 4, 2)).(*image.RGBA)
+	if got, want := Resize(sub, 1, 1).RGBAAt(0, 0), (color.RGBA{100, 50, 0, 255}); got != want {
+		t.Errorf("sub-image pixel = %v, want %v", got, want)
+	}
+}
+
+func TestResizeLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocates a 70 MB image")
+	}
+	// 4200×4200 white pixels sum to more than fits in 32 bits per channel.
+	src := image.NewRGBA(image.Rect(0, 0, 4200, 4200))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	if got, want := Resize(src, 1, 1).RGBAAt(0, 0), (color.RGBA{255, 255, 255, 255}); got != want {
+		t.Errorf("pixel = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,193 @@
+// Package locate finds an image, such as a button or an icon, on the screen.
+//
+// Templates are matched by normalized cross-correlation of their luma, which is
+// insensitive to uniform changes of brightness and contrast: a score of 1 is a
+// perfect match, 0 no correlation. Window statistics come from integral images,
+// and the search starts on a downscaled pyramid of the screen, whose best
+// positions are refined level by level, so that a 4K desktop is searched in a
+// fraction of a second. Templates can also be searched at several scales, to
+// find them on displays with another DPI.
+package locate
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+	"sort"
+
+	"github.com/Fast-IQ/screenshot"
+)
+
+// Match is a location of the template.
+type Match struct {
+	// Rect is where the template was found, in desktop coordinates for Locate and
+	// in the coordinates of the image for Find.
+	Rect image.Rectangle
+	// Score is the normalized cross-correlation, up to 1 for a perfect match.
+	Score float64
+	// Scale is the scale of the template that matched.
+	Scale float64
+}
+
+// Options configures Locate and Find.
+type Options struct {
+	// Rect is the desktop area that Locate searches. An empty Rect searches the
+	// display Display.
+	Rect image.Rectangle
+	// Display is the index of the display that Locate searches when Rect is empty,
+	// default the primary display.
+	Display int
+	// Threshold is the lowest score of a match, from 0 to 1, default 0.9.
+	Threshold float64
+	// Scales lists the scales at which the template is searched, default 1 only.
+	Scales []float64
+	// MaxResults limits the number of matches, zero returns them all.
+	MaxResults int
+	// Capturer is the backend to use, nil means screenshot.DefaultCapturer().
+	Capturer screenshot.ScreenCapturer
+}
+
+const (
+	// coarseSize is the smallest side of the template at the coarsest level of
+	// the pyramid.
+	coarseSize = 12
+	// maxLevels is the largest number of halvings of the pyramid.
+	maxLevels = 4
+	// maxCandidates is the largest number of positions refined per scale.
+	maxCandidates = 256
+	// minTemplate is the smallest side of a scaled template that is searched.
+	minTemplate = 4
+)
+
+// Locate captures the area selected by opts and returns where template appears
+// in it, best match first. Matches overlapping a better one by more than half are
+// dropped.
+func Locate(template image.Image, opts Options) ([]Match, error) {
+	c := opts.Capturer
+	if c == nil {
+		c = screenshot.DefaultCapturer()
+	}
+	rect := opts.Rect
+	if rect.Empty() {
+		var err error
+		rect, err = c.GetDisplayBounds(opts.Display)
+		if err != nil {
+			return nil, err
+		}
+	}
+	img, err := c.Capture(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+	if err != nil {
+		return nil, err
+	}
+	matches, err := Find(img, template, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range matches {
+		matches[i].Rect = matches[i].Rect.Add(rect.Min)
+	}
+	return matches, nil
+}
+
+// Find returns where template appears in img, like Locate; opts.Rect, Display and
+// Capturer are not used.
+func Find(img, template image.Image, opts Options) ([]Match, error) {
+	threshold := opts.Threshold
+	if threshold == 0 {
+		threshold = 0.9
+	}
+	if threshold < 0 || threshold > 1 {
+		return nil, errors.New("locate: threshold out of range 0-1")
+	}
+	scales := opts.Scales
+	if len(scales) == 0 {
+		scales = []float64{1}
+	}
+	if template.Bounds().Empty() {
+		return nil, errors.New("locate: empty template")
+	}
+	tpl := toGray(toRGBA(template))
+	if newKernel(tpl).flat() {
+		return nil, errors.New("locate: template has no contrast")
+	}
+
+	levels := pyramid(toGray(toRGBA(img)), 0)
+	var matches []Match
+	for _, s := range scales {
+		if s <= 0 {
+			return nil, errors.New("locate: scale must be positive")
+		}
+		w, h := int(math.Round(float64(tpl.w)*s)), int(math.Round(float64(tpl.h)*s))
+		if min(w, h) < minTemplate || w > levels[0].img.w || h > levels[0].img.h {
+			continue
+		}
+		// The template at every level of the pyramid, finest first.
+		kernels := []*kernel{newKernel(tpl.resize(w, h))}
+		for t := tpl.resize(w, h); len(kernels) <= maxLevels && min(t.w, t.h)/2 >= coarseSize; {
+			t = t.half()
+			kernels = append(kernels, newKernel(t))
+		}
+		for len(levels) < len(kernels) {
+			last := levels[len(levels)-1].img.half()
+			levels = append(levels, level{last, newIntegral(last)})
+		}
+
+		top := len(kernels) - 1
+		// Scores drop at the coarser levels, down to about half for a template
+		// with fine details lying across the pixels of the coarsest level.
+		coarse := threshold
+		if top > 0 {
+			coarse *= 0.4
+		}
+		for _, c := range kernels[top].scan(levels[top], coarse, maxCandidates) {
+			for l := top - 1; l >= 0; l-- {
+				c = kernels[l].refine(levels[l], 2*c.x, 2*c.y, 2)
+			}
+			if c.score >= threshold {
+				r := image.Rect(c.x, c.y, c.x+w, c.y+h).Add(img.Bounds().Min)
+				matches = append(matches, Match{Rect: r, Score: c.score, Scale: s})
+			}
+		}
+	}
+	return suppress(matches, opts.MaxResults), nil
+}
+
+// suppress returns the matches, best first, without those overlapping a better
+// one by more than half of the smaller of the two, and at most limit unless 0.
+func suppress(matches []Match, limit int) []Match {
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	var kept []Match
+	for _, m := range matches {
+		ok := true
+		for _, k := range kept {
+			overlap := area(m.Rect.Intersect(k.Rect))
+			if 2*overlap > min(area(m.Rect), area(k.Rect)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			kept = append(kept, m)
+			if len(kept) == limit {
+				break
+			}
+		}
+	}
+	return kept
+}
+
+func area(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
+
+// toRGBA returns img as an *image.RGBA, converting it if needed.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, img, b.Min, draw.Src)
+	return rgba
+}
@@ -0,0 +1,176 @@
+package locate
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+
+	"github.com/Fast-IQ/screenshot/screenshottest"
+)
+
+// icon returns a w×h pattern of 4×4 blocks.
+func icon(w, h int, seed int64) *image.RGBA {
+	r := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y += 4 {
+		for x := 0; x < w; x += 4 {
+			c := color.RGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), 255}
+			draw.Draw(img, image.Rect(x, y, x+4, y+4), image.NewUniform(c), image.Point{}, draw.Src)
+		}
+	}
+	return img
+}
+
+// enlarge scales img by an integer factor n with nearest neighbour.
+func enlarge(img *image.RGBA, n int) *image.RGBA {
+	b := img.Rect
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx()*n, b.Dy()*n))
+	for y := range out.Rect.Dy() {
+		for x := range out.Rect.Dx() {
+			out.Set(x, y, img.At(b.Min.X+x/n, b.Min.Y+y/n))
+		}
+	}
+	return out
+}
+
+// screen returns a w×h desktop: a gradient with windows of other patterns.
+func screen(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), 120, 255})
+		}
+	}
+	r := rand.New(rand.NewSource(9))
+	for i := range 12 {
+		p := image.Pt(r.Intn(w-200), r.Intn(h-160))
+		draw.Draw(img, image.Rect(0, 0, 200, 160).Add(p), icon(200, 160, int64(100+i)), image.Point{}, draw.Src)
+	}
+	return img
+}
+
+func paste(dst *image.RGBA, src image.Image, at image.Point) image.Rectangle {
+	r := src.Bounds().Sub(src.Bounds().Min).Add(at)
+	draw.Draw(dst, r, src, src.Bounds().Min, draw.Src)
+	return r
+}
+
+func TestLocate(t *testing.T) {
+	desk := screen(1600, 900)
+	tpl := icon(48, 32, 1)
+	a := paste(desk, tpl, image.Pt(1234, 567))
+	b := paste(desk, tpl, image.Pt(101, 53))
+
+	c := screenshottest.New(image.Rect(0, 0, 1600, 900), image.Rect(1600, 0, 3200, 900))
+	c.SetContent(screenshottest.FromImage(desk))
+	matches, err := Locate(tpl, Options{Capturer: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("%d matches: %v", len(matches), matches)
+	}
+	for _, m := range matches {
+		if (m.Rect != a && m.Rect != b) || m.Score < 0.99 || m.Scale != 1 {
+			t.Errorf("match %+v", m)
+		}
+	}
+
+	// A region, with results in desktop coordinates.
+	matches, err = Locate(tpl, Options{Rect: image.Rect(1000, 500, 1400, 700), Capturer: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].Rect != a {
+		t.Errorf("region: %v, want %v", matches, a)
+	}
+
+	matches, err = Locate(tpl, Options{Capturer: c, MaxResults: 1})
+	if err != nil || len(matches) != 1 {
+		t.Errorf("MaxResults: %v, %v", matches, err)
+	}
+	if matches, _ := Locate(icon(48, 32, 2), Options{Capturer: c}); len(matches) != 0 {
+		t.Errorf("absent template found: %v", matches)
+	}
+}
+
+func TestFindBrightness(t *testing.T) {
+	desk := screen(800, 600)
+	tpl := icon(40, 40, 3)
+	// The template dimmed and with less contrast still matches.
+	dim := image.NewRGBA(tpl.Rect)
+	for i, v := range tpl.Pix {
+		if i%4 == 3 {
+			dim.Pix[i] = v
+		} else {
+			dim.Pix[i] = 40 + v/2
+		}
+	}
+	want := paste(desk, dim, image.Pt(333, 222))
+	matches, err := Find(desk, tpl, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].Rect != want {
+		t.Errorf("matches %v, want %v", matches, want)
+	}
+}
+
+func TestFindScales(t *testing.T) {
+	desk := screen(1200, 800)
+	tpl := icon(32, 24, 4)
+	want := paste(desk, enlarge(tpl, 2), image.Pt(500, 300))
+	// The bounds of the image need not start at the origin.
+	sub := desk.SubImage(image.Rect(400, 200, 1000, 700))
+
+	matches, err := Find(sub, tpl, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("found at scale 1: %v", matches)
+	}
+	matches, err = Find(sub, tpl, Options{Scales: []float64{1, 1.5, 2}, Threshold: 0.8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].Scale != 2 {
+		t.Fatalf("matches %v", matches)
+	}
+	if d := matches[0].Rect.Min.Sub(want.Min); d.X*d.X+d.Y*d.Y > 2 || matches[0].Rect.Size() != want.Size() {
+		t.Errorf("found at %v, want %v", matches[0].Rect, want)
+	}
+}
+
+func TestFindErrors(t *testing.T) {
+	desk := screen(400, 300)
+	flat := image.NewUniform(color.White)
+	if _, err := Find(desk, image.NewRGBA(image.Rectangle{}), Options{}); err == nil {
+		t.Error("empty template accepted")
+	}
+	white := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(white, white.Rect, flat, image.Point{}, draw.Src)
+	if _, err := Find(desk, white, Options{}); err == nil {
+		t.Error("flat template accepted")
+	}
+	if _, err := Find(desk, icon(8, 8, 1), Options{Threshold: 2}); err == nil {
+		t.Error("threshold 2 accepted")
+	}
+	// A template larger than the image is not found.
+	if m, err := Find(desk, icon(300, 20, 1), Options{}); err != nil || len(m) != 0 {
+		t.Errorf("large template: %v, %v", m, err)
+	}
+}
+
+func BenchmarkLocate4K(b *testing.B) {
+	desk := screen(3840, 2160)
+	tpl := icon(64, 48, 5)
+	paste(desk, tpl, image.Pt(3000, 1500))
+	for b.Loop() {
+		if m, err := Find(desk, tpl, Options{}); err != nil || len(m) != 1 {
+			b.Fatal(m, err)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package locate
+
+import (
+	"image"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// gray is a single-channel image of luma values, 0-255.
+type gray struct {
+	w, h int
+	pix  []float32
+}
+
+// toGray converts img to luma with the BT.601 weights.
+func toGray(img *image.RGBA) *gray {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	g := &gray{w: w, h: h, pix: make([]float32, w*h)}
+	for y := 0; y < h; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		out := g.pix[y*w : (y+1)*w]
+		for x := range out {
+			p := row[4*x : 4*x+3]
+			out[x] = float32(77*int(p[0])+150*int(p[1])+29*int(p[2])) / 256
+		}
+	}
+	return g
+}
+
+// half returns g shrunk by 2 in both directions with a 2×2 box filter, dropping an
+// odd last row or column.
+func (g *gray) half() *gray {
+	h := &gray{w: g.w / 2, h: g.h / 2}
+	h.pix = make([]float32, h.w*h.h)
+	for y := 0; y < h.h; y++ {
+		r0, r1 := g.pix[2*y*g.w:], g.pix[(2*y+1)*g.w:]
+		for x := 0; x < h.w; x++ {
+			h.pix[y*h.w+x] = (r0[2*x] + r0[2*x+1] + r1[2*x] + r1[2*x+1]) / 4
+		}
+	}
+	return h
+}
+
+// resize returns g scaled to w×h, bilinear when enlarging and averaging the
+// covered pixels when shrinking.
+func (g *gray) resize(w, h int) *gray {
+	if w == g.w && h == g.h {
+		return g
+	}
+	out := &gray{w: w, h: h, pix: make([]float32, w*h)}
+	sx, sy := float64(g.w)/float64(w), float64(g.h)/float64(h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if sx > 1 || sy > 1 {
+				out.pix[y*w+x] = g.area(float64(x)*sx, float64(y)*sy, float64(x+1)*sx, float64(y+1)*sy)
+			} else {
+				out.pix[y*w+x] = g.bilinear((float64(x)+0.5)*sx-0.5, (float64(y)+0.5)*sy-0.5)
+			}
+		}
+	}
+	return out
+}
+
+// area returns the mean of the pixels whose top-left corner lies in [x0, x1)×[y0, y1).
+func (g *gray) area(x0, y0, x1, y1 float64) float32 {
+	ix0, iy0 := int(x0), int(y0)
+	ix1, iy1 := max(int(math.Ceil(x1)), ix0+1), max(int(math.Ceil(y1)), iy0+1)
+	var sum float32
+	for y := iy0; y < min(iy1, g.h); y++ {
+		for x := ix0; x < min(ix1, g.w); x++ {
+			sum += g.pix[y*g.w+x]
+		}
+	}
+	return sum / float32((min(iy1, g.h)-iy0)*(min(ix1, g.w)-ix0))
+}
+
+func (g *gray) bilinear(x, y float64) float32 {
+	x = math.Max(0, math.Min(x, float64(g.w-1)))
+	y = math.Max(0, math.Min(y, float64(g.h-1)))
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, g.w-1), min(y0+1, g.h-1)
+	fx, fy := float32(x-float64(x0)), float32(y-float64(y0))
+	top := g.pix[y0*g.w+x0]*(1-fx) + g.pix[y0*g.w+x1]*fx
+	bottom := g.pix[y1*g.w+x0]*(1-fx) + g.pix[y1*g.w+x1]*fx
+	return top*(1-fy) + bottom*fy
+}
+
+// integral holds the summed-area tables of an image and of its squares, so that
+// the sums over any window take four lookups.
+type integral struct {
+	stride  int // width + 1
+	sum, sq []float64
+}
+
+func newIntegral(g *gray) *integral {
+	s := g.w + 1
+	ii := &integral{stride: s, sum: make([]float64, s*(g.h+1)), sq: make([]float64, s*(g.h+1))}
+	for y := 0; y < g.h; y++ {
+		var row, rowSq float64
+		for x := 0; x < g.w; x++ {
+			v := float64(g.pix[y*g.w+x])
+			row += v
+			rowSq += v * v
+			i := (y+1)*s + x + 1
+			ii.sum[i] = ii.sum[i-s] + row
+			ii.sq[i] = ii.sq[i-s] + rowSq
+		}
+	}
+	return ii
+}
+
+// window returns the sum and the sum of squares over the w×h window at (x, y).
+func (ii *integral) window(x, y, w, h int) (sum, sq float64) {
+	s := ii.stride
+	a, b, c, d := y*s+x, y*s+x+w, (y+h)*s+x, (y+h)*s+x+w
+	return ii.sum[d] - ii.sum[b] - ii.sum[c] + ii.sum[a], ii.sq[d] - ii.sq[b] - ii.sq[c] + ii.sq[a]
+}
+
+// level is an image of the pyramid with its integral tables.
+type level struct {
+	img *gray
+	ii  *integral
+}
+
+// pyramid returns img and n successive halvings of it.
+func pyramid(img *gray, n int) []level {
+	levels := []level{{img, newIntegral(img)}}
+	for i := 0; i < n; i++ {
+		img = img.half()
+		levels = append(levels, level{img, newIntegral(img)})
+	}
+	return levels
+}
+
+// kernel is a template prepared for correlation: its pixels minus their mean,
+// and the sum of their squares.
+type kernel struct {
+	w, h int
+	pix  []float32
+	norm float64
+}
+
+func newKernel(t *gray) *kernel {
+	var mean float64
+	for _, v := range t.pix {
+		mean += float64(v)
+	}
+	mean /= float64(len(t.pix))
+	k := &kernel{w: t.w, h: t.h, pix: make([]float32, len(t.pix))}
+	for i, v := range t.pix {
+		d := float64(v) - mean
+		k.pix[i] = float32(d)
+		k.norm += d * d
+	}
+	return k
+}
+
+// flat reports whether the template has too little contrast to be located.
+func (k *kernel) flat() bool {
+	return k.norm < minVariance*float64(len(k.pix))
+}
+
+// minVariance is the variance, in squared luma steps, below which a template or
+// a window is considered flat and gets a score of 0.
+const minVariance = 1.0 / 16
+
+// score returns the normalized cross-correlation, from -1 to 1, of k with the
+// window of l at (x, y).
+func (k *kernel) score(l level, x, y int) float64 {
+	n := float64(k.w * k.h)
+	sum, sq := l.ii.window(x, y, k.w, k.h)
+	v := sq - sum*sum/n
+	if v < minVariance*n {
+		return 0
+	}
+	var cross float64
+	for j := 0; j < k.h; j++ {
+		row := l.img.pix[(y+j)*l.img.w+x : (y+j)*l.img.w+x+k.w]
+		var r float32
+		for i, t := range k.pix[j*k.w : (j+1)*k.w] {
+			r += row[i] * t
+		}
+		cross += float64(r)
+	}
+	return cross / math.Sqrt(v*k.norm)
+}
+
+// candidate is a template position at some level of the pyramid.
+type candidate struct {
+	x, y  int
+	score float64
+}
+
+// scan scores every position of k in l and returns the local maxima of at least
+// threshold, best first and at most limit of them.
+func (k *kernel) scan(l level, threshold float64, limit int) []candidate {
+	cw, ch := l.img.w-k.w+1, l.img.h-k.h+1
+	if cw <= 0 || ch <= 0 {
+		return nil
+	}
+	scores := make([]float64, cw*ch)
+	var wg sync.WaitGroup
+	workers := min(runtime.GOMAXPROCS(0), ch)
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for y := n; y < ch; y += workers {
+				for x := 0; x < cw; x++ {
+					scores[y*cw+x] = k.score(l, x, y)
+				}
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	var found []candidate
+	for y := 0; y < ch; y++ {
+	next:
+		for x := 0; x < cw; x++ {
+			s := scores[y*cw+x]
+			if s < threshold {
+				continue
+			}
+			for ny := max(y-1, 0); ny <= min(y+1, ch-1); ny++ {
+				for nx := max(x-1, 0); nx <= min(x+1, cw-1); nx++ {
+					// Ties go to the first position in scan order.
+					if o := scores[ny*cw+nx]; o > s || (o == s && ny*cw+nx < y*cw+x) {
+						continue next
+					}
+				}
+			}
+			found = append(found, candidate{x, y, s})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].score > found[j].score })
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found
+}
+
+// refine returns the best position of k in l within radius of (x, y).
+func (k *kernel) refine(l level, x, y, radius int) candidate {
+	best := candidate{score: math.Inf(-1)}
+	for ny := max(y-radius, 0); ny <= min(y+radius, l.img.h-k.h); ny++ {
+		for nx := max(x-radius, 0); nx <= min(x+radius, l.img.w-k.w); nx++ {
+			if s := k.score(l, nx, ny); s > best.score {
+				best = candidate{nx, ny, s}
+			}
+		}
+	}
+	return best
+}
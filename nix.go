@@ -3,6 +3,7 @@
 package screenshot
 
 import (
+	"fmt"
 	"github.com/jezek/xgb"
 	"github.com/jezek/xgb/xinerama"
 	"image"
@@ -39,43 +40,56 @@ func NumActiveDisplays() (num int) {
 
 // GetDisplayBounds returns the bounds of displayIndex'th display.
 // The main display is displayIndex = 0.
-func GetDisplayBounds(displayIndex int) (rect image.Rectangle) {
+func GetDisplayBounds(displayIndex int) (image.Rectangle, error) {
+	rects, err := GetAllDisplayBounds()
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	if displayIndex < 0 || displayIndex >= len(rects) {
+		return image.Rectangle{}, fmt.Errorf("invalid display index: %d", displayIndex)
+	}
+	return rects[displayIndex], nil
+}
+
+// GetAllDisplayBounds returns the bounds of all active displays, in display index order.
+func GetAllDisplayBounds() (rects []image.Rectangle, e error) {
 	defer func() {
-		e := recover()
-		if e != nil {
-			rect = image.Rectangle{}
+		err := recover()
+		if err != nil {
+			rects = nil
+			e = fmt.Errorf("%v", err)
 		}
 	}()
 
 	c, err := xgb.NewConn()
 	if err != nil {
-		return image.Rectangle{}
+		return nil, err
 	}
 	defer c.Close()
 
 	err = xinerama.Init(c)
 	if err != nil {
-		return image.Rectangle{}
+		return nil, err
 	}
 
 	reply, err := xinerama.QueryScreens(c).Reply()
 	if err != nil {
-		return image.Rectangle{}
+		return nil, err
 	}
 
-	if displayIndex >= int(reply.Number) {
-		return image.Rectangle{}
+	if reply.Number == 0 {
+		return nil, fmt.Errorf("no active displays")
 	}
 
 	primary := reply.ScreenInfo[0]
 	x0 := int(primary.XOrg)
 	y0 := int(primary.YOrg)
 
-	screen := reply.ScreenInfo[displayIndex]
-	x := int(screen.XOrg) - x0
-	y := int(screen.YOrg) - y0
-	w := int(screen.Width)
-	h := int(screen.Height)
-	rect = image.Rect(x, y, x+w, y+h)
-	return rect
+	rects = make([]image.Rectangle, 0, int(reply.Number))
+	for _, screen := range reply.ScreenInfo[:reply.Number] {
+		x := int(screen.XOrg) - x0
+		y := int(screen.YOrg) - y0
+		rects = append(rects, image.Rect(x, y, x+int(screen.Width), y+int(screen.Height)))
+	}
+	return rects, nil
 }
@@ -4,11 +4,38 @@ package screenshot
 
 import (
 	"image"
+	"image/color"
 )
 
-// Capture returns screen capture of specified desktop region.
-// x and y represent distance from the upper-left corner of primary display.
-// Y-axis is downward direction. This means coordinates system is similar to Windows OS.
-func Capture(x, y, width, height int) (img *image.RGBA, e error) {
+// captureBackend captures with the platform backend, see Capture.
+func captureBackend(x, y, width, height int) (img *image.RGBA, e error) {
 	return captureXinerama(x, y, width, height)
 }
+
+func captureExcluding(x, y, width, height int, exclude []WindowID) (*image.RGBA, error) {
+	return captureXExcluding(x, y, width, height, exclude)
+}
+
+// samplePoints reads single pixels, see ColorsAt.
+func samplePoints(points []image.Point) ([]color.RGBA, error) {
+	return xSamplePoints(points)
+}
+
+// displayProfile returns the ICC profile of a display, see DisplayColorSpace.
+func displayProfile(displayIndex int) ([]byte, error) {
+	return xDisplayProfile(displayIndex)
+}
+
+// captureDeep captures at the depth of the display, see CaptureRGBA64.
+func captureDeep(x, y, width, height int) (*image.RGBA64, error) {
+	return captureXDeep(x, y, width, height)
+}
+
+// watchArea returns the backend of an AreaWatcher.
+func watchArea(rect image.Rectangle) (areaBackend, error) {
+	a, err := watchXArea(rect)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
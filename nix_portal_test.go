@@ -0,0 +1,293 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && !freebsd && (linux || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// startSessionBus starts a private dbus-daemon and points DBUS_SESSION_BUS_ADDRESS
+// at it for the duration of the test. The test is skipped when dbus-daemon is not installed.
+func startSessionBus(t *testing.T) {
+	t.Helper()
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	cmd := exec.Command(path, "--session", "--nofork", "--nopidfile", "--print-address=3",
+		"--address=unix:dir="+t.TempDir())
+	cmd.ExtraFiles = []*os.File{w}
+	if err := cmd.Start(); err != nil {
+		_ = w.Close()
+		t.Fatalf("cannot start dbus-daemon: %v", err)
+	}
+	_ = w.Close()
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	ch := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		ch <- strings.TrimSpace(line)
+	}()
+	var address string
+	select {
+	case address = <-ch:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for dbus-daemon")
+	}
+	if address == "" {
+		t.Fatal("dbus-daemon exited before reporting its address")
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", address)
+	t.Setenv("XDG_SESSION_TYPE", "wayland")
+}
+
+// portalResponse configures how fakePortal answers the next Screenshot request.
+type portalResponse struct {
+	// Code is the Request.Response code: 0 success, 1 cancelled, 2 other error.
+	Code uint32
+	// Image is written to a PNG file whose file:// URI is returned, unless URI is set.
+	Image image.Image
+	// URI overrides the returned uri, e.g. to send malformed values.
+	URI string
+	// Delay postpones the Response signal.
+	Delay time.Duration
+}
+
+// fakePortal is a minimal org.freedesktop.portal.Desktop exporting the Screenshot interface.
+type fakePortal struct {
+	t    *testing.T
+	conn *dbus.Conn
+	dir  string
+
+	mu       sync.Mutex
+	response portalResponse
+	tokens   []string
+	files    []string
+	// closed is set by the test cleanup; Screenshot then starts no more responders.
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// startFakePortal starts a private session bus and registers a fake portal on it.
+func startFakePortal(t *testing.T) *fakePortal {
+	t.Helper()
+	startSessionBus(t)
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakePortal{t: t, conn: conn, dir: t.TempDir()}
+	t.Cleanup(func() {
+		p.mu.Lock()
+		p.closed = true
+		p.mu.Unlock()
+		p.wg.Wait()
+		_ = conn.Close()
+	})
+
+	err = conn.Export(p, "/org/freedesktop/portal/desktop", "org.freedesktop.portal.Screenshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := conn.RequestName("org.freedesktop.portal.Desktop", dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("cannot own portal name: %v %v", reply, err)
+	}
+	return p
+}
+
+func (p *fakePortal) setResponse(r portalResponse) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.response = r
+}
+
+// Screenshot implements org.freedesktop.portal.Screenshot.Screenshot.
+func (p *fakePortal) Screenshot(sender dbus.Sender, parentWindow string, options map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	token, ok := options["handle_token"].Value().(string)
+	if !ok {
+		return "", dbus.MakeFailedError(errors.New("handle_token must be a string"))
+	}
+	handle := dbus.ObjectPath("/org/freedesktop/portal/desktop/request/" +
+		strings.ReplaceAll(strings.TrimPrefix(string(sender), ":"), ".", "_") + "/" + token)
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return "", dbus.MakeFailedError(errors.New("portal closed"))
+	}
+	p.tokens = append(p.tokens, token)
+	r := p.response
+	p.wg.Add(1)
+	p.mu.Unlock()
+
+	go func() {
+		defer p.wg.Done()
+		time.Sleep(r.Delay)
+		results := map[string]dbus.Variant{}
+		if r.Code == portalResponseSuccess {
+			uri := r.URI
+			if uri == "" && r.Image != nil {
+				uri = "file://" + p.writePNG(r.Image)
+			}
+			results["uri"] = dbus.MakeVariant(uri)
+		}
+		// Like xdg-desktop-portal, the Response signal is sent to the caller only.
+		msg := &dbus.Message{
+			Type: dbus.TypeSignal,
+			Headers: map[dbus.HeaderField]dbus.Variant{
+				dbus.FieldPath:        dbus.MakeVariant(handle),
+				dbus.FieldInterface:   dbus.MakeVariant("org.freedesktop.portal.Request"),
+				dbus.FieldMember:      dbus.MakeVariant("Response"),
+				dbus.FieldDestination: dbus.MakeVariant(string(sender)),
+				dbus.FieldSignature:   dbus.MakeVariant(dbus.SignatureOf(r.Code, results)),
+			},
+			Body: []interface{}{r.Code, results},
+		}
+		p.conn.Send(msg, nil)
+	}()
+	return handle, nil
+}
+
+func (p *fakePortal) writePNG(img image.Image) string {
+	p.mu.Lock()
+	name := filepath.Join(p.dir, fmt.Sprintf("Screenshot-%d.png", len(p.files)+1))
+	p.files = append(p.files, name)
+	p.mu.Unlock()
+
+	f, err := os.Create(name)
+	if err != nil {
+		p.t.Error(err)
+		return name
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		p.t.Error(err)
+	}
+	return name
+}
+
+func portalTestImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 200, 255})
+		}
+	}
+	return img
+}
+
+func TestPortalCapture(t *testing.T) {
+	p := startFakePortal(t)
+	p.setResponse(portalResponse{Image: portalTestImage()})
+
+	img, err := Capture(10, 5, 8, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Rect != image.Rect(0, 0, 8, 6) {
+		t.Fatalf("image rect %v", img.Rect)
+	}
+	if got, want := img.RGBAAt(0, 0), (color.RGBA{10, 5, 200, 255}); got != want {
+		t.Errorf("pixel (0, 0) = %v, want %v", got, want)
+	}
+	if got, want := img.RGBAAt(7, 5), (color.RGBA{17, 10, 200, 255}); got != want {
+		t.Errorf("pixel (7, 5) = %v, want %v", got, want)
+	}
+
+	// Each request uses a fresh token, and the screenshot file is removed once read.
+	if _, err := Capture(0, 0, 4, 4); err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.tokens) != 2 || p.tokens[0] == p.tokens[1] {
+		t.Errorf("tokens %q, want two distinct tokens", p.tokens)
+	}
+	for _, f := range p.files {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed: %v", f, err)
+		}
+	}
+}
+
+func TestPortalCancelled(t *testing.T) {
+	p := startFakePortal(t)
+	p.setResponse(portalResponse{Code: portalResponseCancelled})
+
+	_, err := Capture(0, 0, 4, 4)
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("got %v, want ErrCancelled", err)
+	}
+
+	p.setResponse(portalResponse{Code: 2})
+	if _, err := Capture(0, 0, 4, 4); err == nil || errors.Is(err, ErrCancelled) {
+		t.Errorf("got %v, want a non-cancellation error", err)
+	}
+}
+
+func TestPortalSlowResponse(t *testing.T) {
+	p := startFakePortal(t)
+	p.setResponse(portalResponse{Image: portalTestImage(), Delay: 200 * time.Millisecond})
+	if _, err := Capture(0, 0, 4, 4); err != nil {
+		t.Fatalf("slow response: %v", err)
+	}
+
+	old := portalTimeout
+	portalTimeout = 50 * time.Millisecond
+	defer func() { portalTimeout = old }()
+	if _, err := Capture(0, 0, 4, 4); err == nil {
+		t.Error("expected timeout error")
+	}
+}
+
+func TestPortalMalformedURI(t *testing.T) {
+	p := startFakePortal(t)
+	missing := filepath.Join(t.TempDir(), "missing.png")
+	notPNG := filepath.Join(t.TempDir(), "not.png")
+	if err := os.WriteFile(notPNG, []byte("not a png"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, uri := range []string{
+		"",
+		"https://example.com/shot.png",
+		"file://" + missing,
+		"::not a uri",
+		"file://" + notPNG,
+	} {
+		p.setResponse(portalResponse{URI: uri})
+		if _, err := Capture(0, 0, 4, 4); err == nil {
+			t.Errorf("uri %q: expected error", uri)
+		}
+	}
+	if _, err := os.Stat(notPNG); !os.IsNotExist(err) {
+		t.Errorf("undecodable screenshot file was not removed: %v", err)
+	}
+}
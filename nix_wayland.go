@@ -3,6 +3,7 @@
 package screenshot
 
 import (
+	"errors"
 	"fmt"
 	"github.com/godbus/dbus/v5"
 	"image"
@@ -11,10 +12,19 @@ import (
 	"net/url"
 	"os"
 	"sync/atomic"
+	"time"
 )
 
 var gTokenCounter uint64 = 0
 
+// portalTimeout bounds the wait for the portal Response signal.
+var portalTimeout = 30 * time.Second
+
+const (
+	portalResponseSuccess   = 0
+	portalResponseCancelled = 1
+)
+
 func captureDbus(x, y, width, height int) (img *image.RGBA, e error) {
 	c, err := dbus.ConnectSessionBus()
 	if err != nil {
@@ -22,11 +32,27 @@ func captureDbus(x, y, width, height int) (img *image.RGBA, e error) {
 	}
 	defer func(c *dbus.Conn) {
 		err := c.Close()
-		if err != nil {
+		if err != nil && e == nil {
 			e = err
 		}
 	}(c)
-	token := atomic.AddUint64(&gTokenCounter, 1)
+
+	// The portal emits Response on the request object whose path the call returns.
+	// Subscribe to every Response before calling so the signal can't be missed, and
+	// pick ours by path once it is known.
+	token := fmt.Sprintf("screenshot%d", atomic.AddUint64(&gTokenCounter, 1))
+
+	ch := make(chan *dbus.Signal, 8)
+	c.Signal(ch)
+	defer c.RemoveSignal(ch)
+	err = c.AddMatchSignal(
+		dbus.WithMatchInterface("org.freedesktop.portal.Request"),
+		dbus.WithMatchMember("Response"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("dbus.AddMatchSignal() failed: %v", err)
+	}
+
 	options := map[string]dbus.Variant{
 		"modal":        dbus.MakeVariant(false),
 		"interactive":  dbus.MakeVariant(false),
@@ -39,59 +65,77 @@ func captureDbus(x, y, width, height int) (img *image.RGBA, e error) {
 	if err != nil {
 		return nil, fmt.Errorf("dbus.Store() failed: %v", err)
 	}
-	ch := make(chan *dbus.Message)
-	c.Eavesdrop(ch)
-	for msg := range ch {
-		o, ok := msg.Headers[dbus.FieldPath]
-		if !ok {
-			continue
-		}
-		s, ok := o.Value().(dbus.ObjectPath)
-		if !ok {
-			return nil, fmt.Errorf("dbus.FieldPath value does't have ObjectPath type")
-		}
-		if s != path {
-			continue
-		}
-		for _, body := range msg.Body {
-			v, ok := body.(map[string]dbus.Variant)
+
+	timeout := time.NewTimer(portalTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case sig, ok := <-ch:
 			if !ok {
-				continue
+				return nil, errors.New("dbus connection closed before portal response")
 			}
-			uri, ok := v["uri"]
-			if !ok {
+			if sig.Path != path || sig.Name != "org.freedesktop.portal.Request.Response" {
 				continue
 			}
-			path, ok := uri.Value().(string)
-			if !ok {
-				return nil, fmt.Errorf("uri is not a string")
-			}
-			fpath, err := url.Parse(path)
-			if err != nil {
-				return nil, fmt.Errorf("url.Parse(%v) failed: %v", path, err)
-			}
-			if fpath.Scheme != "file" {
-				return nil, fmt.Errorf("uri is not a file path")
-			}
-			file, err := os.Open(fpath.Path)
-			if err != nil {
-				return nil, fmt.Errorf("os.Open(%s) failed: %v", path, err)
-			}
-			defer func(file *os.File) {
-				_ = file.Close()
-				_ = os.Remove(fpath.Path)
-			}(file)
-			img, err := png.Decode(file)
-			if err != nil {
-				return nil, fmt.Errorf("png.Decode(%s) failed: %v", path, err)
-			}
-			canvas, err := createImage(image.Rect(0, 0, width, height))
-			if err != nil {
-				return nil, fmt.Errorf("createImage(%v) failed: %v", path, err)
-			}
-			draw.Draw(canvas, image.Rect(0, 0, width, height), img, image.Point{x, y}, draw.Src)
-			return canvas, e
+			return readPortalResponse(sig.Body, x, y, width, height)
+		case <-timeout.C:
+			return nil, fmt.Errorf("no portal response after %v", portalTimeout)
 		}
 	}
-	return nil, fmt.Errorf("dbus.Message doesn't contain uri")
+}
+
+// readPortalResponse decodes the (response, results) body of a Request.Response signal
+// and crops the screenshot file it points to. The file is removed once read.
+func readPortalResponse(body []interface{}, x, y, width, height int) (*image.RGBA, error) {
+	if len(body) != 2 {
+		return nil, fmt.Errorf("unexpected portal response: %v", body)
+	}
+	code, ok := body[0].(uint32)
+	if !ok {
+		return nil, fmt.Errorf("portal response code is not uint32")
+	}
+	switch code {
+	case portalResponseSuccess:
+	case portalResponseCancelled:
+		return nil, ErrCancelled
+	default:
+		return nil, fmt.Errorf("portal request failed with response %d", code)
+	}
+	results, ok := body[1].(map[string]dbus.Variant)
+	if !ok {
+		return nil, fmt.Errorf("portal results is not a{sv}")
+	}
+	uri, ok := results["uri"]
+	if !ok {
+		return nil, fmt.Errorf("dbus.Message doesn't contain uri")
+	}
+	path, ok := uri.Value().(string)
+	if !ok {
+		return nil, fmt.Errorf("uri is not a string")
+	}
+	fpath, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse(%v) failed: %v", path, err)
+	}
+	if fpath.Scheme != "file" {
+		return nil, fmt.Errorf("uri is not a file path")
+	}
+	file, err := os.Open(fpath.Path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open(%s) failed: %v", path, err)
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+		_ = os.Remove(fpath.Path)
+	}(file)
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("png.Decode(%s) failed: %v", path, err)
+	}
+	canvas, err := createImage(image.Rect(0, 0, width, height))
+	if err != nil {
+		return nil, fmt.Errorf("createImage(%v) failed: %v", path, err)
+	}
+	draw.Draw(canvas, image.Rect(0, 0, width, height), img, image.Point{x, y}, draw.Src)
+	return canvas, nil
 }
@@ -0,0 +1,203 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sync"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/damage"
+	"github.com/jezek/xgb/xinerama"
+	"github.com/jezek/xgb/xproto"
+)
+
+// xArea is the AreaWatcher backend of X11. It captures the area with GetImage on a
+// connection of its own, which also receives the DAMAGE events of the root window.
+type xArea struct {
+	rect     image.Rectangle // relative to the primary display
+	conn     *xgb.Conn
+	root     xproto.Window
+	format   *xFormat
+	xinerama bool
+	damage   chan struct{} // nil without the DAMAGE extension
+	closed   sync.Once
+
+	mu sync.Mutex
+	// target is rect on the root window, as of the last capture.
+	target image.Rectangle
+	// screen is the size of the root window as of the last capture.
+	screen image.Rectangle
+}
+
+// watchXArea opens the connection of an xArea for rect and subscribes to the damage
+// of the root window if the server has the DAMAGE extension.
+func watchXArea(rect image.Rectangle) (a *xArea, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			a, e = nil, fmt.Errorf("%v", err)
+		}
+	}()
+	c, err := xgb.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	format, err := xRootFormat(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	screen := xproto.Setup(c).DefaultScreen(c)
+	x0, y0 := xPrimaryOrigin(c)
+	a = &xArea{
+		rect:     rect,
+		conn:     c,
+		root:     screen.Root,
+		format:   format,
+		xinerama: xinerama.Init(c) == nil,
+		target:   rect.Add(image.Pt(x0, y0)),
+		screen:   image.Rect(0, 0, int(screen.WidthInPixels), int(screen.HeightInPixels)),
+	}
+	if xSubscribeDamage(c, a.root) == nil {
+		a.damage = make(chan struct{}, 1)
+		go a.events()
+	}
+	return a, nil
+}
+
+// xSubscribeDamage asks for a DamageNotify event for every drawing to window or
+// its inferiors.
+func xSubscribeDamage(c *xgb.Conn, window xproto.Window) error {
+	if err := damage.Init(c); err != nil {
+		return err
+	}
+	if _, err := damage.QueryVersion(c, 1, 1).Reply(); err != nil {
+		return err
+	}
+	id, err := damage.NewDamageId(c)
+	if err != nil {
+		return err
+	}
+	return damage.CreateChecked(c, id, xproto.Drawable(window), damage.ReportLevelRawRectangles).Check()
+}
+
+// events signals damage for the DamageNotify events overlapping the area until the
+// connection is closed.
+func (a *xArea) events() {
+	defer close(a.damage)
+	for {
+		ev, err := a.conn.WaitForEvent()
+		if ev == nil && err == nil {
+			return
+		}
+		n, ok := ev.(damage.NotifyEvent)
+		if !ok {
+			continue
+		}
+		r := image.Rect(int(n.Area.X), int(n.Area.Y), int(n.Area.X)+int(n.Area.Width), int(n.Area.Y)+int(n.Area.Height))
+		a.mu.Lock()
+		target := a.target
+		a.mu.Unlock()
+		if r.Overlaps(target) {
+			select {
+			case a.damage <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// capture reads the area with a GetImage request, sent together with queries of
+// the primary display and of the root window size, whose replies are awaited at
+// once. In the rare case either changed since the last capture, the area is read
+// again.
+func (a *xArea) capture() (img *image.RGBA, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			img, e = nil, fmt.Errorf("%v", err)
+		}
+	}()
+	c := a.conn
+	for {
+		a.mu.Lock()
+		target, screen := a.target, a.screen
+		a.mu.Unlock()
+
+		var screens xinerama.QueryScreensCookie
+		if a.xinerama {
+			screens = xinerama.QueryScreens(c)
+		}
+		geometry := xproto.GetGeometry(c, xproto.Drawable(a.root))
+		r := screen.Intersect(target)
+		var cookie xproto.GetImageCookie
+		if !r.Empty() {
+			cookie = xproto.GetImage(c, xproto.ImageFormatZPixmap, xproto.Drawable(a.root),
+				int16(r.Min.X), int16(r.Min.Y), uint16(r.Dx()), uint16(r.Dy()), 0xffffffff)
+		}
+
+		newTarget, newScreen := target, screen
+		if a.xinerama {
+			reply, err := screens.Reply()
+			if err != nil {
+				return nil, err
+			}
+			if reply.Number > 0 {
+				newTarget = a.rect.Add(image.Pt(int(reply.ScreenInfo[0].XOrg), int(reply.ScreenInfo[0].YOrg)))
+			}
+		}
+		g, err := geometry.Reply()
+		if err != nil {
+			return nil, err
+		}
+		newScreen = image.Rect(0, 0, int(g.Width), int(g.Height))
+		var data []byte
+		if cookie.Cookie != nil {
+			reply, err := cookie.Reply()
+			var match xproto.MatchError
+			switch {
+			case errors.As(err, &match):
+				// The root window shrank.
+			case err != nil:
+				return nil, err
+			default:
+				data = reply.Data
+			}
+		}
+		if newTarget != target || newScreen != screen {
+			a.mu.Lock()
+			a.target, a.screen = newTarget, newScreen
+			a.mu.Unlock()
+			continue
+		}
+
+		img, err := createImage(image.Rect(0, 0, a.rect.Dx(), a.rect.Dy()))
+		if err != nil {
+			return nil, err
+		}
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = 255
+		}
+		if data != nil {
+			stride := a.format.stride(r.Dx())
+			if len(data) < stride*r.Dy() {
+				return nil, errors.New("short GetImage reply")
+			}
+			p := r.Min.Sub(target.Min)
+			for y := 0; y < r.Dy(); y++ {
+				a.format.toRGBA(img.Pix[img.PixOffset(p.X, p.Y+y):], data[y*stride:], r.Dx())
+			}
+		}
+		return img, nil
+	}
+}
+
+func (a *xArea) damaged() <-chan struct{} {
+	return a.damage
+}
+
+func (a *xArea) close() error {
+	a.closed.Do(a.conn.Close)
+	return nil
+}
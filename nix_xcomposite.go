@@ -0,0 +1,282 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/composite"
+	"github.com/jezek/xgb/xproto"
+)
+
+// xRedirectSettle is how long windows get to repaint into their pixmaps after the
+// redirection was turned on.
+const xRedirectSettle = 100 * time.Millisecond
+
+// xRedirect holds the connection that keeps the top-level windows redirected for
+// the Composite extension. Automatic redirection ends with the connection that
+// requested it, so it stays open.
+var xRedirect struct {
+	sync.Mutex
+	conn    *xgb.Conn
+	display string
+}
+
+// xRedirectedConn returns a connection to the X server of $DISPLAY on which every
+// top-level window has an offscreen pixmap, either because a compositing manager
+// redirects them or through automatic redirection requested on the connection.
+func xRedirectedConn() (*xgb.Conn, error) {
+	xRedirect.Lock()
+	defer xRedirect.Unlock()
+	display := os.Getenv("DISPLAY")
+	if xRedirect.conn != nil {
+		if xRedirect.display == display {
+			return xRedirect.conn, nil
+		}
+		xRedirect.conn.Close()
+		xRedirect.conn = nil
+	}
+
+	c, err := xgb.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	if err := composite.Init(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if _, err := composite.QueryVersion(c, 0, 4).Reply(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	root := xproto.Setup(c).DefaultScreen(c).Root
+	err = composite.RedirectSubwindowsChecked(c, root, composite.RedirectAutomatic).Check()
+	var access xproto.AccessError
+	switch {
+	case errors.As(err, &access):
+		// A compositing manager redirects the windows already.
+	case err != nil:
+		c.Close()
+		return nil, err
+	default:
+		time.Sleep(xRedirectSettle)
+	}
+	xRedirect.conn, xRedirect.display = c, display
+	return c, nil
+}
+
+// xDropRedirect closes c if it is the redirecting connection, so that the next
+// capture starts over after an error.
+func xDropRedirect(c *xgb.Conn) {
+	xRedirect.Lock()
+	defer xRedirect.Unlock()
+	if xRedirect.conn == c {
+		c.Close()
+		xRedirect.conn = nil
+	}
+}
+
+func captureXExcluding(x, y, width, height int, exclude []WindowID) (img *image.RGBA, e error) {
+	c, err := xRedirectedConn()
+	if err != nil {
+		return nil, fmt.Errorf("composite: %w", err)
+	}
+	defer func() {
+		err := recover()
+		if err != nil {
+			img = nil
+			e = fmt.Errorf("%v", err)
+		}
+		if e != nil {
+			xDropRedirect(c)
+		}
+	}()
+
+	img, err = captureXinerama(x, y, width, height)
+	if err != nil {
+		return nil, err
+	}
+	if err := xUncover(c, img, x, y, exclude); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// xUncover repaints the areas of the windows in exclude on img, a capture of the
+// desktop at (x, y), with what is behind them: the desktop background and the
+// pixmaps of the windows below, followed by those of the windows above.
+func xUncover(c *xgb.Conn, img *image.RGBA, x, y int, exclude []WindowID) error {
+	screen := xproto.Setup(c).DefaultScreen(c)
+	format, err := xRootFormat(c)
+	if err != nil {
+		return err
+	}
+	x0, y0 := xPrimaryOrigin(c)
+	target := img.Rect.Sub(img.Rect.Min).Add(image.Pt(x+x0, y+y0))
+
+	excluded := make(map[xproto.Window]bool, len(exclude))
+	for _, id := range exclude {
+		if top, ok := xTopLevel(c, screen.Root, xproto.Window(id)); ok {
+			excluded[top] = true
+		}
+	}
+	tree, err := xproto.QueryTree(c, screen.Root).Reply()
+	if err != nil {
+		return err
+	}
+
+	// The children of root are in stacking order, bottom-most first.
+	type layer struct {
+		win    xproto.Window
+		bounds image.Rectangle
+	}
+	var layers []layer
+	var damage []image.Rectangle
+	for _, w := range tree.Children {
+		attrs, err := xproto.GetWindowAttributes(c, w).Reply()
+		if err != nil || attrs.MapState != xproto.MapStateViewable || attrs.Class == xproto.WindowClassInputOnly {
+			continue
+		}
+		geom, err := xproto.GetGeometry(c, xproto.Drawable(w)).Reply()
+		if err != nil {
+			continue
+		}
+		border := 2 * int(geom.BorderWidth)
+		bounds := image.Rect(int(geom.X), int(geom.Y), int(geom.X)+int(geom.Width)+border, int(geom.Y)+int(geom.Height)+border)
+		if excluded[w] {
+			if d := bounds.Intersect(target); !d.Empty() {
+				damage = append(damage, d)
+			}
+			continue
+		}
+		layers = append(layers, layer{w, bounds})
+	}
+	if len(damage) == 0 {
+		return nil
+	}
+
+	background := xRootPixmap(c, screen.Root)
+	for _, d := range damage {
+		if background == 0 || xPaint(c, format, img, target, xproto.Drawable(background), image.Point{}, d, false) != nil {
+			xFill(img, d.Sub(target.Min).Add(img.Rect.Min))
+		}
+		for _, l := range layers {
+			r := l.bounds.Intersect(d)
+			if r.Empty() {
+				continue
+			}
+			pix, err := xproto.NewPixmapId(c)
+			if err != nil {
+				return err
+			}
+			if err := composite.NameWindowPixmapChecked(c, l.win, pix).Check(); err != nil {
+				// The window went away or was unmapped since it was listed.
+				continue
+			}
+			err = xPaint(c, format, img, target, xproto.Drawable(pix), l.bounds.Min, r, true)
+			_ = xproto.FreePixmapChecked(c, pix).Check()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// xTopLevel returns the child of root that w is or is inside of.
+func xTopLevel(c *xgb.Conn, root, w xproto.Window) (xproto.Window, bool) {
+	for w != root && w != 0 {
+		tree, err := xproto.QueryTree(c, w).Reply()
+		if err != nil {
+			return 0, false
+		}
+		if tree.Parent == root {
+			return w, true
+		}
+		w = tree.Parent
+	}
+	return 0, false
+}
+
+// xRootPixmap returns the wallpaper pixmap set by the desktop, if any.
+func xRootPixmap(c *xgb.Conn, root xproto.Window) xproto.Pixmap {
+	for _, name := range []string{"_XROOTPMAP_ID", "ESETROOT_PMAP_ID"} {
+		atom, err := xAtom(c, name)
+		if err != nil || atom == xproto.AtomNone {
+			continue
+		}
+		prop, err := xproto.GetProperty(c, false, root, atom, xproto.AtomPixmap, 0, 1).Reply()
+		if err != nil || prop.Format != 32 || prop.ValueLen == 0 {
+			continue
+		}
+		return xproto.Pixmap(xgb.Get32(prop.Value))
+	}
+	return 0
+}
+
+// xPaint draws the area r, in root coordinates, of d, a drawable whose top-left
+// corner is at origin, onto img, a capture of the root area target. Drawables of the
+// root depth are converted with f, 32 bit deep ones are ARGB, blended as
+// premultiplied with blend.
+func xPaint(c *xgb.Conn, f *xFormat, img *image.RGBA, target image.Rectangle, d xproto.Drawable, origin image.Point, r image.Rectangle, blend bool) error {
+	reply, err := xproto.GetImage(c, xproto.ImageFormatZPixmap, d,
+		int16(r.Min.X-origin.X), int16(r.Min.Y-origin.Y),
+		uint16(r.Dx()), uint16(r.Dy()), 0xffffffff).Reply()
+	if err != nil {
+		return err
+	}
+	if reply.Depth == f.depth && f.depth != 32 {
+		stride := f.stride(r.Dx())
+		if len(reply.Data) < stride*r.Dy() {
+			return fmt.Errorf("composite: short image of depth %d", reply.Depth)
+		}
+		for iy := 0; iy < r.Dy(); iy++ {
+			i := img.PixOffset(r.Min.X-target.Min.X+img.Rect.Min.X, r.Min.Y+iy-target.Min.Y+img.Rect.Min.Y)
+			f.toRGBA(img.Pix[i:], reply.Data[iy*stride:], r.Dx())
+		}
+		return nil
+	}
+	if reply.Depth != 32 || len(reply.Data) < 4*r.Dx()*r.Dy() {
+		return fmt.Errorf("composite: unsupported depth %d", reply.Depth)
+	}
+	offset := 0
+	for iy := r.Min.Y; iy < r.Max.Y; iy++ {
+		i := img.PixOffset(r.Min.X-target.Min.X+img.Rect.Min.X, iy-target.Min.Y+img.Rect.Min.Y)
+		for ix := r.Min.X; ix < r.Max.X; ix++ {
+			src := reply.Data[offset : offset+4]
+			if f.msb {
+				src = []byte{src[3], src[2], src[1], src[0]}
+			}
+			dst := img.Pix[i : i+4]
+			if a := uint32(src[3]); blend && a != 0xff {
+				// Premultiplied "over".
+				for k, s := range [3]byte{src[2], src[1], src[0]} {
+					dst[k] = byte(uint32(s) + (uint32(dst[k])*(0xff-a)+0x7f)/0xff)
+				}
+			} else {
+				dst[0], dst[1], dst[2] = src[2], src[1], src[0]
+			}
+			dst[3] = 0xff
+			offset += 4
+			i += 4
+		}
+	}
+	return nil
+}
+
+// xFill paints r of img opaque black.
+func xFill(img *image.RGBA, r image.Rectangle) {
+	for iy := r.Min.Y; iy < r.Max.Y; iy++ {
+		i := img.PixOffset(r.Min.X, iy)
+		for ix := r.Min.X; ix < r.Max.X; ix++ {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = 0, 0, 0, 0xff
+			i += 4
+		}
+	}
+}
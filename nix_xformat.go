@@ -0,0 +1,190 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"fmt"
+	"image/color"
+	"math/bits"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// xFormat is the layout of ZPixmap images of the root window: the pixmap format
+// of the root depth, which gives the bits per pixel and the padding of rows, and
+// the root visual, which gives the meaning of the pixel values.
+type xFormat struct {
+	depth byte
+	bpp   int  // bits per pixel, a multiple of 8
+	pad   int  // rows are padded to a multiple of this many bits
+	msb   bool // pixels are stored most significant byte first
+	// bgrx is set for 32 bit pixels of 8 bit channels with blue in the first byte,
+	// the common case, which is copied without lookups.
+	bgrx bool
+
+	// Channel c of pixel p is lut8[c][(p>>shift[c])&mask[c]] in 8 bits. For
+	// TrueColor and DirectColor visuals the masks select the bits of the channel,
+	// for the others they select the whole pixel value, which indexes a copy of
+	// the colormap.
+	shift [3]int
+	mask  [3]uint32
+	lut8  [3][]uint8
+	lut16 [3][]uint16
+}
+
+// xRootFormat returns the format of images of the root window of the default
+// screen.
+func xRootFormat(c *xgb.Conn) (*xFormat, error) {
+	setup := xproto.Setup(c)
+	screen := setup.DefaultScreen(c)
+	var format xproto.Format
+	for _, pf := range setup.PixmapFormats {
+		if pf.Depth == screen.RootDepth {
+			format = pf
+		}
+	}
+	var visual *xproto.VisualInfo
+	for _, d := range screen.AllowedDepths {
+		for i, v := range d.Visuals {
+			if v.VisualId == screen.RootVisual {
+				visual = &d.Visuals[i]
+			}
+		}
+	}
+	if visual == nil {
+		return nil, fmt.Errorf("root visual %d not found", screen.RootVisual)
+	}
+	var colors []xproto.Rgb
+	if !xMasked(visual) && screen.RootDepth <= xMaxColormapDepth {
+		pixels := make([]uint32, 1<<screen.RootDepth)
+		for i := range pixels {
+			pixels[i] = uint32(i)
+		}
+		reply, err := xproto.QueryColors(c, screen.DefaultColormap, pixels).Reply()
+		if err != nil {
+			return nil, err
+		}
+		colors = reply.Colors
+	}
+	return newXFormat(screen.RootDepth, format, setup.ImageByteOrder == xproto.ImageOrderMSBFirst, visual, colors)
+}
+
+// xMaxColormapDepth is the deepest visual without colour masks that is supported.
+const xMaxColormapDepth = 12
+
+// xMasked reports whether the pixel values of visual hold the channels in the bits
+// of its masks, rather than index a colormap.
+func xMasked(visual *xproto.VisualInfo) bool {
+	return visual.Class == xproto.VisualClassTrueColor || visual.Class == xproto.VisualClassDirectColor
+}
+
+// newXFormat returns the format of images of the given depth, pixmap format and
+// visual. colors is the colormap of visuals without masks.
+func newXFormat(depth byte, format xproto.Format, msb bool, visual *xproto.VisualInfo, colors []xproto.Rgb) (*xFormat, error) {
+	f := &xFormat{depth: depth, bpp: int(format.BitsPerPixel), pad: int(format.ScanlinePad), msb: msb}
+	if format.Depth != depth || f.bpp == 0 || f.bpp%8 != 0 || f.bpp > 32 || f.pad == 0 || f.pad%8 != 0 {
+		return nil, fmt.Errorf("unsupported pixmap format: depth %d, %d bits per pixel", depth, f.bpp)
+	}
+
+	if xMasked(visual) {
+		// The gamma ramps of DirectColor colormaps are ignored.
+		for i, m := range []uint32{visual.RedMask, visual.GreenMask, visual.BlueMask} {
+			if m == 0 {
+				return nil, fmt.Errorf("unsupported visual: no bits for channel %d", i)
+			}
+			f.shift[i] = bits.TrailingZeros32(m)
+			n := bits.OnesCount32(m)
+			if n > 16 {
+				f.shift[i] += n - 16
+				n = 16
+			}
+			f.mask[i] = 1<<n - 1
+			f.lut16[i] = make([]uint16, f.mask[i]+1)
+			for v := range f.lut16[i] {
+				f.lut16[i][v] = uint16((uint32(v)*0xffff + f.mask[i]/2) / f.mask[i])
+			}
+		}
+		f.bgrx = f.bpp == 32 && !msb && visual.RedMask == 0xff0000 && visual.GreenMask == 0xff00 && visual.BlueMask == 0xff
+	} else {
+		n := 1 << depth
+		if depth > xMaxColormapDepth || len(colors) < n {
+			return nil, fmt.Errorf("unsupported visual: %d bit colormap", depth)
+		}
+		for i := range f.lut16 {
+			f.mask[i] = uint32(n - 1)
+			f.lut16[i] = make([]uint16, n)
+		}
+		for v, rgb := range colors[:n] {
+			f.lut16[0][v], f.lut16[1][v], f.lut16[2][v] = rgb.Red, rgb.Green, rgb.Blue
+		}
+	}
+	for i := range f.lut8 {
+		f.lut8[i] = make([]uint8, len(f.lut16[i]))
+		for v, w := range f.lut16[i] {
+			f.lut8[i][v] = uint8((uint32(w)*0xff + 0x7fff) / 0xffff)
+		}
+	}
+	return f, nil
+}
+
+// stride returns the length in bytes of an image row width pixels wide.
+func (f *xFormat) stride(width int) int {
+	return (width*f.bpp + f.pad - 1) / f.pad * f.pad / 8
+}
+
+// pixel returns the x'th pixel value of row.
+func (f *xFormat) pixel(row []byte, x int) uint32 {
+	n := f.bpp / 8
+	b := row[n*x : n*x+n]
+	var p uint32
+	if f.msb {
+		for _, v := range b {
+			p = p<<8 | uint32(v)
+		}
+	} else {
+		for i := n - 1; i >= 0; i-- {
+			p = p<<8 | uint32(b[i])
+		}
+	}
+	return p
+}
+
+// rgba returns the colour of pixel value p.
+func (f *xFormat) rgba(p uint32) color.RGBA {
+	return color.RGBA{
+		f.lut8[0][(p>>f.shift[0])&f.mask[0]],
+		f.lut8[1][(p>>f.shift[1])&f.mask[1]],
+		f.lut8[2][(p>>f.shift[2])&f.mask[2]],
+		0xff,
+	}
+}
+
+// toRGBA converts the first width pixels of src, a row of an image, to the opaque
+// RGBA pixels of dst.
+func (f *xFormat) toRGBA(dst, src []byte, width int) {
+	if f.bgrx {
+		for i := 0; i < 4*width; i += 4 {
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = src[i+2], src[i+1], src[i], 0xff
+		}
+		return
+	}
+	for x := range width {
+		c := f.rgba(f.pixel(src, x))
+		dst[4*x], dst[4*x+1], dst[4*x+2], dst[4*x+3] = c.R, c.G, c.B, 0xff
+	}
+}
+
+// toRGBA64 converts the first width pixels of src, a row of an image, to the
+// opaque RGBA64 pixels of dst, keeping channels deeper than 8 bits.
+func (f *xFormat) toRGBA64(dst, src []byte, width int) {
+	for x := range width {
+		p := f.pixel(src, x)
+		d := dst[8*x : 8*x+8]
+		for i := range 3 {
+			v := f.lut16[i][(p>>f.shift[i])&f.mask[i]]
+			d[2*i], d[2*i+1] = uint8(v>>8), uint8(v)
+		}
+		d[6], d[7] = 0xff, 0xff
+	}
+}
@@ -0,0 +1,140 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"encoding/binary"
+	"image/color"
+	"testing"
+
+	"github.com/jezek/xgb/xproto"
+)
+
+func TestXFormat(t *testing.T) {
+	trueColor := func(r, g, b uint32) *xproto.VisualInfo {
+		return &xproto.VisualInfo{Class: xproto.VisualClassTrueColor, RedMask: r, GreenMask: g, BlueMask: b}
+	}
+	tests := []struct {
+		name   string
+		depth  byte
+		format xproto.Format
+		msb    bool
+		visual *xproto.VisualInfo
+		colors []xproto.Rgb
+		// row holds three pixels: red, a colour of odd channel values, white.
+		row    []byte
+		stride int
+		want   color.RGBA
+		want64 color.RGBA64
+	}{
+		{
+			name: "bgrx", depth: 24, format: xproto.Format{Depth: 24, BitsPerPixel: 32, ScanlinePad: 32},
+			visual: trueColor(0xff0000, 0xff00, 0xff),
+			row:    []byte{0, 0, 0xff, 0, 0x56, 0x34, 0x12, 0, 0xff, 0xff, 0xff, 0},
+			stride: 12, want: color.RGBA{0x12, 0x34, 0x56, 0xff}, want64: color.RGBA64{0x1212, 0x3434, 0x5656, 0xffff},
+		},
+		{
+			name: "rgb565", depth: 16, format: xproto.Format{Depth: 16, BitsPerPixel: 16, ScanlinePad: 32},
+			visual: trueColor(0xf800, 0x7e0, 0x1f),
+			// 0x0821 is red 1, green 1, blue 1.
+			row:    []byte{0x00, 0xf8, 0x21, 0x08, 0xff, 0xff},
+			stride: 8, want: color.RGBA{8, 4, 8, 0xff}, want64: color.RGBA64{0x0842, 0x0410, 0x0842, 0xffff},
+		},
+		{
+			name: "rgb565 msb", depth: 16, format: xproto.Format{Depth: 16, BitsPerPixel: 16, ScanlinePad: 16}, msb: true,
+			visual: trueColor(0xf800, 0x7e0, 0x1f),
+			row:    []byte{0xf8, 0x00, 0x08, 0x21, 0xff, 0xff},
+			stride: 6, want: color.RGBA{8, 4, 8, 0xff}, want64: color.RGBA64{0x0842, 0x0410, 0x0842, 0xffff},
+		},
+		{
+			name: "packed 24 bit", depth: 24, format: xproto.Format{Depth: 24, BitsPerPixel: 24, ScanlinePad: 32},
+			visual: trueColor(0xff0000, 0xff00, 0xff),
+			row:    []byte{0, 0, 0xff, 0x56, 0x34, 0x12, 0xff, 0xff, 0xff},
+			stride: 12, want: color.RGBA{0x12, 0x34, 0x56, 0xff}, want64: color.RGBA64{0x1212, 0x3434, 0x5656, 0xffff},
+		},
+		{
+			name: "30 bit", depth: 30, format: xproto.Format{Depth: 30, BitsPerPixel: 32, ScanlinePad: 32},
+			visual: trueColor(0x3ff00000, 0xffc00, 0x3ff),
+			// Red 513, green 2, blue 1020 of 1023.
+			row:    le32(0x3ff00000, 513<<20|2<<10|1020, 0x3fffffff),
+			stride: 12, want: color.RGBA{128, 0, 254, 0xff}, want64: color.RGBA64{0x8060, 0x0080, 0xff3f, 0xffff},
+		},
+		{
+			name: "colormap", depth: 8, format: xproto.Format{Depth: 8, BitsPerPixel: 8, ScanlinePad: 32},
+			visual: &xproto.VisualInfo{Class: xproto.VisualClassPseudoColor},
+			colors: colormap(map[int]xproto.Rgb{1: {Red: 0xffff}, 7: {Red: 0x1234, Green: 0x5678, Blue: 0x9abc}, 9: {Red: 0xffff, Green: 0xffff, Blue: 0xffff}}),
+			row:    []byte{1, 7, 9},
+			stride: 4, want: color.RGBA{0x12, 0x56, 0x9a, 0xff}, want64: color.RGBA64{0x1234, 0x5678, 0x9abc, 0xffff},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newXFormat(tt.depth, tt.format, tt.msb, tt.visual, tt.colors)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := f.stride(3); s != tt.stride {
+				t.Errorf("stride(3) = %d, want %d", s, tt.stride)
+			}
+			want := []color.RGBA{{0xff, 0, 0, 0xff}, tt.want, {0xff, 0xff, 0xff, 0xff}}
+			dst := make([]byte, 12)
+			f.toRGBA(dst, tt.row, 3)
+			for i, w := range want {
+				if got := (color.RGBA{dst[4*i], dst[4*i+1], dst[4*i+2], dst[4*i+3]}); got != w {
+					t.Errorf("pixel %d = %v, want %v", i, got, w)
+				}
+				if got := f.rgba(f.pixel(tt.row, i)); got != w {
+					t.Errorf("rgba of pixel %d = %v, want %v", i, got, w)
+				}
+			}
+			want64 := []color.RGBA64{{0xffff, 0, 0, 0xffff}, tt.want64, {0xffff, 0xffff, 0xffff, 0xffff}}
+			dst = make([]byte, 24)
+			f.toRGBA64(dst, tt.row, 3)
+			for i, w := range want64 {
+				b := dst[8*i:]
+				got := color.RGBA64{binary.BigEndian.Uint16(b), binary.BigEndian.Uint16(b[2:]), binary.BigEndian.Uint16(b[4:]), binary.BigEndian.Uint16(b[6:])}
+				if got != w {
+					t.Errorf("64 bit pixel %d = %v, want %v", i, got, w)
+				}
+			}
+		})
+	}
+}
+
+func TestXFormatUnsupported(t *testing.T) {
+	rgb := &xproto.VisualInfo{Class: xproto.VisualClassTrueColor, RedMask: 0xff0000, GreenMask: 0xff00, BlueMask: 0xff}
+	tests := []struct {
+		name   string
+		depth  byte
+		format xproto.Format
+		visual *xproto.VisualInfo
+	}{
+		{"4 bits per pixel", 4, xproto.Format{Depth: 4, BitsPerPixel: 4, ScanlinePad: 32}, rgb},
+		{"no pixmap format", 24, xproto.Format{}, rgb},
+		{"no blue mask", 24, xproto.Format{Depth: 24, BitsPerPixel: 32, ScanlinePad: 32},
+			&xproto.VisualInfo{Class: xproto.VisualClassTrueColor, RedMask: 0xff0000, GreenMask: 0xff00}},
+		{"16 bit colormap", 16, xproto.Format{Depth: 16, BitsPerPixel: 16, ScanlinePad: 32},
+			&xproto.VisualInfo{Class: xproto.VisualClassStaticGray}},
+	}
+	for _, tt := range tests {
+		if _, err := newXFormat(tt.depth, tt.format, false, tt.visual, nil); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func le32(values ...uint32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func colormap(entries map[int]xproto.Rgb) []xproto.Rgb {
+	colors := make([]xproto.Rgb, 256)
+	for i, c := range entries {
+		colors[i] = c
+	}
+	return colors
+}
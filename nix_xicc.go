@@ -0,0 +1,46 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"fmt"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// xDisplayProfile returns the ICC profile of the displayIndex'th display, which
+// colour managers following the ICC Profiles in X Specification store in the
+// _ICC_PROFILE property of the root window, and _ICC_PROFILE_n for the Xinerama
+// screen n > 0. It returns nil if there is none.
+func xDisplayProfile(displayIndex int) (data []byte, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			data, e = nil, fmt.Errorf("%v", err)
+		}
+	}()
+	c, err := xgb.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	name := "_ICC_PROFILE"
+	if displayIndex > 0 {
+		name = fmt.Sprintf("_ICC_PROFILE_%d", displayIndex)
+	}
+	atom, err := xAtom(c, name)
+	if err != nil || atom == xproto.AtomNone {
+		return nil, err
+	}
+	root := xproto.Setup(c).DefaultScreen(c).Root
+	// Profiles are usually a few kilobytes, but may hold large lookup tables.
+	prop, err := xproto.GetProperty(c, false, root, atom, xproto.AtomCardinal, 0, 1<<22).Reply()
+	if err != nil {
+		return nil, err
+	}
+	if prop.Format != 8 {
+		return nil, nil
+	}
+	return prop.Value, nil
+}
@@ -0,0 +1,139 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"sync"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xinerama"
+	"github.com/jezek/xgb/xproto"
+)
+
+// xSample holds the connection kept open between calls of ColorsAt.
+var xSample struct {
+	sync.Mutex
+	conn    *xgb.Conn
+	display string
+	root    xproto.Window
+	format  *xFormat
+	// x0, y0 is the origin of the primary display the last time it was queried.
+	x0, y0 int
+}
+
+// xSamplePoints reads the pixels at points, relative to the primary display, with a
+// 1×1 GetImage request each. The requests and a query of the primary display are
+// sent together and their replies awaited at once; in the rare case the primary
+// display moved since the last call, the pixels are read again.
+func xSamplePoints(points []image.Point) ([]color.RGBA, error) {
+	xSample.Lock()
+	defer xSample.Unlock()
+	kept := xSample.conn != nil && xSample.display == os.Getenv("DISPLAY")
+	colors, err := xSampleRead(points)
+	if err != nil && kept {
+		// The server of the kept connection may have gone since, and another one
+		// taken its display, possibly at another depth.
+		colors, err = xSampleRead(points)
+	}
+	return colors, err
+}
+
+// xSampleRead is xSamplePoints on the sampling connection, which it opens if
+// needed and closes after an error.
+func xSampleRead(points []image.Point) (colors []color.RGBA, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			xSampleDrop()
+			colors, e = nil, fmt.Errorf("%v", err)
+		}
+	}()
+	if err := xSampleConnect(); err != nil {
+		return nil, err
+	}
+	c := xSample.conn
+	for {
+		x0, y0 := xSample.x0, xSample.y0
+		screens := xinerama.QueryScreens(c)
+		cookies := make([]xproto.GetImageCookie, len(points))
+		for i, p := range points {
+			x, y := p.X+x0, p.Y+y0
+			if x < 0 || y < 0 || x > 0x7fff || y > 0x7fff {
+				continue
+			}
+			cookies[i] = xproto.GetImage(c, xproto.ImageFormatZPixmap, xproto.Drawable(xSample.root), int16(x), int16(y), 1, 1, 0xffffffff)
+		}
+		reply, err := screens.Reply()
+		if err != nil {
+			xSampleDrop()
+			return nil, err
+		}
+		if reply.Number > 0 {
+			xSample.x0, xSample.y0 = int(reply.ScreenInfo[0].XOrg), int(reply.ScreenInfo[0].YOrg)
+		}
+
+		colors = make([]color.RGBA, len(points))
+		for i, cookie := range cookies {
+			colors[i] = color.RGBA{A: 255}
+			if cookie.Cookie == nil {
+				continue
+			}
+			img, err := cookie.Reply()
+			var match xproto.MatchError
+			switch {
+			case errors.As(err, &match):
+				// Outside the root window.
+			case err != nil:
+				xSampleDrop()
+				return nil, err
+			case len(img.Data) >= xSample.format.bpp/8:
+				colors[i] = xSample.format.rgba(xSample.format.pixel(img.Data, 0))
+			}
+		}
+		if xSample.x0 == x0 && xSample.y0 == y0 {
+			return colors, nil
+		}
+	}
+}
+
+// xSampleConnect opens the sampling connection unless it is open to $DISPLAY
+// already.
+func xSampleConnect() error {
+	display := os.Getenv("DISPLAY")
+	if xSample.conn != nil {
+		if xSample.display == display {
+			return nil
+		}
+		xSampleDrop()
+	}
+	c, err := xgb.NewConn()
+	if err != nil {
+		return err
+	}
+	if err := xinerama.Init(c); err != nil {
+		c.Close()
+		return err
+	}
+	format, err := xRootFormat(c)
+	if err != nil {
+		c.Close()
+		return err
+	}
+	xSample.conn, xSample.display, xSample.format = c, display, format
+	xSample.root = xproto.Setup(c).DefaultScreen(c).Root
+	xSample.x0, xSample.y0 = xPrimaryOrigin(c)
+	return nil
+}
+
+// xSampleDrop closes the sampling connection, so that the next call starts over
+// after an error.
+func xSampleDrop() {
+	if xSample.conn != nil {
+		xSample.conn.Close()
+		xSample.conn = nil
+	}
+}
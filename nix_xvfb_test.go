@@ -0,0 +1,524 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fast-IQ/screenshot/icc"
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// startXvfb starts a private Xvfb server with the given extra arguments and points
+// DISPLAY at it for the duration of the test. The test is skipped when Xvfb is not installed.
+func startXvfb(t *testing.T, args ...string) string {
+	t.Helper()
+	path, err := exec.LookPath("Xvfb")
+	if err != nil {
+		t.Skip("Xvfb not found")
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	cmd := exec.Command(path, append([]string{"-displayfd", "3", "-nolisten", "tcp", "-noreset"}, args...)...)
+	cmd.ExtraFiles = []*os.File{w}
+	if err := cmd.Start(); err != nil {
+		_ = w.Close()
+		t.Fatalf("cannot start Xvfb: %v", err)
+	}
+	_ = w.Close()
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	// Xvfb writes the display number to fd 3 once it accepts connections.
+	ch := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		ch <- strings.TrimSpace(line)
+	}()
+	var display string
+	select {
+	case display = <-ch:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for Xvfb")
+	}
+	if display == "" {
+		t.Fatal("Xvfb exited before reporting its display")
+	}
+
+	display = ":" + display
+	t.Setenv("DISPLAY", display)
+	t.Setenv("XDG_SESSION_TYPE", "x11")
+	return display
+}
+
+// fillRects paints rects on the root window with c, converted for the root visual.
+func fillRects(t *testing.T, display string, c color.RGBA, rects ...image.Rectangle) {
+	t.Helper()
+	fillRects64(t, display, color.RGBA64{uint16(c.R) * 0x101, uint16(c.G) * 0x101, uint16(c.B) * 0x101, 0xffff}, rects...)
+}
+
+// fillRects64 is fillRects with a 16 bit colour, for visuals deeper than 8 bits.
+func fillRects64(t *testing.T, display string, c color.RGBA64, rects ...image.Rectangle) {
+	t.Helper()
+	conn, err := xgb.NewConnDisplay(display)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	screen := xproto.Setup(conn).DefaultScreen(conn)
+	visual := rootVisual(t, conn)
+	gc, err := xproto.NewGcontextId(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pixel := scaleToMask(c.R, visual.RedMask) | scaleToMask(c.G, visual.GreenMask) | scaleToMask(c.B, visual.BlueMask)
+	err = xproto.CreateGCChecked(conn, gc, xproto.Drawable(screen.Root), xproto.GcForeground, []uint32{pixel}).Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer xproto.FreeGC(conn, gc)
+
+	xrects := make([]xproto.Rectangle, len(rects))
+	for i, r := range rects {
+		xrects[i] = xproto.Rectangle{X: int16(r.Min.X), Y: int16(r.Min.Y), Width: uint16(r.Dx()), Height: uint16(r.Dy())}
+	}
+	err = xproto.PolyFillRectangleChecked(conn, xproto.Drawable(screen.Root), gc, xrects).Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func rootVisual(t *testing.T, conn *xgb.Conn) *xproto.VisualInfo {
+	t.Helper()
+	screen := xproto.Setup(conn).DefaultScreen(conn)
+	for _, d := range screen.AllowedDepths {
+		for i, v := range d.Visuals {
+			if v.VisualId == screen.RootVisual {
+				return &d.Visuals[i]
+			}
+		}
+	}
+	t.Fatal("root visual not found")
+	return nil
+}
+
+// scaleToMask scales a 16 bit channel value to the bits selected by mask.
+func scaleToMask(v uint16, mask uint32) uint32 {
+	if mask == 0 {
+		return 0
+	}
+	shift := 0
+	for mask&(1<<shift) == 0 {
+		shift++
+	}
+	max := mask >> shift
+	return ((uint32(v)*max + 0x7fff) / 0xffff) << shift
+}
+
+// quantize returns c as the root visual of display stores it, in 8 bits.
+func quantize(t *testing.T, display string, c color.RGBA) color.RGBA {
+	t.Helper()
+	conn, err := xgb.NewConnDisplay(display)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	visual := rootVisual(t, conn)
+	channel := func(v uint8, mask uint32) uint8 {
+		shift := 0
+		for mask&(1<<shift) == 0 {
+			shift++
+		}
+		max := mask >> shift
+		return uint8((scaleToMask(uint16(v)*0x101, mask)>>shift*0xff + max/2) / max)
+	}
+	return color.RGBA{channel(c.R, visual.RedMask), channel(c.G, visual.GreenMask), channel(c.B, visual.BlueMask), 0xff}
+}
+
+func assertPixel(t *testing.T, img *image.RGBA, x, y int, want color.RGBA) {
+	t.Helper()
+	if got := img.RGBAAt(x, y); got != want {
+		t.Errorf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+	}
+}
+
+var (
+	xvfbRed   = color.RGBA{255, 0, 0, 255}
+	xvfbGreen = color.RGBA{0, 255, 0, 255}
+	xvfbBlue  = color.RGBA{0, 0, 255, 255}
+	xvfbGray  = color.RGBA{0x12, 0x34, 0x56, 255}
+	xvfbBlack = color.RGBA{0, 0, 0, 255}
+)
+
+func testXvfbPattern(t *testing.T, depth int, args ...string) {
+	display := startXvfb(t, append([]string{"-screen", "0", fmt.Sprintf("64x48x%d", depth)}, args...)...)
+	fillRects(t, display, xvfbRed, image.Rect(0, 0, 32, 24))
+	fillRects(t, display, xvfbGreen, image.Rect(32, 0, 64, 24))
+	fillRects(t, display, xvfbBlue, image.Rect(0, 24, 32, 48))
+	fillRects(t, display, xvfbGray, image.Rect(32, 24, 64, 48))
+
+	if n := NumActiveDisplays(); n != 1 {
+		t.Fatalf("NumActiveDisplays() = %d, want 1", n)
+	}
+	bounds, err := GetDisplayBounds(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds != image.Rect(0, 0, 64, 48) {
+		t.Fatalf("GetDisplayBounds(0) = %v", bounds)
+	}
+
+	img, err := CaptureRect(bounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Rect != image.Rect(0, 0, 64, 48) {
+		t.Fatalf("image rect %v", img.Rect)
+	}
+	assertPixel(t, img, 0, 0, xvfbRed)
+	assertPixel(t, img, 31, 23, xvfbRed)
+	assertPixel(t, img, 32, 0, xvfbGreen)
+	assertPixel(t, img, 0, 47, xvfbBlue)
+	assertPixel(t, img, 63, 47, quantize(t, display, xvfbGray))
+
+	// Areas outside the screen are opaque black.
+	img, err = Capture(-8, 40, 16, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPixel(t, img, 0, 0, xvfbBlack)
+	assertPixel(t, img, 15, 15, xvfbBlack)
+	assertPixel(t, img, 8, 0, xvfbBlue)
+	assertPixel(t, img, 15, 7, xvfbBlue)
+
+	// Rows of odd width are padded by the server.
+	img, err = Capture(29, 22, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPixel(t, img, 2, 1, xvfbRed)
+	assertPixel(t, img, 3, 1, xvfbGreen)
+	assertPixel(t, img, 2, 2, xvfbBlue)
+	assertPixel(t, img, 4, 2, quantize(t, display, xvfbGray))
+	if c, err := ColorAt(40, 40); err != nil || c != quantize(t, display, xvfbGray) {
+		t.Errorf("ColorAt(40, 40) = %v, %v", c, err)
+	}
+}
+
+func TestXvfbCaptureShm(t *testing.T) {
+	testXvfbPattern(t, 24)
+}
+
+func TestXvfbCaptureNoShm(t *testing.T) {
+	testXvfbPattern(t, 24, "-extension", "MIT-SHM")
+}
+
+func TestXvfbDepth16(t *testing.T) {
+	testXvfbPattern(t, 16)
+}
+
+func TestXvfbDepth16NoShm(t *testing.T) {
+	testXvfbPattern(t, 16, "-extension", "MIT-SHM")
+}
+
+func TestXvfbDepth30(t *testing.T) {
+	testXvfbPattern(t, 30)
+}
+
+func TestXvfbCaptureRGBA64(t *testing.T) {
+	display := startXvfb(t, "-screen", "0", "64x48x30")
+	// Red 513, green 2 and blue 1020 of 1023, between 8 bit values.
+	deep := color.RGBA64{0x8060, 0x0080, 0xff3f, 0xffff}
+	fillRects64(t, display, deep, image.Rect(0, 0, 64, 48))
+
+	img, err := CaptureRGBA64(-4, 0, 16, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.RGBA64At(4, 0); got != deep {
+		t.Errorf("pixel (4, 0) = %v, want %v", got, deep)
+	}
+	if got := img.RGBA64At(0, 0); got != (color.RGBA64{A: 0xffff}) {
+		t.Errorf("pixel off the screen = %v, want opaque black", got)
+	}
+	img8, err := Capture(0, 0, 16, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPixel(t, img8, 0, 0, color.RGBA{128, 0, 254, 255})
+
+	// With a hook, the 8 bit capture is widened.
+	SetCaptureHook(func(backend CaptureFunc) CaptureFunc { return backend })
+	defer SetCaptureHook(nil)
+	img, err = CaptureRGBA64(0, 0, 16, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.RGBA64At(0, 0), (color.RGBA64{0x8080, 0, 0xfefe, 0xffff}); got != want {
+		t.Errorf("hooked pixel = %v, want %v", got, want)
+	}
+}
+
+func TestXvfbColorsAt(t *testing.T) {
+	display := startXvfb(t, "-screen", "0", "64x48x24")
+	fillRects(t, display, xvfbRed, image.Rect(0, 0, 32, 48))
+	fillRects(t, display, xvfbBlue, image.Rect(32, 0, 64, 48))
+
+	points := []image.Point{{0, 0}, {40, 10}, {63, 47}, {-1, 5}, {64, 0}}
+	want := []color.RGBA{xvfbRed, xvfbBlue, xvfbBlue, xvfbBlack, xvfbBlack}
+	colors, err := ColorsAt(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range colors {
+		if c != want[i] {
+			t.Errorf("ColorsAt %v = %v, want %v", points[i], c, want[i])
+		}
+	}
+
+	// The connection is kept and follows the screen contents.
+	fillRects(t, display, xvfbGreen, image.Rect(0, 0, 8, 8))
+	if c, err := ColorAt(3, 3); err != nil || c != xvfbGreen {
+		t.Errorf("ColorAt(3, 3) = %v, %v, want %v", c, err, xvfbGreen)
+	}
+	if c, err := AverageColor(image.Rect(28, 0, 36, 1)); err != nil || c != (color.RGBA{128, 0, 128, 255}) {
+		t.Errorf("AverageColor = %v, %v", c, err)
+	}
+}
+
+func TestXvfbWatchArea(t *testing.T) {
+	display := startXvfb(t, "-screen", "0", "64x48x24")
+	fillRects(t, display, xvfbRed, image.Rect(0, 0, 64, 48))
+
+	a, err := WatchArea(image.Rect(56, 40, 72, 56))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	damaged := a.Damaged()
+	if damaged == nil {
+		t.Fatal("no damage reported")
+	}
+	img, err := a.Capture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPixel(t, img, 0, 0, xvfbRed)
+	assertPixel(t, img, 8, 8, xvfbBlack)
+
+	// Damage outside the area is not reported, damage inside is.
+	fillRects(t, display, xvfbBlue, image.Rect(0, 0, 8, 8))
+	select {
+	case <-damaged:
+		t.Error("damage outside the area reported")
+	case <-time.After(200 * time.Millisecond):
+	}
+	fillRects(t, display, xvfbGreen, image.Rect(60, 44, 64, 48))
+	select {
+	case <-damaged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("damage inside the area not reported")
+	}
+	if img, err = a.Capture(); err != nil {
+		t.Fatal(err)
+	}
+	assertPixel(t, img, 0, 0, xvfbRed)
+	assertPixel(t, img, 7, 7, xvfbGreen)
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case _, ok := <-damaged:
+		if ok {
+			t.Error("damage reported after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("damage channel not closed by Close")
+	}
+}
+
+func TestXvfbXinerama(t *testing.T) {
+	display := startXvfb(t, "-screen", "0", "64x48x24", "-screen", "1", "32x16x24", "+xinerama")
+	fillRects(t, display, xvfbGreen, image.Rect(0, 0, 64, 48))
+	fillRects(t, display, xvfbRed, image.Rect(64, 0, 96, 16))
+
+	want := []image.Rectangle{image.Rect(0, 0, 64, 48), image.Rect(64, 0, 96, 16)}
+	bounds, err := GetAllDisplayBounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bounds) != len(want) || bounds[0] != want[0] || bounds[1] != want[1] {
+		t.Fatalf("GetAllDisplayBounds() = %v, want %v", bounds, want)
+	}
+
+	displays, err := CaptureAllDisplays()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(displays) != 2 {
+		t.Fatalf("got %d displays, want 2", len(displays))
+	}
+	assertPixel(t, displays[0].Image, 63, 47, xvfbGreen)
+	assertPixel(t, displays[1].Image, 0, 0, xvfbRed)
+	assertPixel(t, displays[1].Image, 31, 15, xvfbRed)
+}
+
+func TestXvfbColorSpace(t *testing.T) {
+	display := startXvfb(t, "-screen", "0", "64x48x24", "-screen", "1", "32x16x24", "+xinerama")
+	fillRects(t, display, xvfbRed, image.Rect(0, 0, 96, 48))
+
+	if p, err := DisplayColorSpace(0); err != nil || p != icc.SRGB {
+		t.Errorf("DisplayColorSpace(0) = %v, %v, want sRGB", p, err)
+	}
+	displays, err := CaptureAllDisplaysWith(NewCapturer(CaptureOptions{ColorSpace: icc.DisplayP3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if displays[1].ColorSpace != icc.DisplayP3 {
+		t.Errorf("ColorSpace = %v, want Display P3", displays[1].ColorSpace)
+	}
+	assertPixel(t, displays[0].Image, 10, 10, color.RGBA{234, 51, 35, 255})
+	assertPixel(t, displays[1].Image, 10, 10, color.RGBA{234, 51, 35, 255})
+
+	conn, err := xgb.NewConnDisplay(display)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	atom, err := xproto.InternAtom(conn, false, uint16(len("_ICC_PROFILE_1")), "_ICC_PROFILE_1").Reply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := xproto.Setup(conn).DefaultScreen(conn).Root
+	junk := []byte("not a profile")
+	if err := xproto.ChangePropertyChecked(conn, xproto.PropModeReplace, root, atom.Atom, xproto.AtomCardinal, 8, uint32(len(junk)), junk).Check(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DisplayColorSpace(1); err == nil {
+		t.Error("DisplayColorSpace(1) accepted a malformed profile")
+	}
+	// Only the second display is affected.
+	if _, err := CaptureWithOptions(0, 0, 64, 48, CaptureOptions{ColorSpace: icc.DisplayP3}); err != nil {
+		t.Error(err)
+	}
+	if _, err := CaptureWithOptions(0, 0, 96, 48, CaptureOptions{ColorSpace: icc.DisplayP3}); err == nil {
+		t.Error("capture converted from a malformed profile")
+	}
+}
+
+// createWindow maps a window filled with c, the background pixel of the root visual,
+// on top of the others.
+func createWindow(t *testing.T, conn *xgb.Conn, r image.Rectangle, c color.RGBA) xproto.Window {
+	t.Helper()
+	screen := xproto.Setup(conn).DefaultScreen(conn)
+	id, err := xproto.NewWindowId(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The test screens are 24 bit TrueColor with the usual masks.
+	pixel := uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+	err = xproto.CreateWindowChecked(conn, screen.RootDepth, id, screen.Root,
+		int16(r.Min.X), int16(r.Min.Y), uint16(r.Dx()), uint16(r.Dy()), 0,
+		xproto.WindowClassInputOutput, screen.RootVisual,
+		xproto.CwBackPixel|xproto.CwOverrideRedirect, []uint32{pixel, 1}).Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xproto.MapWindowChecked(conn, id).Check(); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestXvfbExcludeWindows(t *testing.T) {
+	display := startXvfb(t, "-screen", "0", "64x48x24", "+extension", "Composite")
+	conn, err := xgb.NewConnDisplay(display)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	screen := xproto.Setup(conn).DefaultScreen(conn)
+
+	// A red wallpaper pixmap, as desktops publish it.
+	pix, err := xproto.NewPixmapId(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xproto.CreatePixmapChecked(conn, screen.RootDepth, pix, xproto.Drawable(screen.Root), 64, 48).Check(); err != nil {
+		t.Fatal(err)
+	}
+	gc, err := xproto.NewGcontextId(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xproto.CreateGCChecked(conn, gc, xproto.Drawable(pix), xproto.GcForeground, []uint32{0xff0000}).Check(); err != nil {
+		t.Fatal(err)
+	}
+	if err := xproto.PolyFillRectangleChecked(conn, xproto.Drawable(pix), gc, []xproto.Rectangle{{Width: 64, Height: 48}}).Check(); err != nil {
+		t.Fatal(err)
+	}
+	atom, err := xproto.InternAtom(conn, false, uint16(len("_XROOTPMAP_ID")), "_XROOTPMAP_ID").Reply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, 4)
+	xgb.Put32(value, uint32(pix))
+	if err := xproto.ChangePropertyChecked(conn, xproto.PropModeReplace, screen.Root, atom.Atom, xproto.AtomPixmap, 32, 1, value).Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	createWindow(t, conn, image.Rect(0, 0, 32, 48), xvfbGreen)
+	indicator := createWindow(t, conn, image.Rect(16, 8, 48, 24), xvfbBlue)
+	createWindow(t, conn, image.Rect(40, 12, 56, 20), xvfbGray)
+
+	img, err := CaptureRect(image.Rect(0, 0, 64, 48))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPixel(t, img, 20, 10, xvfbBlue)
+	assertPixel(t, img, 36, 10, xvfbBlue)
+
+	opts := CaptureOptions{ExcludeWindows: []WindowID{WindowID(indicator)}}
+	img, err = CaptureWithOptions(0, 0, 64, 48, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPixel(t, img, 20, 10, xvfbGreen) // the window below
+	assertPixel(t, img, 36, 10, xvfbRed)   // the wallpaper
+	assertPixel(t, img, 44, 14, xvfbGray)  // the window above
+	assertPixel(t, img, 47, 23, xvfbRed)
+	assertPixel(t, img, 10, 30, xvfbGreen)
+
+	// Region captures and capturers map the window to their own coordinates.
+	frames := 0
+	err = Stream(context.Background(), StreamOptions{Rect: image.Rect(16, 8, 24, 16), Capturer: NewCapturer(opts)}, func(f Frame) error {
+		assertPixel(t, f.Image, 0, 0, xvfbGreen)
+		frames++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || frames != 1 {
+		t.Fatalf("Stream returned %v after %d frames", err, frames)
+	}
+}
+
+var errStop = errors.New("stop")
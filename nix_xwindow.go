@@ -10,31 +10,88 @@ import (
 	"github.com/jezek/xgb/xinerama"
 	"github.com/jezek/xgb/xproto"
 	"image"
-	"image/color"
 )
 
-func captureXinerama(x, y, width, height int) (img *image.RGBA, e error) {
+func captureXinerama(x, y, width, height int) (*image.RGBA, error) {
+	img, err := createImage(image.Rect(0, 0, width, height))
+	if err != nil {
+		return nil, err
+	}
+
+	// Paint with opaque black
+	index := 0
+	for iy := 0; iy < height; iy++ {
+		j := index
+		for ix := 0; ix < width; ix++ {
+			img.Pix[j+3] = 255
+			j += 4
+		}
+		index += img.Stride
+	}
+
+	err = xCapture(x, y, width, height, func(f *xFormat, data []byte, r image.Rectangle) {
+		stride := f.stride(r.Dx())
+		for iy := 0; iy < r.Dy(); iy++ {
+			f.toRGBA(img.Pix[img.PixOffset(r.Min.X, r.Min.Y+iy):], data[iy*stride:], r.Dx())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// captureXDeep is captureXinerama keeping the precision of visuals with more than
+// 8 bits per channel.
+func captureXDeep(x, y, width, height int) (img *image.RGBA64, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			img, e = nil, fmt.Errorf("%v", err)
+		}
+	}()
+	// image.NewRGBA64 may panic if the rectangle is too large.
+	img = image.NewRGBA64(image.Rect(0, 0, width, height))
+	for i := 6; i < len(img.Pix); i += 8 {
+		img.Pix[i], img.Pix[i+1] = 0xff, 0xff
+	}
+
+	err := xCapture(x, y, width, height, func(f *xFormat, data []byte, r image.Rectangle) {
+		stride := f.stride(r.Dx())
+		for iy := 0; iy < r.Dy(); iy++ {
+			f.toRGBA64(img.Pix[img.PixOffset(r.Min.X, r.Min.Y+iy):], data[iy*stride:], r.Dx())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// xCapture reads the part on the screen of the area at (x, y), relative to the
+// primary display, through shared memory if the server has the MIT-SHM extension,
+// and passes the ZPixmap data to paint, with its format and its place r in the
+// area.
+func xCapture(x, y, width, height int, paint func(f *xFormat, data []byte, r image.Rectangle)) (e error) {
 	defer func() {
 		err := recover()
 		if err != nil {
-			img = nil
 			e = fmt.Errorf("%v", err)
 		}
 	}()
 	c, err := xgb.NewConn()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer c.Close()
 
 	err = xinerama.Init(c)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	reply, err := xinerama.QueryScreens(c).Reply()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	primary := reply.ScreenInfo[0]
@@ -51,84 +108,62 @@ func captureXinerama(x, y, width, height int) (img *image.RGBA, e error) {
 	wholeScreenBounds := image.Rect(0, 0, int(screen.WidthInPixels), int(screen.HeightInPixels))
 	targetBounds := image.Rect(x+x0, y+y0, x+x0+width, y+y0+height)
 	intersect := wholeScreenBounds.Intersect(targetBounds)
+	if intersect.Empty() {
+		return nil
+	}
 
-	rect := image.Rect(0, 0, width, height)
-	img, err = createImage(rect)
+	format, err := xRootFormat(c)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Paint with opaque black
-	index := 0
-	for iy := 0; iy < height; iy++ {
-		j := index
-		for ix := 0; ix < width; ix++ {
-			img.Pix[j+3] = 255
-			j += 4
+	var data []byte
+
+	if useShm {
+		shmSize := format.stride(intersect.Dx()) * intersect.Dy()
+		shmId, err := shm.Get(shm.IPC_PRIVATE, shmSize, shm.IPC_CREAT|0777)
+		if err != nil {
+			return err
+		}
+
+		seg, err := mshm.NewSegId(c)
+		if err != nil {
+			return err
 		}
-		index += img.Stride
-	}
 
-	if !intersect.Empty() {
-		var data []byte
-
-		if useShm {
-			shmSize := intersect.Dx() * intersect.Dy() * 4
-			shmId, err := shm.Get(shm.IPC_PRIVATE, shmSize, shm.IPC_CREAT|0777)
-			if err != nil {
-				return nil, err
-			}
-
-			seg, err := mshm.NewSegId(c)
-			if err != nil {
-				return nil, err
-			}
-
-			data, err = shm.At(shmId, 0, 0)
-			if err != nil {
-				return nil, err
-			}
-
-			mshm.Attach(c, seg, uint32(shmId), false)
-
-			defer mshm.Detach(c, seg)
-			defer func() {
-				_ = shm.Rm(shmId)
-			}()
-			defer func() {
-				_ = shm.Dt(data)
-			}()
-
-			_, err = mshm.GetImage(c, xproto.Drawable(screen.Root),
-				int16(intersect.Min.X), int16(intersect.Min.Y),
-				uint16(intersect.Dx()), uint16(intersect.Dy()), 0xffffffff,
-				byte(xproto.ImageFormatZPixmap), seg, 0).Reply()
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			xImg, err := xproto.GetImage(c, xproto.ImageFormatZPixmap, xproto.Drawable(screen.Root),
-				int16(intersect.Min.X), int16(intersect.Min.Y),
-				uint16(intersect.Dx()), uint16(intersect.Dy()), 0xffffffff).Reply()
-			if err != nil {
-				return nil, err
-			}
-
-			data = xImg.Data
+		data, err = shm.At(shmId, 0, 0)
+		if err != nil {
+			return err
 		}
 
-		// BitBlt by hand
-		offset := 0
-		for iy := intersect.Min.Y; iy < intersect.Max.Y; iy++ {
-			for ix := intersect.Min.X; ix < intersect.Max.X; ix++ {
-				r := data[offset+2]
-				g := data[offset+1]
-				b := data[offset]
-				img.SetRGBA(ix-(x+x0), iy-(y+y0), color.RGBA{r, g, b, 255})
-				offset += 4
-			}
+		mshm.Attach(c, seg, uint32(shmId), false)
+
+		defer mshm.Detach(c, seg)
+		defer func() {
+			_ = shm.Rm(shmId)
+		}()
+		defer func() {
+			_ = shm.Dt(data)
+		}()
+
+		_, err = mshm.GetImage(c, xproto.Drawable(screen.Root),
+			int16(intersect.Min.X), int16(intersect.Min.Y),
+			uint16(intersect.Dx()), uint16(intersect.Dy()), 0xffffffff,
+			byte(xproto.ImageFormatZPixmap), seg, 0).Reply()
+		if err != nil {
+			return err
 		}
+	} else {
+		xImg, err := xproto.GetImage(c, xproto.ImageFormatZPixmap, xproto.Drawable(screen.Root),
+			int16(intersect.Min.X), int16(intersect.Min.Y),
+			uint16(intersect.Dx()), uint16(intersect.Dy()), 0xffffffff).Reply()
+		if err != nil {
+			return err
+		}
+
+		data = xImg.Data
 	}
 
-	return img, e
+	paint(format, data, intersect.Sub(targetBounds.Min))
+	return nil
 }
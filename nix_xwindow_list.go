@@ -0,0 +1,144 @@
+//go:build !s390x && !ppc64le && !darwin && !windows && (linux || freebsd || openbsd || netbsd)
+
+package screenshot
+
+import (
+	"fmt"
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xinerama"
+	"github.com/jezek/xgb/xproto"
+	"image"
+	"os"
+	"strings"
+)
+
+// ListWindows returns the visible top-level windows in stacking order, bottom-most first.
+// Window listing is not available on Wayland.
+func ListWindows() (windows []Window, e error) {
+	if os.Getenv("XDG_SESSION_TYPE") == "wayland" {
+		return nil, ErrUnsupported
+	}
+	defer func() {
+		err := recover()
+		if err != nil {
+			windows = nil
+			e = fmt.Errorf("%v", err)
+		}
+	}()
+
+	c, err := xgb.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	return listXWindows(c)
+}
+
+func listXWindows(c *xgb.Conn) ([]Window, error) {
+	root := xproto.Setup(c).DefaultScreen(c).Root
+	x0, y0 := xPrimaryOrigin(c)
+
+	ids, err := xClientList(c, root)
+	if err != nil {
+		return nil, err
+	}
+
+	windows := make([]Window, 0, len(ids))
+	for _, id := range ids {
+		attrs, err := xproto.GetWindowAttributes(c, id).Reply()
+		if err != nil || attrs.MapState != xproto.MapStateViewable {
+			continue
+		}
+		geom, err := xproto.GetGeometry(c, xproto.Drawable(id)).Reply()
+		if err != nil {
+			continue
+		}
+		pos, err := xproto.TranslateCoordinates(c, id, root, 0, 0).Reply()
+		if err != nil {
+			continue
+		}
+		x := int(pos.DstX) - x0
+		y := int(pos.DstY) - y0
+
+		title := xStringProperty(c, id, "_NET_WM_NAME")
+		if title == "" {
+			title = xStringProperty(c, id, "WM_NAME")
+		}
+		windows = append(windows, Window{
+			ID:     WindowID(id),
+			Title:  title,
+			Class:  xWindowClass(c, id),
+			Bounds: image.Rect(x, y, x+int(geom.Width), y+int(geom.Height)),
+		})
+	}
+	return windows, nil
+}
+
+// xPrimaryOrigin returns the root window position of the primary display, the origin
+// of desktop coordinates.
+func xPrimaryOrigin(c *xgb.Conn) (x0, y0 int) {
+	if xinerama.Init(c) == nil {
+		reply, err := xinerama.QueryScreens(c).Reply()
+		if err == nil && reply.Number > 0 {
+			return int(reply.ScreenInfo[0].XOrg), int(reply.ScreenInfo[0].YOrg)
+		}
+	}
+	return 0, 0
+}
+
+// xClientList returns the managed windows as reported by the window manager,
+// or the children of root when no EWMH window manager is running.
+func xClientList(c *xgb.Conn, root xproto.Window) ([]xproto.Window, error) {
+	for _, name := range []string{"_NET_CLIENT_LIST_STACKING", "_NET_CLIENT_LIST"} {
+		atom, err := xAtom(c, name)
+		if err != nil || atom == xproto.AtomNone {
+			continue
+		}
+		prop, err := xproto.GetProperty(c, false, root, atom, xproto.AtomWindow, 0, 1<<16).Reply()
+		if err != nil || prop.Format != 32 || prop.ValueLen == 0 {
+			continue
+		}
+		ids := make([]xproto.Window, prop.ValueLen)
+		for i := range ids {
+			ids[i] = xproto.Window(xgb.Get32(prop.Value[i*4:]))
+		}
+		return ids, nil
+	}
+
+	tree, err := xproto.QueryTree(c, root).Reply()
+	if err != nil {
+		return nil, err
+	}
+	return tree.Children, nil
+}
+
+func xAtom(c *xgb.Conn, name string) (xproto.Atom, error) {
+	reply, err := xproto.InternAtom(c, true, uint16(len(name)), name).Reply()
+	if err != nil {
+		return xproto.AtomNone, err
+	}
+	return reply.Atom, nil
+}
+
+func xStringProperty(c *xgb.Conn, w xproto.Window, name string) string {
+	atom, err := xAtom(c, name)
+	if err != nil || atom == xproto.AtomNone {
+		return ""
+	}
+	prop, err := xproto.GetProperty(c, false, w, atom, xproto.GetPropertyTypeAny, 0, 1<<16).Reply()
+	if err != nil || prop.Format != 8 {
+		return ""
+	}
+	return string(prop.Value)
+}
+
+// xWindowClass returns the class part of WM_CLASS, which holds "instance\0class\0".
+func xWindowClass(c *xgb.Conn, w xproto.Window) string {
+	prop, err := xproto.GetProperty(c, false, w, xproto.AtomWmClass, xproto.AtomString, 0, 1<<16).Reply()
+	if err != nil || prop.Format != 8 {
+		return ""
+	}
+	parts := strings.Split(strings.TrimRight(string(prop.Value), "\x00"), "\x00")
+	return parts[len(parts)-1]
+}
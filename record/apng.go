@@ -0,0 +1,149 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"io"
+	"time"
+
+	"github.com/Fast-IQ/screenshot/encode"
+)
+
+// APNG chunk sizes without data: length, type and CRC.
+const (
+	chunkOverhead = 12
+	fcTLSize      = chunkOverhead + 26
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// apngWriter buffers the frames in memory because acTL, which precedes them, holds
+// the frame count.
+type apngWriter struct {
+	size    image.Point
+	encoded int
+	frames  []apngFrame
+}
+
+type apngFrame struct {
+	encodedFrame
+	delay [2]uint16 // numerator and denominator in seconds
+}
+
+func (a *apngWriter) overhead() int {
+	// Signature, IHDR, acTL and IEND.
+	return len(pngSignature) + chunkOverhead + 13 + chunkOverhead + 8 + chunkOverhead
+}
+
+func (a *apngWriter) encode(img, prev *image.RGBA, rect image.Rectangle) (encodedFrame, error) {
+	if prev == nil {
+		a.size = img.Rect.Size()
+	}
+	a.encoded++
+	var buf bytes.Buffer
+	sub := img.SubImage(rect).(*image.RGBA)
+	if err := encode.PNG(&buf, sub, &encode.PNGOptions{Speed: encode.PNGFast}); err != nil {
+		return encodedFrame{}, err
+	}
+	data, err := pngData(buf.Bytes())
+	if err != nil {
+		return encodedFrame{}, err
+	}
+	return encodedFrame{rect: rect, data: data}, nil
+}
+
+func (a *apngWriter) frameSize(f encodedFrame) int {
+	n := fcTLSize + chunkOverhead + len(f.data)
+	if a.encoded > 1 {
+		n += 4 // fdAT sequence number
+	}
+	return n
+}
+
+func (a *apngWriter) write(w io.Writer, f *encodedFrame, from, to time.Duration) error {
+	num, den := units(from, to, time.Millisecond), 1000
+	if num > 0xffff {
+		num, den = min(units(from, to, 10*time.Millisecond), 0xffff), 100
+	}
+	a.frames = append(a.frames, apngFrame{*f, [2]uint16{uint16(num), uint16(den)}})
+	return nil
+}
+
+func (a *apngWriter) end(from, to time.Duration) time.Duration {
+	return to
+}
+
+func (a *apngWriter) close(w io.Writer) error {
+	var b []byte
+	b = append(b, pngSignature...)
+	ihdr := binary.BigEndian.AppendUint32(nil, uint32(a.size.X))
+	ihdr = binary.BigEndian.AppendUint32(ihdr, uint32(a.size.Y))
+	ihdr = append(ihdr, 8, 2, 0, 0, 0) // 8-bit RGB, deflate, adaptive filters, no interlace
+	b = appendChunk(b, "IHDR", ihdr)
+	actl := binary.BigEndian.AppendUint32(nil, uint32(len(a.frames)))
+	actl = binary.BigEndian.AppendUint32(actl, 0) // loop forever
+	b = appendChunk(b, "acTL", actl)
+
+	seq := uint32(0)
+	for i, f := range a.frames {
+		fctl := binary.BigEndian.AppendUint32(nil, seq)
+		fctl = binary.BigEndian.AppendUint32(fctl, uint32(f.rect.Dx()))
+		fctl = binary.BigEndian.AppendUint32(fctl, uint32(f.rect.Dy()))
+		fctl = binary.BigEndian.AppendUint32(fctl, uint32(f.rect.Min.X))
+		fctl = binary.BigEndian.AppendUint32(fctl, uint32(f.rect.Min.Y))
+		fctl = binary.BigEndian.AppendUint16(fctl, f.delay[0])
+		fctl = binary.BigEndian.AppendUint16(fctl, f.delay[1])
+		fctl = append(fctl, 0, 0) // dispose none, blend source
+		b = appendChunk(b, "fcTL", fctl)
+		seq++
+		if i == 0 {
+			b = appendChunk(b, "IDAT", f.data)
+			continue
+		}
+		fdat := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(f.data)), seq)
+		b = appendChunk(b, "fdAT", append(fdat, f.data...))
+		seq++
+	}
+	b = appendChunk(b, "IEND", nil)
+	_, err := w.Write(b)
+	return err
+}
+
+func appendChunk(b []byte, typ string, data []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	start := len(b)
+	b = append(b, typ...)
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[start:]))
+}
+
+// pngData returns the concatenated IDAT data of an 8-bit RGB PNG.
+func pngData(p []byte) ([]byte, error) {
+	if !bytes.HasPrefix(p, pngSignature) {
+		return nil, errors.New("record: invalid PNG")
+	}
+	p = p[len(pngSignature):]
+	var data []byte
+	for len(p) >= chunkOverhead {
+		n := int(binary.BigEndian.Uint32(p))
+		if n > len(p)-chunkOverhead {
+			break
+		}
+		typ, body := string(p[4:8]), p[8:8+n]
+		switch typ {
+		case "IHDR":
+			if n != 13 || body[8] != 8 || body[9] != 2 {
+				return nil, errors.New("record: frame is not an 8-bit RGB PNG")
+			}
+		case "IDAT":
+			data = append(data, body...)
+		case "IEND":
+			return data, nil
+		}
+		p = p[chunkOverhead+n:]
+	}
+	return nil, errors.New("record: truncated PNG")
+}
@@ -0,0 +1,311 @@
+package record
+
+import (
+	"compress/lzw"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"slices"
+	"time"
+)
+
+// centisecond is the unit of GIF frame delays.
+const centisecond = 10 * time.Millisecond
+
+// minGIFDelay is the shortest delay browsers honour; they show shorter ones as 10
+// centiseconds.
+const minGIFDelay = 2
+
+// transparent is the palette index of pixels that did not change since the last frame.
+const transparent = 255
+
+// gifWriter streams a GIF89a with one global palette.
+type gifWriter struct {
+	dither  bool
+	size    image.Point
+	q       *quantizer
+	written int           // frames written
+	shown   time.Duration // end of the frames written, in whole centiseconds
+}
+
+func (g *gifWriter) overhead() int {
+	// Header, logical screen descriptor, global colour table, loop extension and trailer.
+	return 6 + 7 + 3*256 + 19 + 1
+}
+
+func (g *gifWriter) encode(img, prev *image.RGBA, rect image.Rectangle) (encodedFrame, error) {
+	if prev == nil {
+		g.size = img.Rect.Size()
+		if g.size.X > 0xffff || g.size.Y > 0xffff {
+			return encodedFrame{}, fmt.Errorf("record: %v is too large for GIF", g.size)
+		}
+		g.q = newQuantizer(img)
+	}
+	pix := g.q.paletted(img, prev, rect, g.dither)
+	bw := &blockWriter{b: []byte{8}} // LZW minimum code size
+	lw := lzw.NewWriter(bw, lzw.LSB, 8)
+	if _, err := lw.Write(pix); err != nil {
+		return encodedFrame{}, err
+	}
+	if err := lw.Close(); err != nil {
+		return encodedFrame{}, err
+	}
+	bw.flush()
+	return encodedFrame{rect: rect, data: append(bw.b, 0)}, nil
+}
+
+func (g *gifWriter) frameSize(f encodedFrame) int {
+	// Graphic control extension and image descriptor.
+	return 8 + 10 + len(f.data)
+}
+
+func (g *gifWriter) write(w io.Writer, f *encodedFrame, from, to time.Duration) error {
+	var b []byte
+	if g.written == 0 {
+		b = append(b, "GIF89a"...)
+		b = binary.LittleEndian.AppendUint16(b, uint16(g.size.X))
+		b = binary.LittleEndian.AppendUint16(b, uint16(g.size.Y))
+		b = append(b, 0xf7, 0, 0) // global table of 256 colours, background 0, square pixels
+		for _, c := range g.q.palette {
+			b = append(b, c[0], c[1], c[2])
+		}
+		for range 256 - len(g.q.palette) {
+			b = append(b, 0, 0, 0)
+		}
+		b = append(b, 0x21, 0xff, 11)
+		b = append(b, "NETSCAPE2.0"...)
+		b = append(b, 3, 1, 0, 0, 0) // loop forever
+	}
+
+	// A frame raised to the minimum delay pushes back the next one, so that the clip
+	// stays as long as the capture.
+	from = g.start(from)
+	delay := min(max(units(from, to, centisecond), minGIFDelay), 0xffff)
+	g.shown = from + time.Duration(delay)*centisecond
+	flags := byte(1 << 2) // do not dispose
+	if g.written > 0 {
+		flags |= 1
+	}
+	b = append(b, 0x21, 0xf9, 4, flags)
+	b = binary.LittleEndian.AppendUint16(b, uint16(delay))
+	b = append(b, transparent, 0)
+
+	b = append(b, 0x2c)
+	for _, v := range []int{f.rect.Min.X, f.rect.Min.Y, f.rect.Dx(), f.rect.Dy()} {
+		b = binary.LittleEndian.AppendUint16(b, uint16(v))
+	}
+	b = append(b, 0) // no local colour table
+	b = append(b, f.data...)
+	g.written++
+	_, err := w.Write(b)
+	return err
+}
+
+// start returns when the next frame written, captured at from, is shown.
+func (g *gifWriter) start(from time.Duration) time.Duration {
+	return max(from.Round(centisecond), g.shown)
+}
+
+func (g *gifWriter) end(from, to time.Duration) time.Duration {
+	next := max(to.Round(centisecond), g.start(from)+minGIFDelay*centisecond)
+	return next + minGIFDelay*centisecond
+}
+
+func (g *gifWriter) close(w io.Writer) error {
+	_, err := w.Write([]byte{0x3b})
+	return err
+}
+
+// blockWriter splits data into GIF sub-blocks of up to 255 bytes.
+type blockWriter struct {
+	b     []byte
+	block []byte
+}
+
+func (w *blockWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := min(255-len(w.block), len(p))
+		w.block = append(w.block, p[:k]...)
+		p = p[k:]
+		if len(w.block) == 255 {
+			w.flush()
+		}
+	}
+	return n, nil
+}
+
+func (w *blockWriter) flush() {
+	if len(w.block) > 0 {
+		w.b = append(w.b, byte(len(w.block)))
+		w.b = append(w.b, w.block...)
+		w.block = w.block[:0]
+	}
+}
+
+// quantizer maps colours to a palette of at most 255 colours chosen by median cut.
+// Colours are looked up at 5 bits per channel.
+type quantizer struct {
+	palette [][3]byte
+	lut     []int16 // palette index by colour key, -1 until first used
+}
+
+func colorKey(r, g, b int32) int {
+	return int(r>>3)<<10 | int(g>>3)<<5 | int(b>>3)
+}
+
+type histEntry struct {
+	key   int
+	count int
+	sum   [3]int
+}
+
+func (e histEntry) channel(c int) int {
+	return e.key >> (10 - 5*c) & 31
+}
+
+func newQuantizer(img *image.RGBA) *quantizer {
+	hist := make([]histEntry, 1<<15)
+	for y := 0; y < img.Rect.Dy(); y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+4*img.Rect.Dx()]
+		for i := 0; i < len(row); i += 4 {
+			e := &hist[colorKey(int32(row[i]), int32(row[i+1]), int32(row[i+2]))]
+			e.count++
+			e.sum[0] += int(row[i])
+			e.sum[1] += int(row[i+1])
+			e.sum[2] += int(row[i+2])
+		}
+	}
+	var entries []histEntry
+	for key, e := range hist {
+		if e.count > 0 {
+			e.key = key
+			entries = append(entries, e)
+		}
+	}
+
+	// Split the box with the most pixels times its widest channel range until there
+	// are enough boxes or every box holds one colour.
+	boxes := [][]histEntry{entries}
+	for len(boxes) < transparent {
+		best, bestScore, bestChannel := -1, 0, 0
+		for i, box := range boxes {
+			count, lo, hi := 0, [3]int{31, 31, 31}, [3]int{}
+			for _, e := range box {
+				count += e.count
+				for c := range 3 {
+					lo[c] = min(lo[c], e.channel(c))
+					hi[c] = max(hi[c], e.channel(c))
+				}
+			}
+			for c := range 3 {
+				if score := count * (hi[c] - lo[c]); score > bestScore {
+					best, bestScore, bestChannel = i, score, c
+				}
+			}
+		}
+		if best < 0 {
+			break
+		}
+		box := boxes[best]
+		slices.SortFunc(box, func(a, b histEntry) int { return a.channel(bestChannel) - b.channel(bestChannel) })
+		total := 0
+		for _, e := range box {
+			total += e.count
+		}
+		split, seen := 1, box[0].count
+		for split < len(box)-1 && seen+box[split].count <= total/2 {
+			seen += box[split].count
+			split++
+		}
+		boxes[best] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	q := &quantizer{lut: make([]int16, 1<<15)}
+	for i := range q.lut {
+		q.lut[i] = -1
+	}
+	for _, box := range boxes {
+		var count int
+		var sum [3]int
+		for _, e := range box {
+			count += e.count
+			for c := range 3 {
+				sum[c] += e.sum[c]
+			}
+		}
+		var p [3]byte
+		for c := range 3 {
+			p[c] = byte((sum[c] + count/2) / count)
+		}
+		q.palette = append(q.palette, p)
+	}
+	return q
+}
+
+// index returns the palette entry nearest to the centre of the cell holding r, g, b.
+func (q *quantizer) index(r, g, b int32) byte {
+	key := colorKey(r, g, b)
+	if i := q.lut[key]; i >= 0 {
+		return byte(i)
+	}
+	cr, cg, cb := r&^7|4, g&^7|4, b&^7|4
+	best, bestDist := 0, int32(1<<30)
+	for i, p := range q.palette {
+		dr, dg, db := cr-int32(p[0]), cg-int32(p[1]), cb-int32(p[2])
+		if d := dr*dr + dg*dg + db*db; d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	q.lut[key] = int16(best)
+	return byte(best)
+}
+
+// paletted maps rect of img to palette indices, with Floyd-Steinberg dithering if
+// dither is set. Pixels equal to those in prev become transparent.
+func (q *quantizer) paletted(img, prev *image.RGBA, rect image.Rectangle, dither bool) []byte {
+	w, h := rect.Dx(), rect.Dy()
+	out := make([]byte, w*h)
+	// Quantization errors in sixteenths for this row and the next, padded by one
+	// pixel on both sides.
+	cur, next := make([]int32, 3*(w+2)), make([]int32, 3*(w+2))
+	for y := 0; y < h; y++ {
+		row := img.Pix[img.PixOffset(rect.Min.X, rect.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			var c [3]int32
+			for k := range 3 {
+				c[k] = int32(row[4*x+k])
+				if dither {
+					c[k] = min(max(c[k]+(cur[3*(x+1)+k]+8)>>4, 0), 255)
+				}
+			}
+			i := q.index(c[0], c[1], c[2])
+			out[y*w+x] = i
+			if !dither {
+				continue
+			}
+			for k := range 3 {
+				e := c[k] - int32(q.palette[i][k])
+				cur[3*(x+2)+k] += 7 * e
+				next[3*x+k] += 3 * e
+				next[3*(x+1)+k] += 5 * e
+				next[3*(x+2)+k] += e
+			}
+		}
+		cur, next = next, cur
+		clear(next)
+	}
+	if prev != nil {
+		for y := 0; y < h; y++ {
+			i := img.PixOffset(rect.Min.X, rect.Min.Y+y)
+			for x := 0; x < w; x++ {
+				if [4]byte(img.Pix[i+4*x:]) == [4]byte(prev.Pix[i+4*x:]) {
+					out[y*w+x] = transparent
+				}
+			}
+		}
+	}
+	return out
+}
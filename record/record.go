@@ -0,0 +1,276 @@
+// Package record turns a stream of frames into a short animated clip, an APNG or a
+// GIF, that plays in any browser without a video codec.
+//
+// Frames are kept at their real timings: a frame is shown until the next one that
+// differs from it was captured, so a static screen costs nothing. Each frame after
+// the first only stores the bounding box of what changed. APNG frames are lossless;
+// GIF frames are mapped to one 255-colour palette computed from the first frame and
+// dithered, with unchanged pixels left transparent.
+package record
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/internal/imgutil"
+)
+
+// Format is a clip format.
+type Format string
+
+const (
+	FormatAPNG Format = "apng"
+	FormatGIF  Format = "gif"
+)
+
+// FormatFromPath returns the format matching the extension of path: ".gif" for GIF,
+// ".png" and ".apng" for APNG.
+func FormatFromPath(path string) (Format, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gif":
+		return FormatGIF, true
+	case ".png", ".apng":
+		return FormatAPNG, true
+	}
+	return "", false
+}
+
+// ErrLimit is returned by Add when the frame would exceed MaxDuration or MaxBytes.
+// The frame is not recorded and neither is any later one.
+var ErrLimit = errors.New("record: limit reached")
+
+var errClosed = errors.New("record: recorder closed")
+
+// Options configures a Recorder.
+type Options struct {
+	// Format is the output format, default FormatAPNG.
+	Format Format
+	// Scale shrinks the frames by a factor in (0, 1], zero means 1.
+	Scale float64
+	// MaxDuration limits the length of the clip, zero means no limit.
+	MaxDuration time.Duration
+	// MaxBytes limits the size of the output, zero means no limit.
+	MaxBytes int
+	// NoDither maps GIF pixels to the nearest palette colour instead of dithering.
+	NoDither bool
+}
+
+// Recorder encodes frames into a clip. Call Close to finish the file.
+type Recorder struct {
+	w    io.Writer
+	opts Options
+	fw   frameWriter
+
+	size image.Point // output frame size, zero before the first frame
+	prev *image.RGBA // latest recorded frame, scaled
+
+	pending     *encodedFrame // latest encoded frame, written once its end is known
+	pendingTime time.Time
+	frames      int
+	start, end  time.Time // the clip runs from start to end
+	bytes       int       // output size with the pending frame
+	full        bool
+	closed      bool
+}
+
+// New returns a Recorder writing to w.
+func New(w io.Writer, opts Options) (*Recorder, error) {
+	if opts.Scale < 0 || opts.Scale > 1 {
+		return nil, fmt.Errorf("record: scale %v out of range (0, 1]", opts.Scale)
+	}
+	if opts.MaxDuration < 0 || opts.MaxBytes < 0 {
+		return nil, errors.New("record: negative limit")
+	}
+	r := &Recorder{w: w, opts: opts}
+	switch opts.Format {
+	case "", FormatAPNG:
+		r.fw = &apngWriter{}
+	case FormatGIF:
+		r.fw = &gifWriter{dither: !opts.NoDither}
+	default:
+		return nil, fmt.Errorf("record: unsupported format %q", opts.Format)
+	}
+	r.bytes = r.fw.overhead()
+	return r, nil
+}
+
+// Add records f. Frames must arrive in capture order and have the same size. A frame
+// identical to the previous one only extends how long that one is shown.
+func (r *Recorder) Add(f screenshot.Frame) error {
+	switch {
+	case r.closed:
+		return errClosed
+	case r.full:
+		return ErrLimit
+	}
+	if r.frames == 0 {
+		r.start = f.Time
+		r.size = f.Image.Rect.Size()
+		if s := r.opts.Scale; s > 0 && s < 1 {
+			r.size = imgutil.Downscale(image.NewRGBA(image.Rectangle{Max: r.size}), s).Rect.Size()
+		}
+	}
+	if f.Time.Before(r.end) {
+		return errors.New("record: frames out of order")
+	}
+	if max := r.opts.MaxDuration; max > 0 && (f.Time.Sub(r.start) >= max ||
+		r.pending != nil && r.fw.end(r.pendingTime.Sub(r.start), f.Time.Sub(r.start)) > max) {
+		r.full = true
+		r.end = r.start.Add(max)
+		return ErrLimit
+	}
+
+	img := opaqueCopy(imgutil.Resize(f.Image, r.size.X, r.size.Y))
+	if img.Rect.Size() != r.size {
+		return fmt.Errorf("record: frame size %v differs from %v", img.Rect.Size(), r.size)
+	}
+	changed := img.Rect
+	if r.prev != nil {
+		changed = diffRect(r.prev, img)
+		if changed.Empty() {
+			r.end = f.Time
+			return nil
+		}
+	}
+	ef, err := r.fw.encode(img, r.prev, changed)
+	if err != nil {
+		return err
+	}
+	n := r.fw.frameSize(ef)
+	if r.opts.MaxBytes > 0 && r.bytes+n > r.opts.MaxBytes {
+		r.full = true
+		r.end = f.Time
+		return ErrLimit
+	}
+	if r.pending != nil {
+		if err := r.fw.write(r.w, r.pending, r.pendingTime.Sub(r.start), f.Time.Sub(r.start)); err != nil {
+			return err
+		}
+	}
+	r.pending, r.pendingTime = &ef, f.Time
+	r.prev = img
+	r.end = f.Time
+	r.bytes += n
+	r.frames++
+	return nil
+}
+
+// Close writes the last frame and finishes the file. The last frame is shown until the
+// latest frame passed to Add or, if it is that frame, for the average frame duration.
+func (r *Recorder) Close() error {
+	if r.closed {
+		return errClosed
+	}
+	r.closed = true
+	if r.pending == nil {
+		return errors.New("record: no frames recorded")
+	}
+	from, to := r.pendingTime.Sub(r.start), r.end.Sub(r.start)
+	if to <= from {
+		to = from + 100*time.Millisecond
+		if r.frames > 1 {
+			to = from + from/time.Duration(r.frames-1)
+		}
+	}
+	if max := r.opts.MaxDuration; max > 0 && to > max {
+		to = max
+	}
+	if err := r.fw.write(r.w, r.pending, from, to); err != nil {
+		return err
+	}
+	return r.fw.close(r.w)
+}
+
+// Record captures stream into a clip written to w until ctx is done or a limit of opts
+// is reached, then finishes the clip. A capture error leaves w incomplete.
+func Record(ctx context.Context, w io.Writer, stream screenshot.StreamOptions, opts Options) error {
+	r, err := New(w, opts)
+	if err != nil {
+		return err
+	}
+	err = screenshot.Stream(ctx, stream, r.Add)
+	if err != nil && !errors.Is(err, ErrLimit) && ctx.Err() == nil {
+		return err
+	}
+	return r.Close()
+}
+
+// encodedFrame is a compressed frame covering rect.
+type encodedFrame struct {
+	rect image.Rectangle
+	data []byte
+}
+
+// frameWriter encodes frames in one format.
+type frameWriter interface {
+	// overhead is the size of the file without frames.
+	overhead() int
+	// encode compresses rect of img, the part that differs from prev, which is nil
+	// for the first frame.
+	encode(img, prev *image.RGBA, rect image.Rectangle) (encodedFrame, error)
+	// frameSize is the number of bytes write will produce for f.
+	frameSize(f encodedFrame) int
+	// write writes f, shown from from to to after the start of the clip.
+	write(w io.Writer, f *encodedFrame, from, to time.Duration) error
+	// end returns when a frame captured at to would stop being shown at the
+	// earliest, after a frame captured at from that is not written yet.
+	end(from, to time.Duration) time.Duration
+	close(w io.Writer) error
+}
+
+// opaqueCopy returns a copy of img at the origin with full alpha.
+func opaqueCopy(img *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(image.Rectangle{Max: img.Rect.Size()})
+	n := 4 * img.Rect.Dx()
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		row := dst.Pix[y*dst.Stride : y*dst.Stride+n]
+		copy(row, img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):])
+		for i := 3; i < n; i += 4 {
+			row[i] = 0xff
+		}
+	}
+	return dst
+}
+
+// diffRect returns the bounding box of the pixels that differ between a and b, which
+// have the same bounds.
+func diffRect(a, b *image.RGBA) image.Rectangle {
+	var r image.Rectangle
+	n := 4 * a.Rect.Dx()
+	for y := 0; y < a.Rect.Dy(); y++ {
+		ra := a.Pix[y*a.Stride : y*a.Stride+n]
+		rb := b.Pix[y*b.Stride : y*b.Stride+n]
+		first := -1
+		for i := 0; i < n; i += 4 {
+			if [4]byte(ra[i:i+4]) != [4]byte(rb[i:i+4]) {
+				first = i / 4
+				break
+			}
+		}
+		if first < 0 {
+			continue
+		}
+		last := first
+		for i := n - 4; i > 4*first; i -= 4 {
+			if [4]byte(ra[i:i+4]) != [4]byte(rb[i:i+4]) {
+				last = i / 4
+				break
+			}
+		}
+		r = r.Union(image.Rect(first, y, last+1, y+1))
+	}
+	return r
+}
+
+// units converts the interval from..to into whole units, rounding both ends so that
+// rounding errors do not add up over the clip.
+func units(from, to, unit time.Duration) int {
+	return int(to.Round(unit)/unit - from.Round(unit)/unit)
+}
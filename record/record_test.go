@@ -0,0 +1,388 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/screenshottest"
+)
+
+var epoch = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// clip returns frames of a box moving over a two-tone background, captured at 0, 100,
+// 250 and 400 ms. The frame at 250 ms repeats the one before.
+func clip() []screenshot.Frame {
+	var frames []screenshot.Frame
+	for i, ms := range []int{0, 100, 250, 400} {
+		img := image.NewRGBA(image.Rect(10, 20, 90, 80))
+		draw.Draw(img, img.Rect, image.NewUniform(color.RGBA{200, 200, 210, 255}), image.Point{}, draw.Src)
+		draw.Draw(img, image.Rect(10, 60, 90, 80), image.NewUniform(color.RGBA{30, 40, 120, 255}), image.Point{}, draw.Src)
+		pos := []int{0, 20, 20, 45}[i]
+		box := image.Rect(15+pos, 30, 25+pos, 45)
+		draw.Draw(img, box, image.NewUniform(color.RGBA{220, 30, 30, 255}), image.Point{}, draw.Src)
+		frames = append(frames, screenshot.Frame{
+			Image: img,
+			Rect:  img.Rect,
+			Time:  epoch.Add(time.Duration(ms) * time.Millisecond),
+			Seq:   uint64(i),
+		})
+	}
+	return frames
+}
+
+func record(t *testing.T, frames []screenshot.Frame, opts Options) ([]byte, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	r, err := New(&buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var limit error
+	for _, f := range frames {
+		if err := r.Add(f); err != nil {
+			if !errors.Is(err, ErrLimit) {
+				t.Fatal(err)
+			}
+			limit = err
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), limit
+}
+
+// sameImage fails unless got matches want pixel for pixel, ignoring positions.
+func sameImage(t *testing.T, name string, got image.Image, want *image.RGBA) {
+	t.Helper()
+	b := got.Bounds()
+	if b.Size() != want.Rect.Size() {
+		t.Fatalf("%s: size %v, want %v", name, b.Size(), want.Rect.Size())
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			g := color.RGBAModel.Convert(got.At(b.Min.X+x, b.Min.Y+y))
+			w := want.At(want.Rect.Min.X+x, want.Rect.Min.Y+y)
+			if g != w {
+				t.Fatalf("%s: pixel (%d, %d) is %v, want %v", name, x, y, g, w)
+			}
+		}
+	}
+}
+
+type apngFrameInfo struct {
+	rect  image.Rectangle
+	delay time.Duration
+	img   image.Image
+}
+
+// decodeAPNG returns the frames of an APNG written by apngWriter.
+func decodeAPNG(t *testing.T, data []byte) (image.Point, []apngFrameInfo) {
+	t.Helper()
+	if !bytes.HasPrefix(data, pngSignature) {
+		t.Fatal("missing PNG signature")
+	}
+	var size image.Point
+	var ihdr []byte
+	var frames []apngFrameInfo
+	numFrames, seq := -1, uint32(0)
+	for p := data[len(pngSignature):]; len(p) > 0; {
+		n := int(binary.BigEndian.Uint32(p))
+		typ, body := string(p[4:8]), p[8:8+n]
+		p = p[chunkOverhead+n:]
+		switch typ {
+		case "IHDR":
+			ihdr = body
+			size = image.Pt(int(binary.BigEndian.Uint32(body)), int(binary.BigEndian.Uint32(body[4:])))
+		case "acTL":
+			numFrames = int(binary.BigEndian.Uint32(body))
+		case "fcTL", "fdAT":
+			if got := binary.BigEndian.Uint32(body); got != seq {
+				t.Fatalf("%s sequence number %d, want %d", typ, got, seq)
+			}
+			seq++
+			if typ == "fdAT" {
+				body = body[4:]
+				typ = "IDAT"
+			} else {
+				x, y := int(binary.BigEndian.Uint32(body[12:])), int(binary.BigEndian.Uint32(body[16:]))
+				w, h := int(binary.BigEndian.Uint32(body[4:])), int(binary.BigEndian.Uint32(body[8:]))
+				num, den := binary.BigEndian.Uint16(body[20:]), binary.BigEndian.Uint16(body[22:])
+				frames = append(frames, apngFrameInfo{
+					rect:  image.Rect(x, y, x+w, y+h),
+					delay: time.Duration(num) * time.Second / time.Duration(den),
+				})
+				continue
+			}
+			fallthrough
+		case "IDAT":
+			f := &frames[len(frames)-1]
+			hdr := append([]byte(nil), ihdr...)
+			binary.BigEndian.PutUint32(hdr, uint32(f.rect.Dx()))
+			binary.BigEndian.PutUint32(hdr[4:], uint32(f.rect.Dy()))
+			file := appendChunk(append([]byte(nil), pngSignature...), "IHDR", hdr)
+			file = appendChunk(file, "IDAT", body)
+			file = appendChunk(file, "IEND", nil)
+			img, err := png.Decode(bytes.NewReader(file))
+			if err != nil {
+				t.Fatalf("frame %d: %v", len(frames)-1, err)
+			}
+			f.img = img
+		}
+	}
+	if numFrames != len(frames) {
+		t.Fatalf("acTL announces %d frames, found %d", numFrames, len(frames))
+	}
+	return size, frames
+}
+
+func TestAPNG(t *testing.T) {
+	frames := clip()
+	data, err := record(t, frames, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Viewers without APNG support show the first frame.
+	first, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameImage(t, "default image", first, frames[0].Image)
+
+	size, got := decodeAPNG(t, data)
+	if size != image.Pt(80, 60) {
+		t.Errorf("size %v, want 80x60", size)
+	}
+	want := []struct {
+		frame int
+		delay time.Duration
+	}{{0, 100 * time.Millisecond}, {1, 300 * time.Millisecond}, {3, 200 * time.Millisecond}}
+	if len(got) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(got), len(want))
+	}
+	canvas := image.NewRGBA(image.Rectangle{Max: size})
+	for i, f := range got {
+		if f.delay != want[i].delay {
+			t.Errorf("frame %d: delay %v, want %v", i, f.delay, want[i].delay)
+		}
+		if i > 0 && f.rect.Dx() >= size.X/2 {
+			t.Errorf("frame %d: %v is not cropped to the moving box", i, f.rect)
+		}
+		draw.Draw(canvas, f.rect, f.img, f.img.Bounds().Min, draw.Src)
+		sameImage(t, "frame", canvas, frames[want[i].frame].Image)
+	}
+}
+
+func TestGIF(t *testing.T) {
+	frames := clip()
+	for _, noDither := range []bool{false, true} {
+		data, err := record(t, frames, Options{Format: FormatGIF, NoDither: noDither})
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.LoopCount != 0 {
+			t.Errorf("loop count %d, want 0", g.LoopCount)
+		}
+		wantFrames, wantDelays := []int{0, 1, 3}, []int{10, 30, 20}
+		if len(g.Image) != len(wantFrames) {
+			t.Fatalf("got %d frames, want %d", len(g.Image), len(wantFrames))
+		}
+		canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+		for i, img := range g.Image {
+			if g.Delay[i] != wantDelays[i] {
+				t.Errorf("frame %d: delay %d, want %d", i, g.Delay[i], wantDelays[i])
+			}
+			// The clip has four colours, so the palette holds them exactly.
+			draw.Draw(canvas, img.Rect, img, img.Rect.Min, draw.Over)
+			sameImage(t, "frame", canvas, frames[wantFrames[i]].Image)
+		}
+	}
+}
+
+func TestGIFShortDelays(t *testing.T) {
+	// Frames 5 ms apart are shown for the 2 cs minimum, which the next ones make up
+	// for, so the clip lasts the 12 cs of MaxDuration.
+	var frames []screenshot.Frame
+	for i, ms := range []int{0, 5, 10, 15, 100} {
+		img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		img.Pix[0] = uint8(i)
+		frames = append(frames, screenshot.Frame{Image: img, Rect: img.Rect, Time: epoch.Add(time.Duration(ms) * time.Millisecond), Seq: uint64(i)})
+	}
+	data, err := record(t, frames, Options{Format: FormatGIF, MaxDuration: 120 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{2, 2, 2, 4, 2}
+	if len(g.Delay) != len(want) {
+		t.Fatalf("delays %v, want %v", g.Delay, want)
+	}
+	for i := range want {
+		if g.Delay[i] != want[i] {
+			t.Errorf("delays %v, want %v", g.Delay, want)
+			break
+		}
+	}
+}
+
+func TestGIFQuantize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 256; x++ {
+			img.SetRGBA(x, y, color.RGBA{byte(x), byte(y * 4), byte(255 - x), 255})
+		}
+	}
+	frame := screenshot.Frame{Image: img, Rect: img.Rect, Time: epoch}
+	// Mean error per channel of single pixels and of 4x4 blocks: dithering keeps the
+	// average colour of an area closer than the nearest colour does.
+	errs := make(map[bool][2]float64)
+	for _, noDither := range []bool{false, true} {
+		data, err := record(t, []screenshot.Frame{frame}, Options{Format: FormatGIF, NoDither: noDither})
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := g.Image[0]
+		var pixel, block int
+		for by := 0; by < 64; by += 4 {
+			for bx := 0; bx < 256; bx += 4 {
+				var sum [3]int
+				for y := by; y < by+4; y++ {
+					for x := bx; x < bx+4; x++ {
+						c := got.Palette[got.ColorIndexAt(x, y)].(color.RGBA)
+						w := img.RGBAAt(x, y)
+						d := [3]int{int(c.R) - int(w.R), int(c.G) - int(w.G), int(c.B) - int(w.B)}
+						for k := range 3 {
+							pixel += abs(d[k])
+							sum[k] += d[k]
+						}
+					}
+				}
+				block += abs(sum[0]) + abs(sum[1]) + abs(sum[2])
+			}
+		}
+		errs[noDither] = [2]float64{float64(pixel) / (3 * 256 * 64), float64(block) / (3 * 256 * 64)}
+	}
+	dithered, nearest := errs[false], errs[true]
+	if nearest[0] > 6 || dithered[0] > 8 {
+		t.Errorf("mean pixel error %.2f nearest, %.2f dithered", nearest[0], dithered[0])
+	}
+	if dithered[1] >= nearest[1] {
+		t.Errorf("mean block error %.2f dithered is not below %.2f nearest", dithered[1], nearest[1])
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func TestLimits(t *testing.T) {
+	frames := clip()
+	for _, format := range []Format{FormatAPNG, FormatGIF} {
+		data, err := record(t, frames, Options{Format: format, MaxDuration: 300 * time.Millisecond})
+		if !errors.Is(err, ErrLimit) {
+			t.Errorf("%s: got %v, want ErrLimit", format, err)
+		}
+		if format == FormatAPNG {
+			_, got := decodeAPNG(t, data)
+			if len(got) != 2 || got[1].delay != 200*time.Millisecond {
+				t.Errorf("apng: frames cut at 300ms are %+v", got)
+			}
+		} else {
+			g, err := gif.DecodeAll(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(g.Image) != 2 || g.Delay[1] != 20 {
+				t.Errorf("gif: frames cut at 300ms have delays %v", g.Delay)
+			}
+		}
+
+		full, _ := record(t, frames, Options{Format: format})
+		max := len(full) - 1
+		data, err = record(t, frames, Options{Format: format, MaxBytes: max})
+		if !errors.Is(err, ErrLimit) {
+			t.Errorf("%s: got %v, want ErrLimit", format, err)
+		}
+		if len(data) > max {
+			t.Errorf("%s: %d bytes exceed MaxBytes %d", format, len(data), max)
+		}
+	}
+
+	r, _ := New(&bytes.Buffer{}, Options{})
+	if err := r.Close(); err == nil {
+		t.Error("closing an empty clip succeeded")
+	}
+	if _, err := New(&bytes.Buffer{}, Options{Format: "mp4"}); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestScale(t *testing.T) {
+	data, err := record(t, clip(), Options{Scale: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 40 || cfg.Height != 30 {
+		t.Errorf("scaled clip is %dx%d, want 40x30", cfg.Width, cfg.Height)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	c := screenshottest.New(image.Rect(0, 0, 64, 48))
+	c.SetContent(screenshottest.Checkerboard(8, 2, color.Black, color.White))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var buf bytes.Buffer
+	err := Record(ctx, &buf, screenshot.StreamOptions{FPS: 50, Capturer: c}, Options{Format: FormatGIF, MaxDuration: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, d := range g.Delay {
+		total += d
+	}
+	if len(g.Image) < 2 || total > 20 {
+		t.Errorf("got %d frames lasting %d cs", len(g.Image), total)
+	}
+}
+
+func TestFormatFromPath(t *testing.T) {
+	for path, want := range map[string]Format{"a.gif": FormatGIF, "b.PNG": FormatAPNG, "c.apng": FormatAPNG, "d.mp4": ""} {
+		if got, _ := FormatFromPath(path); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+}
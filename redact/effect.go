@@ -0,0 +1,101 @@
+package redact
+
+import "image"
+
+// fill paints area of img opaque black.
+func fill(img *image.RGBA, area image.Rectangle) {
+	n := area.Dx() * 4
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		i := img.PixOffset(area.Min.X, y)
+		row := img.Pix[i : i+n]
+		for j := 0; j < n; j += 4 {
+			row[j], row[j+1], row[j+2], row[j+3] = 0, 0, 0, 0xff
+		}
+	}
+}
+
+// pixelate replaces the size×size blocks of area, counted from its top-left
+// corner, with their average colour.
+func pixelate(img *image.RGBA, area image.Rectangle, size int) {
+	for by := area.Min.Y; by < area.Max.Y; by += size {
+		for bx := area.Min.X; bx < area.Max.X; bx += size {
+			block := image.Rect(bx, by, bx+size, by+size).Intersect(area)
+			var sum [4]int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				i := img.PixOffset(block.Min.X, y)
+				for j := i; j < i+4*block.Dx(); j += 4 {
+					sum[0] += int(img.Pix[j])
+					sum[1] += int(img.Pix[j+1])
+					sum[2] += int(img.Pix[j+2])
+					sum[3] += int(img.Pix[j+3])
+				}
+			}
+			n := block.Dx() * block.Dy()
+			var avg [4]byte
+			for c := range avg {
+				avg[c] = byte((sum[c] + n/2) / n)
+			}
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				i := img.PixOffset(block.Min.X, y)
+				for j := i; j < i+4*block.Dx(); j += 4 {
+					copy(img.Pix[j:j+4], avg[:])
+				}
+			}
+		}
+	}
+}
+
+// blurPasses box blurs approximate a Gaussian blur.
+const blurPasses = 3
+
+// blur blurs area of img with repeated box blurs of the given radius. Only pixels
+// inside area are sampled, the edges are extended.
+func blur(img *image.RGBA, area image.Rectangle, radius int) {
+	w, h := area.Dx(), area.Dy()
+	buf := make([]byte, 4*max(w, h))
+	line := make([]byte, 4*max(w, h))
+	for range blurPasses {
+		for y := area.Min.Y; y < area.Max.Y; y++ {
+			i := img.PixOffset(area.Min.X, y)
+			row := img.Pix[i : i+4*w]
+			copy(line, row)
+			boxBlur(row, line[:4*w], radius)
+		}
+		for x := area.Min.X; x < area.Max.X; x++ {
+			for y := 0; y < h; y++ {
+				copy(line[4*y:4*y+4], img.Pix[img.PixOffset(x, area.Min.Y+y):])
+			}
+			boxBlur(buf[:4*h], line[:4*h], radius)
+			for y := 0; y < h; y++ {
+				copy(img.Pix[img.PixOffset(x, area.Min.Y+y):], buf[4*y:4*y+4])
+			}
+		}
+	}
+}
+
+// boxBlur writes the mean of the 2*radius+1 pixels around each pixel of src to dst,
+// repeating the edge pixels. dst and src must not overlap.
+func boxBlur(dst, src []byte, radius int) {
+	n := len(src) / 4
+	at := func(i int) []byte {
+		i = min(max(i, 0), n-1)
+		return src[4*i : 4*i+4]
+	}
+	var sum [4]int
+	for i := -radius; i <= radius; i++ {
+		p := at(i)
+		for c := range sum {
+			sum[c] += int(p[c])
+		}
+	}
+	div := 2*radius + 1
+	for i := 0; i < n; i++ {
+		for c := range sum {
+			dst[4*i+c] = byte((sum[c] + div/2) / div)
+		}
+		out, in := at(i-radius), at(i+radius+1)
+		for c := range sum {
+			sum[c] += int(in[c]) - int(out[c])
+		}
+	}
+}
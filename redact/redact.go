@@ -0,0 +1,272 @@
+// Package redact masks parts of the desktop in every capture: fixed rectangles,
+// whole displays, or windows matched by class or title, which are followed as they
+// move. Matching areas are blacked out, pixelated or blurred in the capture buffer
+// itself before the image is returned, so no unredacted pixels leave the capturer.
+//
+// A Redactor either wraps a screenshot.ScreenCapturer or is installed around the
+// package level capture functions of the screenshot package, which covers
+// screenshot.Capture, Stream, DefaultCapturer and everything built on them.
+//
+// Redaction fails closed: when the windows cannot be listed, the capture fails
+// instead of returning an image that may show them.
+package redact
+
+import (
+	"fmt"
+	"image"
+	"regexp"
+	"sync"
+
+	"github.com/Fast-IQ/screenshot"
+)
+
+// Effect is how an area is masked.
+type Effect int
+
+const (
+	// Black fills the area with opaque black. It is the only effect that keeps
+	// nothing of the original pixels.
+	Black Effect = iota
+	// Pixelate replaces blocks of the area with their average colour.
+	Pixelate
+	// Blur applies a strong blur to the area.
+	Blur
+)
+
+// String returns the name of e.
+func (e Effect) String() string {
+	switch e {
+	case Black:
+		return "black"
+	case Pixelate:
+		return "pixelate"
+	case Blur:
+		return "blur"
+	}
+	return fmt.Sprintf("Effect(%d)", int(e))
+}
+
+// ParseEffect returns the effect called name, as returned by Effect.String.
+func ParseEffect(name string) (Effect, error) {
+	for _, e := range []Effect{Black, Pixelate, Blur} {
+		if e.String() == name {
+			return e, nil
+		}
+	}
+	return 0, fmt.Errorf("redact: unknown effect %q", name)
+}
+
+// Target selects desktop areas to redact. Targets are made by Rect, Display and
+// Windows.
+type Target interface {
+	areas(s *scene) []image.Rectangle
+}
+
+// scene is the desktop state a capture is redacted against.
+type scene struct {
+	displays []image.Rectangle
+	windows  []screenshot.Window
+}
+
+type rectTarget image.Rectangle
+
+func (t rectTarget) areas(*scene) []image.Rectangle {
+	return []image.Rectangle{image.Rectangle(t)}
+}
+
+// Rect targets a fixed rectangle in desktop coordinates.
+func Rect(r image.Rectangle) Target {
+	return rectTarget(r.Canon())
+}
+
+type displayTarget int
+
+func (t displayTarget) areas(s *scene) []image.Rectangle {
+	if int(t) < len(s.displays) {
+		return []image.Rectangle{s.displays[t]}
+	}
+	return nil
+}
+
+// Display targets the whole display with the given index.
+func Display(index int) Target {
+	return displayTarget(index)
+}
+
+type windowTarget struct {
+	class, title *regexp.Regexp
+}
+
+func (t windowTarget) areas(s *scene) []image.Rectangle {
+	var areas []image.Rectangle
+	for _, w := range s.windows {
+		if t.match(w) {
+			areas = append(areas, w.Bounds)
+		}
+	}
+	return areas
+}
+
+func (t windowTarget) match(w screenshot.Window) bool {
+	return (t.class == nil || t.class.MatchString(w.Class)) && (t.title == nil || t.title.MatchString(w.Title))
+}
+
+// Windows targets the top-level windows whose class matches class and whose title
+// matches title; a nil expression matches anything. The class is WM_CLASS on X11.
+// The whole window rectangle is redacted, also where other windows cover it.
+func Windows(class, title *regexp.Regexp) Target {
+	return windowTarget{class, title}
+}
+
+// Rule redacts the areas of Target with Effect.
+type Rule struct {
+	Target Target
+	Effect Effect
+}
+
+// Options configures a Redactor.
+type Options struct {
+	// BlockSize is the block size of Pixelate in pixels, default 16.
+	BlockSize int
+	// BlurRadius is the radius of Blur in pixels, default 12.
+	BlurRadius int
+	// ListWindows lists the windows for Windows targets, default
+	// screenshot.ListWindows.
+	ListWindows func() ([]screenshot.Window, error)
+}
+
+// Redactor applies a set of rules to captures. It is safe for concurrent use and
+// its rules can be changed while capturing.
+type Redactor struct {
+	opts  Options
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+// New returns a Redactor with the given rules.
+func New(opts Options, rules ...Rule) *Redactor {
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = 16
+	}
+	if opts.BlurRadius <= 0 {
+		opts.BlurRadius = 12
+	}
+	if opts.ListWindows == nil {
+		opts.ListWindows = screenshot.ListWindows
+	}
+	return &Redactor{opts: opts, rules: rules}
+}
+
+// Add appends rules.
+func (r *Redactor) Add(rules ...Rule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = append(r.rules[:len(r.rules):len(r.rules)], rules...)
+}
+
+// SetRules replaces the rules.
+func (r *Redactor) SetRules(rules ...Rule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = rules
+}
+
+// Rules returns the current rules.
+func (r *Redactor) Rules() []Rule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.rules[:len(r.rules):len(r.rules)]
+}
+
+// Install makes the package level capture functions of the screenshot package
+// redact every capture, and returns a function that removes the redaction again.
+// Only one Redactor can be installed at a time.
+func (r *Redactor) Install() (uninstall func()) {
+	screenshot.SetCaptureHook(func(backend screenshot.CaptureFunc) screenshot.CaptureFunc {
+		return func(x, y, width, height int) (*image.RGBA, error) {
+			return r.capture(backend, screenshot.GetAllDisplayBounds, x, y, width, height)
+		}
+	})
+	return func() { screenshot.SetCaptureHook(nil) }
+}
+
+// Wrap returns a capturer that redacts the captures of c.
+func (r *Redactor) Wrap(c screenshot.ScreenCapturer) screenshot.ScreenCapturer {
+	return capturer{c, r}
+}
+
+type capturer struct {
+	screenshot.ScreenCapturer
+	r *Redactor
+}
+
+func (c capturer) Capture(x, y, width, height int) (*image.RGBA, error) {
+	return c.r.capture(c.ScreenCapturer.Capture, c.GetAllDisplayBounds, x, y, width, height)
+}
+
+// capture captures the region with capture and redacts it. Windows are listed both
+// before and after the capture, and both positions of a window that moved in
+// between are redacted.
+func (r *Redactor) capture(capture screenshot.CaptureFunc, displays func() ([]image.Rectangle, error), x, y, width, height int) (*image.RGBA, error) {
+	rules := r.Rules()
+	if len(rules) == 0 {
+		return capture(x, y, width, height)
+	}
+	var needDisplays, needWindows bool
+	for _, rule := range rules {
+		switch rule.Target.(type) {
+		case displayTarget:
+			needDisplays = true
+		case windowTarget:
+			needWindows = true
+		}
+	}
+
+	var s scene
+	if needWindows {
+		before, err := r.opts.ListWindows()
+		if err != nil {
+			return nil, fmt.Errorf("redact: listing windows: %w", err)
+		}
+		s.windows = before
+	}
+	img, err := capture(x, y, width, height)
+	if err != nil {
+		return nil, err
+	}
+	if needWindows {
+		after, err := r.opts.ListWindows()
+		if err != nil {
+			return nil, fmt.Errorf("redact: listing windows: %w", err)
+		}
+		s.windows = append(s.windows, after...)
+	}
+	if needDisplays {
+		if s.displays, err = displays(); err != nil {
+			return nil, fmt.Errorf("redact: listing displays: %w", err)
+		}
+	}
+	r.apply(img, image.Pt(x, y), rules, &s)
+	return img, nil
+}
+
+// apply redacts img, a capture whose top-left pixel is at origin on the desktop.
+func (r *Redactor) apply(img *image.RGBA, origin image.Point, rules []Rule, s *scene) {
+	offset := img.Rect.Min.Sub(origin)
+	for _, rule := range rules {
+		for _, area := range rule.Target.areas(s) {
+			area = area.Add(offset).Intersect(img.Rect)
+			if area.Empty() {
+				continue
+			}
+			switch rule.Effect {
+			case Pixelate:
+				pixelate(img, area, r.opts.BlockSize)
+			case Blur:
+				blur(img, area, r.opts.BlurRadius)
+			default:
+				fill(img, area)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package redact
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"regexp"
+	"testing"
+
+	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/screenshottest"
+)
+
+var (
+	black = color.RGBA{0, 0, 0, 255}
+	white = color.RGBA{255, 255, 255, 255}
+)
+
+// isBlack reports whether the desktop point p is black in img, a capture at
+// origin.
+func isBlack(img *image.RGBA, origin image.Point, p image.Point) bool {
+	return img.RGBAAt(p.X-origin.X+img.Rect.Min.X, p.Y-origin.Y+img.Rect.Min.Y) == black
+}
+
+func TestWrap(t *testing.T) {
+	c := screenshottest.New(image.Rect(0, 0, 100, 80), image.Rect(100, 0, 160, 80))
+	// The password manager moves between the two listings of a capture.
+	calls := 0
+	list := func() ([]screenshot.Window, error) {
+		calls++
+		x := 10 * calls
+		return []screenshot.Window{
+			{ID: 1, Title: "Vault", Class: "KeePassXC", Bounds: image.Rect(x, 40, x+10, 50)},
+			{ID: 2, Title: "Terminal", Class: "xterm", Bounds: image.Rect(60, 40, 80, 50)},
+		}, nil
+	}
+	r := New(Options{ListWindows: list},
+		Rule{Target: Rect(image.Rect(0, 0, 5, 5))},
+		Rule{Target: Display(1)},
+		Rule{Target: Windows(regexp.MustCompile("^KeePass"), nil)},
+	)
+	wc := r.Wrap(c)
+	img, err := wc.Capture(0, 0, 160, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin := image.Point{}
+	for _, tc := range []struct {
+		p    image.Point
+		want bool
+	}{
+		{image.Pt(0, 0), true},
+		{image.Pt(4, 4), true},
+		{image.Pt(5, 5), false},
+		{image.Pt(100, 0), true},
+		{image.Pt(159, 79), true},
+		{image.Pt(99, 79), false},
+		{image.Pt(10, 40), true}, // before the capture
+		{image.Pt(25, 45), true}, // after the capture
+		{image.Pt(35, 45), false},
+		{image.Pt(65, 45), false},
+	} {
+		if got := isBlack(img, origin, tc.p); got != tc.want {
+			t.Errorf("pixel %v redacted %v, want %v", tc.p, got, tc.want)
+		}
+	}
+
+	// A region capture maps the rules to its own coordinates.
+	origin = image.Pt(90, 30)
+	img, err = wc.Capture(origin.X, origin.Y, 20, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isBlack(img, origin, image.Pt(99, 35)) || !isBlack(img, origin, image.Pt(100, 35)) {
+		t.Error("display rule misplaced in a region capture")
+	}
+
+	r.SetRules()
+	if img, _ = wc.Capture(0, 0, 10, 10); isBlack(img, image.Point{}, image.Pt(0, 0)) {
+		t.Error("redacted without rules")
+	}
+	r.Add(Rule{Target: Rect(image.Rect(0, 0, 1, 1))})
+	if len(r.Rules()) != 1 {
+		t.Errorf("%d rules after Add", len(r.Rules()))
+	}
+}
+
+func TestFailClosed(t *testing.T) {
+	c := screenshottest.New(image.Rect(0, 0, 20, 20))
+	lerr := errors.New("no window list")
+	r := New(Options{ListWindows: func() ([]screenshot.Window, error) { return nil, lerr }},
+		Rule{Target: Windows(nil, regexp.MustCompile("Signal"))})
+	img, err := r.Wrap(c).Capture(0, 0, 20, 20)
+	if !errors.Is(err, lerr) || img != nil {
+		t.Errorf("Capture = %v, %v, want no image and the listing error", img, err)
+	}
+
+	r.SetRules(Rule{Target: Display(0)})
+	c.SetDisplays()
+	if img, err = r.Wrap(c).Capture(0, 0, 20, 20); err == nil {
+		t.Errorf("Capture without display bounds succeeded")
+	}
+}
+
+func checker(r image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := white
+			if (x+y)%2 == 0 {
+				c = black
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestEffects(t *testing.T) {
+	area := image.Rect(4, 4, 20, 14)
+	for _, e := range []Effect{Black, Pixelate, Blur} {
+		img := checker(image.Rect(0, 0, 24, 18))
+		r := New(Options{BlockSize: 4, BlurRadius: 3}, Rule{Target: Rect(area), Effect: e})
+		r.apply(img, image.Point{}, r.Rules(), &scene{})
+
+		for y := 0; y < 18; y++ {
+			for x := 0; x < 24; x++ {
+				p := image.Pt(x, y)
+				if !p.In(area) {
+					if got, want := img.RGBAAt(x, y), checker(img.Rect).RGBAAt(x, y); got != want {
+						t.Fatalf("%v changed pixel %v outside the area", e, p)
+					}
+					continue
+				}
+				c := img.RGBAAt(x, y)
+				if c.A != 255 || c.R != c.G || c.G != c.B {
+					t.Fatalf("%v: pixel %v = %v", e, p, c)
+				}
+				switch e {
+				case Black:
+					if c != black {
+						t.Fatalf("black: pixel %v = %v", p, c)
+					}
+				default:
+					// Both effects average the checkerboard to grey.
+					if c.R < 100 || c.R > 155 {
+						t.Fatalf("%v: pixel %v = %v, want grey", e, p, c)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestParseEffect(t *testing.T) {
+	for _, e := range []Effect{Black, Pixelate, Blur} {
+		if got, err := ParseEffect(e.String()); err != nil || got != e {
+			t.Errorf("ParseEffect(%q) = %v, %v", e, got, err)
+		}
+	}
+	if _, err := ParseEffect("smudge"); err == nil {
+		t.Error("ParseEffect accepted an unknown effect")
+	}
+}
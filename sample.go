@@ -0,0 +1,112 @@
+package screenshot
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// maxSampleArea is the largest bounding box of the points that the capture based
+// sampling captures at once; points further apart are captured one by one.
+const maxSampleArea = 1 << 16
+
+// ColorAt returns the colour of the pixel at (x, y), in the coordinates of
+// Capture, without capturing more than that pixel.
+func ColorAt(x, y int) (color.RGBA, error) {
+	colors, err := ColorsAt([]image.Point{{x, y}})
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	return colors[0], nil
+}
+
+// ColorsAt returns the colours of the pixels at points, in the coordinates of
+// Capture. Pixels outside every display are opaque black.
+//
+// On X11 the points are read in a single round trip on a connection kept open for
+// the next call, and on Windows with one 1×1 BitBlt each from the same device
+// context. Elsewhere, or while a capture hook is set so that the hook sees every
+// pixel read, the bounding box of the points is captured, or every point alone
+// when they are far apart.
+func ColorsAt(points []image.Point) ([]color.RGBA, error) {
+	if len(points) == 0 {
+		return nil, nil
+	}
+	if captureHook.Load() != nil {
+		return sampleCapture(Capture, points)
+	}
+	return samplePoints(points)
+}
+
+// ColorsAtWith is like ColorsAt but uses the given capturer.
+func ColorsAtWith(c ScreenCapturer, points []image.Point) ([]color.RGBA, error) {
+	if _, ok := c.(defaultCapturer); ok {
+		return ColorsAt(points)
+	}
+	if len(points) == 0 {
+		return nil, nil
+	}
+	return sampleCapture(c.Capture, points)
+}
+
+// AverageColor returns the mean colour of the pixels of rect, capturing rect only.
+func AverageColor(rect image.Rectangle) (color.RGBA, error) {
+	return AverageColorWith(DefaultCapturer(), rect)
+}
+
+// AverageColorWith is like AverageColor but uses the given capturer.
+func AverageColorWith(c ScreenCapturer, rect image.Rectangle) (color.RGBA, error) {
+	if rect.Empty() {
+		return color.RGBA{}, errors.New("screenshot: empty rectangle")
+	}
+	img, err := c.Capture(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	var sum [4]uint64
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		i := img.PixOffset(img.Rect.Min.X, y)
+		row := img.Pix[i : i+4*img.Rect.Dx()]
+		for j := 0; j < len(row); j += 4 {
+			for c := range sum {
+				sum[c] += uint64(row[j+c])
+			}
+		}
+	}
+	n := uint64(img.Rect.Dx() * img.Rect.Dy())
+	return color.RGBA{
+		R: uint8((sum[0] + n/2) / n),
+		G: uint8((sum[1] + n/2) / n),
+		B: uint8((sum[2] + n/2) / n),
+		A: uint8((sum[3] + n/2) / n),
+	}, nil
+}
+
+// sampleCapture samples points with capture, in one capture of their bounding box
+// when it is small enough.
+func sampleCapture(capture CaptureFunc, points []image.Point) ([]color.RGBA, error) {
+	var box image.Rectangle
+	for _, p := range points {
+		box = box.Union(image.Rectangle{p, p.Add(image.Pt(1, 1))})
+	}
+	colors := make([]color.RGBA, len(points))
+	if box.Dx()*box.Dy() <= maxSampleArea {
+		img, err := capture(box.Min.X, box.Min.Y, box.Dx(), box.Dy())
+		if err != nil {
+			return nil, err
+		}
+		for i, p := range points {
+			q := p.Sub(box.Min).Add(img.Rect.Min)
+			colors[i] = img.RGBAAt(q.X, q.Y)
+		}
+		return colors, nil
+	}
+	for i, p := range points {
+		img, err := capture(p.X, p.Y, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		colors[i] = img.RGBAAt(img.Rect.Min.X, img.Rect.Min.Y)
+	}
+	return colors, nil
+}
@@ -4,18 +4,20 @@ package screenshot
 
 import (
 	"errors"
-	"github.com/Fast-IQ/screenshot/win_cap"
-	"github.com/lxn/win"
 	"image"
-	"runtime"
-	"syscall"
-	"unsafe"
+	"sync/atomic"
+
+	"github.com/Fast-IQ/screenshot/icc"
 )
 
 // ErrUnsupported is returned when the platform or architecture used to compile the program
 // does not support screenshot, e.g. if you're compiling without CGO on Darwin
 var ErrUnsupported = errors.New("screenshot does not support your platform")
 
+// ErrCancelled is returned when the user or the system denies an interactive capture request,
+// e.g. when the xdg-desktop-portal dialog is dismissed on Wayland.
+var ErrCancelled = errors.New("screenshot request was cancelled")
+
 type ScreenCapturer interface {
 	//	NumActiveDisplays() int
 	Capture(x, y, width, height int) (*image.RGBA, error)
@@ -23,6 +25,115 @@ type ScreenCapturer interface {
 	GetAllDisplayBounds() ([]image.Rectangle, error)
 }
 
+// DefaultCapturer returns the ScreenCapturer used by the package level functions.
+func DefaultCapturer() ScreenCapturer {
+	return defaultCapturer{}
+}
+
+type defaultCapturer struct{}
+
+func (defaultCapturer) Capture(x, y, width, height int) (*image.RGBA, error) {
+	return Capture(x, y, width, height)
+}
+
+func (defaultCapturer) GetDisplayBounds(displayIndex int) (image.Rectangle, error) {
+	return GetDisplayBounds(displayIndex)
+}
+
+func (defaultCapturer) GetAllDisplayBounds() ([]image.Rectangle, error) {
+	return GetAllDisplayBounds()
+}
+
+// Capture returns screen capture of specified desktop region.
+// x and y represent distance from the upper-left corner of primary display.
+// Y-axis is downward direction. This means coordinates system is similar to Windows OS.
+func Capture(x, y, width, height int) (*image.RGBA, error) {
+	return hooked(captureBackend)(x, y, width, height)
+}
+
+// CaptureOptions changes what a capture shows.
+type CaptureOptions struct {
+	// ExcludeWindows lists windows to leave out of the capture, which shows what is
+	// behind them instead, e.g. to keep a recording indicator out of the recording.
+	// See CaptureWithOptions for the platforms supporting it.
+	ExcludeWindows []WindowID
+	// ColorSpace converts the capture to this colour space, e.g. icc.SRGB, from that
+	// of each display it covers, see DisplayColorSpace, so that captures of
+	// different displays and machines are comparable. nil leaves the pixels as the
+	// displays have them.
+	ColorSpace *icc.Profile
+}
+
+// CaptureWithOptions is like Capture, with options.
+//
+// Excluding windows is supported on X11, where the windows behind are composited
+// from their Composite extension pixmaps, on macOS 14.4 and later through the
+// ScreenCaptureKit content filter, and on Windows for windows their owning process
+// excluded with ExcludeFromCapture. Elsewhere it returns ErrUnsupported.
+//
+// On X11 without a compositing manager, the first capture excluding windows turns
+// on automatic redirection of the top-level windows for the rest of the process,
+// and windows may need a moment to repaint into their pixmaps. The desktop
+// background is taken from the _XROOTPMAP_ID wallpaper, or black.
+//
+// Converting the colour space works everywhere, and treats displays whose profile
+// cannot be read, as on Wayland, as sRGB.
+func CaptureWithOptions(x, y, width, height int, opts CaptureOptions) (*image.RGBA, error) {
+	capture := Capture
+	if len(opts.ExcludeWindows) > 0 {
+		exclude := opts.ExcludeWindows
+		capture = hooked(func(x, y, width, height int) (*image.RGBA, error) {
+			return captureExcluding(x, y, width, height, exclude)
+		})
+	}
+	img, err := capture(x, y, width, height)
+	if err != nil || opts.ColorSpace == nil {
+		return img, err
+	}
+	if err := convertCapture(img, image.Rect(x, y, x+width, y+height), opts.ColorSpace); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// NewCapturer returns a ScreenCapturer whose captures use opts, for Stream and the
+// other functions taking a capturer.
+func NewCapturer(opts CaptureOptions) ScreenCapturer {
+	return optionsCapturer{opts: opts}
+}
+
+type optionsCapturer struct {
+	defaultCapturer
+	opts CaptureOptions
+}
+
+func (c optionsCapturer) Capture(x, y, width, height int) (*image.RGBA, error) {
+	return CaptureWithOptions(x, y, width, height, c.opts)
+}
+
+// CaptureFunc has the signature of Capture.
+type CaptureFunc func(x, y, width, height int) (*image.RGBA, error)
+
+var captureHook atomic.Pointer[func(backend CaptureFunc) CaptureFunc]
+
+// SetCaptureHook makes Capture, and every function and capturer built on it, call
+// hook(backend) instead of the platform backend, e.g. to post-process every image
+// before it is returned. A nil hook restores the backend.
+func SetCaptureHook(hook func(backend CaptureFunc) CaptureFunc) {
+	if hook == nil {
+		captureHook.Store(nil)
+		return
+	}
+	captureHook.Store(&hook)
+}
+
+func hooked(backend CaptureFunc) CaptureFunc {
+	if hook := captureHook.Load(); hook != nil {
+		return (*hook)(backend)
+	}
+	return backend
+}
+
 // CaptureDisplay captures whole region of displayIndex'th display, starts at 0 for primary display.
 func CaptureDisplay(displayIndex int) (*image.RGBA, error) {
 	rect, err := GetDisplayBounds(displayIndex)
@@ -41,29 +152,92 @@ func CaptureRect(rect image.Rectangle) (*image.RGBA, error) {
 	return Capture(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
 }
 
-// NumActiveDisplays возвращает количество мониторов
-func NumActiveDisplays() int {
-	count := 0
-	pinner := new(runtime.Pinner)
-	pinner.Pin(&count)
-	defer pinner.Unpin()
+// DisplayImage is a capture of a single display.
+// Bounds is the display rectangle in desktop coordinates, Image starts at (0, 0).
+type DisplayImage struct {
+	Index  int
+	Bounds image.Rectangle
+	Image  *image.RGBA
+	// ColorSpace is the colour space of Image, that of the display or the one
+	// converted to with CaptureOptions.ColorSpace. It is nil when unknown, e.g.
+	// for custom capturers or on Wayland.
+	ColorSpace *icc.Profile
+}
 
-	callback := syscall.NewCallback(func(hMonitor win.HMONITOR, hdc win.HDC, lprc *win.RECT, dwData uintptr) uintptr {
-		// Увеличиваем счётчик при каждом вызове callback'а
-		if dwData != 0 {
-			countPtr := (*int)(unsafe.Pointer(dwData))
-			*countPtr++
-		}
-		return 1 // продолжить перечисление
-	})
+// CaptureAllDisplays captures every active display in a single backend pass
+// and returns one image per display, in display index order.
+// The union of all displays is grabbed at once and then split, so all images
+// are taken at the same instant as far as the backend allows.
+func CaptureAllDisplays() ([]DisplayImage, error) {
+	bounds, err := GetAllDisplayBounds()
+	if err != nil {
+		return nil, err
+	}
+	displays, err := captureDisplays(Capture, bounds)
+	if err != nil {
+		return nil, err
+	}
+	setColorSpaces(DefaultCapturer(), displays, bounds)
+	return displays, nil
+}
 
-	// Передаём указатель на count через dwData
-	win_cap.EnumDisplayMonitors(win.HDC(0), nil, callback, uintptr(unsafe.Pointer(&count)))
+// CaptureAllDisplaysWith is like CaptureAllDisplays but uses the given capturer.
+func CaptureAllDisplaysWith(c ScreenCapturer) ([]DisplayImage, error) {
+	bounds, err := c.GetAllDisplayBounds()
+	if err != nil {
+		return nil, err
+	}
+	displays, err := captureDisplays(c.Capture, bounds)
+	if err != nil {
+		return nil, err
+	}
+	setColorSpaces(c, displays, bounds)
+	return displays, nil
+}
+
+func captureDisplays(capture func(x, y, width, height int) (*image.RGBA, error), bounds []image.Rectangle) ([]DisplayImage, error) {
+	if len(bounds) == 0 {
+		return nil, errors.New("no active displays")
+	}
+
+	var all image.Rectangle
+	for _, b := range bounds {
+		all = all.Union(b)
+	}
+	if all.Empty() {
+		return nil, errors.New("no valid display area found")
+	}
+
+	img, err := capture(all.Min.X, all.Min.Y, all.Dx(), all.Dy())
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]DisplayImage, len(bounds))
+	for i, b := range bounds {
+		result[i] = DisplayImage{
+			Index:  i,
+			Bounds: b,
+			Image:  cropImage(img, b.Sub(all.Min)),
+		}
+	}
+	return result, nil
+}
 
-	return count
+// cropImage copies rect of src (in src's own coordinates, relative to src.Rect.Min)
+// into a new image starting at (0, 0).
+func cropImage(src *image.RGBA, rect image.Rectangle) *image.RGBA {
+	rect = rect.Add(src.Rect.Min).Intersect(src.Rect)
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	rowLen := rect.Dx() * 4
+	for y := 0; y < rect.Dy(); y++ {
+		si := src.PixOffset(rect.Min.X, rect.Min.Y+y)
+		copy(dst.Pix[y*dst.Stride:y*dst.Stride+rowLen], src.Pix[si:si+rowLen])
+	}
+	return dst
 }
 
-/*func createImage(rect image.Rectangle) (img *image.RGBA, e error) {
+func createImage(rect image.Rectangle) (img *image.RGBA, e error) {
 	img = nil
 	e = errors.New("Cannot create image.RGBA ")
 
@@ -77,4 +251,4 @@ func NumActiveDisplays() int {
 	img = image.NewRGBA(rect)
 
 	return img, e
-}*/
+}
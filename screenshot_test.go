@@ -1,10 +1,18 @@
 package screenshot
 
 import (
+	"image"
+	"image/color"
+	"slices"
 	"testing"
+
+	"github.com/Fast-IQ/screenshot/icc"
 )
 
 func TestCaptureRect(t *testing.T) {
+	if NumActiveDisplays() == 0 {
+		t.Skip("No displays found")
+	}
 	bounds, err := GetDisplayBounds(0)
 	if err != nil {
 		t.Error(err)
@@ -16,6 +24,9 @@ func TestCaptureRect(t *testing.T) {
 }
 
 func BenchmarkCaptureRect(t *testing.B) {
+	if NumActiveDisplays() == 0 {
+		t.Skip("No displays found")
+	}
 	bounds, err := GetDisplayBounds(0)
 	if err != nil {
 		t.Error(err)
@@ -28,3 +39,277 @@ func BenchmarkCaptureRect(t *testing.B) {
 		}
 	}
 }
+
+func TestCaptureDisplays(t *testing.T) {
+	bounds := []image.Rectangle{
+		image.Rect(0, 0, 4, 3),
+		image.Rect(4, -2, 6, 2),
+	}
+	calls := 0
+	capture := func(x, y, width, height int) (*image.RGBA, error) {
+		calls++
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for iy := 0; iy < height; iy++ {
+			for ix := 0; ix < width; ix++ {
+				img.SetRGBA(ix, iy, color.RGBA{uint8(x + ix), uint8(y + iy), 0, 255})
+			}
+		}
+		return img, nil
+	}
+
+	images, err := captureDisplays(capture, bounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("capture called %d times, want 1", calls)
+	}
+	if len(images) != len(bounds) {
+		t.Fatalf("got %d images, want %d", len(images), len(bounds))
+	}
+	for i, d := range images {
+		if d.Index != i || d.Bounds != bounds[i] {
+			t.Errorf("display %d: got index %d bounds %v", i, d.Index, d.Bounds)
+		}
+		if d.Image.Rect != image.Rect(0, 0, bounds[i].Dx(), bounds[i].Dy()) {
+			t.Errorf("display %d: image rect %v", i, d.Image.Rect)
+		}
+		want := color.RGBA{uint8(bounds[i].Min.X), uint8(bounds[i].Min.Y), 0, 255}
+		if got := d.Image.RGBAAt(0, 0); got != want {
+			t.Errorf("display %d: top-left pixel %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestStitchDisplays(t *testing.T) {
+	solid := func(w, h int, c color.RGBA) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+		}
+		return img
+	}
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	gap := color.RGBA{1, 2, 3, 255}
+	displays := []DisplayImage{
+		{Index: 0, Bounds: image.Rect(0, 0, 4, 4), Image: solid(4, 4, red)},
+		{Index: 1, Bounds: image.Rect(-6, 2, -4, 4), Image: solid(2, 2, blue)},
+	}
+
+	vd := stitchDisplays(displays, VirtualDesktopOptions{Fill: gap})
+	if vd.Image.Rect != image.Rect(0, 0, 10, 4) {
+		t.Fatalf("image rect %v", vd.Image.Rect)
+	}
+	if vd.Layout[0] != image.Rect(6, 0, 10, 4) || vd.Layout[1] != image.Rect(0, 2, 2, 4) {
+		t.Errorf("layout %v", vd.Layout)
+	}
+	if got := vd.Image.RGBAAt(0, 0); got != gap {
+		t.Errorf("gap pixel %v, want %v", got, gap)
+	}
+	if got := vd.Image.RGBAAt(1, 3); got != blue {
+		t.Errorf("display 1 pixel %v, want %v", got, blue)
+	}
+
+	vd = stitchDisplays(displays, VirtualDesktopOptions{Compact: true})
+	if vd.Image.Rect != image.Rect(0, 0, 6, 4) {
+		t.Fatalf("compact image rect %v", vd.Image.Rect)
+	}
+	if vd.Layout[1] != image.Rect(0, 0, 2, 2) || vd.Layout[0] != image.Rect(2, 0, 6, 4) {
+		t.Errorf("compact layout %v", vd.Layout)
+	}
+	if got := vd.Image.RGBAAt(0, 3); got != (color.RGBA{}) {
+		t.Errorf("unfilled gap pixel %v, want transparent", got)
+	}
+}
+
+func TestCaptureHook(t *testing.T) {
+	defer SetCaptureHook(nil)
+	var called bool
+	SetCaptureHook(func(backend CaptureFunc) CaptureFunc {
+		return func(x, y, width, height int) (*image.RGBA, error) {
+			called = true
+			return image.NewRGBA(image.Rect(0, 0, width, height)), nil
+		}
+	})
+	img, err := CaptureRect(image.Rect(10, 10, 30, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called || img.Rect != image.Rect(0, 0, 20, 10) {
+		t.Errorf("hook not used: called %v, image %v", called, img.Rect)
+	}
+
+	SetCaptureHook(nil)
+	called = false
+	_, _ = Capture(0, 0, 1, 1)
+	if called {
+		t.Error("hook still used after removal")
+	}
+}
+
+// gridCapturer renders pixel (x, y) as {x, y, 7, 255} and counts the pixels captured.
+type gridCapturer struct {
+	defaultCapturer
+	pixels int
+}
+
+func (g *gridCapturer) Capture(x, y, width, height int) (*image.RGBA, error) {
+	g.pixels += width * height
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for iy := range height {
+		for ix := range width {
+			img.SetRGBA(ix, iy, color.RGBA{uint8(x + ix), uint8(y + iy), 7, 255})
+		}
+	}
+	return img, nil
+}
+
+func TestColorsAt(t *testing.T) {
+	g := &gridCapturer{}
+	points := []image.Point{{10, 20}, {12, 25}, {10, 20}}
+	colors, err := ColorsAtWith(g, points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range points {
+		if want := (color.RGBA{uint8(p.X), uint8(p.Y), 7, 255}); colors[i] != want {
+			t.Errorf("color at %v = %v, want %v", p, colors[i], want)
+		}
+	}
+	if g.pixels != 3*6 {
+		t.Errorf("captured %d pixels, want the 3×6 bounding box", g.pixels)
+	}
+
+	// Points far apart are captured alone.
+	g.pixels = 0
+	colors, err = ColorsAtWith(g, []image.Point{{0, 0}, {1000, 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.pixels != 2 || colors[1] != (color.RGBA{1000 % 256, 1000 % 256, 7, 255}) {
+		t.Errorf("captured %d pixels, colors %v", g.pixels, colors)
+	}
+
+	c, err := AverageColorWith(g, image.Rect(10, 10, 13, 12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (color.RGBA{11, 11, 7, 255}); c != want {
+		t.Errorf("AverageColorWith = %v, want %v", c, want)
+	}
+	if _, err := AverageColorWith(g, image.Rectangle{}); err == nil {
+		t.Error("empty rectangle accepted")
+	}
+}
+
+func TestColorsAtHook(t *testing.T) {
+	defer SetCaptureHook(nil)
+	g := &gridCapturer{}
+	SetCaptureHook(func(backend CaptureFunc) CaptureFunc {
+		return g.Capture
+	})
+	c, err := ColorAt(3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (color.RGBA{3, 4, 7, 255}); c != want || g.pixels != 1 {
+		t.Errorf("ColorAt = %v after capturing %d pixels, want %v through the hook", c, g.pixels, want)
+	}
+}
+
+func TestWatchAreaWith(t *testing.T) {
+	g := &gridCapturer{}
+	a, err := WatchAreaWith(g, image.Rect(5, 6, 9, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if a.Damaged() != nil {
+		t.Error("damage reported for a custom capturer")
+	}
+	img, err := a.Capture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Rect.Dx() != 4 || img.Rect.Dy() != 2 || img.RGBAAt(1, 1) != (color.RGBA{6, 7, 7, 255}) || g.pixels != 8 {
+		t.Errorf("captured %v after %d pixels", img.Rect, g.pixels)
+	}
+	if _, err := WatchAreaWith(g, image.Rectangle{}); err == nil {
+		t.Error("empty rectangle accepted")
+	}
+}
+
+func TestConvertDisplays(t *testing.T) {
+	// A capture of x 5 to 35: display 0 up to 15, display 1 from 15 to 25 and
+	// mirrored by display 2, nothing after.
+	rect := image.Rect(5, 0, 35, 10)
+	bounds := []image.Rectangle{image.Rect(0, 0, 15, 10), image.Rect(15, 0, 25, 10), image.Rect(15, 0, 25, 10)}
+	spaces := []*icc.Profile{icc.SRGB, icc.DisplayP3, nil}
+	source := func(i int) (*icc.Profile, error) {
+		if spaces[i] == nil {
+			return nil, ErrUnsupported
+		}
+		return spaces[i], nil
+	}
+	red := color.RGBA{255, 0, 0, 255}
+	img := image.NewRGBA(image.Rect(0, 0, 30, 10))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+3] = 255, 255
+	}
+	if err := convertDisplays(img, rect, bounds, source, icc.DisplayP3); err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range map[int]color.RGBA{0: {234, 51, 35, 255}, 9: {234, 51, 35, 255}, 10: red, 19: red, 20: red, 29: red} {
+		if got := img.RGBAAt(x, 5); got != want {
+			t.Errorf("pixel at %d = %v, want %v", x, got, want)
+		}
+	}
+
+	_, bad := icc.Parse([]byte("not a profile"))
+	source = func(int) (*icc.Profile, error) { return nil, bad }
+	if err := convertDisplays(img, rect, bounds, source, icc.SRGB); err == nil {
+		t.Error("profile error ignored")
+	}
+}
+
+func TestDisplayColorSpaceCache(t *testing.T) {
+	colorCache.Lock()
+	layout, displays := colorCache.layout, colorCache.displays
+	bounds := []image.Rectangle{image.Rect(0, 0, 40, 30), image.Rect(40, 0, 60, 10)}
+	colorCache.layout, colorCache.displays = slices.Clone(bounds), map[int]*icc.Profile{1: icc.DisplayP3}
+	colorCache.Unlock()
+	defer func() {
+		colorCache.Lock()
+		colorCache.layout, colorCache.displays = layout, displays
+		colorCache.Unlock()
+	}()
+
+	if p, err := displayColorSpace(1, bounds); p != icc.DisplayP3 || err != nil {
+		t.Errorf("cached display = %v, %v, want Display P3", p, err)
+	}
+	if _, err := displayColorSpace(2, bounds); err == nil {
+		t.Error("no error for display 2 of 2")
+	}
+	// Another layout reads the profile again, here of the real display if any.
+	moved := []image.Rectangle{bounds[0], bounds[1].Add(image.Pt(0, 5))}
+	if p, err := displayColorSpace(1, moved); p == icc.DisplayP3 && err == nil {
+		t.Error("profile of the previous layout returned")
+	}
+}
+
+func TestCaptureRGBA64Hook(t *testing.T) {
+	defer SetCaptureHook(nil)
+	g := &gridCapturer{}
+	SetCaptureHook(func(CaptureFunc) CaptureFunc { return g.Capture })
+	img, err := CaptureRGBA64(10, 20, 4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Rect != image.Rect(0, 0, 4, 3) {
+		t.Fatalf("image rect %v", img.Rect)
+	}
+	if got, want := img.RGBA64At(3, 2), (color.RGBA64{13 * 0x101, 22 * 0x101, 7 * 0x101, 0xffff}); got != want {
+		t.Errorf("pixel (3, 2) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,183 @@
+// Package screenshottest provides a headless screenshot.ScreenCapturer for tests.
+//
+// The fake has a configurable virtual display layout and frame content, and can
+// simulate slow backends, capture failures and display hotplug, so code built on
+// top of the screenshot package can be tested without a real desktop.
+//
+// Assert compares captures against golden images under testdata, rewritten by
+// running the tests with -update, or SCREENSHOTTEST_UPDATE=1.
+package screenshottest
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"sync"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+)
+
+// ErrNoDisplays is returned by display queries when the layout is empty,
+// e.g. after every display was unplugged.
+var ErrNoDisplays = errors.New("screenshottest: no displays")
+
+var _ screenshot.ScreenCapturer = (*Capturer)(nil)
+
+// Capturer is a fake screenshot.ScreenCapturer. It is safe for concurrent use.
+//
+// Coordinates follow the screenshot package: the primary display (index 0)
+// is expected to start at (0, 0). Desktop areas not covered by any display
+// are captured as opaque black, like the X11 backend does.
+type Capturer struct {
+	mu       sync.Mutex
+	displays []image.Rectangle
+	content  Content
+	latency  time.Duration
+	err      error
+	failNext []error
+	frame    int
+	captures int
+}
+
+// New returns a Capturer with the given display layout, filled with opaque white.
+func New(displays ...image.Rectangle) *Capturer {
+	c := &Capturer{
+		content: Solid(color.White),
+	}
+	c.SetDisplays(displays...)
+	return c
+}
+
+// SetContent replaces the frame content.
+func (c *Capturer) SetContent(content Content) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.content = content
+}
+
+// SetLatency makes every subsequent Capture sleep for d before returning.
+func (c *Capturer) SetLatency(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latency = d
+}
+
+// SetError makes every subsequent call fail with err. nil clears the error.
+func (c *Capturer) SetError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+// FailNext queues errors returned by the next Capture calls, one per call.
+func (c *Capturer) FailNext(errs ...error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failNext = append(c.failNext, errs...)
+}
+
+// SetDisplays replaces the display layout, simulating a hotplug event.
+func (c *Capturer) SetDisplays(displays ...image.Rectangle) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.displays = append([]image.Rectangle(nil), displays...)
+}
+
+// AddDisplay plugs in a display and returns its index.
+func (c *Capturer) AddDisplay(bounds image.Rectangle) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.displays = append(c.displays, bounds)
+	return len(c.displays) - 1
+}
+
+// RemoveDisplay unplugs the displayIndex'th display. Displays after it shift down by one.
+func (c *Capturer) RemoveDisplay(displayIndex int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if displayIndex < 0 || displayIndex >= len(c.displays) {
+		return fmt.Errorf("invalid display index: %d", displayIndex)
+	}
+	c.displays = append(c.displays[:displayIndex], c.displays[displayIndex+1:]...)
+	return nil
+}
+
+// Captures returns the number of Capture calls made so far, including failed ones.
+func (c *Capturer) Captures() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.captures
+}
+
+// Capture renders the current content for the given desktop region.
+func (c *Capturer) Capture(x, y, width, height int) (*image.RGBA, error) {
+	c.mu.Lock()
+	c.captures++
+	latency := c.latency
+	err := c.err
+	if err == nil && len(c.failNext) > 0 {
+		err = c.failNext[0]
+		c.failNext = c.failNext[1:]
+	}
+	c.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("width or height should be > 0")
+	}
+
+	c.mu.Lock()
+	displays := append([]image.Rectangle(nil), c.displays...)
+	content := c.content
+	frame := c.frame
+	c.frame++
+	c.mu.Unlock()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for iy := 0; iy < height; iy++ {
+		for ix := 0; ix < width; ix++ {
+			p := image.Point{X: x + ix, Y: y + iy}
+			col := color.RGBA{A: 255}
+			for _, d := range displays {
+				if p.In(d) {
+					col = content(frame, p.X, p.Y)
+					break
+				}
+			}
+			img.SetRGBA(ix, iy, col)
+		}
+	}
+	return img, nil
+}
+
+// GetDisplayBounds returns the bounds of displayIndex'th display.
+func (c *Capturer) GetDisplayBounds(displayIndex int) (image.Rectangle, error) {
+	bounds, err := c.GetAllDisplayBounds()
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	if displayIndex < 0 || displayIndex >= len(bounds) {
+		return image.Rectangle{}, fmt.Errorf("invalid display index: %d", displayIndex)
+	}
+	return bounds[displayIndex], nil
+}
+
+// GetAllDisplayBounds returns the current display layout.
+func (c *Capturer) GetAllDisplayBounds() ([]image.Rectangle, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	if len(c.displays) == 0 {
+		return nil, ErrNoDisplays
+	}
+	return append([]image.Rectangle(nil), c.displays...), nil
+}
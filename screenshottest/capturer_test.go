@@ -0,0 +1,172 @@
+package screenshottest
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+)
+
+func TestCapturerContent(t *testing.T) {
+	c := New(image.Rect(0, 0, 4, 4), image.Rect(6, 0, 8, 2))
+	red := color.RGBA{255, 0, 0, 255}
+	c.SetContent(Solid(red))
+
+	img, err := c.Capture(0, 0, 8, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.RGBAAt(1, 1); got != red {
+		t.Errorf("display pixel %v, want %v", got, red)
+	}
+	if got := img.RGBAAt(5, 0); got != (color.RGBA{A: 255}) {
+		t.Errorf("gap pixel %v, want opaque black", got)
+	}
+	if got := img.RGBAAt(7, 3); got != (color.RGBA{A: 255}) {
+		t.Errorf("pixel below display 1 %v, want opaque black", got)
+	}
+}
+
+func TestCapturerMovingPattern(t *testing.T) {
+	c := New(image.Rect(0, 0, 8, 1))
+	black, white := color.RGBA{A: 255}, color.RGBA{255, 255, 255, 255}
+	c.SetContent(Checkerboard(2, 1, black, white))
+
+	first, _ := c.Capture(0, 0, 8, 1)
+	second, _ := c.Capture(0, 0, 8, 1)
+	for x := 1; x < 8; x++ {
+		if first.RGBAAt(x-1, 0) != second.RGBAAt(x, 0) {
+			t.Fatalf("frame 1 is not frame 0 shifted right by one pixel at x=%d", x)
+		}
+	}
+}
+
+func TestCapturerGradient(t *testing.T) {
+	r := image.Rect(0, 0, 5, 1)
+	c := New(r)
+	c.SetContent(Gradient(r, color.Black, color.White))
+	img, _ := c.Capture(0, 0, 5, 1)
+	for x, want := range []uint8{0, 64, 128, 191, 255} {
+		if got := img.RGBAAt(x, 0).R; got != want {
+			t.Errorf("x=%d: got %d, want %d", x, got, want)
+		}
+	}
+}
+
+func TestCapturerFrames(t *testing.T) {
+	c := New(image.Rect(0, 0, 1, 1))
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	c.SetContent(Frames(Solid(red), Solid(blue)))
+	for i, want := range []color.RGBA{red, blue, red} {
+		img, _ := c.Capture(0, 0, 1, 1)
+		if got := img.RGBAAt(0, 0); got != want {
+			t.Errorf("frame %d: %v, want %v", i, got, want)
+		}
+	}
+
+	c.SetContent(Frames())
+	img, _ := c.Capture(0, 0, 1, 1)
+	if got := img.RGBAAt(0, 0); got != (color.RGBA{}) {
+		t.Errorf("no frames: %v, want transparent black", got)
+	}
+}
+
+func TestCapturerErrors(t *testing.T) {
+	c := New(image.Rect(0, 0, 2, 2))
+	errBoom := errors.New("boom")
+
+	c.FailNext(errBoom)
+	if _, err := c.Capture(0, 0, 1, 1); !errors.Is(err, errBoom) {
+		t.Errorf("first capture: got %v, want %v", err, errBoom)
+	}
+	if _, err := c.Capture(0, 0, 1, 1); err != nil {
+		t.Errorf("second capture: %v", err)
+	}
+
+	c.SetError(errBoom)
+	if _, err := c.GetAllDisplayBounds(); !errors.Is(err, errBoom) {
+		t.Errorf("GetAllDisplayBounds: got %v, want %v", err, errBoom)
+	}
+	c.SetError(nil)
+
+	c.SetLatency(20 * time.Millisecond)
+	start := time.Now()
+	if _, err := c.Capture(0, 0, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("capture returned after %v, want >= 20ms", elapsed)
+	}
+	if n := c.Captures(); n != 3 {
+		t.Errorf("Captures() = %d, want 3", n)
+	}
+}
+
+func TestCapturerHotplug(t *testing.T) {
+	c := New(image.Rect(0, 0, 4, 4))
+	i := c.AddDisplay(image.Rect(4, 0, 6, 2))
+	displays, err := screenshot.CaptureAllDisplaysWith(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(displays) != 2 || displays[i].Bounds != image.Rect(4, 0, 6, 2) {
+		t.Fatalf("after plug: %+v", displays)
+	}
+
+	if err := c.RemoveDisplay(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetDisplayBounds(1); err == nil {
+		t.Error("expected error for unplugged display index")
+	}
+	if err := c.RemoveDisplay(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetAllDisplayBounds(); !errors.Is(err, ErrNoDisplays) {
+		t.Errorf("got %v, want ErrNoDisplays", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	c := New(image.Rect(0, 0, 8, 8))
+	c.SetContent(Checkerboard(2, 1, color.Black, color.White))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var frames []screenshot.Frame
+	err := screenshot.Stream(ctx, screenshot.StreamOptions{FPS: 100, Capturer: c}, func(f screenshot.Frame) error {
+		frames = append(frames, f)
+		if len(frames) == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames, want 3", len(frames))
+	}
+	for i, f := range frames {
+		if f.Seq != uint64(i) || f.Rect != image.Rect(0, 0, 8, 8) {
+			t.Errorf("frame %d: seq %d rect %v", i, f.Seq, f.Rect)
+		}
+		// The checkerboard moves every frame, so its only tile is always dirty.
+		if len(f.Dirty) != 1 || f.Dirty[0] != image.Rect(0, 0, 8, 8) {
+			t.Errorf("frame %d: dirty %v", i, f.Dirty)
+		}
+	}
+
+	errBoom := errors.New("boom")
+	c.FailNext(errBoom)
+	err = screenshot.Stream(context.Background(), screenshot.StreamOptions{Capturer: c}, func(screenshot.Frame) error {
+		return nil
+	})
+	if !errors.Is(err, errBoom) {
+		t.Errorf("got %v, want %v", err, errBoom)
+	}
+}
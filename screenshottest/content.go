@@ -0,0 +1,94 @@
+package screenshottest
+
+import (
+	"image"
+	"image/color"
+)
+
+// Content returns the color of desktop pixel (x, y) in the frame'th capture.
+// frame starts at 0 and is incremented by every successful capture.
+type Content func(frame, x, y int) color.RGBA
+
+// Solid fills the whole desktop with c.
+func Solid(c color.Color) Content {
+	rgba := toRGBA(c)
+	return func(frame, x, y int) color.RGBA {
+		return rgba
+	}
+}
+
+// Gradient is a horizontal linear gradient from `from` at r.Min.X to `to` at r.Max.X-1.
+// Pixels outside r are clamped to the nearest edge color.
+func Gradient(r image.Rectangle, from, to color.Color) Content {
+	a, b := toRGBA(from), toRGBA(to)
+	span := r.Dx() - 1
+	return func(frame, x, y int) color.RGBA {
+		t := x - r.Min.X
+		if t <= 0 || span <= 0 {
+			return a
+		}
+		if t >= span {
+			return b
+		}
+		return color.RGBA{
+			R: lerp(a.R, b.R, t, span),
+			G: lerp(a.G, b.G, t, span),
+			B: lerp(a.B, b.B, t, span),
+			A: lerp(a.A, b.A, t, span),
+		}
+	}
+}
+
+// Checkerboard draws size×size squares alternating between a and b.
+// Every frame the pattern is shifted by speed pixels to the right, speed 0 keeps it static.
+func Checkerboard(size, speed int, a, b color.Color) Content {
+	ca, cb := toRGBA(a), toRGBA(b)
+	if size <= 0 {
+		size = 1
+	}
+	return func(frame, x, y int) color.RGBA {
+		if (floorDiv(x-frame*speed, size)+floorDiv(y, size))%2 == 0 {
+			return ca
+		}
+		return cb
+	}
+}
+
+// FromImage returns the pixels of img in desktop coordinates.
+// Pixels outside img bounds are opaque black.
+func FromImage(img image.Image) Content {
+	bounds := img.Bounds()
+	return func(frame, x, y int) color.RGBA {
+		if !(image.Point{X: x, Y: y}).In(bounds) {
+			return color.RGBA{A: 255}
+		}
+		return toRGBA(img.At(x, y))
+	}
+}
+
+// Frames cycles through the given contents, one per capture. Without contents,
+// every pixel is transparent black.
+func Frames(contents ...Content) Content {
+	if len(contents) == 0 {
+		return func(frame, x, y int) color.RGBA { return color.RGBA{} }
+	}
+	return func(frame, x, y int) color.RGBA {
+		return contents[frame%len(contents)](frame, x, y)
+	}
+}
+
+func toRGBA(c color.Color) color.RGBA {
+	return color.RGBAModel.Convert(c).(color.RGBA)
+}
+
+func lerp(a, b uint8, t, span int) uint8 {
+	return uint8((int(a)*(span-t) + int(b)*t + span/2) / span)
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,181 @@
+package screenshottest
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/Fast-IQ/screenshot/imgdiff"
+)
+
+// init registers the -update flag of the test binary, unless a package imported
+// earlier already defined one, which is then used instead. Test packages using
+// Assert should not define their own.
+func init() {
+	if flag.Lookup("update") == nil {
+		flag.Bool("update", false, "screenshottest: write golden images instead of comparing against them")
+	}
+}
+
+// updating reports whether to write the golden images instead of comparing against
+// them: when the tests run with -update, or with SCREENSHOTTEST_UPDATE set to true
+// for test binaries whose -update flag is not boolean. SCREENSHOTTEST_UPDATE=false
+// overrides the flag.
+func updating() bool {
+	if v, err := strconv.ParseBool(os.Getenv("SCREENSHOTTEST_UPDATE")); err == nil {
+		return v
+	}
+	f := flag.Lookup("update")
+	if f == nil {
+		return false
+	}
+	v, err := strconv.ParseBool(f.Value.String())
+	return err == nil && v
+}
+
+// AssertOptions configures AssertWith.
+type AssertOptions struct {
+	// Dir holds the golden images, default "testdata" in the package directory.
+	Dir string
+	// ArtifactDir receives the actual image and the diff image of failed
+	// assertions, default Dir/failures.
+	ArtifactDir string
+	// Tolerance is the largest difference of any channel, 0-255, for pixels that
+	// match.
+	Tolerance uint8
+	// AntiAliasing ignores pixels that differ in an anti-aliased edge only.
+	AntiAliasing bool
+	// Ignore lists rectangles that are not compared, relative to the top-left
+	// corner of the image, e.g. a clock.
+	Ignore []image.Rectangle
+	// Mask, if set, excludes the pixels where it is not fully transparent.
+	Mask image.Image
+	// MaxChanged is the number of pixels that may differ.
+	MaxChanged int
+}
+
+// Assert compares img against the golden image Dir/name.png with exact equality;
+// see AssertWith.
+func Assert(t testing.TB, img image.Image, name string) bool {
+	t.Helper()
+	return AssertWith(t, img, name, nil)
+}
+
+// AssertWith compares img against the golden image Dir/name.png, where name may
+// contain slashes. On a mismatch it reports the differences with t.Errorf and
+// writes name.actual.png and name.diff.png to ArtifactDir; they are removed again
+// once the assertion passes. With -update, or SCREENSHOTTEST_UPDATE=1 in the
+// environment, the golden image is written instead.
+// It reports whether the assertion passed.
+func AssertWith(t testing.TB, img image.Image, name string, opts *AssertOptions) bool {
+	t.Helper()
+	if opts == nil {
+		opts = &AssertOptions{}
+	}
+	dir := opts.Dir
+	if dir == "" {
+		dir = "testdata"
+	}
+	artifacts := opts.ArtifactDir
+	if artifacts == "" {
+		artifacts = filepath.Join(dir, "failures")
+	}
+	golden := filepath.Join(dir, filepath.FromSlash(name)+".png")
+	actual := filepath.Join(artifacts, filepath.FromSlash(name)+".actual.png")
+	diff := filepath.Join(artifacts, filepath.FromSlash(name)+".diff.png")
+
+	if updating() {
+		if err := writePNG(golden, img); err != nil {
+			t.Errorf("screenshottest: %v", err)
+			return false
+		}
+		removeArtifacts(actual, diff)
+		t.Logf("screenshottest: updated %s", golden)
+		return true
+	}
+
+	want, err := readPNG(golden)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("golden image %s missing, run the tests with -update to create it", golden)
+		}
+		t.Errorf("screenshottest: %s: %v", name, err)
+		failed(t, actual, img, diff, nil)
+		return false
+	}
+	res, err := imgdiff.Compare(img, want, &imgdiff.Options{
+		Tolerance:    opts.Tolerance,
+		AntiAliasing: opts.AntiAliasing,
+		Ignore:       opts.Ignore,
+		Mask:         opts.Mask,
+	})
+	if err != nil {
+		t.Errorf("screenshottest: %s: %v", name, err)
+		failed(t, actual, img, diff, nil)
+		return false
+	}
+	if res.Changed > opts.MaxChanged {
+		t.Errorf("screenshottest: %s differs from %s: %v %v", name, golden, res, res.Regions)
+		failed(t, actual, img, diff, res.Diff)
+		return false
+	}
+	removeArtifacts(actual, diff)
+	return true
+}
+
+// failed writes the artifacts of a failed assertion, the diff only if diffImg is
+// set; a stale one is removed otherwise.
+func failed(t testing.TB, actual string, img image.Image, diff string, diffImg image.Image) {
+	t.Helper()
+	if err := writePNG(actual, img); err != nil {
+		t.Logf("screenshottest: %v", err)
+		return
+	}
+	t.Logf("screenshottest: actual image written to %s", actual)
+	if diffImg == nil {
+		_ = os.Remove(diff)
+		return
+	}
+	if err := writePNG(diff, diffImg); err != nil {
+		t.Logf("screenshottest: %v", err)
+		return
+	}
+	t.Logf("screenshottest: diff image written to %s", diff)
+}
+
+func removeArtifacts(paths ...string) {
+	for _, p := range paths {
+		_ = os.Remove(p)
+	}
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return img, nil
+}
+
+func writePNG(path string, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,126 @@
+package screenshottest
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// recorder is a testing.TB recording failures instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper()                         {}
+func (r *recorder) Logf(format string, args ...any) {}
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestUpdating(t *testing.T) {
+	update := flag.Lookup("update")
+	if update == nil {
+		t.Fatal("-update flag not registered")
+	}
+	defer update.Value.Set(update.Value.String())
+	_ = update.Value.Set("false")
+	t.Setenv("SCREENSHOTTEST_UPDATE", "")
+	if updating() {
+		t.Error("updating without flag or environment")
+	}
+	_ = update.Value.Set("true")
+	if !updating() {
+		t.Error("-update flag not honoured")
+	}
+	t.Setenv("SCREENSHOTTEST_UPDATE", "false")
+	if updating() {
+		t.Error("SCREENSHOTTEST_UPDATE=false does not override the flag")
+	}
+}
+
+func TestAssert(t *testing.T) {
+	// Compare even when the tests run with -update.
+	t.Setenv("SCREENSHOTTEST_UPDATE", "false")
+	dir := t.TempDir()
+	c := New(image.Rect(0, 0, 40, 30))
+	c.SetContent(Gradient(image.Rect(0, 0, 40, 30), color.Black, color.White))
+	img, err := c.Capture(0, 0, 40, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &AssertOptions{Dir: dir}
+	golden := filepath.Join(dir, "ui", "panel.png")
+	actual := filepath.Join(dir, "failures", "ui", "panel.actual.png")
+	diff := filepath.Join(dir, "failures", "ui", "panel.diff.png")
+
+	// A missing golden image fails.
+	r := &recorder{}
+	if AssertWith(r, img, "ui/panel", opts) || len(r.errors) != 1 || !exists(actual) {
+		t.Fatalf("missing golden: %v", r.errors)
+	}
+
+	t.Setenv("SCREENSHOTTEST_UPDATE", "1")
+	r = &recorder{}
+	ok := AssertWith(r, img, "ui/panel", opts)
+	t.Setenv("SCREENSHOTTEST_UPDATE", "false")
+	if !ok || len(r.errors) != 0 || !exists(golden) || exists(actual) {
+		t.Fatalf("update: %v", r.errors)
+	}
+	r = &recorder{}
+	if !AssertWith(r, img, "ui/panel", opts) {
+		t.Fatalf("same image: %v", r.errors)
+	}
+
+	// A changed corner fails, with artifacts, unless ignored.
+	changed := image.NewRGBA(img.Rect)
+	copy(changed.Pix, img.Pix)
+	for y := range 3 {
+		for x := range 4 {
+			changed.Set(36+x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+	r = &recorder{}
+	if AssertWith(r, changed, "ui/panel", opts) || len(r.errors) != 1 || !exists(actual) || !exists(diff) {
+		t.Fatalf("changed image: %v", r.errors)
+	}
+	r = &recorder{}
+	opts.Ignore = []image.Rectangle{image.Rect(30, 0, 40, 5)}
+	if !AssertWith(r, changed, "ui/panel", opts) || exists(actual) || exists(diff) {
+		t.Fatalf("ignored change: %v", r.errors)
+	}
+	opts.Ignore = nil
+	opts.MaxChanged = 12
+	if !AssertWith(r, changed, "ui/panel", opts) {
+		t.Fatalf("MaxChanged: %v", r.errors)
+	}
+	opts.MaxChanged = 0
+
+	// Small differences pass within the tolerance.
+	noisy := image.NewRGBA(img.Rect)
+	for i, v := range img.Pix {
+		noisy.Pix[i] = v
+		if i%4 != 3 && v < 255 {
+			noisy.Pix[i]++
+		}
+	}
+	opts.Tolerance = 1
+	if !AssertWith(r, noisy, "ui/panel", opts) {
+		t.Fatalf("tolerance: %v", r.errors)
+	}
+
+	// A different size fails.
+	r = &recorder{}
+	if AssertWith(r, image.NewRGBA(image.Rect(0, 0, 20, 30)), "ui/panel", opts) || len(r.errors) != 1 {
+		t.Fatalf("size: %v", r.errors)
+	}
+}
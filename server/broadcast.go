@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"image"
+	"sync"
+
+	"github.com/Fast-IQ/screenshot"
+)
+
+// hub shares one screenshot.Stream per desktop rectangle between all viewers of that
+// rectangle. A stream starts with its first viewer, runs at the highest frame rate any
+// of its viewers asked for, and stops when the last viewer leaves.
+type hub struct {
+	capturer screenshot.ScreenCapturer
+
+	mu      sync.Mutex
+	streams map[image.Rectangle]*broadcast
+}
+
+// broadcast is the shared stream of one rectangle. Its fields are guarded by hub.mu.
+type broadcast struct {
+	rect    image.Rectangle
+	viewers map[*viewer]struct{}
+	fps     float64
+	cancel  context.CancelFunc
+}
+
+// viewer receives the latest frame of a broadcast. frames holds at most one frame and a
+// newer frame replaces an unread one, so slow viewers skip frames instead of lagging behind.
+type viewer struct {
+	rect   image.Rectangle
+	fps    float64
+	frames chan screenshot.Frame
+	// done is closed with err set when the stream stops because of a capture error.
+	done chan struct{}
+	err  error
+}
+
+func newHub(c screenshot.ScreenCapturer) *hub {
+	return &hub{
+		capturer: c,
+		streams:  make(map[image.Rectangle]*broadcast),
+	}
+}
+
+// subscribe adds a viewer of rect wanting fps frames per second.
+func (h *hub) subscribe(rect image.Rectangle, fps float64) *viewer {
+	v := &viewer{
+		rect:   rect,
+		fps:    fps,
+		frames: make(chan screenshot.Frame, 1),
+		done:   make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b, ok := h.streams[rect]
+	if !ok {
+		b = &broadcast{rect: rect, viewers: make(map[*viewer]struct{})}
+		h.streams[rect] = b
+	}
+	b.viewers[v] = struct{}{}
+	if b.cancel == nil || fps > b.fps {
+		h.start(b)
+	}
+	return v
+}
+
+// unsubscribe removes v and stops its stream when no viewer is left.
+func (h *hub) unsubscribe(v *viewer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b, ok := h.streams[v.rect]
+	if !ok {
+		return
+	}
+	if _, ok := b.viewers[v]; !ok {
+		return
+	}
+	delete(b.viewers, v)
+	if len(b.viewers) == 0 {
+		b.cancel()
+		delete(h.streams, v.rect)
+		return
+	}
+	// Slow down once the fastest viewer is gone.
+	fps := 0.0
+	for v := range b.viewers {
+		fps = max(fps, v.fps)
+	}
+	if fps < b.fps {
+		h.start(b)
+	}
+}
+
+// active returns the number of running streams.
+func (h *hub) active() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.streams)
+}
+
+// start (re)starts the stream of b at the highest frame rate of its viewers.
+// It must be called with h.mu held.
+func (h *hub) start(b *broadcast) {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.fps = 0
+	for v := range b.viewers {
+		b.fps = max(b.fps, v.fps)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	opts := screenshot.StreamOptions{
+		Rect:     b.rect,
+		FPS:      b.fps,
+		Capturer: h.capturer,
+	}
+	go func() {
+		err := screenshot.Stream(ctx, opts, func(f screenshot.Frame) error {
+			h.publish(b, f)
+			return nil
+		})
+		h.stopped(ctx, b, err)
+	}()
+}
+
+// publish hands f to the viewers of b. A frame replacing an unread one also carries
+// the unread frame's dirty tiles, so viewers sending changes only do not miss any.
+func (h *hub) publish(b *broadcast, f screenshot.Frame) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for v := range b.viewers {
+		vf := f
+		select {
+		case old := <-v.frames:
+			vf.Dirty = append(old.Dirty[:len(old.Dirty):len(old.Dirty)], f.Dirty...)
+		default:
+		}
+		v.frames <- vf
+	}
+}
+
+// stopped is called when the stream of b started with ctx returns err.
+// Unless the stream was canceled on purpose, its viewers are disconnected with err.
+func (h *hub) stopped(ctx context.Context, b *broadcast, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	b.cancel()
+	for v := range b.viewers {
+		v.err = err
+		close(v.done)
+	}
+	if h.streams[b.rect] == b {
+		delete(h.streams, b.rect)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"image"
+	"sync"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+)
+
+// frameCache serves captures of a desktop rectangle. Concurrent requests for the same
+// rectangle share one backend capture, finished captures are reused for ttl, and at
+// most cap(sem) backend captures run at the same time.
+type frameCache struct {
+	capturer screenshot.ScreenCapturer
+	ttl      time.Duration
+	sem      chan struct{}
+
+	mu      sync.Mutex
+	entries map[image.Rectangle]*cacheEntry
+}
+
+type cacheEntry struct {
+	done chan struct{} // closed once img/err/time are set
+	img  *image.RGBA
+	err  error
+	time time.Time
+}
+
+func newFrameCache(c screenshot.ScreenCapturer, ttl time.Duration, maxConcurrent int) *frameCache {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	return &frameCache{
+		capturer: c,
+		ttl:      ttl,
+		sem:      make(chan struct{}, maxConcurrent),
+		entries:  make(map[image.Rectangle]*cacheEntry),
+	}
+}
+
+// get returns a capture of rect that is at most ttl old. The returned image is shared
+// and must not be modified.
+func (c *frameCache) get(ctx context.Context, rect image.Rectangle) (*image.RGBA, time.Time, error) {
+	c.mu.Lock()
+	e, ok := c.entries[rect]
+	if ok && !c.usable(e) {
+		ok = false
+	}
+	if !ok {
+		c.prune()
+		e = &cacheEntry{done: make(chan struct{})}
+		c.entries[rect] = e
+		c.mu.Unlock()
+		c.capture(rect, e)
+	} else {
+		c.mu.Unlock()
+	}
+
+	select {
+	case <-e.done:
+		return e.img, e.time, e.err
+	case <-ctx.Done():
+		return nil, time.Time{}, ctx.Err()
+	}
+}
+
+// usable reports whether e is in flight or holds a fresh successful capture.
+// It must be called with c.mu held.
+func (c *frameCache) usable(e *cacheEntry) bool {
+	select {
+	case <-e.done:
+		return e.err == nil && time.Since(e.time) < c.ttl
+	default:
+		return true
+	}
+}
+
+// prune drops finished entries that can no longer be served. It must be called with c.mu held.
+func (c *frameCache) prune() {
+	for rect, e := range c.entries {
+		if !c.usable(e) {
+			delete(c.entries, rect)
+		}
+	}
+}
+
+// capture runs the backend capture for e in the background, so that a canceled
+// request does not fail the other requests waiting on the same entry.
+func (c *frameCache) capture(rect image.Rectangle, e *cacheEntry) {
+	go func() {
+		defer close(e.done)
+		c.sem <- struct{}{}
+		defer func() { <-c.sem }()
+
+		e.time = time.Now()
+		e.img, e.err = c.capturer.Capture(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+		if e.err != nil {
+			c.mu.Lock()
+			if c.entries[rect] == e {
+				delete(c.entries, rect)
+			}
+			c.mu.Unlock()
+		}
+	}()
+}
@@ -0,0 +1,64 @@
+// Package server exposes screen captures over HTTP.
+//
+// Endpoints:
+//
+//	GET /displays                      JSON list of displays
+//	GET /capture?display=0             capture of a whole display
+//	GET /capture/rect?x=0&y=0&w=640&h=480  capture of a desktop region
+//	GET /stream?display=0              MJPEG live stream of a display
+//	GET /stream/rect?x=0&y=0&w=640&h=480   MJPEG live stream of a desktop region
+//	GET /ws?display=0                  WebSocket tile stream of a display
+//	GET /ws/rect?x=0&y=0&w=640&h=480   WebSocket tile stream of a desktop region
+//
+// With a token configured, requests authenticate with an "Authorization: Bearer"
+// header or, for browsers that cannot set headers, an access_token query parameter.
+//
+// Regions are clipped to the displays; a region outside of them or larger than
+// Options.MaxPixels once clipped is rejected with 400 Bad Request.
+//
+// The capture endpoints accept format=png|jpeg|qoi|webp, quality=1..100 for JPEG
+// and scale=(0,1] to downscale the image. WebP is lossless.
+//
+// The stream endpoints send multipart/x-mixed-replace JPEG frames and accept
+// quality and scale as above plus fps=(0,30], default 5. All viewers of the same
+// region share one capture loop, which starts with the first viewer and stops
+// with the last; a client that cannot keep up skips frames.
+//
+// # Tile stream
+//
+// The WebSocket endpoints accept format=png|jpeg|webp (default jpeg), quality and fps
+// like the stream endpoints. Without a token, browsers may only connect from a
+// page of the same origin. All messages are binary WebSocket messages with
+// big-endian integers.
+//
+// The server sends frame messages:
+//
+//	u8   type = 1
+//	u8   flags, bit 0 set for a keyframe
+//	u32  seq, incremented with every frame message
+//	u16  width, u16 height of the whole image
+//	u16  number of tiles, each of them:
+//	     u16 x, u16 y, u16 width, u16 height   position in the image
+//	     u8  format: 1 = PNG, 2 = JPEG, 3 = WebP
+//	     u32 length, followed by length bytes of encoded image
+//
+// A keyframe holds a single tile covering the whole image and is sent first and
+// on request; the client resets its image to width×height. Other frames only hold
+// the tiles that changed since the previous frame message and are drawn over the
+// current image. Unknown message types are to be ignored by both sides.
+//
+// The client acknowledges every frame message once it is drawn:
+//
+//	u8   type = 1
+//	u32  seq of the frame
+//
+// and may ask for a keyframe, e.g. after it lost its image:
+//
+//	u8   type = 2
+//
+// At most two frames are unacknowledged at any time; while a client lags behind,
+// its changes accumulate and are sent together later. The round trip times of the
+// acknowledgements give the client's throughput, and the JPEG quality is lowered,
+// down to 20, while frames take longer than the frame interval to arrive and raised
+// back up to the requested quality when they are fast.
+package server
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/Fast-IQ/screenshot/encode"
+	"github.com/Fast-IQ/screenshot/internal/imgutil"
+)
+
+const (
+	defaultStreamFPS = 5
+	maxStreamFPS     = 30
+)
+
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	rect, status, err := s.displayRect(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	s.serveMJPEG(w, r, rect)
+}
+
+func (s *Server) handleStreamRect(w http.ResponseWriter, r *http.Request) {
+	rect, status, err := s.paramRect(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	s.serveMJPEG(w, r, rect)
+}
+
+// serveMJPEG streams rect as multipart/x-mixed-replace JPEG frames until the client
+// goes away. The frames come from the capture loop shared by all viewers of rect;
+// each client gets at most its own fps, older frames are dropped when it falls behind.
+func (s *Server) serveMJPEG(w http.ResponseWriter, r *http.Request, rect image.Rectangle) {
+	q := r.URL.Query()
+	if f := q.Get("format"); f != "" && f != "jpeg" && f != "jpg" {
+		http.Error(w, "format: stream only supports jpeg", http.StatusBadRequest)
+		return
+	}
+	enc, err := parseEncoding(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	enc.format = encode.FormatJPEG
+	fps, err := fpsParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	interval := time.Duration(float64(time.Second) / fps)
+
+	v := s.streams.subscribe(rect, fps)
+	defer s.streams.unsubscribe(v)
+
+	mw := multipart.NewWriter(w)
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	var buf bytes.Buffer
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-v.done:
+			// The headers are gone already, ending the response is all that is left.
+			return
+		case f := <-v.frames:
+			sent := time.Now()
+			buf.Reset()
+			if err := enc.encode(&buf, imgutil.Downscale(f.Image, enc.scale)); err != nil {
+				return
+			}
+			part, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":   {"image/jpeg"},
+				"Content-Length": {strconv.Itoa(buf.Len())},
+				"X-Capture-Time": {f.Time.UTC().Format(time.RFC3339Nano)},
+			})
+			if err != nil {
+				return
+			}
+			if _, err := buf.WriteTo(part); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+
+			// Hold back until this client's next frame is due; frames published
+			// in the meantime replace each other in v.frames.
+			wait := time.NewTimer(interval - time.Since(sent))
+			select {
+			case <-r.Context().Done():
+				wait.Stop()
+				return
+			case <-wait.C:
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/encode"
+	"github.com/Fast-IQ/screenshot/internal/imgutil"
+)
+
+// Options configures a Server.
+type Options struct {
+	// Capturer is the capture backend, nil means screenshot.DefaultCapturer().
+	Capturer screenshot.ScreenCapturer
+	// CacheTTL is how long a captured frame is reused for identical requests.
+	// Zero only shares captures between concurrent requests.
+	CacheTTL time.Duration
+	// MaxConcurrent limits the number of backend captures running at once, default 1.
+	MaxConcurrent int
+	// Token enables bearer token authentication when not empty.
+	Token string
+	// MaxPixels limits the area of the rectangles requested from /capture/rect,
+	// /stream/rect and /ws/rect once clipped to the desktop, default DefaultMaxPixels.
+	MaxPixels int
+}
+
+// DefaultMaxPixels is the default Options.MaxPixels, an 8192x8192 area.
+const DefaultMaxPixels = 8192 * 8192
+
+// Server is an http.Handler serving display information and captures.
+type Server struct {
+	capturer  screenshot.ScreenCapturer
+	token     string
+	maxPixels int
+	frames    *frameCache
+	streams   *hub
+	mux       *http.ServeMux
+}
+
+// Display is the JSON representation of a display returned by /displays.
+type Display struct {
+	Index   int  `json:"index"`
+	X       int  `json:"x"`
+	Y       int  `json:"y"`
+	Width   int  `json:"width"`
+	Height  int  `json:"height"`
+	Primary bool `json:"primary"`
+}
+
+// New returns a Server configured by opts.
+func New(opts Options) *Server {
+	c := opts.Capturer
+	if c == nil {
+		c = screenshot.DefaultCapturer()
+	}
+	maxPixels := opts.MaxPixels
+	if maxPixels <= 0 {
+		maxPixels = DefaultMaxPixels
+	}
+	s := &Server{
+		capturer:  c,
+		token:     opts.Token,
+		maxPixels: maxPixels,
+		frames:    newFrameCache(c, opts.CacheTTL, opts.MaxConcurrent),
+		streams:   newHub(c),
+		mux:       http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /displays", s.handleDisplays)
+	s.mux.HandleFunc("GET /capture", s.handleCapture)
+	s.mux.HandleFunc("GET /capture/rect", s.handleCaptureRect)
+	s.mux.HandleFunc("GET /stream", s.handleStream)
+	s.mux.HandleFunc("GET /stream/rect", s.handleStreamRect)
+	s.mux.HandleFunc("GET /ws", s.handleTiles)
+	s.mux.HandleFunc("GET /ws/rect", s.handleTilesRect)
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="screenshot"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok {
+		// Browsers cannot set headers on WebSocket or <img> requests.
+		token = r.URL.Query().Get("access_token")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+func (s *Server) handleDisplays(w http.ResponseWriter, r *http.Request) {
+	bounds, err := s.capturer.GetAllDisplayBounds()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	displays := make([]Display, len(bounds))
+	for i, b := range bounds {
+		displays[i] = Display{
+			Index:   i,
+			X:       b.Min.X,
+			Y:       b.Min.Y,
+			Width:   b.Dx(),
+			Height:  b.Dy(),
+			Primary: i == 0,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(displays)
+}
+
+func (s *Server) handleCapture(w http.ResponseWriter, r *http.Request) {
+	rect, status, err := s.displayRect(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	s.serveRect(w, r, rect)
+}
+
+func (s *Server) handleCaptureRect(w http.ResponseWriter, r *http.Request) {
+	rect, status, err := s.paramRect(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	s.serveRect(w, r, rect)
+}
+
+// displayRect returns the bounds of the display selected by the display query parameter,
+// with the HTTP status to report on error.
+func (s *Server) displayRect(r *http.Request) (image.Rectangle, int, error) {
+	display, err := intParam(r.URL.Query().Get("display"), 0)
+	if err != nil {
+		return image.Rectangle{}, http.StatusBadRequest, fmt.Errorf("display: %v", err)
+	}
+	rect, err := s.capturer.GetDisplayBounds(display)
+	if err != nil {
+		return image.Rectangle{}, http.StatusNotFound, err
+	}
+	return rect, http.StatusOK, nil
+}
+
+// maxCoord bounds the query parameters of paramRect, far beyond any desktop but
+// small enough that their sums cannot overflow.
+const maxCoord = 1 << 24
+
+// paramRect returns the desktop rectangle given by the x, y, w and h query parameters,
+// clipped to the displays, with the HTTP status to report on error.
+func (s *Server) paramRect(r *http.Request) (image.Rectangle, int, error) {
+	q := r.URL.Query()
+	var v [4]int
+	for i, name := range []string{"x", "y", "w", "h"} {
+		if q.Get(name) == "" {
+			return image.Rectangle{}, http.StatusBadRequest, fmt.Errorf("%s: missing", name)
+		}
+		n, err := intParam(q.Get(name), 0)
+		if err != nil {
+			return image.Rectangle{}, http.StatusBadRequest, fmt.Errorf("%s: %v", name, err)
+		}
+		if n < -maxCoord || n > maxCoord {
+			return image.Rectangle{}, http.StatusBadRequest, fmt.Errorf("%s: out of range", name)
+		}
+		v[i] = n
+	}
+	if v[2] <= 0 || v[3] <= 0 {
+		return image.Rectangle{}, http.StatusBadRequest, fmt.Errorf("w and h must be > 0")
+	}
+
+	bounds, err := s.capturer.GetAllDisplayBounds()
+	if err != nil {
+		return image.Rectangle{}, http.StatusInternalServerError, err
+	}
+	var desktop image.Rectangle
+	for _, b := range bounds {
+		desktop = desktop.Union(b)
+	}
+	rect := image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]).Intersect(desktop)
+	if rect.Empty() {
+		return image.Rectangle{}, http.StatusBadRequest, fmt.Errorf("rectangle is outside the displays")
+	}
+	if rect.Dx()*rect.Dy() > s.maxPixels {
+		return image.Rectangle{}, http.StatusBadRequest, fmt.Errorf("rectangle is larger than %d pixels", s.maxPixels)
+	}
+	return rect, http.StatusOK, nil
+}
+
+func (s *Server) serveRect(w http.ResponseWriter, r *http.Request, rect image.Rectangle) {
+	enc, err := parseEncoding(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	img, captured, err := s.frames.get(r.Context(), rect)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := enc.encode(&buf, imgutil.Downscale(img, enc.scale)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", enc.contentType())
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Capture-Time", captured.UTC().Format(time.RFC3339Nano))
+	_, _ = buf.WriteTo(w)
+}
+
+// encoding holds the output parameters of a capture request.
+type encoding struct {
+	format  encode.Format
+	quality int
+	scale   float64
+}
+
+func parseEncoding(r *http.Request) (encoding, error) {
+	q := r.URL.Query()
+	e := encoding{format: encode.FormatPNG, quality: 80, scale: 1}
+	var err error
+	if f := q.Get("format"); f != "" {
+		if e.format, err = encode.ParseFormat(f); err != nil {
+			return e, fmt.Errorf("format: unsupported %q", f)
+		}
+	}
+	if e.quality, err = intParam(q.Get("quality"), e.quality); err != nil || e.quality < 1 || e.quality > 100 {
+		return e, fmt.Errorf("quality: must be an integer in 1..100")
+	}
+	if v := q.Get("scale"); v != "" {
+		e.scale, err = strconv.ParseFloat(v, 64)
+		if err != nil || e.scale <= 0 || e.scale > 1 {
+			return e, fmt.Errorf("scale: must be a number in (0, 1]")
+		}
+	}
+	return e, nil
+}
+
+func (e encoding) contentType() string {
+	return e.format.ContentType()
+}
+
+func (e encoding) encode(buf *bytes.Buffer, img *image.RGBA) error {
+	return encode.Encode(buf, img, e.format, &encode.Options{
+		PNG:  encode.PNGOptions{Speed: encode.PNGFast},
+		JPEG: encode.JPEGOptions{Quality: e.quality},
+	})
+}
+
+func intParam(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fast-IQ/screenshot/encode"
+	"github.com/Fast-IQ/screenshot/screenshottest"
+)
+
+func newTestServer(t *testing.T, opts Options) (*httptest.Server, *screenshottest.Capturer) {
+	t.Helper()
+	fake := screenshottest.New(image.Rect(0, 0, 40, 30), image.Rect(40, 0, 60, 10))
+	fake.SetContent(screenshottest.Solid(color.RGBA{10, 20, 30, 255}))
+	opts.Capturer = fake
+	ts := httptest.NewServer(New(opts))
+	t.Cleanup(ts.Close)
+	return ts, fake
+}
+
+func get(t *testing.T, url, token string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestDisplays(t *testing.T) {
+	ts, _ := newTestServer(t, Options{})
+	resp := get(t, ts.URL+"/displays", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	var displays []Display
+	if err := json.NewDecoder(resp.Body).Decode(&displays); err != nil {
+		t.Fatal(err)
+	}
+	want := []Display{
+		{Index: 0, Width: 40, Height: 30, Primary: true},
+		{Index: 1, X: 40, Width: 20, Height: 10},
+	}
+	if len(displays) != len(want) || displays[0] != want[0] || displays[1] != want[1] {
+		t.Errorf("got %+v, want %+v", displays, want)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	ts, _ := newTestServer(t, Options{})
+
+	resp := get(t, ts.URL+"/capture?display=1", "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	img, err := png.Decode(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 20, 10) {
+		t.Errorf("bounds %v", img.Bounds())
+	}
+
+	resp = get(t, ts.URL+"/capture/rect?x=0&y=0&w=40&h=30&format=jpeg&quality=90&scale=0.5", "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	img, err = jpeg.Decode(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 20, 15) {
+		t.Errorf("scaled bounds %v", img.Bounds())
+	}
+
+	resp = get(t, ts.URL+"/capture?format=qoi", "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/qoi" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if img, err = encode.DecodeQOI(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 40, 30) {
+		t.Errorf("qoi bounds %v", img.Bounds())
+	}
+}
+
+func TestCaptureBadRequest(t *testing.T) {
+	ts, _ := newTestServer(t, Options{})
+	for _, path := range []string{
+		"/capture?display=x",
+		"/capture?format=gif",
+		"/capture?quality=0&format=jpeg",
+		"/capture?scale=2",
+		"/capture/rect?x=0&y=0&w=10",
+		"/capture/rect?x=0&y=0&w=0&h=10",
+		"/capture/rect?x=100&y=0&w=10&h=10",
+		"/capture/rect?x=0&y=0&w=99999999999&h=10",
+		"/stream/rect?x=0&y=-20&w=10&h=10",
+		"/ws/rect?x=-10&y=40&w=10&h=10",
+	} {
+		if resp := get(t, ts.URL+path, ""); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", path, resp.StatusCode)
+		}
+	}
+	if resp := get(t, ts.URL+"/capture?display=5", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown display: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestCaptureRectClipped(t *testing.T) {
+	ts, _ := newTestServer(t, Options{MaxPixels: 400})
+
+	// The displays span (0, 0)-(60, 30).
+	resp := get(t, ts.URL+"/capture/rect?x=50&y=5&w=1000000&h=1000000", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	img, err := png.Decode(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 10, 25) {
+		t.Errorf("bounds %v, want clipped to 10x25", img.Bounds())
+	}
+
+	if resp := get(t, ts.URL+"/capture/rect?x=0&y=0&w=21&h=20", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("over MaxPixels: status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	ts, _ := newTestServer(t, Options{Token: "secret"})
+	if resp := get(t, ts.URL+"/displays", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no token: status %d", resp.StatusCode)
+	}
+	if resp := get(t, ts.URL+"/displays", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d", resp.StatusCode)
+	}
+	if resp := get(t, ts.URL+"/displays", "secret"); resp.StatusCode != http.StatusOK {
+		t.Errorf("valid token: status %d", resp.StatusCode)
+	}
+}
+
+func TestCaptureCache(t *testing.T) {
+	ts, fake := newTestServer(t, Options{CacheTTL: time.Minute})
+	get(t, ts.URL+"/capture", "")
+	get(t, ts.URL+"/capture?format=jpeg", "")
+	if n := fake.Captures(); n != 1 {
+		t.Errorf("captures = %d, want 1 within cache TTL", n)
+	}
+	get(t, ts.URL+"/capture?display=1", "")
+	if n := fake.Captures(); n != 2 {
+		t.Errorf("captures = %d, want 2 for another display", n)
+	}
+}
+
+func TestCaptureShared(t *testing.T) {
+	ts, fake := newTestServer(t, Options{})
+	fake.SetLatency(100 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(ts.URL + "/capture")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status %d", resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := fake.Captures(); n != 1 {
+		t.Errorf("captures = %d, want 1 shared capture", n)
+	}
+}
+
+// readParts reads n JPEG parts of an MJPEG stream.
+func readParts(t *testing.T, resp *http.Response, n int) []image.Image {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/x-mixed-replace" {
+		t.Fatalf("content type %q", resp.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	var imgs []image.Image
+	for len(imgs) < n {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := part.Header.Get("Content-Type"); ct != "image/jpeg" {
+			t.Fatalf("part content type %q", ct)
+		}
+		img, err := jpeg.Decode(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs
+}
+
+func TestStream(t *testing.T) {
+	ts, _ := newTestServer(t, Options{})
+	resp := get(t, ts.URL+"/stream?display=1&fps=30", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	for _, img := range readParts(t, resp, 3) {
+		if img.Bounds() != image.Rect(0, 0, 20, 10) {
+			t.Errorf("bounds %v", img.Bounds())
+		}
+	}
+
+	resp = get(t, ts.URL+"/stream/rect?x=0&y=0&w=40&h=30&fps=30&scale=0.5", "")
+	if img := readParts(t, resp, 1)[0]; img.Bounds() != image.Rect(0, 0, 20, 15) {
+		t.Errorf("scaled bounds %v", img.Bounds())
+	}
+}
+
+func TestStreamBadRequest(t *testing.T) {
+	ts, _ := newTestServer(t, Options{})
+	for _, path := range []string{
+		"/stream?fps=0",
+		"/stream?fps=100",
+		"/stream?format=png",
+		"/stream/rect?x=0&y=0",
+	} {
+		if resp := get(t, ts.URL+path, ""); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestStreamShared(t *testing.T) {
+	fake := screenshottest.New(image.Rect(0, 0, 40, 30))
+	srv := New(Options{Capturer: fake})
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	var resps []*http.Response
+	for i := 0; i < 3; i++ {
+		resp, err := http.Get(ts.URL + "/stream?fps=20")
+		if err != nil {
+			t.Fatal(err)
+		}
+		readParts(t, resp, 2)
+		resps = append(resps, resp)
+	}
+	if n := srv.streams.active(); n != 1 {
+		t.Errorf("active streams = %d, want 1 shared stream", n)
+	}
+
+	for _, resp := range resps {
+		_ = resp.Body.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.streams.active() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("stream still running after the last viewer left")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Let a frame in flight settle, then no more captures may happen.
+	time.Sleep(100 * time.Millisecond)
+	n := fake.Captures()
+	time.Sleep(200 * time.Millisecond)
+	if m := fake.Captures(); m != n {
+		t.Errorf("captures went on after the stream stopped: %d -> %d", n, m)
+	}
+}
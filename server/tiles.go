@@ -0,0 +1,335 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/encode"
+)
+
+// Tile protocol message types and formats, see the package documentation.
+const (
+	tileMsgFrame    = 1 // server to client
+	tileMsgAck      = 1 // client to server
+	tileMsgKeyframe = 2 // client to server
+
+	tileFlagKeyframe = 1
+
+	tileFormatPNG  = 1
+	tileFormatJPEG = 2
+	tileFormatWebP = 3
+)
+
+// tileFormats maps the formats tiles can be sent in to their protocol codes.
+var tileFormats = map[encode.Format]byte{
+	encode.FormatPNG:  tileFormatPNG,
+	encode.FormatJPEG: tileFormatJPEG,
+	encode.FormatWebP: tileFormatWebP,
+}
+
+const (
+	// tileWindow is the number of unacknowledged frames after which a client gets no more
+	// frames until it catches up; changes pile up in the meantime.
+	tileWindow = 2
+	// minTileQuality is the lowest JPEG quality the throughput adaptation goes down to.
+	minTileQuality = 20
+)
+
+func (s *Server) handleTiles(w http.ResponseWriter, r *http.Request) {
+	rect, status, err := s.displayRect(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	s.serveTiles(w, r, rect)
+}
+
+func (s *Server) handleTilesRect(w http.ResponseWriter, r *http.Request) {
+	rect, status, err := s.paramRect(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	s.serveTiles(w, r, rect)
+}
+
+// serveTiles upgrades the request to a WebSocket and sends a keyframe of rect followed
+// by the tiles that changed, as described in the package documentation.
+func (s *Server) serveTiles(w http.ResponseWriter, r *http.Request, rect image.Rectangle) {
+	if !s.sameOrigin(r) {
+		http.Error(w, "cross-origin websocket requires a token", http.StatusForbidden)
+		return
+	}
+	enc, err := parseEncoding(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("format") == "" {
+		enc.format = encode.FormatJPEG
+	}
+	if _, ok := tileFormats[enc.format]; !ok {
+		http.Error(w, "format: tiles only support png, jpeg and webp", http.StatusBadRequest)
+		return
+	}
+	if enc.scale != 1 {
+		http.Error(w, "scale: not supported for tiles", http.StatusBadRequest)
+		return
+	}
+	fps, err := fpsParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer ws.nc.Close()
+
+	v := s.streams.subscribe(rect, fps)
+	defer s.streams.unsubscribe(v)
+
+	msgs := make(chan []byte)
+	readDone := make(chan struct{})
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(readDone)
+		for {
+			op, msg, err := ws.readMessage()
+			if err != nil {
+				return
+			}
+			if op != wsBinary || len(msg) == 0 {
+				continue
+			}
+			select {
+			case msgs <- msg:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	sess := newTileSession(enc, fps)
+	throttle := time.NewTimer(0)
+	defer throttle.Stop()
+	ready := true // the client's frame interval has passed
+	for {
+		select {
+		case <-readDone:
+			return
+		case <-v.done:
+			_ = ws.close(wsCloseInternal)
+			return
+		case msg := <-msgs:
+			switch {
+			case msg[0] == tileMsgAck && len(msg) == 5:
+				sess.ack(binary.BigEndian.Uint32(msg[1:]), time.Now())
+			case msg[0] == tileMsgKeyframe:
+				sess.size = image.Point{}
+			}
+		case f := <-v.frames:
+			sess.add(f)
+		case <-throttle.C:
+			ready = true
+		}
+		if !ready || !sess.canSend() {
+			continue
+		}
+		msg, err := sess.next(time.Now())
+		if err != nil {
+			_ = ws.close(wsCloseInternal)
+			return
+		}
+		if err := ws.writeMessage(wsBinary, msg); err != nil {
+			return
+		}
+		ready = false
+		throttle.Reset(sess.interval)
+	}
+}
+
+// sameOrigin reports whether a browser request comes from a page of this server.
+// Browsers do not restrict cross-origin WebSockets, so without a token any web page
+// could otherwise watch the screen of a local server.
+func (s *Server) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || s.token != "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// fpsParam parses the fps query parameter shared by the live endpoints.
+func fpsParam(r *http.Request) (float64, error) {
+	v := r.URL.Query().Get("fps")
+	if v == "" {
+		return defaultStreamFPS, nil
+	}
+	fps, err := strconv.ParseFloat(v, 64)
+	if err != nil || fps <= 0 || fps > maxStreamFPS {
+		return 0, fmt.Errorf("fps: must be a number in (0, %d]", maxStreamFPS)
+	}
+	return fps, nil
+}
+
+// tileSession tracks what a tile client has and has acknowledged.
+type tileSession struct {
+	enc        encoding
+	maxQuality int
+	interval   time.Duration
+
+	frame    *screenshot.Frame // latest frame, nil until the first one
+	size     image.Point       // size of the client's image, zero before the keyframe
+	pending  map[image.Rectangle]struct{}
+	seq      uint32
+	inflight map[uint32]sentFrame
+	// throughput is the smoothed client throughput in bytes per second, 0 until measured.
+	throughput float64
+}
+
+type sentFrame struct {
+	time  time.Time
+	bytes int
+}
+
+func newTileSession(enc encoding, fps float64) *tileSession {
+	return &tileSession{
+		enc:        enc,
+		maxQuality: enc.quality,
+		interval:   time.Duration(float64(time.Second) / fps),
+		pending:    make(map[image.Rectangle]struct{}),
+		inflight:   make(map[uint32]sentFrame),
+	}
+}
+
+// add records a new frame and its changes.
+func (t *tileSession) add(f screenshot.Frame) {
+	t.frame = &f
+	for _, r := range f.Dirty {
+		t.pending[r] = struct{}{}
+	}
+}
+
+// canSend reports whether there is something to send and the client is not too far behind.
+func (t *tileSession) canSend() bool {
+	if t.frame == nil || len(t.inflight) >= tileWindow {
+		return false
+	}
+	return t.keyframe() || len(t.pending) > 0
+}
+
+func (t *tileSession) keyframe() bool {
+	return t.size != t.frame.Image.Rect.Size()
+}
+
+// ack marks frame seq as received, updates the throughput estimate and adapts the
+// JPEG quality so that a frame fits into the frame interval at that throughput.
+func (t *tileSession) ack(seq uint32, now time.Time) {
+	sent, ok := t.inflight[seq]
+	if !ok {
+		return
+	}
+	delete(t.inflight, seq)
+	elapsed := now.Sub(sent.time).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	rate := float64(sent.bytes) / elapsed
+	if t.throughput == 0 {
+		t.throughput = rate
+	} else {
+		t.throughput = 0.7*t.throughput + 0.3*rate
+	}
+
+	if t.enc.format != encode.FormatJPEG {
+		return
+	}
+	budget := t.throughput * t.interval.Seconds()
+	switch {
+	case float64(sent.bytes) > budget:
+		t.enc.quality = max(minTileQuality, t.enc.quality*3/4)
+	case float64(sent.bytes) < budget/2:
+		t.enc.quality = min(t.maxQuality, t.enc.quality+5)
+	}
+}
+
+// next encodes the message for the pending changes: the whole image after a size
+// change, otherwise the pending tiles with horizontal neighbours merged.
+func (t *tileSession) next(now time.Time) ([]byte, error) {
+	img := t.frame.Image
+	var rects []image.Rectangle
+	flags := byte(0)
+	if t.keyframe() {
+		flags = tileFlagKeyframe
+		rects = []image.Rectangle{img.Rect}
+		t.size = img.Rect.Size()
+	} else {
+		rects = mergeTiles(t.pending, img.Rect)
+	}
+	clear(t.pending)
+
+	t.seq++
+	msg := []byte{tileMsgFrame, flags}
+	msg = binary.BigEndian.AppendUint32(msg, t.seq)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(img.Rect.Dx()))
+	msg = binary.BigEndian.AppendUint16(msg, uint16(img.Rect.Dy()))
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(rects)))
+	format := tileFormats[t.enc.format]
+	var buf bytes.Buffer
+	for _, r := range rects {
+		buf.Reset()
+		if err := t.enc.encode(&buf, img.SubImage(r).(*image.RGBA)); err != nil {
+			return nil, err
+		}
+		p := r.Min.Sub(img.Rect.Min)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(p.X))
+		msg = binary.BigEndian.AppendUint16(msg, uint16(p.Y))
+		msg = binary.BigEndian.AppendUint16(msg, uint16(r.Dx()))
+		msg = binary.BigEndian.AppendUint16(msg, uint16(r.Dy()))
+		msg = append(msg, format)
+		msg = binary.BigEndian.AppendUint32(msg, uint32(buf.Len()))
+		msg = append(msg, buf.Bytes()...)
+	}
+	t.inflight[t.seq] = sentFrame{time: now, bytes: len(msg)}
+	return msg, nil
+}
+
+// mergeTiles returns the tiles inside bounds in row-major order, with horizontally
+// adjacent tiles of the same row merged into one rectangle.
+func mergeTiles(tiles map[image.Rectangle]struct{}, bounds image.Rectangle) []image.Rectangle {
+	sorted := make([]image.Rectangle, 0, len(tiles))
+	for r := range tiles {
+		if r = r.Intersect(bounds); !r.Empty() {
+			sorted = append(sorted, r)
+		}
+	}
+	slices.SortFunc(sorted, func(a, b image.Rectangle) int {
+		return cmp.Or(cmp.Compare(a.Min.Y, b.Min.Y), cmp.Compare(a.Min.X, b.Min.X))
+	})
+	var merged []image.Rectangle
+	for _, r := range sorted {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if last.Min.Y == r.Min.Y && last.Max.Y == r.Max.Y && last.Max.X == r.Min.X {
+				last.Max.X = r.Max.X
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fast-IQ/screenshot/encode"
+	"github.com/Fast-IQ/screenshot/screenshottest"
+)
+
+// wsClient is a minimal WebSocket client for the tile protocol.
+type wsClient struct {
+	t  *testing.T
+	nc net.Conn
+	br *bufio.Reader
+}
+
+// dialWS opens a WebSocket to path and returns the client, or the HTTP response when
+// the server refused the upgrade.
+func dialWS(t *testing.T, ts *httptest.Server, path string, header http.Header) (*wsClient, *http.Response) {
+	t.Helper()
+	nc, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = nc.Close() })
+	_ = nc.SetDeadline(time.Now().Add(10 * time.Second))
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if err := req.Write(nc); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp
+	}
+	// The example key of RFC 6455.
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Sec-WebSocket-Accept %q", got)
+	}
+	return &wsClient{t: t, nc: nc, br: br}, resp
+}
+
+func (c *wsClient) read() []byte {
+	c.t.Helper()
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	if hdr[0] != 0x80|wsBinary || hdr[1]&0x80 != 0 {
+		c.t.Fatalf("unexpected frame header % x", hdr)
+	}
+	n := uint64(hdr[1])
+	switch n {
+	case 126:
+		var ext [2]byte
+		_, _ = io.ReadFull(c.br, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, _ = io.ReadFull(c.br, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(c.br, msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+func (c *wsClient) write(op byte, payload []byte) {
+	c.t.Helper()
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.nc.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) ack(seq uint32) {
+	c.write(wsBinary, binary.BigEndian.AppendUint32([]byte{tileMsgAck}, seq))
+}
+
+type tileFrame struct {
+	keyframe bool
+	seq      uint32
+	size     image.Point
+	tiles    []image.Rectangle
+}
+
+// apply decodes a frame message onto img, which is replaced on keyframes.
+func (c *wsClient) apply(msg []byte, img **image.RGBA) tileFrame {
+	c.t.Helper()
+	if msg[0] != tileMsgFrame {
+		c.t.Fatalf("message type %d", msg[0])
+	}
+	f := tileFrame{
+		keyframe: msg[1]&tileFlagKeyframe != 0,
+		seq:      binary.BigEndian.Uint32(msg[2:]),
+		size:     image.Pt(int(binary.BigEndian.Uint16(msg[6:])), int(binary.BigEndian.Uint16(msg[8:]))),
+	}
+	if f.keyframe {
+		*img = image.NewRGBA(image.Rectangle{Max: f.size})
+	}
+	n := int(binary.BigEndian.Uint16(msg[10:]))
+	p := msg[12:]
+	for i := 0; i < n; i++ {
+		x, y := int(binary.BigEndian.Uint16(p)), int(binary.BigEndian.Uint16(p[2:]))
+		w, h := int(binary.BigEndian.Uint16(p[4:])), int(binary.BigEndian.Uint16(p[6:]))
+		size := int(binary.BigEndian.Uint32(p[9:]))
+		var tile image.Image
+		var err error
+		switch p[8] {
+		case tileFormatPNG:
+			tile, err = png.Decode(bytes.NewReader(p[13 : 13+size]))
+		case tileFormatWebP:
+			tile, err = encode.DecodeWebP(bytes.NewReader(p[13 : 13+size]))
+		default:
+			c.t.Fatalf("tile format %d", p[8])
+		}
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		r := image.Rect(x, y, x+w, y+h)
+		draw.Draw(*img, r, tile, tile.Bounds().Min, draw.Src)
+		f.tiles = append(f.tiles, r)
+		p = p[13+size:]
+	}
+	return f
+}
+
+func TestTiles(t *testing.T) {
+	ts, fake := newTestServer(t, Options{})
+	fake.SetDisplays(image.Rect(0, 0, 200, 130))
+	fake.SetContent(screenshottest.Gradient(image.Rect(0, 0, 200, 130), color.White, color.Black))
+
+	c, resp := dialWS(t, ts, "/ws?format=png&fps=30", nil)
+	if c == nil {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	var img *image.RGBA
+	f := c.apply(c.read(), &img)
+	if !f.keyframe || f.size != image.Pt(200, 130) || len(f.tiles) != 1 {
+		t.Fatalf("first frame %+v, want a keyframe", f)
+	}
+	c.ack(f.seq)
+
+	// A change inside one tile only sends that tile.
+	square := image.Rect(70, 70, 80, 80)
+	gradient := screenshottest.Gradient(image.Rect(0, 0, 200, 130), color.White, color.Black)
+	fake.SetContent(func(frame, x, y int) color.RGBA {
+		if image.Pt(x, y).In(square) {
+			return color.RGBA{R: 255, A: 255}
+		}
+		return gradient(frame, x, y)
+	})
+	f = c.apply(c.read(), &img)
+	if f.keyframe || len(f.tiles) != 1 || f.tiles[0] != image.Rect(64, 64, 128, 128) {
+		t.Fatalf("frame %+v, want the changed tile only", f)
+	}
+	want, _ := fake.Capture(0, 0, 200, 130)
+	if !bytes.Equal(img.Pix, want.Pix) {
+		t.Error("image differs from the capture after applying the tiles")
+	}
+	c.ack(f.seq)
+
+	// Keyframes are sent on request, also when nothing changed.
+	c.write(wsBinary, []byte{tileMsgKeyframe})
+	for !f.keyframe {
+		f = c.apply(c.read(), &img)
+		c.ack(f.seq)
+	}
+	want, _ = fake.Capture(0, 0, 200, 130)
+	if !bytes.Equal(img.Pix, want.Pix) {
+		t.Error("keyframe differs from the capture")
+	}
+}
+
+func TestTilesWebP(t *testing.T) {
+	ts, fake := newTestServer(t, Options{})
+	fake.SetContent(screenshottest.Gradient(image.Rect(0, 0, 40, 30), color.White, color.Black))
+
+	c, resp := dialWS(t, ts, "/ws/rect?x=0&y=0&w=40&h=30&format=webp", nil)
+	if c == nil {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	var img *image.RGBA
+	f := c.apply(c.read(), &img)
+	if !f.keyframe || f.size != image.Pt(40, 30) {
+		t.Fatalf("first frame %+v, want a keyframe", f)
+	}
+	// WebP tiles are lossless.
+	want, _ := fake.Capture(0, 0, 40, 30)
+	if !bytes.Equal(img.Pix, want.Pix) {
+		t.Error("image differs from the capture")
+	}
+}
+
+func TestTilesFlowControl(t *testing.T) {
+	ts, fake := newTestServer(t, Options{})
+	// New content in every frame.
+	fake.SetContent(screenshottest.Checkerboard(4, 1, color.White, color.Black))
+
+	c, _ := dialWS(t, ts, "/ws?format=png&fps=30", nil)
+	var img *image.RGBA
+	var seqs []uint32
+	for i := 0; i < tileWindow; i++ {
+		seqs = append(seqs, c.apply(c.read(), &img).seq)
+	}
+	// Without acknowledgements nothing more arrives.
+	_ = c.nc.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if _, err := c.br.Peek(1); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got data or error %v while the window is full", err)
+	}
+	_ = c.nc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	c.ack(seqs[0])
+	if f := c.apply(c.read(), &img); f.seq != seqs[len(seqs)-1]+1 {
+		t.Errorf("frame seq %d after ack", f.seq)
+	}
+}
+
+func TestTilesRejected(t *testing.T) {
+	ts, _ := newTestServer(t, Options{})
+	if resp := get(t, ts.URL+"/ws", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET: status %d, want 400", resp.StatusCode)
+	}
+	if _, resp := dialWS(t, ts, "/ws?scale=0.5", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("scale: status %d, want 400", resp.StatusCode)
+	}
+	if _, resp := dialWS(t, ts, "/ws?format=qoi", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("qoi: status %d, want 400", resp.StatusCode)
+	}
+	evil := http.Header{"Origin": {"https://evil.example"}}
+	if _, resp := dialWS(t, ts, "/ws", evil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross origin: status %d, want 403", resp.StatusCode)
+	}
+
+	ts, _ = newTestServer(t, Options{Token: "secret"})
+	if _, resp := dialWS(t, ts, "/ws", evil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no token: status %d, want 401", resp.StatusCode)
+	}
+	if c, resp := dialWS(t, ts, "/ws?access_token=secret", evil); c == nil {
+		t.Errorf("access_token: status %d, want 101", resp.StatusCode)
+	}
+}
+
+func TestTileQuality(t *testing.T) {
+	sess := newTileSession(encoding{format: "jpeg", quality: 80, scale: 1}, 10)
+	now := time.Now()
+	// 100 kB frames at 200 kB/s do not fit into 100 ms.
+	for i := uint32(1); i <= 5; i++ {
+		sess.inflight[i] = sentFrame{time: now, bytes: 100_000}
+		sess.ack(i, now.Add(500*time.Millisecond))
+	}
+	if sess.enc.quality != minTileQuality {
+		t.Errorf("quality %d on a slow link, want %d", sess.enc.quality, minTileQuality)
+	}
+	// 1 kB frames arriving in 1 ms leave plenty of room.
+	for i := uint32(6); i <= 30; i++ {
+		sess.inflight[i] = sentFrame{time: now, bytes: 1000}
+		sess.ack(i, now.Add(time.Millisecond))
+	}
+	if sess.enc.quality != 80 {
+		t.Errorf("quality %d on a fast link, want 80", sess.enc.quality)
+	}
+}
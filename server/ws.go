@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// WebSocket close codes.
+const (
+	wsCloseNormal   = 1000
+	wsCloseProtocol = 1002
+	wsCloseInternal = 1011
+)
+
+// wsMaxMessage limits the size of client messages, which are only small acknowledgements.
+const wsMaxMessage = 1 << 16
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var errWSProtocol = errors.New("websocket: protocol error")
+
+// wsConn is the server side of a WebSocket connection (RFC 6455). Reads must come from a
+// single goroutine; writes are serialized and may come from any goroutine.
+type wsConn struct {
+	nc net.Conn
+	br *bufio.Reader
+
+	wmu sync.Mutex
+}
+
+// upgradeWebSocket answers the opening handshake of r and takes over the connection.
+// On failure it has already replied with an HTTP error.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("websocket: not an upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version")
+	}
+
+	nc, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+	// The server's timeouts do not apply to hijacked connections any more.
+	_ = nc.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := brw.Flush(); err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	return &wsConn{nc: nc, br: brw.Reader}, nil
+}
+
+// headerContains reports whether the comma separated header name contains token.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeMessage sends payload as a single unmasked frame.
+func (c *wsConn) writeMessage(op byte, payload []byte) error {
+	hdr := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xffff:
+		hdr = append(hdr, 126)
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr = append(hdr, 127)
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	bufs := net.Buffers{hdr, payload}
+	_, err := bufs.WriteTo(c.nc)
+	return err
+}
+
+// readMessage returns the next text or binary message, answering pings on the way.
+// It returns io.EOF once the client closed the connection.
+func (c *wsConn) readMessage() (op byte, payload []byte, err error) {
+	for {
+		fin, frameOp, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOp {
+		case wsPing:
+			if err := c.writeMessage(wsPong, data); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			_ = c.writeMessage(wsClose, data[:min(len(data), 2)])
+			return 0, nil, io.EOF
+		case wsContinuation:
+			if op == 0 {
+				return 0, nil, errWSProtocol
+			}
+		case wsText, wsBinary:
+			if op != 0 {
+				return 0, nil, errWSProtocol
+			}
+			op = frameOp
+		default:
+			return 0, nil, errWSProtocol
+		}
+		if len(payload)+len(data) > wsMaxMessage {
+			return 0, nil, errWSProtocol
+		}
+		payload = append(payload, data...)
+		if fin {
+			return op, payload, nil
+		}
+	}
+}
+
+// readFrame reads one frame. Client frames must be masked.
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0f
+	if hdr[0]&0x70 != 0 || hdr[1]&0x80 == 0 {
+		return false, 0, nil, errWSProtocol
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxMessage || op >= wsClose && (n > 125 || !fin) {
+		return false, 0, nil, errWSProtocol
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// close sends a close frame with code and closes the connection.
+func (c *wsConn) close(code uint16) error {
+	_ = c.writeMessage(wsClose, binary.BigEndian.AppendUint16(nil, code))
+	return c.nc.Close()
+}
@@ -0,0 +1,96 @@
+package screenshot
+
+import (
+	"context"
+	"image"
+	"time"
+
+	"github.com/Fast-IQ/screenshot/icc"
+	"github.com/Fast-IQ/screenshot/internal/imgutil"
+)
+
+// Frame is a single capture delivered by Stream.
+type Frame struct {
+	Image *image.RGBA
+	// Rect is the captured region in desktop coordinates.
+	Rect image.Rectangle
+	Time time.Time
+	// Seq numbers the delivered frames, starting at 0.
+	Seq uint64
+	// Dirty lists the DirtyTileSize×DirtyTileSize tiles of Image, clipped to its bounds,
+	// that changed since the previous frame. Every tile of the first frame is dirty.
+	Dirty []image.Rectangle
+	// ColorSpace is the colour space of Image, see DisplayImage.ColorSpace; for a
+	// Rect spanning displays, that of the display covering most of it.
+	ColorSpace *icc.Profile
+}
+
+// DirtyTileSize is the tile size of Frame.Dirty.
+const DirtyTileSize = 64
+
+// StreamOptions configures Stream.
+type StreamOptions struct {
+	// Rect is the desktop region to capture. An empty Rect captures the primary display.
+	Rect image.Rectangle
+	// FPS is the target frame rate. Zero or less means one frame per second.
+	FPS float64
+	// Capturer is the backend to use, nil means DefaultCapturer().
+	Capturer ScreenCapturer
+}
+
+// Stream captures opts.Rect at opts.FPS and calls fn with every frame, until ctx is done
+// or either the capture or fn returns an error. The first frame is captured immediately.
+// When fn is slower than the frame interval, the frames in between are skipped rather than queued.
+// Stream returns ctx.Err() when stopped by ctx.
+func Stream(ctx context.Context, opts StreamOptions, fn func(Frame) error) error {
+	c := opts.Capturer
+	if c == nil {
+		c = DefaultCapturer()
+	}
+	rect := opts.Rect
+	if rect.Empty() {
+		var err error
+		rect, err = c.GetDisplayBounds(0)
+		if err != nil {
+			return err
+		}
+	}
+	space := rectColorSpace(c, rect)
+	fps := opts.FPS
+	if fps <= 0 {
+		fps = 1
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / fps))
+	defer ticker.Stop()
+
+	var prev *image.RGBA
+	for seq := uint64(0); ; seq++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		now := time.Now()
+		img, err := c.Capture(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+		if err != nil {
+			return err
+		}
+		dirty := imgutil.DirtyTiles(prev, img, DirtyTileSize)
+		prev = img
+		err = fn(Frame{
+			Image:      img,
+			Rect:       rect,
+			Time:       now,
+			Seq:        seq,
+			Dirty:      dirty,
+			ColorSpace: space,
+		})
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
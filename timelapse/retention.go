@@ -0,0 +1,121 @@
+package timelapse
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Retention limits what is kept in the directory. Each non-zero limit is enforced
+// after every capture by removing the oldest files first.
+type Retention struct {
+	// MaxCount is the number of files to keep.
+	MaxCount int
+	// MaxAge removes files captured longer ago than this.
+	MaxAge time.Duration
+	// MaxBytes is the total size of the files to keep.
+	MaxBytes int64
+}
+
+func (r Retention) enabled() bool {
+	return r.MaxCount > 0 || r.MaxAge > 0 || r.MaxBytes > 0
+}
+
+// layout is the path of a capture relative to the directory, before the display
+// index and the extension.
+const layout = "2006/01/02/150405"
+
+// parsePath returns the file described by the path of a capture relative to dir, as
+// written by the scheduler.
+func parsePath(dir, rel string, loc *time.Location) (File, bool) {
+	slash := filepath.ToSlash(rel)
+	if len(slash) < len(layout)+2 || slash[len(layout)] != '-' {
+		return File{}, false
+	}
+	t, err := time.ParseInLocation(layout, slash[:len(layout)], loc)
+	if err != nil {
+		return File{}, false
+	}
+	var display int
+	rest, _, _ := strings.Cut(slash[len(layout)+1:], ".")
+	rest, _, _ = strings.Cut(rest, "-")
+	if _, err := fmt.Sscan(rest, &display); err != nil {
+		return File{}, false
+	}
+	return File{Path: filepath.Join(dir, rel), Display: display, Time: t}, true
+}
+
+// scan lists the captures below dir, oldest first. Other files are ignored.
+func scan(dir string, loc *time.Location) ([]File, error) {
+	var files []File
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(path, tmpSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f, ok := parsePath(dir, rel, loc)
+		if !ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f.Size = info.Size()
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Time.Before(files[j].Time) })
+	return files, nil
+}
+
+// expired returns how many of the oldest files exceed the limits at now.
+func (r Retention) expired(files []File, now time.Time) int {
+	n := 0
+	if r.MaxAge > 0 {
+		cutoff := now.Add(-r.MaxAge)
+		for n < len(files) && files[n].Time.Before(cutoff) {
+			n++
+		}
+	}
+	if r.MaxCount > 0 && len(files)-n > r.MaxCount {
+		n = len(files) - r.MaxCount
+	}
+	if r.MaxBytes > 0 {
+		var total int64
+		for _, f := range files[n:] {
+			total += f.Size
+		}
+		for n < len(files) && total > r.MaxBytes {
+			total -= files[n].Size
+			n++
+		}
+	}
+	return n
+}
+
+// removeEmptyDirs removes the directories from the one of path up to, but not
+// including, dir as long as they are empty.
+func removeEmptyDirs(dir, path string) {
+	for p := filepath.Dir(path); p != dir && strings.HasPrefix(p, dir); p = filepath.Dir(p) {
+		if os.Remove(p) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package timelapse
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when captures are taken.
+type Schedule interface {
+	// Next returns the first capture time after t, or the zero time if there is none.
+	Next(t time.Time) time.Time
+}
+
+// Every returns a schedule firing every d, aligned to multiples of d since the zero
+// time, so that Every(time.Minute) fires at the start of each minute. d must be
+// positive.
+func Every(d time.Duration) Schedule {
+	if d <= 0 {
+		panic("timelapse: non-positive interval")
+	}
+	return interval(d)
+}
+
+type interval time.Duration
+
+func (d interval) Next(t time.Time) time.Time {
+	return t.Truncate(time.Duration(d)).Add(time.Duration(d))
+}
+
+// cron is a parsed cron expression, one bit per allowed value.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// anyDay is set when day of month or day of week is "*"; otherwise a day
+	// matching either field matches, as in cron(8).
+	anyDay bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a schedule in the five field cron format: minute, hour, day of
+// month, month and day of week, in local time. Fields take *, values, ranges such as
+// 1-5, steps such as */15 or 8-18/2, and comma separated lists of those. Day of week
+// runs from 0 for Sunday to 6, 7 is Sunday too. As in cron(8), when both day fields
+// are restricted a day matching either one fires.
+//
+// The macros @yearly, @monthly, @weekly, @daily, @hourly and "@every <duration>" are
+// accepted as well.
+func ParseCron(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil {
+			return nil, fmt.Errorf("timelapse: cron %q: %v", spec, err)
+		}
+		if every <= 0 {
+			return nil, fmt.Errorf("timelapse: cron %q: non-positive interval", spec)
+		}
+		return Every(every), nil
+	}
+	if m, ok := cronMacros[spec]; ok {
+		spec = m
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("timelapse: cron %q: want 5 fields, got %d", spec, len(fields))
+	}
+	var c cron
+	var err error
+	for i, f := range []struct {
+		set      *uint64
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	} {
+		if *f.set, err = parseField(fields[i], f.min, f.max); err != nil {
+			return nil, fmt.Errorf("timelapse: cron %q: %v", spec, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.anyDay = fields[2] == "*" || fields[4] == "*"
+	return &c, nil
+}
+
+func parseField(field string, lo, hi int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value in %q", part)
+				}
+			} else if hasStep {
+				to = hi
+			}
+			if from < lo || to > hi || from > to {
+				return 0, fmt.Errorf("%q out of range %d-%d", part, lo, hi)
+			}
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// maxYears bounds the search of expressions that never fire, such as February 30.
+const maxYears = 5
+
+func (c *cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(maxYears, 0, 0)
+	for t.Before(end) {
+		y, mo, d := t.Date()
+		switch {
+		case c.month&(1<<uint(mo)) == 0:
+			t = time.Date(y, mo+1, 1, 0, 0, 0, 0, loc)
+		case !c.day(t):
+			t = time.Date(y, mo, d+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(y, mo, d, t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			next := c.minute >> uint(t.Minute()) << uint(t.Minute())
+			if next == 0 {
+				t = time.Date(y, mo, d, t.Hour()+1, 0, 0, 0, loc)
+			} else {
+				t = t.Add(time.Duration(bits.TrailingZeros64(next)-t.Minute()) * time.Minute)
+			}
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cron) day(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDay {
+		return dom && dow
+	}
+	return dom || dow
+}
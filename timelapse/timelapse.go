@@ -0,0 +1,276 @@
+// Package timelapse takes screenshots on a schedule, an interval or a cron
+// expression, and files them into a directory tree by date:
+//
+//	dir/2024/05/01/120000-0.png
+//
+// where the last part is the display index. Captures identical to the previous one
+// of the same display are skipped, and a retention policy removes the oldest files
+// by count, age or total size. Hooks report every file saved and removed, e.g. to
+// upload or audit them.
+package timelapse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/encode"
+	"github.com/Fast-IQ/screenshot/hash"
+)
+
+// File is a capture in the directory.
+type File struct {
+	Path    string
+	Display int
+	// Time is the scheduled time of the capture.
+	Time time.Time
+	Size int64
+}
+
+// Options configures a Scheduler.
+type Options struct {
+	// Dir is the root of the directory tree, created if missing.
+	Dir string
+	// Schedule decides when to capture, default Every(time.Minute).
+	Schedule Schedule
+	// Displays lists the display indexes to capture, nil captures every display.
+	Displays []int
+	// Format is the image format, default PNG.
+	Format encode.Format
+	// Encode holds the format settings, nil uses the defaults.
+	Encode *encode.Options
+	// KeepIdentical saves captures identical to the previous one of their display,
+	// which are skipped by default.
+	KeepIdentical bool
+	// MaxDistance also skips captures whose perceptual hash (hash.DHash) is at most
+	// this many bits from that of the last file saved for their display, so that a
+	// blinking cursor or a ticking clock does not make a new file. Zero skips exact
+	// duplicates only.
+	MaxDistance int
+	// Retention limits the files kept in Dir. Files already there from earlier runs
+	// count too.
+	Retention Retention
+	// Active, if set, is called before each capture; nothing is captured while it
+	// returns false, e.g. while the user is idle or the screen is locked.
+	Active func() bool
+	// OnSave is called with every file saved.
+	OnSave func(File)
+	// OnRemove is called with every file removed by the retention policy.
+	OnRemove func(File)
+	// Capturer is the backend to use, nil means screenshot.DefaultCapturer().
+	Capturer screenshot.ScreenCapturer
+	// Location is the time zone of the directory tree and of cron schedules,
+	// default time.Local.
+	Location *time.Location
+}
+
+// tmpSuffix marks files being written, which are renamed once complete.
+const tmpSuffix = ".tmp"
+
+// Scheduler captures into a directory tree. It is not safe for concurrent use.
+type Scheduler struct {
+	opts  Options
+	last  map[int]fingerprint // last file saved per display
+	files []File              // oldest first
+	buf   bytes.Buffer
+}
+
+// New returns a Scheduler for opts and lists the captures already in opts.Dir.
+func New(opts Options) (*Scheduler, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("timelapse: no directory")
+	}
+	if opts.Retention.MaxCount < 0 || opts.Retention.MaxAge < 0 || opts.Retention.MaxBytes < 0 {
+		return nil, errors.New("timelapse: negative retention limit")
+	}
+	if opts.MaxDistance < 0 || opts.MaxDistance > 64 {
+		return nil, errors.New("timelapse: MaxDistance out of range 0-64")
+	}
+	if opts.Schedule == nil {
+		opts.Schedule = Every(time.Minute)
+	}
+	if opts.Format == "" {
+		opts.Format = encode.FormatPNG
+	}
+	if _, err := encode.ParseFormat(string(opts.Format)); err != nil {
+		return nil, err
+	}
+	if opts.Capturer == nil {
+		opts.Capturer = screenshot.DefaultCapturer()
+	}
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := scan(opts.Dir, opts.Location)
+	if err != nil {
+		return nil, err
+	}
+	return &Scheduler{opts: opts, last: make(map[int]fingerprint), files: files}, nil
+}
+
+// Files returns the captures in the directory that the scheduler knows of, oldest
+// first.
+func (s *Scheduler) Files() []File {
+	return s.files
+}
+
+// Capture captures the displays now, saves them as taken at t and applies the
+// retention policy at t. It returns the files saved, none when opts.Active reports
+// the user away or every display is unchanged.
+func (s *Scheduler) Capture(t time.Time) ([]File, error) {
+	if s.opts.Active != nil && !s.opts.Active() {
+		return nil, nil
+	}
+	images, err := s.capture()
+	if err != nil {
+		return nil, err
+	}
+	var saved []File
+	for _, d := range images {
+		fp := fingerprint{exact: hash.Exact(d.Image)}
+		if s.opts.MaxDistance > 0 {
+			fp.perceptual = hash.DHash(d.Image)
+		}
+		if last, ok := s.last[d.Index]; ok && !s.opts.KeepIdentical && s.unchanged(last, fp) {
+			continue
+		}
+		f, err := s.save(d, t)
+		if err != nil {
+			return saved, err
+		}
+		s.last[d.Index] = fp
+		saved = append(saved, f)
+		s.files = append(s.files, f)
+		if s.opts.OnSave != nil {
+			s.opts.OnSave(f)
+		}
+	}
+	return saved, s.prune(t)
+}
+
+func (s *Scheduler) capture() ([]screenshot.DisplayImage, error) {
+	c := s.opts.Capturer
+	if s.opts.Displays == nil {
+		return screenshot.CaptureAllDisplaysWith(c)
+	}
+	images := make([]screenshot.DisplayImage, 0, len(s.opts.Displays))
+	for _, i := range s.opts.Displays {
+		bounds, err := c.GetDisplayBounds(i)
+		if err != nil {
+			return nil, err
+		}
+		img, err := c.Capture(bounds.Min.X, bounds.Min.Y, bounds.Dx(), bounds.Dy())
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, screenshot.DisplayImage{Index: i, Bounds: bounds, Image: img})
+	}
+	return images, nil
+}
+
+// save writes d under its path for t. The image is written to a temporary file
+// first, so that the tree never holds a partial capture.
+func (s *Scheduler) save(d screenshot.DisplayImage, t time.Time) (File, error) {
+	s.buf.Reset()
+	if err := encode.Encode(&s.buf, d.Image, s.opts.Format, s.opts.Encode); err != nil {
+		return File{}, err
+	}
+	base := filepath.Join(s.opts.Dir, filepath.FromSlash(t.In(s.opts.Location).Format(layout)))
+	base += fmt.Sprintf("-%d", d.Index)
+	ext := s.opts.Format.Extension()
+	path := base + ext
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		path = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return File{}, err
+	}
+	if err := os.WriteFile(path+tmpSuffix, s.buf.Bytes(), 0644); err != nil {
+		return File{}, err
+	}
+	if err := os.Rename(path+tmpSuffix, path); err != nil {
+		_ = os.Remove(path + tmpSuffix)
+		return File{}, err
+	}
+	return File{Path: path, Display: d.Index, Time: t, Size: int64(s.buf.Len())}, nil
+}
+
+// prune removes the files exceeding the retention policy at now.
+func (s *Scheduler) prune(now time.Time) error {
+	if !s.opts.Retention.enabled() {
+		return nil
+	}
+	n := s.opts.Retention.expired(s.files, now)
+	for i, f := range s.files[:n] {
+		if err := os.Remove(f.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.files = s.files[i:]
+			return err
+		}
+		removeEmptyDirs(s.opts.Dir, f.Path)
+		if s.opts.OnRemove != nil {
+			s.opts.OnRemove(f)
+		}
+	}
+	s.files = s.files[n:]
+	return nil
+}
+
+// Run captures at every time of the schedule until ctx is done or a capture fails.
+// Scheduled times missed while a capture was running are skipped. Run returns
+// ctx.Err() when stopped by ctx.
+func (s *Scheduler) Run(ctx context.Context) error {
+	var last time.Time
+	for {
+		now := time.Now().In(s.opts.Location)
+		if now.Before(last) {
+			now = last
+		}
+		next := s.opts.Schedule.Next(now)
+		if next.IsZero() {
+			return errors.New("timelapse: schedule never fires")
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if _, err := s.Capture(next); err != nil {
+			return err
+		}
+		last = next
+	}
+}
+
+// Run is a shortcut for New followed by Scheduler.Run.
+func Run(ctx context.Context, opts Options) error {
+	s, err := New(opts)
+	if err != nil {
+		return err
+	}
+	return s.Run(ctx)
+}
+
+// fingerprint identifies the content of a capture.
+type fingerprint struct {
+	exact, perceptual uint64
+}
+
+func (s *Scheduler) unchanged(last, fp fingerprint) bool {
+	if last.exact == fp.exact {
+		return true
+	}
+	return s.opts.MaxDistance > 0 && hash.Distance(last.perceptual, fp.perceptual) <= s.opts.MaxDistance
+}
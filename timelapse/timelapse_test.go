@@ -0,0 +1,340 @@
+package timelapse
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Fast-IQ/screenshot/encode"
+	"github.com/Fast-IQ/screenshot/screenshottest"
+)
+
+var epoch = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// twoDisplays returns a capturer with a left display changing every capture and a
+// static right one.
+func twoDisplays() *screenshottest.Capturer {
+	c := screenshottest.New(image.Rect(0, 0, 40, 30), image.Rect(40, 0, 60, 30))
+	c.SetContent(func(frame, x, y int) color.RGBA {
+		if x < 40 {
+			return color.RGBA{uint8(frame * 40), 100, 200, 255}
+		}
+		return color.RGBA{10, 20, 30, 255}
+	})
+	return c
+}
+
+func rel(t *testing.T, dir string, files []File) []string {
+	t.Helper()
+	var paths []string
+	for _, f := range files {
+		p, err := filepath.Rel(dir, f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filepath.ToSlash(p))
+	}
+	return paths
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCapture(t *testing.T) {
+	dir := t.TempDir()
+	var saved []File
+	s, err := New(Options{
+		Dir:      dir,
+		Capturer: twoDisplays(),
+		Location: time.UTC,
+		OnSave:   func(f File) { saved = append(saved, f) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		if _, err := s.Capture(epoch.Add(time.Duration(i) * time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"2024/05/01/120000-0.png",
+		"2024/05/01/120000-1.png",
+		"2024/05/01/120100-0.png",
+		"2024/05/01/120200-0.png",
+	}
+	if got := rel(t, dir, saved); !equal(got, want) {
+		t.Fatalf("saved %v, want %v", got, want)
+	}
+	if got := rel(t, dir, s.Files()); !equal(got, want) {
+		t.Fatalf("Files() = %v, want %v", got, want)
+	}
+
+	f := saved[2]
+	if f.Display != 0 || !f.Time.Equal(epoch.Add(time.Minute)) {
+		t.Errorf("file = %+v", f)
+	}
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != f.Size {
+		t.Errorf("size %d, file has %d", f.Size, info.Size())
+	}
+	r, err := os.Open(f.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	img, err := png.Decode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b != image.Rect(0, 0, 40, 30) {
+		t.Errorf("bounds %v", b)
+	}
+	if c := color.RGBAModel.Convert(img.At(5, 5)).(color.RGBA); c != (color.RGBA{40, 100, 200, 255}) {
+		t.Errorf("pixel %v", c)
+	}
+}
+
+func TestCaptureOptions(t *testing.T) {
+	dir := t.TempDir()
+	active := false
+	s, err := New(Options{
+		Dir:           dir,
+		Capturer:      twoDisplays(),
+		Location:      time.UTC,
+		Displays:      []int{1},
+		Format:        encode.FormatJPEG,
+		KeepIdentical: true,
+		Active:        func() bool { return active },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := s.Capture(epoch)
+	if err != nil || len(files) != 0 {
+		t.Fatalf("inactive capture saved %v, %v", files, err)
+	}
+	active = true
+	for range 2 {
+		if _, err := s.Capture(epoch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"2024/05/01/120000-1.jpg", "2024/05/01/120000-1-1.jpg"}
+	if got := rel(t, dir, s.Files()); !equal(got, want) {
+		t.Fatalf("files %v, want %v", got, want)
+	}
+	if f := s.Files()[1]; f.Display != 1 {
+		t.Errorf("display %d", f.Display)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*/*/*/*"+tmpSuffix)); len(matches) != 0 {
+		t.Errorf("temporary files left: %v", matches)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	dir := t.TempDir()
+	c := twoDisplays()
+	opts := Options{Dir: dir, Capturer: c, Location: time.UTC, Displays: []int{0}}
+
+	// An earlier run left captures on two days, next to a file that is not one.
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, at := range []time.Duration{-25 * time.Hour, -24 * time.Hour, -2 * time.Hour, -time.Hour} {
+		if _, err := s.Capture(epoch.Add(at)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notes := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notes, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var removed []File
+	opts.Retention = Retention{MaxAge: 12 * time.Hour}
+	opts.OnRemove = func(f File) { removed = append(removed, f) }
+	s, err = New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Files()) != 4 {
+		t.Fatalf("found %d earlier files, want 4", len(s.Files()))
+	}
+	if _, err := s.Capture(epoch); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2024/04/30/110000-0.png", "2024/04/30/120000-0.png"}
+	if got := rel(t, dir, removed); !equal(got, want) {
+		t.Errorf("removed %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2024", "04")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("empty directories not removed: %v", err)
+	}
+	if _, err := os.Stat(notes); err != nil {
+		t.Errorf("unrelated file: %v", err)
+	}
+
+	// Count and size limits.
+	size := s.Files()[0].Size
+	s.opts.Retention = Retention{MaxCount: 2}
+	if _, err := s.Capture(epoch.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"2024/05/01/120000-0.png", "2024/05/01/120100-0.png"}
+	if got := rel(t, dir, s.Files()); !equal(got, want) {
+		t.Errorf("after MaxCount files %v, want %v", got, want)
+	}
+	s.opts.Retention = Retention{MaxBytes: size + size/2}
+	if _, err := s.Capture(epoch.Add(2 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"2024/05/01/120200-0.png"}
+	if got := rel(t, dir, s.Files()); !equal(got, want) {
+		t.Errorf("after MaxBytes files %v, want %v", got, want)
+	}
+	if len(removed) != 6 {
+		t.Errorf("OnRemove called %d times, want 6", len(removed))
+	}
+}
+
+// fastSchedule fires every 20ms.
+type fastSchedule struct{}
+
+func (fastSchedule) Next(t time.Time) time.Time { return t.Add(20 * time.Millisecond) }
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	saved := 0
+	err := Run(ctx, Options{
+		Dir:           dir,
+		Schedule:      fastSchedule{},
+		Capturer:      twoDisplays(),
+		Displays:      []int{0},
+		Format:        encode.FormatQOI,
+		KeepIdentical: true,
+		OnSave:        func(File) { saved++ },
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run returned %v", err)
+	}
+	if saved < 2 {
+		t.Errorf("saved %d files", saved)
+	}
+
+	cerr := errors.New("capture failed")
+	c := twoDisplays()
+	c.SetError(cerr)
+	if err := Run(context.Background(), Options{Dir: dir, Schedule: fastSchedule{}, Capturer: c}); !errors.Is(err, cerr) {
+		t.Errorf("Run with failing capturer returned %v", err)
+	}
+}
+
+func TestEvery(t *testing.T) {
+	s := Every(15 * time.Minute)
+	for _, tc := range []struct{ from, want time.Time }{
+		{epoch, epoch.Add(15 * time.Minute)},
+		{epoch.Add(time.Second), epoch.Add(15 * time.Minute)},
+		{epoch.Add(14 * time.Minute), epoch.Add(15 * time.Minute)},
+	} {
+		if got := s.Next(tc.from); !got.Equal(tc.want) {
+			t.Errorf("Next(%v) = %v, want %v", tc.from, got, tc.want)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	// 2024-05-01 is a Wednesday.
+	for _, tc := range []struct {
+		spec string
+		want []string
+	}{
+		{"* * * * *", []string{"2024-05-01 12:01", "2024-05-01 12:02"}},
+		{"*/20 * * * *", []string{"2024-05-01 12:20", "2024-05-01 12:40", "2024-05-01 13:00"}},
+		{"0,30 9-17 * * 1-5", []string{"2024-05-01 12:30", "2024-05-01 13:00"}},
+		{"0 9 * * 1-5", []string{"2024-05-02 09:00", "2024-05-03 09:00", "2024-05-06 09:00"}},
+		{"0 0 * * 7", []string{"2024-05-05 00:00", "2024-05-12 00:00"}},
+		{"15 8 1,15 * 0", []string{"2024-05-05 08:15", "2024-05-12 08:15", "2024-05-15 08:15"}},
+		{"0 0 29 2 *", []string{"2028-02-29 00:00"}},
+		{"5-50/15 12 * * *", []string{"2024-05-01 12:05", "2024-05-01 12:20", "2024-05-01 12:35", "2024-05-01 12:50", "2024-05-02 12:05"}},
+		{"@daily", []string{"2024-05-02 00:00", "2024-05-03 00:00"}},
+		{"@every 90m", []string{"2024-05-01 13:30", "2024-05-01 15:00"}},
+	} {
+		s, err := ParseCron(tc.spec)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tc.spec, err)
+			continue
+		}
+		at := epoch
+		for _, w := range tc.want {
+			want, _ := time.ParseInLocation("2006-01-02 15:04", w, time.UTC)
+			if at = s.Next(at); !at.Equal(want) {
+				t.Errorf("%q: next %v, want %v", tc.spec, at, want)
+				break
+			}
+		}
+	}
+
+	if s, _ := ParseCron("0 0 30 2 *"); !s.Next(epoch).IsZero() {
+		t.Error("February 30 fires")
+	}
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@every -1m", "@every soon"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestMaxDistance(t *testing.T) {
+	// A gradient with a blinking cursor, inverted from the fourth capture on.
+	c := screenshottest.New(image.Rect(0, 0, 64, 48))
+	c.SetContent(func(frame, x, y int) color.RGBA {
+		v := uint8(40 + 3*x)
+		if frame%2 == 1 && x == 20 && y >= 10 && y < 14 {
+			v = 0
+		}
+		if frame >= 3 {
+			v = 255 - v
+		}
+		return color.RGBA{v, v, v, 255}
+	})
+	dir := t.TempDir()
+	s, err := New(Options{Dir: dir, Capturer: c, Location: time.UTC, MaxDistance: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 4 {
+		if _, err := s.Capture(epoch.Add(time.Duration(i) * time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"2024/05/01/120000-0.png", "2024/05/01/120300-0.png"}
+	if got := rel(t, dir, s.Files()); !equal(got, want) {
+		t.Errorf("files %v, want %v", got, want)
+	}
+	if _, err := New(Options{Dir: dir, MaxDistance: 65}); err == nil {
+		t.Error("New accepted MaxDistance 65")
+	}
+}
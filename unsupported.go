@@ -4,12 +4,11 @@ package screenshot
 
 import (
 	"image"
+	"image/color"
 )
 
-// Capture returns screen capture of specified desktop region.
-// x and y represent distance from the upper-left corner of primary display.
-// Y-axis is downward direction. This means coordinates system is similar to Windows OS.
-func Capture(x, y, width, height int) (*image.RGBA, error) {
+// captureBackend captures with the platform backend, see Capture.
+func captureBackend(x, y, width, height int) (*image.RGBA, error) {
 	return nil, ErrUnsupported
 }
 
@@ -20,6 +19,36 @@ func NumActiveDisplays() int {
 
 // GetDisplayBounds returns the bounds of displayIndex'th display.
 // The main display is displayIndex = 0.
-func GetDisplayBounds(displayIndex int) image.Rectangle {
-	return image.Rectangle{}
+func GetDisplayBounds(displayIndex int) (image.Rectangle, error) {
+	return image.Rectangle{}, ErrUnsupported
+}
+
+// GetAllDisplayBounds returns the bounds of all active displays.
+func GetAllDisplayBounds() ([]image.Rectangle, error) {
+	return nil, ErrUnsupported
+}
+
+// ListWindows returns the visible top-level windows.
+func ListWindows() ([]Window, error) {
+	return nil, ErrUnsupported
+}
+
+func captureExcluding(x, y, width, height int, exclude []WindowID) (*image.RGBA, error) {
+	return nil, ErrUnsupported
+}
+
+func samplePoints(points []image.Point) ([]color.RGBA, error) {
+	return nil, ErrUnsupported
+}
+
+func displayProfile(displayIndex int) ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+func captureDeep(x, y, width, height int) (*image.RGBA64, error) {
+	return nil, ErrUnsupported
+}
+
+func watchArea(rect image.Rectangle) (areaBackend, error) {
+	return nil, ErrUnsupported
 }
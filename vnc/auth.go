@@ -0,0 +1,41 @@
+package vnc
+
+import (
+	"crypto/des"
+	"crypto/rand"
+	"crypto/subtle"
+	"math/bits"
+)
+
+// RFB security types.
+const (
+	securityNone = 1
+	securityVNC  = 2
+)
+
+// vncResponse returns the DES encryption of challenge with password, as the client computes it
+// for VNC authentication: the key is the first 8 bytes of the password, NUL padded, with the
+// bit order of every byte reversed.
+func vncResponse(password string, challenge []byte) []byte {
+	var key [8]byte
+	copy(key[:], password)
+	for i, b := range key {
+		key[i] = bits.Reverse8(b)
+	}
+	block, _ := des.NewCipher(key[:]) // the key has the right size
+	resp := make([]byte, len(challenge))
+	for i := 0; i+des.BlockSize <= len(challenge); i += des.BlockSize {
+		block.Encrypt(resp[i:], challenge[i:i+des.BlockSize])
+	}
+	return resp
+}
+
+func newChallenge() ([]byte, error) {
+	challenge := make([]byte, 16)
+	_, err := rand.Read(challenge)
+	return challenge, err
+}
+
+func checkResponse(password string, challenge, resp []byte) bool {
+	return subtle.ConstantTimeCompare(vncResponse(password, challenge), resp) == 1
+}
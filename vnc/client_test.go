@@ -0,0 +1,420 @@
+package vnc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// testClient is a minimal RFB 3.8 viewer decoding every encoding the server sends.
+type testClient struct {
+	t  *testing.T
+	nc net.Conn
+	r  *bufio.Reader
+
+	pf     pixelFormat
+	fb     *image.RGBA
+	name   string
+	cursor *image.RGBA
+	hot    image.Point
+	// rects counts the received rectangles per encoding.
+	rects map[int32]int
+
+	zbuf bytes.Buffer
+	zr   io.ReadCloser
+}
+
+// handshakeError is returned by dial when the server rejects the security handshake.
+type handshakeError struct{ reason string }
+
+func (e handshakeError) Error() string { return "handshake failed: " + e.reason }
+
+func dial(t *testing.T, addr, password string) (*testClient, error) {
+	t.Helper()
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = nc.Close() })
+	_ = nc.SetDeadline(time.Now().Add(10 * time.Second))
+	c := &testClient{t: t, nc: nc, r: bufio.NewReader(nc), rects: make(map[int32]int)}
+
+	var version [12]byte
+	c.read(version[:])
+	if string(version[:]) != "RFB 003.008\n" {
+		t.Fatalf("server version %q", version[:])
+	}
+	c.write([]byte("RFB 003.008\n"))
+
+	types := make([]byte, c.readU8())
+	c.read(types)
+	security := types[0]
+	c.write([]byte{security})
+	if security == securityVNC {
+		challenge := make([]byte, 16)
+		c.read(challenge)
+		c.write(vncResponse(password, challenge))
+	}
+	if c.readU32() != 0 {
+		reason := make([]byte, c.readU32())
+		c.read(reason)
+		return nil, handshakeError{string(reason)}
+	}
+
+	c.write([]byte{1})
+	w, h := c.readU16(), c.readU16()
+	var pf [16]byte
+	c.read(pf[:])
+	c.pf = unmarshalPixelFormat(pf[:])
+	name := make([]byte, c.readU32())
+	c.read(name)
+	c.name = string(name)
+	c.fb = image.NewRGBA(image.Rect(0, 0, int(w), int(h)))
+	return c, nil
+}
+
+func (c *testClient) read(b []byte) {
+	c.t.Helper()
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) readU8() uint8 {
+	var b [1]byte
+	c.read(b[:])
+	return b[0]
+}
+
+func (c *testClient) readU16() uint16 {
+	var b [2]byte
+	c.read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
+
+func (c *testClient) readU32() uint32 {
+	var b [4]byte
+	c.read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func (c *testClient) write(b []byte) {
+	c.t.Helper()
+	if _, err := c.nc.Write(b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) setPixelFormat(pf pixelFormat) {
+	c.pf = pf
+	c.write(append([]byte{msgSetPixelFormat, 0, 0, 0}, pf.marshal()...))
+}
+
+func (c *testClient) setEncodings(encodings ...int32) {
+	msg := []byte{msgSetEncodings, 0}
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(encodings)))
+	for _, e := range encodings {
+		msg = binary.BigEndian.AppendUint32(msg, uint32(e))
+	}
+	c.write(msg)
+}
+
+func (c *testClient) request(incremental bool) {
+	msg := []byte{msgFramebufferUpdateRequest, 0, 0, 0, 0, 0}
+	if incremental {
+		msg[1] = 1
+	}
+	msg = binary.BigEndian.AppendUint16(msg, uint16(c.fb.Rect.Dx()))
+	msg = binary.BigEndian.AppendUint16(msg, uint16(c.fb.Rect.Dy()))
+	c.write(msg)
+}
+
+// update reads one FramebufferUpdate and applies it, returning the encodings of its rectangles.
+func (c *testClient) update() []int32 {
+	c.t.Helper()
+	if t := c.readU8(); t != 0 {
+		c.t.Fatalf("message type %d, want FramebufferUpdate", t)
+	}
+	c.readU8()
+	var encodings []int32
+	for n := c.readU16(); n > 0; n-- {
+		x, y, w, h := int(c.readU16()), int(c.readU16()), int(c.readU16()), int(c.readU16())
+		r := image.Rect(x, y, x+w, y+h)
+		enc := int32(c.readU32())
+		encodings = append(encodings, enc)
+		c.rects[enc]++
+		if enc >= 0 && !r.In(c.fb.Rect) {
+			c.t.Fatalf("rectangle %v outside of framebuffer %v", r, c.fb.Rect)
+		}
+		switch enc {
+		case encodingRaw:
+			c.raw(c.fb, r)
+		case encodingCopyRect:
+			src := image.Pt(int(c.readU16()), int(c.readU16()))
+			tmp := image.NewRGBA(image.Rect(0, 0, w, h))
+			draw.Draw(tmp, tmp.Rect, c.fb, src, draw.Src)
+			draw.Draw(c.fb, r, tmp, image.Point{}, draw.Src)
+		case encodingHextile:
+			c.hextile(r)
+		case encodingZRLE:
+			c.zrle(r)
+		case encodingTightPNG:
+			c.tightPNG(r)
+		case encodingDesktopSize:
+			c.fb = image.NewRGBA(image.Rect(0, 0, w, h))
+		case encodingCursor:
+			c.cursor = image.NewRGBA(image.Rect(0, 0, w, h))
+			c.hot = image.Pt(x, y)
+			c.raw(c.cursor, c.cursor.Rect)
+			mask := make([]byte, (w+7)/8*h)
+			c.read(mask)
+		default:
+			c.t.Fatalf("unexpected encoding %d", enc)
+		}
+	}
+	return encodings
+}
+
+// pixel reads a PIXEL from r and converts it to a colour.
+func (c *testClient) pixel(r io.Reader, size int) color.RGBA {
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(r, b[:size]); err != nil {
+		c.t.Fatal(err)
+	}
+	var v uint32
+	switch {
+	case size == 1:
+		v = uint32(b[0])
+	case size == 2 && c.pf.BigEndian:
+		v = uint32(binary.BigEndian.Uint16(b))
+	case size == 2:
+		v = uint32(binary.LittleEndian.Uint16(b))
+	case c.pf.BigEndian:
+		v = binary.BigEndian.Uint32(b)
+	default:
+		v = binary.LittleEndian.Uint32(b)
+	}
+	return c.colour(v)
+}
+
+func (c *testClient) colour(v uint32) color.RGBA {
+	pf := c.pf
+	ch := func(shift uint8, max uint16) uint8 {
+		return uint8((v >> shift & uint32(max)) * 255 / uint32(max))
+	}
+	return color.RGBA{ch(pf.RedShift, pf.RedMax), ch(pf.GreenShift, pf.GreenMax), ch(pf.BlueShift, pf.BlueMax), 255}
+}
+
+// cpixel reads a ZRLE CPIXEL.
+func (c *testClient) cpixel(r io.Reader) color.RGBA {
+	skip, ok := c.pf.compact()
+	if !ok {
+		return c.pixel(r, c.pf.bytesPerPixel())
+	}
+	var b [3]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	full := append(append(append([]byte(nil), b[:skip]...), 0), b[skip:]...)
+	return c.pixel(bytes.NewReader(full), 4)
+}
+
+func (c *testClient) raw(dst *image.RGBA, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			dst.SetRGBA(x, y, c.pixel(c.r, c.pf.bytesPerPixel()))
+		}
+	}
+}
+
+func (c *testClient) fill(r image.Rectangle, col color.RGBA) {
+	draw.Draw(c.fb, r, image.NewUniform(col), image.Point{}, draw.Src)
+}
+
+func (c *testClient) hextile(r image.Rectangle) {
+	size := c.pf.bytesPerPixel()
+	var bg, fg color.RGBA
+	for ty := r.Min.Y; ty < r.Max.Y; ty += 16 {
+		for tx := r.Min.X; tx < r.Max.X; tx += 16 {
+			tile := image.Rect(tx, ty, tx+16, ty+16).Intersect(r)
+			flags := c.readU8()
+			if flags&hextileRaw != 0 {
+				for y := tile.Min.Y; y < tile.Max.Y; y++ {
+					for x := tile.Min.X; x < tile.Max.X; x++ {
+						c.fb.SetRGBA(x, y, c.pixel(c.r, size))
+					}
+				}
+				continue
+			}
+			if flags&hextileBackground != 0 {
+				bg = c.pixel(c.r, size)
+			}
+			if flags&hextileForeground != 0 {
+				fg = c.pixel(c.r, size)
+			}
+			c.fill(tile, bg)
+			if flags&hextileAnySubrects == 0 {
+				continue
+			}
+			for n := c.readU8(); n > 0; n-- {
+				col := fg
+				if flags&hextileSubrectsColoured != 0 {
+					col = c.pixel(c.r, size)
+				}
+				xy, wh := c.readU8(), c.readU8()
+				x, y := tile.Min.X+int(xy>>4), tile.Min.Y+int(xy&15)
+				c.fill(image.Rect(x, y, x+int(wh>>4)+1, y+int(wh&15)+1), col)
+			}
+		}
+	}
+}
+
+func (c *testClient) zrle(r image.Rectangle) {
+	data := make([]byte, c.readU32())
+	c.read(data)
+	c.zbuf.Write(data)
+	if c.zr == nil {
+		zr, err := zlib.NewReader(&c.zbuf)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		c.zr = zr
+	}
+	zr := c.zr
+	readByte := func() byte {
+		var b [1]byte
+		if _, err := io.ReadFull(zr, b[:]); err != nil {
+			c.t.Fatal(err)
+		}
+		return b[0]
+	}
+	for ty := r.Min.Y; ty < r.Max.Y; ty += 64 {
+		for tx := r.Min.X; tx < r.Max.X; tx += 64 {
+			tile := image.Rect(tx, ty, tx+64, ty+64).Intersect(r)
+			sub := readByte()
+			switch {
+			case sub == 0:
+				for y := tile.Min.Y; y < tile.Max.Y; y++ {
+					for x := tile.Min.X; x < tile.Max.X; x++ {
+						c.fb.SetRGBA(x, y, c.cpixel(zr))
+					}
+				}
+			case sub == 1:
+				c.fill(tile, c.cpixel(zr))
+			case sub <= 16:
+				palette := make([]color.RGBA, sub)
+				for i := range palette {
+					palette[i] = c.cpixel(zr)
+				}
+				bits := 4
+				switch {
+				case sub == 2:
+					bits = 1
+				case sub <= 4:
+					bits = 2
+				}
+				for y := tile.Min.Y; y < tile.Max.Y; y++ {
+					var b byte
+					n := 0
+					for x := tile.Min.X; x < tile.Max.X; x++ {
+						if n == 0 {
+							b, n = readByte(), 8
+						}
+						n -= bits
+						c.fb.SetRGBA(x, y, palette[int(b>>n)&(1<<bits-1)])
+					}
+				}
+			case sub == 128:
+				for i := 0; i < tile.Dx()*tile.Dy(); {
+					col := c.cpixel(zr)
+					n := 1
+					for {
+						b := readByte()
+						n += int(b)
+						if b != 255 {
+							break
+						}
+					}
+					for ; n > 0; n-- {
+						c.fb.SetRGBA(tile.Min.X+i%tile.Dx(), tile.Min.Y+i/tile.Dx(), col)
+						i++
+					}
+				}
+			default:
+				c.t.Fatalf("unexpected ZRLE subencoding %d", sub)
+			}
+		}
+	}
+}
+
+func (c *testClient) tightPNG(r image.Rectangle) {
+	switch ctl := c.readU8(); ctl {
+	case tightFill:
+		if c.pf.tightRGB() {
+			var rgb [3]byte
+			c.read(rgb[:])
+			c.fill(r, color.RGBA{rgb[0], rgb[1], rgb[2], 255})
+		} else {
+			c.fill(r, c.pixel(c.r, c.pf.bytesPerPixel()))
+		}
+	case tightPNG:
+		n, shift := 0, 0
+		for i := 0; i < 3; i++ {
+			b := c.readU8()
+			if i == 2 {
+				n |= int(b) << shift
+				break
+			}
+			n |= int(b&0x7f) << shift
+			shift += 7
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		data := make([]byte, n)
+		c.read(data)
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if img.Bounds().Size() != r.Size() {
+			c.t.Fatalf("PNG size %v, rectangle %v", img.Bounds().Size(), r.Size())
+		}
+		draw.Draw(c.fb, r, img, img.Bounds().Min, draw.Src)
+	default:
+		c.t.Fatalf("unexpected Tight compression control %#x", ctl)
+	}
+}
+
+// compare fails unless fb equals want within tolerance per channel.
+func compare(fb, want *image.RGBA, tolerance int) error {
+	if fb.Rect.Size() != want.Rect.Size() {
+		return fmt.Errorf("framebuffer size %v, want %v", fb.Rect.Size(), want.Rect.Size())
+	}
+	diff := func(a, b uint8) int {
+		if a > b {
+			return int(a - b)
+		}
+		return int(b - a)
+	}
+	for y := 0; y < want.Rect.Dy(); y++ {
+		for x := 0; x < want.Rect.Dx(); x++ {
+			got := fb.RGBAAt(fb.Rect.Min.X+x, fb.Rect.Min.Y+y)
+			exp := want.RGBAAt(want.Rect.Min.X+x, want.Rect.Min.Y+y)
+			if diff(got.R, exp.R) > tolerance || diff(got.G, exp.G) > tolerance || diff(got.B, exp.B) > tolerance {
+				return fmt.Errorf("pixel (%d,%d) = %v, want %v", x, y, got, exp)
+			}
+		}
+	}
+	return nil
+}
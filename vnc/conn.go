@@ -0,0 +1,539 @@
+package vnc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// handshakeTimeout bounds the time a client may take to get through the handshake.
+const handshakeTimeout = 10 * time.Second
+
+// Client to server message types.
+const (
+	msgSetPixelFormat           = 0
+	msgSetEncodings             = 2
+	msgFramebufferUpdateRequest = 3
+	msgKeyEvent                 = 4
+	msgPointerEvent             = 5
+	msgClientCutText            = 6
+)
+
+// maxCutText limits the clipboard text a client may send; the text is discarded anyway.
+const maxCutText = 1 << 20
+
+// conn is one client connection. The reading goroutine handles client messages,
+// the writing goroutine sends an update whenever the client asked for one and
+// there is something to send.
+type conn struct {
+	s     *Server
+	nc    net.Conn
+	r     *bufio.Reader
+	minor int // protocol minor version, 3, 7 or 8
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wake      chan struct{}
+
+	mu          sync.Mutex
+	pf          pixelFormat
+	enc         encoder
+	zrle        *zrleEncoder // kept for the whole connection, ZRLE has one zlib stream
+	copyRect    bool
+	desktopSize bool
+	cursor      bool
+	cursorSent  bool
+	width       int // framebuffer size as known by the client
+	height      int
+	fb          *image.RGBA // latest frame
+	origin      image.Point // desktop position of fb
+	cols, rows  int
+	dirty       []bool          // tiles changed since they were last sent
+	full        image.Rectangle // pending non-incremental request region
+	requested   bool
+	area        image.Rectangle // region of the pending request
+}
+
+func newConn(s *Server, nc net.Conn) *conn {
+	return &conn{
+		s:    s,
+		nc:   nc,
+		r:    bufio.NewReader(nc),
+		done: make(chan struct{}),
+		wake: make(chan struct{}, 1),
+		pf:   defaultFormat,
+		enc:  rawEncoder{},
+	}
+}
+
+func (c *conn) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		_ = c.nc.Close()
+	})
+}
+
+func (c *conn) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// handshake runs the protocol version, security and initialisation phases.
+func (c *conn) handshake() error {
+	_ = c.nc.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer c.nc.SetDeadline(time.Time{})
+
+	if _, err := io.WriteString(c.nc, "RFB 003.008\n"); err != nil {
+		return err
+	}
+	var version [12]byte
+	if _, err := io.ReadFull(c.r, version[:]); err != nil {
+		return err
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(string(version[:]), "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 {
+		return fmt.Errorf("vnc: unsupported protocol version %q", version[:])
+	}
+	switch {
+	case minor >= 8:
+		c.minor = 8
+	case minor == 7:
+		c.minor = 7
+	default:
+		c.minor = 3
+	}
+
+	security := byte(securityNone)
+	if c.s.opts.Password != "" {
+		security = securityVNC
+	}
+	if c.minor == 3 {
+		if err := binary.Write(c.nc, binary.BigEndian, uint32(security)); err != nil {
+			return err
+		}
+	} else {
+		if _, err := c.nc.Write([]byte{1, security}); err != nil {
+			return err
+		}
+		chosen, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if chosen != security {
+			return c.securityFailed("unsupported security type")
+		}
+	}
+
+	if security == securityVNC {
+		challenge, err := newChallenge()
+		if err != nil {
+			return err
+		}
+		if _, err := c.nc.Write(challenge); err != nil {
+			return err
+		}
+		resp := make([]byte, len(challenge))
+		if _, err := io.ReadFull(c.r, resp); err != nil {
+			return err
+		}
+		if !checkResponse(c.s.opts.Password, challenge, resp) {
+			return c.securityFailed("authentication failed")
+		}
+	}
+	if security == securityVNC || c.minor == 8 {
+		if err := binary.Write(c.nc, binary.BigEndian, uint32(0)); err != nil {
+			return err
+		}
+	}
+
+	// ClientInit: the shared flag does not matter, every client shares the desktop.
+	if _, err := c.r.ReadByte(); err != nil {
+		return err
+	}
+	rect, err := c.s.bounds()
+	if err != nil {
+		return err
+	}
+	c.width, c.height = rect.Dx(), rect.Dy()
+	msg := binary.BigEndian.AppendUint16(nil, uint16(c.width))
+	msg = binary.BigEndian.AppendUint16(msg, uint16(c.height))
+	msg = append(msg, c.pf.marshal()...)
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(c.s.opts.Name)))
+	msg = append(msg, c.s.opts.Name...)
+	_, err = c.nc.Write(msg)
+	return err
+}
+
+// securityFailed reports a failed security handshake to the client.
+func (c *conn) securityFailed(reason string) error {
+	msg := binary.BigEndian.AppendUint32(nil, 1)
+	if c.minor == 8 {
+		msg = binary.BigEndian.AppendUint32(msg, uint32(len(reason)))
+		msg = append(msg, reason...)
+	}
+	_, _ = c.nc.Write(msg)
+	return errors.New("vnc: " + reason)
+}
+
+// serve handles client messages and runs the writing goroutine until either fails.
+func (c *conn) serve() error {
+	go c.writeLoop()
+	err := c.readLoop()
+	c.close()
+	return err
+}
+
+func (c *conn) readLoop() error {
+	buf := make([]byte, 20)
+	for {
+		t, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case msgSetPixelFormat:
+			if _, err := io.ReadFull(c.r, buf[:19]); err != nil {
+				return err
+			}
+			pf := unmarshalPixelFormat(buf[3:19])
+			if err := pf.validate(); err != nil {
+				return err
+			}
+			c.mu.Lock()
+			c.pf = pf
+			c.mu.Unlock()
+
+		case msgSetEncodings:
+			if _, err := io.ReadFull(c.r, buf[:3]); err != nil {
+				return err
+			}
+			encodings := make([]int32, binary.BigEndian.Uint16(buf[1:]))
+			if err := binary.Read(c.r, binary.BigEndian, encodings); err != nil {
+				return err
+			}
+			c.setEncodings(encodings)
+
+		case msgFramebufferUpdateRequest:
+			if _, err := io.ReadFull(c.r, buf[:9]); err != nil {
+				return err
+			}
+			x, y := int(binary.BigEndian.Uint16(buf[1:])), int(binary.BigEndian.Uint16(buf[3:]))
+			w, h := int(binary.BigEndian.Uint16(buf[5:])), int(binary.BigEndian.Uint16(buf[7:]))
+			r := image.Rect(x, y, x+w, y+h)
+			c.mu.Lock()
+			c.requested = true
+			c.area = r
+			if buf[0] == 0 {
+				c.full = c.full.Union(r)
+			}
+			c.mu.Unlock()
+			c.signal()
+
+		case msgKeyEvent:
+			if _, err := io.ReadFull(c.r, buf[:7]); err != nil {
+				return err
+			}
+			if in := c.input(); in != nil {
+				in.Key(buf[0] != 0, binary.BigEndian.Uint32(buf[3:]))
+			}
+
+		case msgPointerEvent:
+			if _, err := io.ReadFull(c.r, buf[:5]); err != nil {
+				return err
+			}
+			if in := c.input(); in != nil {
+				c.mu.Lock()
+				origin := c.origin
+				c.mu.Unlock()
+				x := int(binary.BigEndian.Uint16(buf[1:])) + origin.X
+				y := int(binary.BigEndian.Uint16(buf[3:])) + origin.Y
+				in.Pointer(buf[0], x, y)
+			}
+
+		case msgClientCutText:
+			if _, err := io.ReadFull(c.r, buf[:7]); err != nil {
+				return err
+			}
+			n := binary.BigEndian.Uint32(buf[3:])
+			if n > maxCutText {
+				return errors.New("vnc: cut text too long")
+			}
+			if _, err := c.r.Discard(int(n)); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("vnc: unknown client message type %d", t)
+		}
+	}
+}
+
+// input returns the receiver of input events, nil when they are dropped.
+func (c *conn) input() Input {
+	if c.s.opts.ViewOnly {
+		return nil
+	}
+	return c.s.opts.Input
+}
+
+// setEncodings picks the first supported pixel encoding of the client's
+// preference list and records the supported pseudo-encodings.
+func (c *conn) setEncodings(encodings []int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enc = nil
+	c.copyRect, c.desktopSize, c.cursor = false, false, false
+	for _, e := range encodings {
+		switch e {
+		case encodingCopyRect:
+			c.copyRect = true
+		case encodingDesktopSize:
+			c.desktopSize = true
+		case encodingCursor:
+			if !c.cursor {
+				c.cursorSent = false
+			}
+			c.cursor = true
+		case encodingZRLE:
+			if c.enc == nil {
+				if c.zrle == nil {
+					c.zrle = newZRLEEncoder()
+				}
+				c.enc = c.zrle
+			}
+		default:
+			if c.enc == nil {
+				c.enc = newEncoder(e)
+			}
+		}
+	}
+	if c.enc == nil {
+		c.enc = rawEncoder{}
+	}
+}
+
+// setFrame hands the client a new frame whose changed tiles are dirty.
+func (c *conn) setFrame(img *image.RGBA, origin image.Point, dirty []image.Rectangle) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cols := (img.Rect.Dx() + tileSize - 1) / tileSize
+	rows := (img.Rect.Dy() + tileSize - 1) / tileSize
+	if c.fb == nil || c.fb.Rect != img.Rect {
+		c.cols, c.rows = cols, rows
+		c.dirty = make([]bool, cols*rows)
+		for i := range c.dirty {
+			c.dirty[i] = true
+		}
+	} else {
+		for _, r := range dirty {
+			p := r.Min.Sub(img.Rect.Min)
+			c.dirty[p.Y/tileSize*cols+p.X/tileSize] = true
+		}
+	}
+	c.fb = img
+	c.origin = origin
+	c.signal()
+}
+
+func (c *conn) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.wake:
+		}
+		msg := c.update()
+		if msg == nil {
+			continue
+		}
+		if _, err := c.nc.Write(msg); err != nil {
+			c.close()
+			return
+		}
+	}
+}
+
+// rect is one rectangle of a framebuffer update.
+type rect struct {
+	r    image.Rectangle
+	copy bool // send as CopyRect from src
+	src  image.Point
+}
+
+// update returns the next FramebufferUpdate message, nil if nothing is to be sent yet.
+func (c *conn) update() []byte {
+	c.mu.Lock()
+	if !c.requested || c.fb == nil {
+		c.mu.Unlock()
+		return nil
+	}
+	fb, pf, enc := c.fb, c.pf, c.enc
+
+	if c.desktopSize && (fb.Rect.Dx() != c.width || fb.Rect.Dy() != c.height) {
+		// The client resizes its framebuffer and requests again, the whole new
+		// framebuffer is still dirty after setFrame.
+		c.width, c.height = fb.Rect.Dx(), fb.Rect.Dy()
+		c.requested = false
+		c.full = image.Rectangle{}
+		c.mu.Unlock()
+		return appendRectHeader([]byte{0, 0, 0, 1}, image.Rect(0, 0, fb.Rect.Dx(), fb.Rect.Dy()), encodingDesktopSize)
+	}
+	sendCursor := c.cursor && !c.cursorSent
+	c.cursorSent = c.cursorSent || sendCursor
+	rects := c.takeDirty(fb)
+	if len(rects) == 0 && !sendCursor {
+		c.mu.Unlock()
+		return nil
+	}
+	c.requested = false
+	c.full = image.Rectangle{}
+	c.mu.Unlock()
+
+	// Encoding happens without c.mu, so new frames are not held up by a slow client.
+	n := len(rects)
+	if sendCursor {
+		n++
+	}
+	msg := []byte{0, 0}
+	msg = binary.BigEndian.AppendUint16(msg, uint16(n))
+	if sendCursor {
+		cur, hot := c.s.opts.Cursor, c.s.opts.CursorHotspot
+		msg = appendRectHeader(msg, image.Rect(hot.X, hot.Y, hot.X+cur.Rect.Dx(), hot.Y+cur.Rect.Dy()), encodingCursor)
+		msg = appendCursor(msg, cur, pf)
+	}
+	for _, r := range rects {
+		if r.copy {
+			msg = appendRectHeader(msg, r.r.Sub(fb.Rect.Min), encodingCopyRect)
+			msg = binary.BigEndian.AppendUint16(msg, uint16(r.src.X-fb.Rect.Min.X))
+			msg = binary.BigEndian.AppendUint16(msg, uint16(r.src.Y-fb.Rect.Min.Y))
+			continue
+		}
+		msg = appendRectHeader(msg, r.r.Sub(fb.Rect.Min), enc.encoding())
+		msg = enc.encode(msg, fb, r.r, pf)
+	}
+	return msg
+}
+
+// takeDirty returns the rectangles to send for the pending request and clears their
+// tiles: the dirty tiles and, for a non-incremental request, every tile of its region,
+// as far as they are inside the client's framebuffer. Runs of tiles in a row are
+// merged, and a full tile that equals an unchanged tile is sent as CopyRect when the
+// client supports it. It must be called with c.mu held.
+func (c *conn) takeDirty(fb *image.RGBA) []rect {
+	visible := image.Rect(0, 0, c.width, c.height).Add(fb.Rect.Min).Intersect(fb.Rect)
+	area := c.area.Add(fb.Rect.Min).Intersect(visible)
+	full := c.full.Add(fb.Rect.Min)
+	tile := func(i int) image.Rectangle {
+		x := fb.Rect.Min.X + i%c.cols*tileSize
+		y := fb.Rect.Min.Y + i/c.cols*tileSize
+		return image.Rect(x, y, x+tileSize, y+tileSize).Intersect(visible)
+	}
+
+	send := make([]bool, len(c.dirty))
+	n := 0
+	for i := range c.dirty {
+		t := tile(i)
+		if !t.Empty() && t.Overlaps(area) && (c.dirty[i] || t.Overlaps(full)) {
+			send[i] = true
+			n++
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+
+	var sources map[uint64][]int
+	if c.copyRect {
+		sources = make(map[uint64][]int)
+		for i := range c.dirty {
+			if t := tile(i); !send[i] && !c.dirty[i] && t.Size() == (image.Point{tileSize, tileSize}) {
+				h := hashRect(fb, t)
+				sources[h] = append(sources[h], i)
+			}
+		}
+	}
+
+	var rects []rect
+	for row := 0; row < c.rows; row++ {
+		var run image.Rectangle
+		flush := func() {
+			if !run.Empty() {
+				rects = append(rects, rect{r: run})
+				run = image.Rectangle{}
+			}
+		}
+		for col := 0; col < c.cols; col++ {
+			i := row*c.cols + col
+			if !send[i] {
+				flush()
+				continue
+			}
+			c.dirty[i] = false
+			t := tile(i)
+			if src, ok := findCopy(fb, t, sources); ok {
+				flush()
+				rects = append(rects, rect{r: t, copy: true, src: src})
+				continue
+			}
+			run = run.Union(t)
+		}
+		flush()
+	}
+	return rects
+}
+
+// findCopy returns the position of an unchanged tile with the same pixels as t.
+func findCopy(fb *image.RGBA, t image.Rectangle, sources map[uint64][]int) (image.Point, bool) {
+	if len(sources) == 0 || t.Size() != (image.Point{tileSize, tileSize}) {
+		return image.Point{}, false
+	}
+	cols := (fb.Rect.Dx() + tileSize - 1) / tileSize
+	for _, i := range sources[hashRect(fb, t)] {
+		src := fb.Rect.Min.Add(image.Pt(i%cols*tileSize, i/cols*tileSize))
+		if sameRect(fb, t, src) {
+			return src, true
+		}
+	}
+	return image.Point{}, false
+}
+
+// hashRect returns the FNV-1a hash of the pixels of r.
+func hashRect(img *image.RGBA, r image.Rectangle) uint64 {
+	h := fnv.New64a()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := img.PixOffset(r.Min.X, y)
+		_, _ = h.Write(img.Pix[i : i+r.Dx()*4])
+	}
+	return h.Sum64()
+}
+
+// sameRect reports whether the pixels of r equal those of the same size at src.
+func sameRect(img *image.RGBA, r image.Rectangle, src image.Point) bool {
+	n := r.Dx() * 4
+	for y := 0; y < r.Dy(); y++ {
+		i := img.PixOffset(r.Min.X, r.Min.Y+y)
+		j := img.PixOffset(src.X, src.Y+y)
+		if !bytes.Equal(img.Pix[i:i+n], img.Pix[j:j+n]) {
+			return false
+		}
+	}
+	return true
+}
+
+func appendRectHeader(buf []byte, r image.Rectangle, encoding int32) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(r.Min.X))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(r.Min.Y))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(r.Dx()))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(r.Dy()))
+	return binary.BigEndian.AppendUint32(buf, uint32(encoding))
+}
@@ -0,0 +1,62 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+)
+
+// arrowShape is the default cursor: '#' is black, '.' is white, anything else transparent.
+var arrowShape = []string{
+	"#           ",
+	"##          ",
+	"#.#         ",
+	"#..#        ",
+	"#...#       ",
+	"#....#      ",
+	"#.....#     ",
+	"#......#    ",
+	"#.......#   ",
+	"#........#  ",
+	"#.........# ",
+	"#......#####",
+	"#...#..#    ",
+	"#..# #..#   ",
+	"#.#  #..#   ",
+	"##    #..#  ",
+	"      #..#  ",
+	"       ##   ",
+}
+
+// defaultCursor returns the arrow cursor sent when Options.Cursor is nil.
+func defaultCursor() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, len(arrowShape[0]), len(arrowShape)))
+	for y, row := range arrowShape {
+		for x, c := range row {
+			switch c {
+			case '#':
+				img.SetRGBA(x, y, color.RGBA{A: 255})
+			case '.':
+				img.SetRGBA(x, y, color.RGBA{255, 255, 255, 255})
+			}
+		}
+	}
+	return img
+}
+
+// appendCursor appends the payload of a Cursor pseudo-encoding rectangle: the pixels
+// followed by a bitmask with one bit per pixel, set for pixels with alpha >= 128.
+func appendCursor(buf []byte, img *image.RGBA, pf pixelFormat) []byte {
+	r := img.Rect
+	buf = rawEncoder{}.encode(buf, img, r, pf)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := make([]byte, (r.Dx()+7)/8)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if img.RGBAAt(x, y).A >= 128 {
+				i := x - r.Min.X
+				row[i/8] |= 0x80 >> (i % 8)
+			}
+		}
+		buf = append(buf, row...)
+	}
+	return buf
+}
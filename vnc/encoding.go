@@ -0,0 +1,323 @@
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/png"
+)
+
+// RFB encodings and pseudo-encodings.
+const (
+	encodingRaw         = 0
+	encodingCopyRect    = 1
+	encodingHextile     = 5
+	encodingZRLE        = 16
+	encodingTightPNG    = -260
+	encodingCursor      = -239
+	encodingDesktopSize = -223
+)
+
+// encoder writes the payload of a framebuffer update rectangle.
+type encoder interface {
+	encoding() int32
+	// encode appends the r part of img, in the pixel format pf, to buf.
+	encode(buf []byte, img *image.RGBA, r image.Rectangle, pf pixelFormat) []byte
+}
+
+// newEncoder returns the encoder for a pixel encoding, nil for unsupported ones.
+func newEncoder(encoding int32) encoder {
+	switch encoding {
+	case encodingRaw:
+		return rawEncoder{}
+	case encodingHextile:
+		return hextileEncoder{}
+	case encodingZRLE:
+		return newZRLEEncoder()
+	case encodingTightPNG:
+		return tightPNGEncoder{}
+	}
+	return nil
+}
+
+type rawEncoder struct{}
+
+func (rawEncoder) encoding() int32 { return encodingRaw }
+
+func (rawEncoder) encode(buf []byte, img *image.RGBA, r image.Rectangle, pf pixelFormat) []byte {
+	for _, v := range pf.pixels(img, r) {
+		buf = pf.appendPixel(buf, v)
+	}
+	return buf
+}
+
+// Hextile subencoding flags.
+const (
+	hextileRaw              = 1
+	hextileBackground       = 2
+	hextileForeground       = 4
+	hextileAnySubrects      = 8
+	hextileSubrectsColoured = 16
+)
+
+type hextileEncoder struct{}
+
+func (hextileEncoder) encoding() int32 { return encodingHextile }
+
+// encode sends solid 16×16 tiles as a background colour, tiles with few colour runs as
+// coloured subrectangles on the most common colour and everything else raw.
+func (hextileEncoder) encode(buf []byte, img *image.RGBA, r image.Rectangle, pf pixelFormat) []byte {
+	bpp := pf.bytesPerPixel()
+	var bg uint32
+	bgValid := false
+	for ty := r.Min.Y; ty < r.Max.Y; ty += 16 {
+		for tx := r.Min.X; tx < r.Max.X; tx += 16 {
+			tile := image.Rect(tx, ty, tx+16, ty+16).Intersect(r)
+			w, h := tile.Dx(), tile.Dy()
+			px := pf.pixels(img, tile)
+			tileBG := mostCommon(px)
+
+			type subrect struct {
+				colour uint32
+				x, y   int
+				w      int
+			}
+			var subs []subrect
+			for y := 0; y < h && len(subs) <= 255; y++ {
+				row := px[y*w : (y+1)*w]
+				for x := 0; x < w; {
+					if row[x] == tileBG {
+						x++
+						continue
+					}
+					end := x + 1
+					for end < w && row[end] == row[x] {
+						end++
+					}
+					subs = append(subs, subrect{row[x], x, y, end - x})
+					x = end
+				}
+			}
+
+			flags := byte(0)
+			if !bgValid || bg != tileBG {
+				flags |= hextileBackground
+			}
+			size := 1 + len(subs)*(bpp+2)
+			if len(subs) > 255 || size >= w*h*bpp {
+				buf = append(buf, hextileRaw)
+				for _, v := range px {
+					buf = pf.appendPixel(buf, v)
+				}
+				bgValid = false
+				continue
+			}
+			if len(subs) > 0 {
+				flags |= hextileAnySubrects | hextileSubrectsColoured
+			}
+			buf = append(buf, flags)
+			if flags&hextileBackground != 0 {
+				buf = pf.appendPixel(buf, tileBG)
+				bg, bgValid = tileBG, true
+			}
+			if len(subs) > 0 {
+				buf = append(buf, byte(len(subs)))
+				for _, s := range subs {
+					buf = pf.appendPixel(buf, s.colour)
+					buf = append(buf, byte(s.x<<4|s.y), byte((s.w-1)<<4))
+				}
+			}
+		}
+	}
+	return buf
+}
+
+// mostCommon returns the most frequent value of px.
+func mostCommon(px []uint32) uint32 {
+	counts := make(map[uint32]int)
+	best, bestN := px[0], 0
+	for _, v := range px {
+		counts[v]++
+		if n := counts[v]; n > bestN {
+			best, bestN = v, n
+		}
+	}
+	return best
+}
+
+// zrleEncoder keeps the zlib stream that ZRLE shares across all rectangles of a connection.
+type zrleEncoder struct {
+	out bytes.Buffer
+	zw  *zlib.Writer
+}
+
+func newZRLEEncoder() *zrleEncoder {
+	e := &zrleEncoder{}
+	e.zw = zlib.NewWriter(&e.out)
+	return e
+}
+
+func (*zrleEncoder) encoding() int32 { return encodingZRLE }
+
+func (e *zrleEncoder) encode(buf []byte, img *image.RGBA, r image.Rectangle, pf pixelFormat) []byte {
+	var data []byte
+	for ty := r.Min.Y; ty < r.Max.Y; ty += 64 {
+		for tx := r.Min.X; tx < r.Max.X; tx += 64 {
+			tile := image.Rect(tx, ty, tx+64, ty+64).Intersect(r)
+			data = zrleTile(data, pf.pixels(img, tile), tile.Dx(), pf)
+		}
+	}
+	e.out.Reset()
+	_, _ = e.zw.Write(data) // writes to a bytes.Buffer do not fail
+	_ = e.zw.Flush()
+	buf = binary.BigEndian.AppendUint32(buf, uint32(e.out.Len()))
+	return append(buf, e.out.Bytes()...)
+}
+
+// zrleTile appends one ZRLE tile: solid, packed palette for up to 16 colours,
+// and the smaller of plain RLE and raw otherwise.
+func zrleTile(buf []byte, px []uint32, w int, pf pixelFormat) []byte {
+	var palette []uint32
+	index := make(map[uint32]int)
+	for _, v := range px {
+		if _, ok := index[v]; !ok {
+			if len(palette) == 16 {
+				palette = nil
+				break
+			}
+			index[v] = len(palette)
+			palette = append(palette, v)
+		}
+	}
+
+	switch {
+	case len(palette) == 1:
+		buf = append(buf, 1)
+		return pf.appendCPixel(buf, palette[0])
+	case len(palette) > 1:
+		buf = append(buf, byte(len(palette)))
+		for _, v := range palette {
+			buf = pf.appendCPixel(buf, v)
+		}
+		bits := 4
+		switch {
+		case len(palette) == 2:
+			bits = 1
+		case len(palette) <= 4:
+			bits = 2
+		}
+		for y := 0; y < len(px)/w; y++ {
+			var b byte
+			n := 0
+			for _, v := range px[y*w : (y+1)*w] {
+				b = b<<bits | byte(index[v])
+				n += bits
+				if n == 8 {
+					buf = append(buf, b)
+					b, n = 0, 0
+				}
+			}
+			if n > 0 {
+				buf = append(buf, b<<(8-n))
+			}
+		}
+		return buf
+	}
+
+	type run struct {
+		v uint32
+		n int
+	}
+	var runs []run
+	rleSize := 0
+	for i := 0; i < len(px); {
+		j := i + 1
+		for j < len(px) && px[j] == px[i] {
+			j++
+		}
+		runs = append(runs, run{px[i], j - i})
+		rleSize += (j-i-1)/255 + 1
+		i = j
+	}
+	cpixel := len(pf.appendCPixel(nil, 0))
+	if len(runs)*cpixel+rleSize < len(px)*cpixel {
+		buf = append(buf, 128)
+		for _, r := range runs {
+			buf = pf.appendCPixel(buf, r.v)
+			n := r.n - 1
+			for ; n >= 255; n -= 255 {
+				buf = append(buf, 255)
+			}
+			buf = append(buf, byte(n))
+		}
+		return buf
+	}
+	buf = append(buf, 0)
+	for _, v := range px {
+		buf = pf.appendCPixel(buf, v)
+	}
+	return buf
+}
+
+// Tight compression control values used by Tight-PNG.
+const (
+	tightFill = 0x80
+	tightPNG  = 0xa0
+)
+
+type tightPNGEncoder struct{}
+
+func (tightPNGEncoder) encoding() int32 { return encodingTightPNG }
+
+// encode sends a solid rectangle as a fill colour and anything else as a PNG image.
+func (tightPNGEncoder) encode(buf []byte, img *image.RGBA, r image.Rectangle, pf pixelFormat) []byte {
+	if c, ok := solid(img, r); ok {
+		buf = append(buf, tightFill)
+		if pf.tightRGB() {
+			return append(buf, c[0], c[1], c[2])
+		}
+		return pf.appendPixel(buf, pf.pixel(c[0], c[1], c[2]))
+	}
+
+	sub := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	for y := 0; y < r.Dy(); y++ {
+		i := img.PixOffset(r.Min.X, r.Min.Y+y)
+		row := sub.Pix[y*sub.Stride : y*sub.Stride+r.Dx()*4]
+		copy(row, img.Pix[i:])
+		for x := 3; x < len(row); x += 4 {
+			row[x] = 255
+		}
+	}
+	var data bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	_ = enc.Encode(&data, sub) // writes to a bytes.Buffer do not fail
+	buf = append(buf, tightPNG)
+	buf = appendCompactLen(buf, data.Len())
+	return append(buf, data.Bytes()...)
+}
+
+// appendCompactLen appends n in the 1 to 3 byte Tight length representation.
+func appendCompactLen(buf []byte, n int) []byte {
+	for i := 0; i < 2 && n >= 0x80; i++ {
+		buf = append(buf, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(buf, byte(n))
+}
+
+// solid reports whether r of img has a single colour, and returns it.
+func solid(img *image.RGBA, r image.Rectangle) ([3]uint8, bool) {
+	i := img.PixOffset(r.Min.X, r.Min.Y)
+	c := [3]uint8{img.Pix[i], img.Pix[i+1], img.Pix[i+2]}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := img.PixOffset(r.Min.X, y)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if img.Pix[i] != c[0] || img.Pix[i+1] != c[1] || img.Pix[i+2] != c[2] {
+				return c, false
+			}
+			i += 4
+		}
+	}
+	return c, true
+}
@@ -0,0 +1,150 @@
+package vnc
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+)
+
+// pixelFormat is the RFB PIXEL_FORMAT structure.
+type pixelFormat struct {
+	BPP, Depth                      uint8
+	BigEndian, TrueColour           bool
+	RedMax, GreenMax, BlueMax       uint16
+	RedShift, GreenShift, BlueShift uint8
+}
+
+// defaultFormat is the 32-bit little-endian xRGB format announced in ServerInit.
+var defaultFormat = pixelFormat{
+	BPP: 32, Depth: 24, TrueColour: true,
+	RedMax: 255, GreenMax: 255, BlueMax: 255,
+	RedShift: 16, GreenShift: 8, BlueShift: 0,
+}
+
+func (pf pixelFormat) marshal() []byte {
+	b := make([]byte, 16)
+	b[0], b[1] = pf.BPP, pf.Depth
+	if pf.BigEndian {
+		b[2] = 1
+	}
+	if pf.TrueColour {
+		b[3] = 1
+	}
+	binary.BigEndian.PutUint16(b[4:], pf.RedMax)
+	binary.BigEndian.PutUint16(b[6:], pf.GreenMax)
+	binary.BigEndian.PutUint16(b[8:], pf.BlueMax)
+	b[10], b[11], b[12] = pf.RedShift, pf.GreenShift, pf.BlueShift
+	return b
+}
+
+func unmarshalPixelFormat(b []byte) pixelFormat {
+	return pixelFormat{
+		BPP:        b[0],
+		Depth:      b[1],
+		BigEndian:  b[2] != 0,
+		TrueColour: b[3] != 0,
+		RedMax:     binary.BigEndian.Uint16(b[4:]),
+		GreenMax:   binary.BigEndian.Uint16(b[6:]),
+		BlueMax:    binary.BigEndian.Uint16(b[8:]),
+		RedShift:   b[10],
+		GreenShift: b[11],
+		BlueShift:  b[12],
+	}
+}
+
+func (pf pixelFormat) validate() error {
+	if !pf.TrueColour {
+		return errors.New("vnc: colour map pixel formats are not supported")
+	}
+	switch pf.BPP {
+	case 8, 16, 32:
+	default:
+		return errors.New("vnc: invalid bits per pixel")
+	}
+	if pf.RedMax == 0 || pf.GreenMax == 0 || pf.BlueMax == 0 {
+		return errors.New("vnc: invalid colour maximum")
+	}
+	return nil
+}
+
+// bytesPerPixel returns the size of a PIXEL.
+func (pf pixelFormat) bytesPerPixel() int {
+	return int(pf.BPP) / 8
+}
+
+// pixel converts an 8-bit RGB colour to a pixel value.
+func (pf pixelFormat) pixel(r, g, b uint8) uint32 {
+	return scaleChannel(r, pf.RedMax)<<pf.RedShift |
+		scaleChannel(g, pf.GreenMax)<<pf.GreenShift |
+		scaleChannel(b, pf.BlueMax)<<pf.BlueShift
+}
+
+// scaleChannel rounds an 8-bit channel value to the range 0..max.
+func scaleChannel(v uint8, max uint16) uint32 {
+	return (uint32(v)*uint32(max) + 127) / 255
+}
+
+// appendPixel appends v as a PIXEL in the client's byte order.
+func (pf pixelFormat) appendPixel(buf []byte, v uint32) []byte {
+	switch pf.BPP {
+	case 8:
+		return append(buf, uint8(v))
+	case 16:
+		if pf.BigEndian {
+			return binary.BigEndian.AppendUint16(buf, uint16(v))
+		}
+		return binary.LittleEndian.AppendUint16(buf, uint16(v))
+	default:
+		if pf.BigEndian {
+			return binary.BigEndian.AppendUint32(buf, v)
+		}
+		return binary.LittleEndian.AppendUint32(buf, v)
+	}
+}
+
+// compact reports how a 32-bit pixel is shortened to the 3-byte CPIXEL of ZRLE:
+// ok is false when CPIXEL equals PIXEL, otherwise skip is the index of the byte to drop.
+func (pf pixelFormat) compact() (skip int, ok bool) {
+	if pf.BPP != 32 || pf.Depth > 24 {
+		return 0, false
+	}
+	mask := uint32(pf.RedMax)<<pf.RedShift | uint32(pf.GreenMax)<<pf.GreenShift | uint32(pf.BlueMax)<<pf.BlueShift
+	low := mask&0xff000000 == 0
+	high := mask&0x000000ff == 0
+	switch {
+	case low && !pf.BigEndian, high && pf.BigEndian:
+		return 3, true
+	case low && pf.BigEndian, high && !pf.BigEndian:
+		return 0, true
+	}
+	return 0, false
+}
+
+// appendCPixel appends v as a ZRLE CPIXEL.
+func (pf pixelFormat) appendCPixel(buf []byte, v uint32) []byte {
+	skip, ok := pf.compact()
+	if !ok {
+		return pf.appendPixel(buf, v)
+	}
+	n := len(buf)
+	buf = pf.appendPixel(buf, v)
+	return append(buf[:n+skip], buf[n+skip+1:]...)
+}
+
+// tightRGB reports whether Tight TPIXELs are sent as 3 bytes of red, green and blue.
+func (pf pixelFormat) tightRGB() bool {
+	return pf.BPP == 32 && pf.Depth == 24 && pf.RedMax == 255 && pf.GreenMax == 255 && pf.BlueMax == 255
+}
+
+// pixels converts the r part of img into pixel values in row-major order.
+func (pf pixelFormat) pixels(img *image.RGBA, r image.Rectangle) []uint32 {
+	px := make([]uint32, 0, r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := img.PixOffset(r.Min.X, y)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			px = append(px, pf.pixel(img.Pix[i], img.Pix[i+1], img.Pix[i+2]))
+			i += 4
+		}
+	}
+	return px
+}
@@ -0,0 +1,266 @@
+// Package vnc serves the desktop over the RFB 3.8 protocol, so any VNC viewer can
+// watch it without running a separate VNC server next to the application.
+//
+// Framebuffer updates are sent with the Raw, CopyRect, Hextile, ZRLE or Tight-PNG
+// encoding, whichever the client prefers, and the DesktopSize and Cursor
+// pseudo-encodings are supported. All clients share one capture loop that runs
+// while at least one client is connected. Frames are compared tile by tile and
+// incremental updates only carry the tiles that changed since the client's
+// previous update; a client that reads slowly gets the latest frame, not a backlog.
+package vnc
+
+import (
+	"context"
+	"errors"
+	"image"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+	"github.com/Fast-IQ/screenshot/internal/imgutil"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close.
+var ErrServerClosed = errors.New("vnc: server closed")
+
+// tileSize is the granularity of change detection.
+const tileSize = 64
+
+// Input receives keyboard and pointer events of clients. Events are dropped in view-only mode.
+type Input interface {
+	// Key reports a press or release of the X11 keysym key.
+	Key(down bool, keysym uint32)
+	// Pointer reports the pointer position in desktop coordinates and the pressed
+	// buttons, bit 0 being the left button.
+	Pointer(buttons uint8, x, y int)
+}
+
+// Options configures a Server.
+type Options struct {
+	// Capturer is the capture backend, nil means screenshot.DefaultCapturer().
+	Capturer screenshot.ScreenCapturer
+	// Display is the index of the display to serve when Rect is empty.
+	Display int
+	// Rect is the desktop region to serve, empty means the whole display.
+	Rect image.Rectangle
+	// FPS is the capture rate, default 10.
+	FPS float64
+	// Name is the desktop name shown by viewers, default "screenshot".
+	Name string
+	// Password enables VNC authentication when not empty. Only the first 8 bytes are used.
+	Password string
+	// ViewOnly drops all keyboard and pointer events.
+	ViewOnly bool
+	// Input receives keyboard and pointer events, nil drops them.
+	Input Input
+	// Cursor is the cursor image sent to clients supporting the Cursor pseudo-encoding,
+	// nil means a plain arrow. CursorHotspot is relative to Cursor.Rect.Min.
+	Cursor        *image.RGBA
+	CursorHotspot image.Point
+}
+
+// Server is an RFB server.
+type Server struct {
+	opts     Options
+	capturer screenshot.ScreenCapturer
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	clients   map[*conn]struct{}
+	cancel    context.CancelFunc // stops the capture loop, nil when it is not running
+	closed    bool
+}
+
+// New returns a Server configured by opts.
+func New(opts Options) *Server {
+	c := opts.Capturer
+	if c == nil {
+		c = screenshot.DefaultCapturer()
+	}
+	if opts.FPS <= 0 {
+		opts.FPS = 10
+	}
+	if opts.Name == "" {
+		opts.Name = "screenshot"
+	}
+	if opts.Cursor == nil {
+		opts.Cursor = defaultCursor()
+		opts.CursorHotspot = image.Point{}
+	}
+	return &Server{
+		opts:      opts,
+		capturer:  c,
+		listeners: make(map[net.Listener]struct{}),
+		clients:   make(map[*conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves clients until Close.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and serves each in its own goroutine until Close.
+// It always returns a non-nil error and closes l.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		_ = l.Close()
+	}()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go func() { _ = s.ServeConn(nc) }()
+	}
+}
+
+// ServeConn runs the RFB protocol on nc until the client disconnects or the server
+// is closed. It closes nc.
+func (s *Server) ServeConn(nc net.Conn) error {
+	c := newConn(s, nc)
+	defer c.close()
+	if err := c.handshake(); err != nil {
+		return err
+	}
+	if !s.add(c) {
+		return ErrServerClosed
+	}
+	defer s.remove(c)
+	return c.serve()
+}
+
+// Close stops the listeners and disconnects all clients.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	for c := range s.clients {
+		c.close()
+	}
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+	return nil
+}
+
+// bounds returns the served desktop region.
+func (s *Server) bounds() (image.Rectangle, error) {
+	if !s.opts.Rect.Empty() {
+		return s.opts.Rect, nil
+	}
+	return s.capturer.GetDisplayBounds(s.opts.Display)
+}
+
+// add registers c and starts the capture loop for the first client.
+func (s *Server) add(c *conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.clients[c] = struct{}{}
+	if s.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.cancel = cancel
+		go s.run(ctx)
+	}
+	return true
+}
+
+// remove unregisters c and stops the capture loop after the last client.
+func (s *Server) remove(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, c)
+	if len(s.clients) == 0 && s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+}
+
+// active reports whether the capture loop is running.
+func (s *Server) active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cancel != nil
+}
+
+// run captures the served region at opts.FPS and hands every frame with its changed
+// tiles to the clients. A capture error disconnects all clients.
+func (s *Server) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / s.opts.FPS))
+	defer ticker.Stop()
+
+	var prev *image.RGBA
+	for {
+		rect, err := s.bounds()
+		var img *image.RGBA
+		if err == nil {
+			img, err = s.capturer.Capture(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+		}
+		if err != nil {
+			s.fail(ctx)
+			return
+		}
+		dirty := imgutil.DirtyTiles(prev, img, tileSize)
+		prev = img
+
+		s.mu.Lock()
+		if ctx.Err() != nil {
+			s.mu.Unlock()
+			return
+		}
+		for c := range s.clients {
+			c.setFrame(img, rect.Min, dirty)
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fail disconnects all clients after the capture loop started with ctx failed.
+func (s *Server) fail(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	s.cancel()
+	s.cancel = nil
+	for c := range s.clients {
+		c.close()
+	}
+}
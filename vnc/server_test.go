@@ -0,0 +1,318 @@
+package vnc
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fast-IQ/screenshot/screenshottest"
+)
+
+var (
+	white = color.RGBA{255, 255, 255, 255}
+	blue  = color.RGBA{20, 40, 200, 255}
+)
+
+func newTestServer(t *testing.T, opts Options, displays ...image.Rectangle) (string, *Server, *screenshottest.Capturer) {
+	t.Helper()
+	fake := screenshottest.New(displays...)
+	opts.Capturer = fake
+	if opts.FPS == 0 {
+		opts.FPS = 50
+	}
+	s := New(opts)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(func() { _ = s.Close() })
+	return l.Addr().String(), s, fake
+}
+
+func mustDial(t *testing.T, addr, password string) *testClient {
+	t.Helper()
+	c, err := dial(t, addr, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// capture returns what the fake shows for r now.
+func capture(t *testing.T, fake *screenshottest.Capturer, r image.Rectangle) *image.RGBA {
+	t.Helper()
+	img, err := fake.Capture(r.Min.X, r.Min.Y, r.Dx(), r.Dy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// updateUntil reads incremental updates until fb matches the fake, since a change of
+// content can reach the client one frame later than the request.
+func updateUntil(t *testing.T, c *testClient, fake *screenshottest.Capturer, r image.Rectangle, tolerance int) {
+	t.Helper()
+	var err error
+	for i := 0; i < 20; i++ {
+		c.request(true)
+		c.update()
+		if err = compare(c.fb, capture(t, fake, r), tolerance); err == nil {
+			return
+		}
+	}
+	t.Fatal(err)
+}
+
+func TestEncodings(t *testing.T) {
+	display := image.Rect(0, 0, 150, 90)
+	rgb565 := pixelFormat{
+		BPP: 16, Depth: 16, BigEndian: true, TrueColour: true,
+		RedMax: 31, GreenMax: 63, BlueMax: 31,
+		RedShift: 11, GreenShift: 5, BlueShift: 0,
+	}
+	bgr233 := pixelFormat{
+		BPP: 8, Depth: 8, TrueColour: true,
+		RedMax: 7, GreenMax: 7, BlueMax: 3,
+		RedShift: 0, GreenShift: 3, BlueShift: 6,
+	}
+	bigEndian := defaultFormat
+	bigEndian.BigEndian = true
+
+	for _, tc := range []struct {
+		name      string
+		encoding  int32
+		pf        *pixelFormat
+		tolerance int
+	}{
+		{"raw", encodingRaw, nil, 0},
+		{"hextile", encodingHextile, nil, 0},
+		{"zrle", encodingZRLE, nil, 0},
+		{"tight-png", encodingTightPNG, nil, 0},
+		{"raw-rgb565", encodingRaw, &rgb565, 8},
+		{"hextile-rgb565", encodingHextile, &rgb565, 8},
+		{"zrle-rgb565", encodingZRLE, &rgb565, 8},
+		{"zrle-bgr233", encodingZRLE, &bgr233, 85},
+		{"zrle-big-endian", encodingZRLE, &bigEndian, 0},
+		{"tight-png-rgb565", encodingTightPNG, &rgb565, 8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr, _, fake := newTestServer(t, Options{}, display)
+			// A smooth gradient exercises raw and RLE tiles, the checkerboard palette tiles.
+			gradient := screenshottest.Gradient(display, blue, white)
+			checker := screenshottest.Checkerboard(5, 0, blue, white)
+			fake.SetContent(func(frame, x, y int) color.RGBA {
+				if y < 45 {
+					return gradient(frame, x, y)
+				}
+				return checker(frame, x, y)
+			})
+
+			c := mustDial(t, addr, "")
+			if c.fb.Rect != image.Rect(0, 0, 150, 90) || c.name != "screenshot" {
+				t.Fatalf("ServerInit: %v %q", c.fb.Rect, c.name)
+			}
+			if tc.pf != nil {
+				c.setPixelFormat(*tc.pf)
+			}
+			c.setEncodings(tc.encoding)
+			c.request(false)
+			for _, enc := range c.update() {
+				if enc != tc.encoding {
+					t.Errorf("rectangle encoding %d, want %d", enc, tc.encoding)
+				}
+			}
+			if err := compare(c.fb, capture(t, fake, display), tc.tolerance); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestIncrementalUpdate(t *testing.T) {
+	display := image.Rect(0, 0, 256, 192)
+	addr, _, fake := newTestServer(t, Options{}, display)
+	fake.SetContent(screenshottest.Solid(white))
+
+	c := mustDial(t, addr, "")
+	c.setEncodings(encodingZRLE)
+	c.request(false)
+	c.update()
+
+	// Only the tile containing the square has to be sent.
+	square := image.Rect(70, 70, 80, 80)
+	fake.SetContent(func(frame, x, y int) color.RGBA {
+		if image.Pt(x, y).In(square) {
+			return blue
+		}
+		return white
+	})
+	c.rects = make(map[int32]int)
+	updateUntil(t, c, fake, display, 0)
+	if n := c.rects[encodingZRLE]; n != 1 {
+		t.Errorf("incremental update sent %d rectangles, want the changed tile only", n)
+	}
+}
+
+func TestCopyRect(t *testing.T) {
+	display := image.Rect(0, 0, 256, 128)
+	addr, _, fake := newTestServer(t, Options{}, display)
+	pattern := screenshottest.Checkerboard(3, 0, blue, white)
+	// A pattern in the first tile that is then also drawn at the third tile.
+	at := func(tiles ...int) screenshottest.Content {
+		return func(frame, x, y int) color.RGBA {
+			for _, tile := range tiles {
+				if y < tileSize && x >= tile*tileSize && x < (tile+1)*tileSize {
+					return pattern(frame, x-tile*tileSize, y)
+				}
+			}
+			return white
+		}
+	}
+	fake.SetContent(at(0))
+
+	c := mustDial(t, addr, "")
+	c.setEncodings(encodingCopyRect, encodingRaw)
+	c.request(false)
+	c.update()
+
+	fake.SetContent(at(0, 2))
+	c.rects = make(map[int32]int)
+	updateUntil(t, c, fake, display, 0)
+	if c.rects[encodingCopyRect] != 1 || c.rects[encodingRaw] != 0 {
+		t.Errorf("rectangles %v, want a single CopyRect", c.rects)
+	}
+}
+
+func TestDesktopSize(t *testing.T) {
+	addr, _, fake := newTestServer(t, Options{}, image.Rect(0, 0, 100, 50))
+	fake.SetContent(screenshottest.Gradient(image.Rect(0, 0, 200, 100), blue, white))
+
+	c := mustDial(t, addr, "")
+	c.setEncodings(encodingDesktopSize, encodingHextile)
+	c.request(false)
+	c.update()
+
+	bigger := image.Rect(0, 0, 200, 100)
+	fake.SetDisplays(bigger)
+	for i := 0; c.fb.Rect != bigger; i++ {
+		if i == 20 {
+			t.Fatalf("framebuffer %v after resize, want %v", c.fb.Rect, bigger)
+		}
+		c.request(true)
+		c.update()
+	}
+	c.request(true)
+	c.update()
+	if err := compare(c.fb, capture(t, fake, bigger), 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	addr, _, _ := newTestServer(t, Options{}, image.Rect(0, 0, 32, 32))
+	c := mustDial(t, addr, "")
+	c.setEncodings(encodingRaw, encodingCursor)
+	c.request(false)
+	c.update()
+	if c.cursor == nil || c.cursor.Rect != defaultCursor().Rect {
+		t.Fatal("no default cursor received")
+	}
+	if got := c.cursor.RGBAAt(1, 2); got != white {
+		t.Errorf("cursor pixel = %v, want white", got)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	addr, _, _ := newTestServer(t, Options{Password: "secret"}, image.Rect(0, 0, 16, 16))
+	_, err := dial(t, addr, "wrong")
+	var herr handshakeError
+	if !errors.As(err, &herr) || herr.reason != "authentication failed" {
+		t.Errorf("wrong password: %v", err)
+	}
+	if _, err := dial(t, addr, "secret"); err != nil {
+		t.Errorf("valid password: %v", err)
+	}
+}
+
+type recordedInput struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordedInput) Key(down bool, keysym uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if down {
+		r.events = append(r.events, "down "+string(rune(keysym)))
+	}
+}
+
+func (r *recordedInput) Pointer(buttons uint8, x, y int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, "pointer "+image.Pt(x, y).String())
+}
+
+func (r *recordedInput) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func TestInput(t *testing.T) {
+	for _, viewOnly := range []bool{false, true} {
+		in := &recordedInput{}
+		// Serve the second display, pointer events are translated to desktop coordinates.
+		opts := Options{Display: 1, Input: in, ViewOnly: viewOnly}
+		addr, _, _ := newTestServer(t, opts, image.Rect(0, 0, 100, 64), image.Rect(100, 0, 164, 64))
+
+		c := mustDial(t, addr, "")
+		c.request(false)
+		c.update()
+		c.write([]byte{msgKeyEvent, 1, 0, 0, 0, 0, 0, 'a'})
+		c.write([]byte{msgPointerEvent, 1, 0, 5, 0, 7})
+		c.write([]byte{msgClientCutText, 0, 0, 0, 0, 0, 0, 3, 'x', 'y', 'z'})
+		// The server handles messages in order, so the events are through once the update is.
+		c.request(false)
+		c.update()
+
+		got := in.get()
+		if viewOnly && len(got) != 0 {
+			t.Errorf("view-only: got events %v", got)
+		}
+		if want := []string{"down a", "pointer (105,7)"}; !viewOnly && (len(got) != 2 || got[0] != want[0] || got[1] != want[1]) {
+			t.Errorf("got events %v, want %v", got, want)
+		}
+	}
+}
+
+func TestCaptureStopsWithLastClient(t *testing.T) {
+	addr, s, fake := newTestServer(t, Options{}, image.Rect(0, 0, 32, 32))
+	c1 := mustDial(t, addr, "")
+	c2 := mustDial(t, addr, "")
+	for _, c := range []*testClient{c1, c2} {
+		c.request(false)
+		c.update()
+	}
+	_ = c1.nc.Close()
+	_ = c2.nc.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.active() {
+		if time.Now().After(deadline) {
+			t.Fatal("capture loop still running after the last client left")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	n := fake.Captures()
+	time.Sleep(100 * time.Millisecond)
+	if m := fake.Captures(); m != n {
+		t.Errorf("captures went on after the last client left: %d -> %d", n, m)
+	}
+}
@@ -0,0 +1,41 @@
+package watch
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/Fast-IQ/screenshot/imgdiff"
+)
+
+// Matcher tests a capture of the watched area, for Options.Match.
+type Matcher func(img *image.RGBA) bool
+
+// MatchColor matches when at least the fraction minFraction, from 0 to 1, of the
+// pixels are within tolerance of c in every channel.
+func MatchColor(c color.Color, tolerance uint8, minFraction float64) Matcher {
+	want := color.RGBAModel.Convert(c).(color.RGBA)
+	ref := []byte{want.R, want.G, want.B, want.A}
+	tol := int(tolerance)
+	return func(img *image.RGBA) bool {
+		w, h := img.Rect.Dx(), img.Rect.Dy()
+		n := 0
+		for y := 0; y < h; y++ {
+			row := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				if within(row[4*x:4*x+4], ref, tol) {
+					n++
+				}
+			}
+		}
+		return w > 0 && h > 0 && float64(n) >= minFraction*float64(w*h)
+	}
+}
+
+// MatchImage matches when the capture equals template, which has the size of the
+// watched area, as compared by imgdiff.Compare with opts.
+func MatchImage(template image.Image, opts *imgdiff.Options) Matcher {
+	return func(img *image.RGBA) bool {
+		res, err := imgdiff.Compare(img, template, opts)
+		return err == nil && res.Equal()
+	}
+}
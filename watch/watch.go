@@ -0,0 +1,278 @@
+// Package watch watches a small area of the screen, such as a status indicator or
+// a progress bar, and calls back when it changes, when it settles, or when it
+// matches a colour or an image.
+//
+// Only the watched rectangle is captured and compared, through a
+// screenshot.AreaWatcher. On X11 it captures on a connection kept open and reports
+// damage to the area, and Run only captures when the area was drawn to or a
+// callback is due; elsewhere, and with a custom Capturer, Run polls the area at an
+// interval, which for a few hundred pixels several times a second is cheap too.
+package watch
+
+import (
+	"context"
+	"errors"
+	"image"
+	"time"
+
+	"github.com/Fast-IQ/screenshot"
+)
+
+// Event describes the watched area when a callback fires.
+type Event struct {
+	// Image is the capture of the area. It is not modified afterwards and may be
+	// kept.
+	Image *image.RGBA
+	// Rect is the watched area in desktop coordinates.
+	Rect image.Rectangle
+	// Time is the time of the capture.
+	Time time.Time
+	// Changed bounds the pixels that changed, in desktop coordinates, for OnChange.
+	Changed image.Rectangle
+}
+
+// Options configures a Watcher.
+type Options struct {
+	// Rect is the desktop area to watch.
+	Rect image.Rectangle
+	// Interval is the time between captures, default 250ms. Where damage is
+	// reported, it is the shortest time between captures instead.
+	Interval time.Duration
+	// Tolerance is the largest difference of any channel, 0-255, of pixels that
+	// did not change, to ignore dithering and compression noise.
+	Tolerance uint8
+	// MinChanged is the number of pixels that must change for a change, default 1.
+	MinChanged int
+	// Debounce is the shortest time between two OnChange calls. Changes in between
+	// are reported together by the next call, against the image of the last one.
+	Debounce time.Duration
+	// Stable is how long the area must stay unchanged for OnStable, default 1s.
+	Stable time.Duration
+	// Match, if set, is tested against every capture for OnMatch.
+	Match Matcher
+
+	// OnChange is called when the area changed since the last OnChange, or since
+	// the first capture.
+	OnChange func(Event)
+	// OnStable is called once the area stayed unchanged for Stable, at the start
+	// and after every change.
+	OnStable func(Event)
+	// OnMatch is called when Match starts matching, including on the first capture.
+	OnMatch func(Event)
+	// OnUnmatch is called when Match stops matching.
+	OnUnmatch func(Event)
+
+	// Capturer is the backend to use, nil means screenshot.DefaultCapturer().
+	Capturer screenshot.ScreenCapturer
+}
+
+// area captures the watched rectangle, see screenshot.AreaWatcher.
+type area interface {
+	Capture() (*image.RGBA, error)
+	Damaged() <-chan struct{}
+	Close() error
+}
+
+// Watcher watches an area of the screen. It is not safe for concurrent use; the
+// callbacks run on the goroutine calling Check or Run. Call Close to release it.
+type Watcher struct {
+	opts Options
+	area area
+
+	prev       *image.RGBA // previous capture
+	reported   *image.RGBA // capture of the last OnChange
+	lastReport time.Time   // time of the last OnChange
+	lastChange time.Time   // time of the last capture differing from the one before
+	stable     bool        // OnStable fired since lastChange
+	unreported bool        // a change is held back by Debounce
+	matched    bool
+}
+
+// New returns a Watcher for opts.
+func New(opts Options) (*Watcher, error) {
+	if opts.Rect.Empty() {
+		return nil, errors.New("watch: empty rectangle")
+	}
+	if opts.Interval < 0 || opts.Debounce < 0 || opts.Stable < 0 || opts.MinChanged < 0 {
+		return nil, errors.New("watch: negative option")
+	}
+	if opts.Interval == 0 {
+		opts.Interval = 250 * time.Millisecond
+	}
+	if opts.MinChanged == 0 {
+		opts.MinChanged = 1
+	}
+	if opts.Stable == 0 {
+		opts.Stable = time.Second
+	}
+	if opts.Capturer == nil {
+		opts.Capturer = screenshot.DefaultCapturer()
+	}
+	a, err := screenshot.WatchAreaWith(opts.Capturer, opts.Rect)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{opts: opts, area: a}, nil
+}
+
+// Close releases the connection kept for the captures.
+func (w *Watcher) Close() error {
+	return w.area.Close()
+}
+
+// Check captures the area once, as taken at now, and fires the callbacks due.
+func (w *Watcher) Check(now time.Time) error {
+	r := w.opts.Rect
+	img, err := w.area.Capture()
+	if err != nil {
+		return err
+	}
+	ev := Event{Image: img, Rect: r, Time: now}
+
+	if w.prev == nil {
+		w.lastChange = now
+		w.reported, w.lastReport = img, now
+		if w.opts.OnChange != nil {
+			ev.Changed = r
+			w.opts.OnChange(ev)
+			ev.Changed = image.Rectangle{}
+		}
+	} else {
+		changed := false
+		if n, _ := w.changes(w.prev, img); n >= w.opts.MinChanged {
+			changed = true
+			w.lastChange = now
+			w.stable = false
+		}
+		if now.Sub(w.lastReport) < w.opts.Debounce {
+			w.unreported = w.unreported || changed
+		} else {
+			w.unreported = false
+			if n, box := w.changes(w.reported, img); n >= w.opts.MinChanged {
+				w.reported, w.lastReport = img, now
+				if w.opts.OnChange != nil {
+					ev.Changed = box.Add(r.Min)
+					w.opts.OnChange(ev)
+					ev.Changed = image.Rectangle{}
+				}
+			}
+		}
+	}
+	w.prev = img
+
+	if !w.stable && now.Sub(w.lastChange) >= w.opts.Stable {
+		w.stable = true
+		if w.opts.OnStable != nil {
+			w.opts.OnStable(ev)
+		}
+	}
+
+	if w.opts.Match != nil {
+		m := w.opts.Match(img)
+		switch {
+		case m && !w.matched && w.opts.OnMatch != nil:
+			w.opts.OnMatch(ev)
+		case !m && w.matched && w.opts.OnUnmatch != nil:
+			w.opts.OnUnmatch(ev)
+		}
+		w.matched = m
+	}
+	return nil
+}
+
+// Run checks the area until ctx is done or a capture fails, and returns ctx.Err()
+// when stopped by ctx. The first check is immediate. Where damage is reported, the
+// area is checked again once it was drawn to, or when OnStable or a change held
+// back by Debounce is due, at most every Interval; elsewhere every Interval.
+func (w *Watcher) Run(ctx context.Context) error {
+	damaged := w.area.Damaged()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		last := time.Now()
+		if err := w.Check(last); err != nil {
+			return err
+		}
+
+		if damaged != nil {
+			var due <-chan time.Time
+			if t, ok := w.due(); ok {
+				timer.Reset(time.Until(t))
+				due = timer.C
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case _, ok := <-damaged:
+				if !ok {
+					// Damage is no longer reported, poll instead.
+					damaged = nil
+				}
+			case <-due:
+			}
+		}
+		timer.Reset(time.Until(last.Add(w.opts.Interval)))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// due returns when Check has a callback to fire next without a change of the
+// area: OnStable, or OnChange held back by Debounce.
+func (w *Watcher) due() (time.Time, bool) {
+	var t time.Time
+	ok := false
+	if !w.stable {
+		t, ok = w.lastChange.Add(w.opts.Stable), true
+	}
+	if d := w.lastReport.Add(w.opts.Debounce); w.unreported && (!ok || d.Before(t)) {
+		t, ok = d, true
+	}
+	return t, ok
+}
+
+// Run is a shortcut for New followed by Watcher.Run.
+func Run(ctx context.Context, opts Options) error {
+	w, err := New(opts)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return w.Run(ctx)
+}
+
+// changes returns the number of pixels of a and b, of the same size, that differ
+// by more than the tolerance, and their bounds.
+func (w *Watcher) changes(a, b *image.RGBA) (int, image.Rectangle) {
+	tol := int(w.opts.Tolerance)
+	n := 0
+	var box image.Rectangle
+	width, height := b.Rect.Dx(), b.Rect.Dy()
+	for y := 0; y < height; y++ {
+		pa := a.Pix[a.PixOffset(a.Rect.Min.X, a.Rect.Min.Y+y):]
+		pb := b.Pix[b.PixOffset(b.Rect.Min.X, b.Rect.Min.Y+y):]
+		for x := 0; x < width; x++ {
+			if !within(pa[4*x:4*x+4], pb[4*x:4*x+4], tol) {
+				n++
+				box = box.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return n, box
+}
+
+func within(p, q []byte, tol int) bool {
+	for c := range 4 {
+		d := int(p[c]) - int(q[c])
+		if d > tol || -d > tol {
+			return false
+		}
+	}
+	return true
+}
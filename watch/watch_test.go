@@ -0,0 +1,312 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/Fast-IQ/screenshot/imgdiff"
+	"github.com/Fast-IQ/screenshot/screenshottest"
+)
+
+var (
+	green = color.RGBA{0, 200, 0, 255}
+	red   = color.RGBA{220, 0, 0, 255}
+)
+
+// indicator returns content with a 4×4 dot of c at (12, 10) on grey.
+func indicator(c color.RGBA) screenshottest.Content {
+	dot := image.Rect(12, 10, 16, 14)
+	return func(frame, x, y int) color.RGBA {
+		if (image.Point{x, y}).In(dot) {
+			return c
+		}
+		return color.RGBA{128, 128, 128, 255}
+	}
+}
+
+type recorder struct {
+	changes, stables, matches, unmatches []Event
+}
+
+func (r *recorder) options(c *screenshottest.Capturer) Options {
+	return Options{
+		Rect:      image.Rect(10, 8, 20, 16),
+		Stable:    time.Second,
+		Capturer:  c,
+		OnChange:  func(e Event) { r.changes = append(r.changes, e) },
+		OnStable:  func(e Event) { r.stables = append(r.stables, e) },
+		OnMatch:   func(e Event) { r.matches = append(r.matches, e) },
+		OnUnmatch: func(e Event) { r.unmatches = append(r.unmatches, e) },
+	}
+}
+
+func TestCheck(t *testing.T) {
+	c := screenshottest.New(image.Rect(0, 0, 100, 100))
+	c.SetContent(indicator(green))
+	r := &recorder{}
+	opts := r.options(c)
+	opts.Match = MatchColor(green, 10, 0.2)
+	w, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Unix(1000, 0)
+	at := func(d time.Duration) {
+		t.Helper()
+		if err := w.Check(t0.Add(d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	at(0)
+	if len(r.changes) != 1 || r.changes[0].Changed != opts.Rect || len(r.matches) != 1 || len(r.stables) != 0 {
+		t.Fatalf("first check: %d changes, %d matches, %d stables", len(r.changes), len(r.matches), len(r.stables))
+	}
+	at(500 * time.Millisecond)
+	at(time.Second)
+	if len(r.changes) != 1 || len(r.stables) != 1 {
+		t.Fatalf("unchanged: %d changes, %d stables", len(r.changes), len(r.stables))
+	}
+
+	c.SetContent(indicator(red))
+	at(1500 * time.Millisecond)
+	if len(r.changes) != 2 || len(r.unmatches) != 1 {
+		t.Fatalf("changed: %d changes, %d unmatches", len(r.changes), len(r.unmatches))
+	}
+	if want := image.Rect(12, 10, 16, 14); r.changes[1].Changed != want {
+		t.Errorf("Changed = %v, want %v", r.changes[1].Changed, want)
+	}
+	if got := r.changes[1].Image.RGBAAt(3, 3); got != red {
+		t.Errorf("event image pixel %v, want %v", got, red)
+	}
+	at(2000 * time.Millisecond)
+	if len(r.stables) != 1 {
+		t.Fatal("stable too early")
+	}
+	at(2500 * time.Millisecond)
+	if len(r.stables) != 2 || len(r.changes) != 2 {
+		t.Fatalf("settled: %d changes, %d stables", len(r.changes), len(r.stables))
+	}
+
+	c.SetContent(indicator(green))
+	at(3000 * time.Millisecond)
+	if len(r.matches) != 2 {
+		t.Errorf("%d matches, want 2", len(r.matches))
+	}
+
+	c.SetError(errors.New("boom"))
+	if err := w.Check(t0.Add(4 * time.Second)); err == nil {
+		t.Error("capture error not returned")
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	c := screenshottest.New(image.Rect(0, 0, 100, 100))
+	r := &recorder{}
+	opts := r.options(c)
+	opts.Debounce = time.Second
+	w, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Unix(1000, 0)
+	shades := []uint8{0, 50, 100, 100, 150}
+	for i, s := range shades {
+		c.SetContent(indicator(color.RGBA{s, s, s, 255}))
+		if err := w.Check(t0.Add(time.Duration(i) * 300 * time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first capture, then the changes up to 900ms reported at 1200ms.
+	if len(r.changes) != 2 || !r.changes[1].Time.Equal(t0.Add(1200*time.Millisecond)) {
+		t.Fatalf("%d changes", len(r.changes))
+	}
+}
+
+func TestTolerance(t *testing.T) {
+	c := screenshottest.New(image.Rect(0, 0, 100, 100))
+	c.SetContent(indicator(green))
+	r := &recorder{}
+	opts := r.options(c)
+	opts.Tolerance = 8
+	opts.MinChanged = 4
+	w, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Unix(1000, 0)
+	w.Check(t0)
+	c.SetContent(indicator(color.RGBA{5, 205, 5, 255}))
+	w.Check(t0.Add(time.Second))
+	if len(r.changes) != 1 {
+		t.Errorf("noise reported as change")
+	}
+	c.SetContent(indicator(red))
+	w.Check(t0.Add(2 * time.Second))
+	if len(r.changes) != 2 {
+		t.Errorf("change not reported")
+	}
+}
+
+func TestMatchImage(t *testing.T) {
+	c := screenshottest.New(image.Rect(0, 0, 100, 100))
+	c.SetContent(indicator(red))
+	rect := image.Rect(10, 8, 20, 16)
+	template, err := c.Capture(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := MatchImage(template, &imgdiff.Options{Tolerance: 2})
+	c.SetContent(indicator(color.RGBA{221, 1, 0, 255}))
+	img, _ := c.Capture(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+	if !m(img) {
+		t.Error("near-identical image does not match")
+	}
+	c.SetContent(indicator(green))
+	img, _ = c.Capture(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+	if m(img) {
+		t.Error("different image matches")
+	}
+}
+
+func TestRun(t *testing.T) {
+	c := screenshottest.New(image.Rect(0, 0, 100, 100))
+	c.SetContent(indicator(red))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan Event, 1)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		c.SetContent(indicator(green))
+	}()
+	err := Run(ctx, Options{
+		Rect:     image.Rect(10, 8, 20, 16),
+		Interval: 5 * time.Millisecond,
+		Capturer: c,
+		Match:    MatchColor(green, 0, 0.2),
+		OnMatch: func(e Event) {
+			done <- e
+			cancel()
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run: %v", err)
+	}
+	if e := <-done; e.Image.RGBAAt(2, 2) != green {
+		t.Errorf("matched image pixel %v", e.Image.RGBAAt(2, 2))
+	}
+
+	if _, err := New(Options{}); err == nil {
+		t.Error("empty rectangle accepted")
+	}
+}
+
+// damageArea is an area whose damage the test reports, counting the captures.
+type damageArea struct {
+	c        *screenshottest.Capturer
+	rect     image.Rectangle
+	damage   chan struct{}
+	captures chan struct{}
+}
+
+func (a *damageArea) Capture() (*image.RGBA, error) {
+	a.captures <- struct{}{}
+	r := a.rect
+	return a.c.Capture(r.Min.X, r.Min.Y, r.Dx(), r.Dy())
+}
+
+func (a *damageArea) Damaged() <-chan struct{} { return a.damage }
+func (a *damageArea) Close() error             { return nil }
+
+func TestRunDamage(t *testing.T) {
+	c := screenshottest.New(image.Rect(0, 0, 100, 100))
+	c.SetContent(indicator(red))
+	rect := image.Rect(10, 8, 20, 16)
+	a := &damageArea{c: c, rect: rect, damage: make(chan struct{}, 1), captures: make(chan struct{}, 100)}
+	changes := make(chan Event, 10)
+	stables := make(chan Event, 10)
+	w, err := New(Options{
+		Rect:     rect,
+		Interval: time.Millisecond,
+		Stable:   50 * time.Millisecond,
+		Capturer: c,
+		OnChange: func(e Event) { changes <- e },
+		OnStable: func(e Event) { stables <- e },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.area = a
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- w.Run(ctx) }()
+
+	<-changes
+	// OnStable is due without damage, with a single capture for it.
+	<-stables
+	<-a.captures
+	<-a.captures
+	time.Sleep(30 * time.Millisecond)
+	if n := len(a.captures); n != 0 {
+		t.Fatalf("%d captures without damage", n)
+	}
+
+	c.SetContent(indicator(green))
+	a.damage <- struct{}{}
+	if e := <-changes; e.Image.RGBAAt(2, 2) != green {
+		t.Errorf("changed image pixel %v", e.Image.RGBAAt(2, 2))
+	}
+	<-stables
+
+	// Without damage reports, Run polls.
+	close(a.damage)
+	c.SetContent(indicator(red))
+	if e := <-changes; e.Image.RGBAAt(2, 2) != red {
+		t.Errorf("polled image pixel %v", e.Image.RGBAAt(2, 2))
+	}
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run: %v", err)
+	}
+}
+
+func TestRunDebounceDue(t *testing.T) {
+	c := screenshottest.New(image.Rect(0, 0, 100, 100))
+	c.SetContent(indicator(red))
+	rect := image.Rect(10, 8, 20, 16)
+	a := &damageArea{c: c, rect: rect, damage: make(chan struct{}, 1), captures: make(chan struct{}, 100)}
+	changes := make(chan Event, 10)
+	w, err := New(Options{
+		Rect:     rect,
+		Interval: time.Millisecond,
+		Debounce: 50 * time.Millisecond,
+		Stable:   time.Hour,
+		Capturer: c,
+		OnChange: func(e Event) { changes <- e },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.area = a
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	<-changes
+	// Drawn to within Debounce: the change is reported once it elapsed, without
+	// further damage.
+	c.SetContent(indicator(green))
+	a.damage <- struct{}{}
+	select {
+	case e := <-changes:
+		if e.Image.RGBAAt(2, 2) != green {
+			t.Errorf("changed image pixel %v", e.Image.RGBAAt(2, 2))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("debounced change not reported")
+	}
+}
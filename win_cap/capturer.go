@@ -1,3 +1,5 @@
+//go:build windows
+
 package win_cap
 
 import (
@@ -11,6 +13,8 @@ var (
 	user32 = windows.NewLazySystemDLL("user32.dll")
 
 	funcEnumDisplayMonitors = user32.NewProc("EnumDisplayMonitors")
+	funcEnumWindows         = user32.NewProc("EnumWindows")
+	funcGetWindowTextW      = user32.NewProc("GetWindowTextW")
 
 	procRtlGetNtVersionNumbers = ntdll.NewProc("RtlGetNtVersionNumbers")
 )
@@ -25,6 +29,18 @@ func EnumDisplayMonitors(hdc win.HDC, lprcClip *win.RECT, lpfnEnum uintptr, dwDa
 	return ret != 0
 }
 
+func EnumWindows(lpEnumFunc uintptr, lParam uintptr) bool {
+	ret, _, _ := funcEnumWindows.Call(lpEnumFunc, lParam)
+	return ret != 0
+}
+
+// GetWindowText возвращает заголовок окна
+func GetWindowText(hwnd win.HWND) string {
+	buf := make([]uint16, 512)
+	n, _, _ := funcGetWindowTextW.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	return windows.UTF16ToString(buf[:n])
+}
+
 func GetWindowsVersion() (major, minor uint32) {
 	if procRtlGetNtVersionNumbers.Find() == nil {
 		r1, r2, _ := procRtlGetNtVersionNumbers.Call()
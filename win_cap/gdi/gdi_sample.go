@@ -0,0 +1,69 @@
+//go:build windows
+
+package gdi
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"runtime"
+	"unsafe"
+
+	"github.com/lxn/win"
+)
+
+// Sample returns the colours of the pixels at points, copying each with a 1×1
+// BitBlt into the same 32-bit DIB from a single device context.
+func (c *GDICapturer) Sample(points []image.Point) ([]color.RGBA, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	hwnd := GetDesktopWindow()
+	if hwnd == 0 {
+		return nil, fmt.Errorf("failed to get desktop window")
+	}
+	hDC := win.GetDC(hwnd)
+	if hDC == 0 {
+		return nil, fmt.Errorf("failed to get device context")
+	}
+	defer win.ReleaseDC(hwnd, hDC)
+	screenDPI := c.getCachedDPI(hDC)
+
+	hdcMemDC := win.CreateCompatibleDC(hDC)
+	if hdcMemDC == 0 {
+		return nil, fmt.Errorf("CreateCompatibleDC failed")
+	}
+	defer win.DeleteDC(hdcMemDC)
+
+	bt := win.BITMAPINFO{
+		BmiHeader: win.BITMAPINFOHEADER{
+			BiSize:        uint32(unsafe.Sizeof(win.BITMAPINFOHEADER{})),
+			BiWidth:       1,
+			BiHeight:      -1,
+			BiPlanes:      1,
+			BiBitCount:    32,
+			BiCompression: BI_RGB,
+		},
+	}
+	var bits unsafe.Pointer
+	mBmp := CreateDIBSection(hdcMemDC, &bt, DIB_RGB_COLORS, &bits, 0, 0)
+	if mBmp == 0 {
+		return nil, fmt.Errorf("failed to create DIB section")
+	}
+	defer win.DeleteObject(win.HGDIOBJ(mBmp))
+	oldObj := win.SelectObject(hdcMemDC, win.HGDIOBJ(mBmp))
+	if oldObj == 0 {
+		return nil, fmt.Errorf("SelectObject failed")
+	}
+	defer win.SelectObject(hdcMemDC, oldObj)
+
+	pixel := unsafe.Slice((*byte)(bits), 4)
+	colors := make([]color.RGBA, len(points))
+	for i, p := range points {
+		if !win.BitBlt(hdcMemDC, 0, 0, 1, 1, hDC,
+			int32(ScaleForDPI(p.X, screenDPI)), int32(ScaleForDPI(p.Y, screenDPI)), SRCCOPY) {
+			return nil, fmt.Errorf("bitblt failed")
+		}
+		colors[i] = color.RGBA{pixel[2], pixel[1], pixel[0], 255}
+	}
+	return colors, nil
+}
@@ -0,0 +1,18 @@
+package screenshot
+
+import (
+	"image"
+)
+
+// WindowID identifies a top-level window: an X11 window id or a Windows HWND.
+type WindowID uint64
+
+// Window describes a visible top-level window.
+type Window struct {
+	ID    WindowID
+	Title string
+	// Class is WM_CLASS on X11 and the window class name on Windows.
+	Class string
+	// Bounds is the window rectangle in desktop coordinates.
+	Bounds image.Rectangle
+}
@@ -0,0 +1,10 @@
+//go:build !windows || !amd64
+
+package screenshot
+
+// ExcludeFromCapture hides a window of the calling process from every screen
+// capture of the system, including those of other programs. It is only available on
+// Windows, elsewhere use CaptureOptions.ExcludeWindows.
+func ExcludeFromCapture(id WindowID) error {
+	return ErrUnsupported
+}
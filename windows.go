@@ -4,8 +4,16 @@
 package screenshot
 
 import (
+	"errors"
+	"fmt"
+	"github.com/Fast-IQ/screenshot/win_cap"
 	"github.com/Fast-IQ/screenshot/win_cap/gdi"
+	"github.com/lxn/win"
 	"image"
+	"image/color"
+	"runtime"
+	"syscall"
+	"unsafe"
 )
 
 var (
@@ -36,11 +44,21 @@ func init() {
 
 }
 
-// Capture делает скриншот области экрана
-func Capture(x, y, width, height int) (*image.RGBA, error) {
+// captureBackend captures with the platform backend, see Capture.
+func captureBackend(x, y, width, height int) (*image.RGBA, error) {
 	return currentCapturer.Capture(x, y, width, height)
 }
 
+// samplePoints reads single pixels, see ColorsAt.
+func samplePoints(points []image.Point) ([]color.RGBA, error) {
+	if s, ok := currentCapturer.(interface {
+		Sample([]image.Point) ([]color.RGBA, error)
+	}); ok {
+		return s.Sample(points)
+	}
+	return sampleCapture(captureBackend, points)
+}
+
 // GetDisplayBounds возвращает область отдельного монитора
 func GetDisplayBounds(displayIndex int) (image.Rectangle, error) {
 	return currentCapturer.GetDisplayBounds(displayIndex)
@@ -50,3 +68,111 @@ func GetDisplayBounds(displayIndex int) (image.Rectangle, error) {
 func GetAllDisplayBounds() ([]image.Rectangle, error) {
 	return currentCapturer.GetAllDisplayBounds()
 }
+
+// NumActiveDisplays возвращает количество мониторов
+func NumActiveDisplays() int {
+	count := 0
+	pinner := new(runtime.Pinner)
+	pinner.Pin(&count)
+	defer pinner.Unpin()
+
+	callback := syscall.NewCallback(func(hMonitor win.HMONITOR, hdc win.HDC, lprc *win.RECT, dwData uintptr) uintptr {
+		// Увеличиваем счётчик при каждом вызове callback'а
+		if dwData != 0 {
+			countPtr := (*int)(unsafe.Pointer(dwData))
+			*countPtr++
+		}
+		return 1 // продолжить перечисление
+	})
+
+	// Передаём указатель на count через dwData
+	win_cap.EnumDisplayMonitors(win.HDC(0), nil, callback, uintptr(unsafe.Pointer(&count)))
+
+	return count
+}
+
+// ListWindows возвращает видимые окна верхнего уровня, начиная с нижнего по Z-порядку
+func ListWindows() ([]Window, error) {
+	var result []Window
+	pinner := new(runtime.Pinner)
+	pinner.Pin(&result)
+	defer pinner.Unpin()
+
+	// Передаём указатель на result через lParam
+	if !win_cap.EnumWindows(enumWindowsCallback, uintptr(unsafe.Pointer(&result))) {
+		return nil, errors.New("EnumWindows failed")
+	}
+
+	// EnumWindows перечисляет окна сверху вниз
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result, nil
+}
+
+// Количество callback'ов syscall.NewCallback ограничено, поэтому создаём его один раз
+var enumWindowsCallback = syscall.NewCallback(func(hwnd win.HWND, lParam uintptr) uintptr {
+	if !win.IsWindowVisible(hwnd) || win.IsIconic(hwnd) {
+		return 1
+	}
+	var rect win.RECT
+	if !win.GetWindowRect(hwnd, &rect) || rect.Right <= rect.Left || rect.Bottom <= rect.Top {
+		return 1
+	}
+	class := make([]uint16, 256)
+	n, _ := win.GetClassName(hwnd, &class[0], len(class))
+
+	result := (*[]Window)(unsafe.Pointer(lParam))
+	*result = append(*result, Window{
+		ID:     WindowID(hwnd),
+		Title:  win_cap.GetWindowText(hwnd),
+		Class:  syscall.UTF16ToString(class[:n]),
+		Bounds: image.Rect(int(rect.Left), int(rect.Top), int(rect.Right), int(rect.Bottom)),
+	})
+	return 1
+})
+
+// wdaExcludeFromCapture is the WDA_EXCLUDEFROMCAPTURE display affinity, available
+// since Windows 10 2004.
+const wdaExcludeFromCapture = 0x11
+
+var (
+	user32                       = syscall.NewLazyDLL("user32.dll")
+	procSetWindowDisplayAffinity = user32.NewProc("SetWindowDisplayAffinity")
+	procGetWindowDisplayAffinity = user32.NewProc("GetWindowDisplayAffinity")
+)
+
+// ExcludeFromCapture hides a window of the calling process from every screen
+// capture of the system, including those of other programs. It is only available on
+// Windows, elsewhere use CaptureOptions.ExcludeWindows.
+func ExcludeFromCapture(id WindowID) error {
+	if r, _, err := procSetWindowDisplayAffinity.Call(uintptr(id), wdaExcludeFromCapture); r == 0 {
+		return fmt.Errorf("SetWindowDisplayAffinity: %w", err)
+	}
+	return nil
+}
+
+// captureExcluding captures without the given windows. GDI cannot leave out other
+// windows, so they must have been excluded by their owning process already.
+func captureExcluding(x, y, width, height int, exclude []WindowID) (*image.RGBA, error) {
+	for _, id := range exclude {
+		var affinity uint32
+		if r, _, err := procGetWindowDisplayAffinity.Call(uintptr(id), uintptr(unsafe.Pointer(&affinity))); r == 0 {
+			return nil, fmt.Errorf("GetWindowDisplayAffinity: window %#x: %w", id, err)
+		}
+		if affinity != wdaExcludeFromCapture {
+			return nil, fmt.Errorf("window %#x is not excluded from capture, call ExcludeFromCapture in the process owning it", id)
+		}
+	}
+	return captureBackend(x, y, width, height)
+}
+
+// captureDeep is not available, GDI captures have 8 bits per channel.
+func captureDeep(x, y, width, height int) (*image.RGBA64, error) {
+	return nil, ErrUnsupported
+}
+
+// watchArea is not available, AreaWatcher captures through Capture.
+func watchArea(rect image.Rectangle) (areaBackend, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,68 @@
+//go:build windows && amd64
+
+package screenshot
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/Fast-IQ/screenshot/win_cap"
+	"github.com/lxn/win"
+)
+
+var (
+	gdi32              = syscall.NewLazyDLL("gdi32.dll")
+	procGetICMProfileW = gdi32.NewProc("GetICMProfileW")
+)
+
+// monitorInfoEx is MONITORINFOEXW, MONITORINFO followed by the device name.
+type monitorInfoEx struct {
+	win.MONITORINFO
+	Device [32]uint16
+}
+
+// enumMonitorsCallback appends every monitor to the *[]win.HMONITOR in dwData, in
+// the order GetAllDisplayBounds lists them.
+var enumMonitorsCallback = syscall.NewCallback(func(hMonitor win.HMONITOR, hdc win.HDC, lprc *win.RECT, dwData uintptr) uintptr {
+	monitors := (*[]win.HMONITOR)(unsafe.Pointer(dwData))
+	*monitors = append(*monitors, hMonitor)
+	return 1
+})
+
+// displayProfile returns the ICC profile Windows colour management assigns a
+// monitor, see DisplayColorSpace, read from the file GetICMProfileW names for a
+// device context of the monitor.
+func displayProfile(displayIndex int) ([]byte, error) {
+	var monitors []win.HMONITOR
+	pinner := new(runtime.Pinner)
+	pinner.Pin(&monitors)
+	defer pinner.Unpin()
+	if !win_cap.EnumDisplayMonitors(0, nil, enumMonitorsCallback, uintptr(unsafe.Pointer(&monitors))) {
+		return nil, fmt.Errorf("EnumDisplayMonitors failed")
+	}
+	if displayIndex < 0 || displayIndex >= len(monitors) {
+		return nil, fmt.Errorf("invalid display index: %d", displayIndex)
+	}
+
+	info := monitorInfoEx{}
+	info.CbSize = uint32(unsafe.Sizeof(info))
+	if !win.GetMonitorInfo(monitors[displayIndex], &info.MONITORINFO) {
+		return nil, fmt.Errorf("GetMonitorInfo failed")
+	}
+	hdc := win.CreateDC(&info.Device[0], nil, nil, nil)
+	if hdc == 0 {
+		return nil, fmt.Errorf("CreateDC failed")
+	}
+	defer win.DeleteDC(hdc)
+
+	path := make([]uint16, win.MAX_PATH)
+	size := uint32(len(path))
+	if r, _, _ := procGetICMProfileW.Call(uintptr(hdc), uintptr(unsafe.Pointer(&size)), uintptr(unsafe.Pointer(&path[0]))); r == 0 {
+		// No profile is associated with the monitor.
+		return nil, nil
+	}
+	return os.ReadFile(syscall.UTF16ToString(path))
+}
@@ -0,0 +1,40 @@
+//go:build amd64 && !purego
+
+package yuv
+
+// lumaRowSSE2 converts len(dst), a multiple of 8, pixels.
+//
+//go:noescape
+func lumaRowSSE2(dst, src []byte, c *coefficients)
+
+// chromaRowSSE2 converts len(u), a multiple of 4, blocks into the planes u and v.
+//
+//go:noescape
+func chromaRowSSE2(u, v, r0, r1 []byte, c *coefficients)
+
+// chromaRowNV12SSE2 converts len(uv)/2, a multiple of 4, blocks into interleaved samples.
+//
+//go:noescape
+func chromaRowNV12SSE2(uv, r0, r1 []byte, c *coefficients)
+
+func lumaRow(dst, src []byte, c *coefficients) {
+	n := len(dst) &^ 7
+	if n > 0 {
+		lumaRowSSE2(dst[:n], src[:4*n], c)
+	}
+	lumaRowGeneric(dst[n:], src[4*n:], c)
+}
+
+func chromaRow(u, v []byte, step int, r0, r1 []byte, c *coefficients) {
+	n := len(r0) / 8 &^ 3
+	if n > 0 {
+		if step == 2 {
+			chromaRowNV12SSE2(u[:2*n], r0[:8*n], r1[:8*n], c)
+		} else {
+			chromaRowSSE2(u[:n], v[:n], r0[:8*n], r1[:8*n], c)
+		}
+	}
+	if 8*n < len(r0) {
+		chromaRowGeneric(u[step*n:], v[step*n:], step, r0[8*n:], r1[8*n:], c)
+	}
+}
@@ -0,0 +1,193 @@
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// Offsets in coefficients.
+#define coefY 0
+#define coefCb 16
+#define coefCr 32
+#define coefYBias 48
+
+// chromaBias is 128 plus one half in fixed point.
+#define chromaBias 2105344
+
+// DOT4 turns two registers holding the r, g, b, a words of two pixels each into the
+// four dot products with the coefficient words in coef: PMADDWL yields r*kr+g*kg and
+// b*kb per pixel, SHUFPS separates the halves and PADDL adds them. lo and tmp are
+// clobbered, the result is left in lo.
+#define DOT4(lo, hi, coef, tmp) \
+	PMADDWL coef, lo \
+	PMADDWL coef, hi \
+	MOVO lo, tmp \
+	SHUFPS $0x88, hi, lo \
+	SHUFPS $0xDD, hi, tmp \
+	PADDL tmp, lo
+
+// func lumaRowSSE2(dst, src []byte, c *coefficients)
+//
+// Eight pixels per iteration, each four-byte pixel widened to words.
+TEXT ·lumaRowSSE2(SB), NOSPLIT, $0-56
+	MOVQ dst_base+0(FP), DI
+	MOVQ dst_len+8(FP), CX
+	MOVQ src_base+24(FP), SI
+	MOVQ c+48(FP), AX
+	MOVOU coefY(AX), X6
+	MOVL coefYBias(AX), BX
+	MOVL BX, X5
+	PSHUFD $0, X5, X5
+	PXOR X7, X7
+	SHRQ $3, CX
+	JZ lumaDone
+
+lumaLoop:
+	MOVOU (SI), X0
+	MOVOU 16(SI), X1
+
+	MOVO X0, X2
+	PUNPCKLBW X7, X2
+	PUNPCKHBW X7, X0
+	DOT4(X2, X0, X6, X3)
+	PADDL X5, X2
+	PSRAL $14, X2
+
+	MOVO X1, X4
+	PUNPCKLBW X7, X4
+	PUNPCKHBW X7, X1
+	DOT4(X4, X1, X6, X3)
+	PADDL X5, X4
+	PSRAL $14, X4
+
+	PACKSSLW X4, X2
+	PACKUSWB X2, X2
+	MOVQ X2, (DI)
+
+	ADDQ $32, SI
+	ADDQ $8, DI
+	DECQ CX
+	JNZ lumaLoop
+
+lumaDone:
+	RET
+
+// BLOCKS4 loads eight pixels from rows SI and DX and leaves the rounded averages of
+// their four 2×2 blocks as r, g, b, a words in X0 (blocks 0 and 1) and X2 (blocks 2
+// and 3). X7 must be zero and X8 hold the word 2.
+#define BLOCKS4 \
+	MOVOU (SI), X0 \
+	MOVOU 16(SI), X2 \
+	MOVOU (DX), X4 \
+	MOVOU 16(DX), X5 \
+	MOVO X0, X1 \
+	PUNPCKLBW X7, X0 \
+	PUNPCKHBW X7, X1 \
+	MOVO X4, X3 \
+	PUNPCKLBW X7, X4 \
+	PUNPCKHBW X7, X3 \
+	PADDW X4, X0 \
+	PADDW X3, X1 \
+	MOVO X2, X3 \
+	PUNPCKLBW X7, X2 \
+	PUNPCKHBW X7, X3 \
+	MOVO X5, X4 \
+	PUNPCKLBW X7, X5 \
+	PUNPCKHBW X7, X4 \
+	PADDW X5, X2 \
+	PADDW X4, X3 \
+	MOVO X0, X4 \
+	PSRLO $8, X4 \
+	PADDW X4, X0 \
+	MOVO X1, X4 \
+	PSRLO $8, X4 \
+	PADDW X4, X1 \
+	PUNPCKLQDQ X1, X0 \
+	MOVO X2, X4 \
+	PSRLO $8, X4 \
+	PADDW X4, X2 \
+	MOVO X3, X4 \
+	PSRLO $8, X4 \
+	PADDW X4, X3 \
+	PUNPCKLQDQ X3, X2 \
+	PADDW X8, X0 \
+	PADDW X8, X2 \
+	PSRLW $2, X0 \
+	PSRLW $2, X2
+
+// CHROMA4 computes four samples from the block averages in X0 and X2 with the
+// coefficient words in coef and leaves them in the low bytes of dst, saturated to
+// 0–255. X9 must hold chromaBias.
+#define CHROMA4(coef, dst) \
+	MOVO X0, dst \
+	MOVO X2, X4 \
+	DOT4(dst, X4, coef, X5) \
+	PADDL X9, dst \
+	PSRAL $14, dst \
+	PACKSSLW dst, dst \
+	PACKUSWB dst, dst
+
+#define CHROMA_SETUP \
+	MOVOU coefCb(AX), X10 \
+	MOVOU coefCr(AX), X11 \
+	PXOR X7, X7 \
+	MOVL $2, BX \
+	MOVL BX, X8 \
+	PSHUFLW $0, X8, X8 \
+	PSHUFD $0, X8, X8 \
+	MOVL $chromaBias, BX \
+	MOVL BX, X9 \
+	PSHUFD $0, X9, X9
+
+// func chromaRowSSE2(u, v, r0, r1 []byte, c *coefficients)
+TEXT ·chromaRowSSE2(SB), NOSPLIT, $0-104
+	MOVQ u_base+0(FP), DI
+	MOVQ u_len+8(FP), CX
+	MOVQ v_base+24(FP), R8
+	MOVQ r0_base+48(FP), SI
+	MOVQ r1_base+72(FP), DX
+	MOVQ c+96(FP), AX
+	CHROMA_SETUP
+	SHRQ $2, CX
+	JZ chromaDone
+
+chromaLoop:
+	BLOCKS4
+	CHROMA4(X10, X12)
+	CHROMA4(X11, X13)
+	MOVL X12, (DI)
+	MOVL X13, (R8)
+
+	ADDQ $32, SI
+	ADDQ $32, DX
+	ADDQ $4, DI
+	ADDQ $4, R8
+	DECQ CX
+	JNZ chromaLoop
+
+chromaDone:
+	RET
+
+// func chromaRowNV12SSE2(uv, r0, r1 []byte, c *coefficients)
+TEXT ·chromaRowNV12SSE2(SB), NOSPLIT, $0-80
+	MOVQ uv_base+0(FP), DI
+	MOVQ uv_len+8(FP), CX
+	MOVQ r0_base+24(FP), SI
+	MOVQ r1_base+48(FP), DX
+	MOVQ c+72(FP), AX
+	CHROMA_SETUP
+	SHRQ $3, CX
+	JZ nv12Done
+
+nv12Loop:
+	BLOCKS4
+	CHROMA4(X10, X12)
+	CHROMA4(X11, X13)
+	PUNPCKLBW X13, X12
+	MOVQ X12, (DI)
+
+	ADDQ $32, SI
+	ADDQ $32, DX
+	ADDQ $8, DI
+	DECQ CX
+	JNZ nv12Loop
+
+nv12Done:
+	RET
@@ -0,0 +1,11 @@
+//go:build !amd64 || purego
+
+package yuv
+
+func lumaRow(dst, src []byte, c *coefficients) {
+	lumaRowGeneric(dst, src, c)
+}
+
+func chromaRow(u, v []byte, step int, r0, r1 []byte, c *coefficients) {
+	chromaRowGeneric(u, v, step, r0, r1, c)
+}
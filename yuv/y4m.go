@@ -0,0 +1,124 @@
+package yuv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"time"
+)
+
+// Y4MOptions configures a Y4MWriter.
+type Y4MOptions struct {
+	// FPS is the frame rate in the header, default 30. Rates such as 29.97 are
+	// written as the exact NTSC fraction 30000/1001.
+	FPS float64
+	// Colorspace of the frames. YUV4MPEG2 records the range but not the matrix,
+	// which the reader has to be told, e.g. with ffmpeg's -colorspace.
+	Colorspace Colorspace
+}
+
+// Y4MWriter writes a YUV4MPEG2 stream of 4:2:0 frames.
+type Y4MWriter struct {
+	w        *bufio.Writer
+	width    int
+	height   int
+	num, den int
+	cs       Colorspace
+	frame    []byte
+	frames   int       // frames written
+	start    time.Time // time of the first frame passed to WriteFrameAt
+}
+
+// NewY4MWriter writes the stream header for width×height frames to w.
+func NewY4MWriter(w io.Writer, width, height int, opts Y4MOptions) (*Y4MWriter, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("yuv: invalid frame size %dx%d", width, height)
+	}
+	if opts.FPS == 0 {
+		opts.FPS = 30
+	}
+	if opts.FPS < 0.001 || math.IsInf(opts.FPS, 0) || math.IsNaN(opts.FPS) {
+		return nil, fmt.Errorf("yuv: invalid frame rate %v", opts.FPS)
+	}
+	y := &Y4MWriter{w: bufio.NewWriterSize(w, 1<<16), width: width, height: height, cs: opts.Colorspace}
+	y.num, y.den = frameRate(opts.FPS)
+	colorRange := "LIMITED"
+	if opts.Colorspace.Range == Full {
+		colorRange = "FULL"
+	}
+	fmt.Fprintf(y.w, "YUV4MPEG2 W%d H%d F%d:%d Ip A1:1 C420jpeg XYSCSS=420JPEG XCOLORRANGE=%s\n",
+		width, height, y.num, y.den, colorRange)
+	return y, y.w.Flush()
+}
+
+// FrameRate returns the frame rate in the header as a fraction.
+func (y *Y4MWriter) FrameRate() (num, den int) {
+	return y.num, y.den
+}
+
+// Frames returns the number of frames written.
+func (y *Y4MWriter) Frames() int {
+	return y.frames
+}
+
+// WriteFrame converts img, which must have the stream's size, and writes it as the
+// next frame.
+func (y *Y4MWriter) WriteFrame(img *image.RGBA) error {
+	if img.Rect.Dx() != y.width || img.Rect.Dy() != y.height {
+		return fmt.Errorf("yuv: frame size %v differs from stream size %dx%d", img.Rect.Size(), y.width, y.height)
+	}
+	y.frame = I420(y.frame, img, y.cs)
+	return y.writeFrame(1)
+}
+
+// WriteFrameAt writes img as the frame shown at t, the first call fixing the start of
+// the stream. Frames the capture missed repeat the previous image and images arriving
+// before their slot are dropped, so that the stream keeps the rate of its header.
+func (y *Y4MWriter) WriteFrameAt(img *image.RGBA, t time.Time) error {
+	if y.start.IsZero() {
+		y.start = t
+	}
+	slot := int(math.Round(t.Sub(y.start).Seconds() * float64(y.num) / float64(y.den)))
+	if slot < y.frames {
+		return nil
+	}
+	if y.frames > 0 && slot > y.frames {
+		if err := y.writeFrame(slot - y.frames); err != nil {
+			return err
+		}
+	}
+	return y.WriteFrame(img)
+}
+
+func (y *Y4MWriter) writeFrame(count int) error {
+	if y.frame == nil {
+		return errors.New("yuv: no frame to repeat")
+	}
+	for range count {
+		_, _ = y.w.WriteString("FRAME\n")
+		_, _ = y.w.Write(y.frame)
+		y.frames++
+	}
+	return y.w.Flush()
+}
+
+// frameRate returns fps as a fraction: whole numbers over 1, NTSC rates over 1001
+// and anything else in thousandths.
+func frameRate(fps float64) (num, den int) {
+	if r := math.Round(fps); math.Abs(fps-r) < 1e-9 {
+		return int(r), 1
+	}
+	if r := math.Round(fps * 1001 / 1000); math.Abs(fps*1001/1000-r) < 1e-3 {
+		return int(r) * 1000, 1001
+	}
+	num, den = int(math.Round(fps*1000)), 1000
+	for a, b := num, den; ; {
+		if b == 0 {
+			return num / a, den / a
+		}
+		a, b = b, a%b
+	}
+}
@@ -0,0 +1,192 @@
+// Package yuv converts captured RGBA frames to the planar YUV 4:2:0 layouts video
+// encoders take, I420 and NV12, and writes them as YUV4MPEG2 streams.
+//
+// Luma is computed per pixel and chroma from the average of each 2×2 block, sited in
+// its centre as in JPEG and MPEG-1. Alpha is ignored. On amd64 the conversion uses
+// SSE2; build with the purego tag to use plain Go.
+package yuv
+
+import (
+	"image"
+	"math"
+)
+
+// Matrix selects the RGB to YCbCr coefficients.
+type Matrix int
+
+const (
+	// BT601 is the standard definition matrix, also used by JPEG.
+	BT601 Matrix = iota
+	// BT709 is the high definition matrix.
+	BT709
+)
+
+// Range selects the code values used for black and white.
+type Range int
+
+const (
+	// Limited maps luma to 16–235 and chroma to 16–240, as video usually does.
+	Limited Range = iota
+	// Full uses 0–255 for every component.
+	Full
+)
+
+// Colorspace is a matrix and a range. The zero value is BT.601 limited range.
+type Colorspace struct {
+	Matrix Matrix
+	Range  Range
+}
+
+// String returns the name ffmpeg uses for the matrix and range, e.g. "bt709 tv".
+func (cs Colorspace) String() string {
+	s := "bt601"
+	if cs.Matrix == BT709 {
+		s = "bt709"
+	}
+	if cs.Range == Full {
+		return s + " pc"
+	}
+	return s + " tv"
+}
+
+// coefBits is the fixed-point precision of the conversion.
+const coefBits = 14
+
+// coefficients holds the conversion in fixed point. The assembly relies on the
+// layout of the first four fields.
+type coefficients struct {
+	y      [8]int16 // r, g, b, 0 for two pixels, the layout PMADDWD multiplies with
+	cbw    [8]int16 // cb as y
+	crw    [8]int16 // cr as y
+	yBias  int32
+	cb, cr [3]int32
+}
+
+var coefTable [2][2]*coefficients
+
+func init() {
+	for m := range coefTable {
+		for r := range coefTable[m] {
+			coefTable[m][r] = newCoefficients(Colorspace{Matrix(m), Range(r)})
+		}
+	}
+}
+
+func (cs Colorspace) coefficients() *coefficients {
+	return coefTable[cs.Matrix&1][cs.Range&1]
+}
+
+func newCoefficients(cs Colorspace) *coefficients {
+	kr, kb := 0.299, 0.114
+	if cs.Matrix == BT709 {
+		kr, kb = 0.2126, 0.0722
+	}
+	yScale, cScale, yOffset := 1.0, 1.0, int32(0)
+	if cs.Range == Limited {
+		yScale, cScale, yOffset = 219.0/255, 224.0/255, 16
+	}
+	fix := func(v float64) int32 { return int32(math.Round(v * (1 << coefBits))) }
+
+	// Rounding the shares of each coefficient set so that they add up exactly keeps
+	// greys free of colour and white at its nominal value.
+	c := &coefficients{}
+	yr, yb := fix(kr*yScale), fix(kb*yScale)
+	yg := fix(yScale) - yr - yb
+	c.y = [8]int16{int16(yr), int16(yg), int16(yb), 0, int16(yr), int16(yg), int16(yb), 0}
+	c.yBias = yOffset<<coefBits + 1<<(coefBits-1)
+
+	half := fix(cScale / 2)
+	cbr := fix(-kr * cScale / (2 * (1 - kb)))
+	c.cb = [3]int32{cbr, -half - cbr, half}
+	crb := fix(-kb * cScale / (2 * (1 - kr)))
+	c.cr = [3]int32{half, -half - crb, crb}
+	for i := range 2 {
+		for k := range 3 {
+			c.cbw[4*i+k], c.crw[4*i+k] = int16(c.cb[k]), int16(c.cr[k])
+		}
+	}
+	return c
+}
+
+// FrameSize returns the size of a 4:2:0 frame of width×height pixels.
+func FrameSize(width, height int) int {
+	return width*height + 2*((width+1)/2)*((height+1)/2)
+}
+
+// I420 converts src to I420: the luma plane followed by the Cb and Cr planes, each
+// chroma plane having half the width and height rounded up. The frame is written to
+// dst if it is large enough, otherwise to a new slice, which is returned.
+func I420(dst []byte, src *image.RGBA, cs Colorspace) []byte {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst = grow(dst, FrameSize(w, h))
+	cw, ch := (w+1)/2, (h+1)/2
+	u := dst[w*h : w*h+cw*ch]
+	v := dst[w*h+cw*ch:]
+	convert(src, cs.coefficients(), dst[:w*h], u, v, 1)
+	return dst
+}
+
+// NV12 converts src to NV12: the luma plane followed by one plane of interleaved Cb
+// and Cr samples. The frame is written to dst if it is large enough, otherwise to a
+// new slice, which is returned.
+func NV12(dst []byte, src *image.RGBA, cs Colorspace) []byte {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst = grow(dst, FrameSize(w, h))
+	uv := dst[w*h:]
+	convert(src, cs.coefficients(), dst[:w*h], uv, uv[1:], 2)
+	return dst
+}
+
+func grow(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
+
+// convert writes the luma of src to y and its chroma to u and v, whose samples are
+// step bytes apart.
+func convert(src *image.RGBA, c *coefficients, y, u, v []byte, step int) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	for row := 0; row < h; row++ {
+		i := src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+row)
+		lumaRow(y[row*w:row*w+w], src.Pix[i:i+4*w], c)
+	}
+
+	cw := (w + 1) / 2
+	for cy := 0; cy < (h+1)/2; cy++ {
+		// Odd heights repeat the last row.
+		i0 := src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+2*cy)
+		i1 := src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+min(2*cy+1, h-1))
+		j := cy * cw * step
+		chromaRow(u[j:], v[j:], step, src.Pix[i0:i0+4*w], src.Pix[i1:i1+4*w], c)
+	}
+}
+
+// chromaRowGeneric writes the chroma of the 2×2 blocks of rows r0 and r1 to u and v,
+// whose samples are step bytes apart. An odd width repeats the last column.
+func chromaRowGeneric(u, v []byte, step int, r0, r1 []byte, c *coefficients) {
+	w := len(r0) / 4
+	for cx, j := 0, 0; cx < (w+1)/2; cx, j = cx+1, j+step {
+		x0, x1 := 8*cx, 4*min(2*cx+1, w-1)
+		r := (int32(r0[x0]) + int32(r0[x1]) + int32(r1[x0]) + int32(r1[x1]) + 2) >> 2
+		g := (int32(r0[x0+1]) + int32(r0[x1+1]) + int32(r1[x0+1]) + int32(r1[x1+1]) + 2) >> 2
+		b := (int32(r0[x0+2]) + int32(r0[x1+2]) + int32(r1[x0+2]) + int32(r1[x1+2]) + 2) >> 2
+		u[j] = chroma(r, g, b, &c.cb)
+		v[j] = chroma(r, g, b, &c.cr)
+	}
+}
+
+func chroma(r, g, b int32, k *[3]int32) byte {
+	const bias = 128<<coefBits + 1<<(coefBits-1)
+	return byte(min(max((r*k[0]+g*k[1]+b*k[2]+bias)>>coefBits, 0), 255))
+}
+
+// lumaRowGeneric writes the luma of the RGBA pixels in src to dst.
+func lumaRowGeneric(dst, src []byte, c *coefficients) {
+	yr, yg, yb := int32(c.y[0]), int32(c.y[1]), int32(c.y[2])
+	for x := range dst {
+		p := src[4*x : 4*x+3]
+		dst[x] = byte((int32(p[0])*yr + int32(p[1])*yg + int32(p[2])*yb + c.yBias) >> coefBits)
+	}
+}
@@ -0,0 +1,240 @@
+package yuv
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+func randomImage(w, h int) *image.RGBA {
+	rng := rand.New(rand.NewPCG(1, uint64(w*h)))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = byte(rng.Uint32())
+	}
+	return img
+}
+
+// reference converts one RGB colour in floating point.
+func reference(r, g, b float64, cs Colorspace) (y, cb, cr float64) {
+	kr, kb := 0.299, 0.114
+	if cs.Matrix == BT709 {
+		kr, kb = 0.2126, 0.0722
+	}
+	y = kr*r + (1-kr-kb)*g + kb*b
+	cb = (b - y) / (2 * (1 - kb))
+	cr = (r - y) / (2 * (1 - kr))
+	if cs.Range == Limited {
+		return 16 + y*219/255, 128 + cb*224/255, 128 + cr*224/255
+	}
+	return y, math.Min(128+cb, 255), math.Min(128+cr, 255)
+}
+
+var colorspaces = []Colorspace{{BT601, Limited}, {BT601, Full}, {BT709, Limited}, {BT709, Full}}
+
+func TestI420(t *testing.T) {
+	for _, cs := range colorspaces {
+		for _, size := range []image.Point{{16, 8}, {37, 21}, {1, 1}, {2, 3}} {
+			src := randomImage(size.X+5, size.Y+3).SubImage(image.Rect(3, 2, 3+size.X, 2+size.Y)).(*image.RGBA)
+			frame := I420(nil, src, cs)
+			if len(frame) != FrameSize(size.X, size.Y) {
+				t.Fatalf("%v %v: frame of %d bytes", cs, size, len(frame))
+			}
+			w, h := size.X, size.Y
+			cw, ch := (w+1)/2, (h+1)/2
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					c := src.RGBAAt(3+x, 2+y)
+					want, _, _ := reference(float64(c.R), float64(c.G), float64(c.B), cs)
+					if got := float64(frame[y*w+x]); math.Abs(got-want) > 1 {
+						t.Fatalf("%v %v: Y(%d, %d) = %v, want %.2f", cs, size, x, y, got, want)
+					}
+				}
+			}
+			for y := 0; y < ch; y++ {
+				for x := 0; x < cw; x++ {
+					var r, g, b float64
+					for _, p := range []image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+						c := src.RGBAAt(3+min(2*x+p.X, w-1), 2+min(2*y+p.Y, h-1))
+						r, g, b = r+float64(c.R)/4, g+float64(c.G)/4, b+float64(c.B)/4
+					}
+					_, wantCb, wantCr := reference(r, g, b, cs)
+					gotCb, gotCr := float64(frame[w*h+y*cw+x]), float64(frame[w*h+cw*ch+y*cw+x])
+					if math.Abs(gotCb-wantCb) > 1 || math.Abs(gotCr-wantCr) > 1 {
+						t.Fatalf("%v %v: chroma (%d, %d) = %v, %v, want %.2f, %.2f", cs, size, x, y, gotCb, gotCr, wantCb, wantCr)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestNominalLevels(t *testing.T) {
+	for _, cs := range colorspaces {
+		black, white := 16, 235
+		if cs.Range == Full {
+			black, white = 0, 255
+		}
+		for _, tt := range []struct {
+			c    color.RGBA
+			want [3]int
+		}{
+			{color.RGBA{0, 0, 0, 255}, [3]int{black, 128, 128}},
+			{color.RGBA{255, 255, 255, 255}, [3]int{white, 128, 128}},
+			{color.RGBA{128, 128, 128, 255}, [3]int{(black*127 + white*128 + 127) / 255, 128, 128}},
+		} {
+			img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+			for i := range 4 {
+				img.SetRGBA(i%2, i/2, tt.c)
+			}
+			f := I420(nil, img, cs)
+			if got := [3]int{int(f[0]), int(f[4]), int(f[5])}; got != tt.want {
+				t.Errorf("%v %v: got %v, want %v", cs, tt.c, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestNV12(t *testing.T) {
+	src := randomImage(33, 17)
+	i420 := I420(nil, src, Colorspace{})
+	nv12 := NV12(make([]byte, 0, 1<<16), src, Colorspace{})
+	luma := 33 * 17
+	chroma := 17 * 9
+	if !bytes.Equal(nv12[:luma], i420[:luma]) {
+		t.Fatal("luma planes differ")
+	}
+	for i := range chroma {
+		if nv12[luma+2*i] != i420[luma+i] || nv12[luma+2*i+1] != i420[luma+chroma+i] {
+			t.Fatalf("chroma sample %d differs", i)
+		}
+	}
+}
+
+func TestLumaRow(t *testing.T) {
+	src := randomImage(100, 1).Pix
+	for _, cs := range colorspaces {
+		c := cs.coefficients()
+		for n := 0; n <= 100; n++ {
+			got, want := make([]byte, n), make([]byte, n)
+			lumaRow(got, src, c)
+			lumaRowGeneric(want, src, c)
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%v: %d pixels differ", cs, n)
+			}
+		}
+	}
+}
+
+func TestY4M(t *testing.T) {
+	var buf bytes.Buffer
+	y, err := NewY4MWriter(&buf, 5, 3, Y4MOptions{FPS: 29.97, Colorspace: Colorspace{BT709, Full}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := "YUV4MPEG2 W5 H3 F30000:1001 Ip A1:1 C420jpeg XYSCSS=420JPEG XCOLORRANGE=FULL\n"
+	if buf.String() != header {
+		t.Fatalf("header %q, want %q", buf.String(), header)
+	}
+
+	// Slots are 33.4ms apart: the frame at 100ms fills slot 3 after repeating the one
+	// at 33ms for slot 2, and the one at 110ms is too early for slot 4.
+	start := time.Unix(100, 0)
+	imgs := make([]*image.RGBA, 4)
+	for i, ms := range []int{0, 33, 100, 110} {
+		imgs[i] = image.NewRGBA(image.Rect(0, 0, 5, 3))
+		for j := range imgs[i].Pix {
+			imgs[i].Pix[j] = byte(60 * i)
+		}
+		if err := y.WriteFrameAt(imgs[i], start.Add(time.Duration(ms)*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if y.Frames() != 4 {
+		t.Errorf("wrote %d frames, want 4", y.Frames())
+	}
+	frames := strings.Split(strings.TrimPrefix(buf.String(), header), "FRAME\n")[1:]
+	if len(frames) != 4 {
+		t.Fatalf("stream holds %d frames", len(frames))
+	}
+	for i, want := range []int{0, 1, 1, 2} {
+		if f := I420(nil, imgs[want], Colorspace{BT709, Full}); frames[i] != string(f) {
+			t.Errorf("frame %d is not image %d", i, want)
+		}
+	}
+	if err := y.WriteFrame(image.NewRGBA(image.Rect(0, 0, 4, 3))); err == nil {
+		t.Error("frame of the wrong size accepted")
+	}
+}
+
+func TestFrameRate(t *testing.T) {
+	for fps, want := range map[float64][2]int{
+		30:     {30, 1},
+		29.97:  {30000, 1001},
+		59.94:  {60000, 1001},
+		23.976: {24000, 1001},
+		12.5:   {25, 2},
+		0.5:    {1, 2},
+	} {
+		if num, den := frameRate(fps); [2]int{num, den} != want {
+			t.Errorf("frameRate(%v) = %d/%d, want %d/%d", fps, num, den, want[0], want[1])
+		}
+	}
+}
+
+func BenchmarkI420(b *testing.B) {
+	src := randomImage(1920, 1080)
+	dst := I420(nil, src, Colorspace{})
+	b.SetBytes(int64(len(src.Pix)))
+	for b.Loop() {
+		I420(dst, src, Colorspace{})
+	}
+}
+
+func BenchmarkLumaRowGeneric(b *testing.B) {
+	src := randomImage(1920, 1).Pix
+	dst := make([]byte, 1920)
+	c := Colorspace{}.coefficients()
+	b.SetBytes(int64(len(src)))
+	for b.Loop() {
+		lumaRowGeneric(dst, src, c)
+	}
+}
+
+func BenchmarkLumaRow(b *testing.B) {
+	src := randomImage(1920, 1).Pix
+	dst := make([]byte, 1920)
+	c := Colorspace{}.coefficients()
+	b.SetBytes(int64(len(src)))
+	for b.Loop() {
+		lumaRow(dst, src, c)
+	}
+}
+
+func TestChromaRow(t *testing.T) {
+	rows := randomImage(100, 2)
+	r0, r1 := rows.Pix[:400], rows.Pix[400:]
+	for _, cs := range colorspaces {
+		c := cs.coefficients()
+		for w := 1; w <= 100; w++ {
+			cw := (w + 1) / 2
+			for _, step := range []int{1, 2} {
+				got, want := make([]byte, 2*cw), make([]byte, 2*cw)
+				gu, gv, wu, wv := got[:cw], got[cw:], want[:cw], want[cw:]
+				if step == 2 {
+					gu, gv, wu, wv = got, got[1:], want, want[1:]
+				}
+				chromaRow(gu, gv, step, r0[:4*w], r1[:4*w], c)
+				chromaRowGeneric(wu, wv, step, r0[:4*w], r1[:4*w], c)
+				if !bytes.Equal(got, want) {
+					t.Fatalf("%v: %d pixels with step %d differ", cs, w, step)
+				}
+			}
+		}
+	}
+}